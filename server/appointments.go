@@ -1,12 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // --- Appointment DTOs ---
@@ -20,6 +22,170 @@ type CreateAppointmentInput struct {
 	PaymentID      *uint  `json:"payment_id"`
 }
 
+// --- Appointment Notifications ---
+
+// Wording for each appointment event: heading, then the client's and the professional's message
+var appointmentMessages = map[string][3]string{
+	EventAppointmentBooked:      {"Appointment booked", "Your appointment has been booked.", "A client has booked an appointment with you."},
+	EventAppointmentRescheduled: {"Appointment rescheduled", "Your appointment has been moved to a new time.", "A client has moved their appointment to a new time."},
+	EventAppointmentCancelled:   {"Appointment cancelled", "Your appointment has been cancelled.", "A client has cancelled their appointment."},
+	EventAppointmentDeclined:    {"Appointment declined", "Your professional can't take this appointment, so it has been cancelled and anything you paid refunded.", "You declined this appointment."},
+}
+
+// formatInTimezone shows a time in a user's timezone, falling back to UTC
+func formatInTimezone(t time.Time, timezone string) string {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return t.In(loc).Format("Mon 02 Jan 2006, 15:04 MST")
+}
+
+// professionalOnTimeOff reports whether start to end overlaps the
+// professional's time off
+func professionalOnTimeOff(professionalID uuid.UUID, start, end time.Time) bool {
+	var count int64
+	db.Model(&ProfessionalTimeOff{}).
+		Where("professional_id = ? AND start_time < ? AND end_time > ?", professionalID, end, start).
+		Count(&count)
+	return count > 0
+}
+
+// notifyAppointment tells the client and the professional about a change to an appointment
+func notifyAppointment(tx *gorm.DB, appointment Appointment, event string) error {
+	type recipient struct {
+		userID  uint
+		message string
+	}
+	recipients := []recipient{{appointment.ClientID, appointmentMessages[event][1]}}
+	var professional Professional
+	if tx.Where("id = ?", appointment.ProfessionalID).First(&professional).Error == nil {
+		recipients = append(recipients, recipient{professional.UserID, appointmentMessages[event][2]})
+	}
+	var service Service
+	tx.Where("id = ?", appointment.ServiceID).First(&service)
+	var client User
+	tx.First(&client, appointment.ClientID)
+
+	heading := appointmentMessages[event][0]
+	for _, r := range recipients {
+		var user User
+		if tx.First(&user, r.userID).Error != nil {
+			continue
+		}
+		when := formatInTimezone(appointment.StartTime, user.Timezone)
+		message := r.message
+		calendarEvent := appointmentEvent(appointment, service.Name, "")
+		if user.ID != appointment.ClientID {
+			calendarEvent = appointmentEvent(appointment, service.Name, client.Name)
+		}
+		invite := calendarInvite(calendarEvent, user)
+		err := Notify(tx, user.ID, event, NotificationContent{
+			Title: heading,
+			Body:  fmt.Sprintf("%s %s (%s)", message, when, appointment.ReferenceCode),
+			URL:   frontendURL() + "/appointments",
+			Email: func(tx *gorm.DB, to string) error {
+				return SendAppointmentUpdate(tx, to, user.Name, heading, message, when, appointment.ReferenceCode, invite)
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// consumeAppointmentPayment spends the client's service payment on a new
+// appointment. Service IDs are UUIDs, so service payments carry no ProductID;
+// consuming the payment is what binds it to the one appointment it paid for.
+func consumeAppointmentPayment(tx *gorm.DB, paymentID, clientID uint) error {
+	_, err := ConsumePayment(tx, paymentID, "appointment", func(p *Payment) error {
+		return checkProductPayment(p, clientID, ProductService, 0)
+	})
+	return err
+}
+
+// appointmentPayment reports whether payment is the one that paid for the
+// appointment: the client's service payment, spent on an appointment
+func appointmentPayment(appointment Appointment, payment Payment) bool {
+	return appointment.PaymentID != nil && *appointment.PaymentID == payment.ID &&
+		payment.UserID == appointment.ClientID && payment.ProductType == ProductService &&
+		payment.ConsumedBy == "appointment"
+}
+
+// --- Declined Appointments ---
+
+// SettleDeclinedAppointments refunds and tells clients whose appointment a
+// professional declined. Professionals decline through the professional API,
+// which has no gateway access, so the refund happens here.
+func SettleDeclinedAppointments() int {
+	var appointments []Appointment
+	db.Where("declined_at IS NOT NULL AND decline_settled_at IS NULL").Find(&appointments)
+
+	settled := 0
+	for _, appointment := range appointments {
+		// Claimed first so two servers can't both refund the same decline
+		res := db.Model(&Appointment{}).Where("id = ? AND decline_settled_at IS NULL", appointment.ID).
+			Update("decline_settled_at", time.Now())
+		if res.Error != nil || res.RowsAffected == 0 {
+			continue
+		}
+		settleDeclinedAppointment(appointment)
+		settled++
+	}
+	return settled
+}
+
+// settleDeclinedAppointment refunds the appointment's payment and tells the
+// client and the professional
+func settleDeclinedAppointment(appointment Appointment) {
+	updates := map[string]interface{}{"calendar_sequence": appointment.CalendarSequence + 1}
+	refunded, err := refundDeclinedAppointment(appointment)
+	if err != nil {
+		fmt.Printf("ERROR: Could not refund declined appointment %s: %v\n", appointment.ReferenceCode, err)
+	} else if refunded {
+		updates["payment_status"] = "refunded"
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&appointment).Updates(updates).Error; err != nil {
+			return err
+		}
+		return notifyAppointment(tx, appointment, EventAppointmentDeclined)
+	})
+	if err != nil {
+		fmt.Printf("ERROR: Could not notify declined appointment %s: %v\n", appointment.ReferenceCode, err)
+	}
+	wakeCalendarSync(appointment.ProfessionalID)
+}
+
+// refundDeclinedAppointment refunds what is left of the client's payment for
+// the appointment, to the card if the gateway takes it and otherwise to their
+// wallet. Payments that were never taken, belong to someone else or paid for
+// something else are left alone.
+func refundDeclinedAppointment(appointment Appointment) (bool, error) {
+	if appointment.PaymentID == nil {
+		return false, nil
+	}
+	var payment Payment
+	if err := db.First(&payment, *appointment.PaymentID).Error; err != nil {
+		return false, nil
+	}
+	if !appointmentPayment(appointment, payment) ||
+		(payment.Status != "success" && payment.Status != "partially_refunded") {
+		return false, nil
+	}
+
+	reason := "Appointment " + appointment.ReferenceCode + " declined by the professional"
+	_, err := refundPayment(&payment, 0, false, reason)
+	var gatewayErr gatewayRefundError
+	if errors.As(err, &gatewayErr) {
+		fmt.Printf("WARNING: Gateway refund for appointment %s failed, refunding to wallet: %v\n", appointment.ReferenceCode, gatewayErr.err)
+		_, err = refundPayment(&payment, 0, true, reason)
+	}
+	return err == nil, err
+}
+
 // --- Appointment Handlers ---
 
 // CreateAppointment - Protected - Book with a professional
@@ -62,6 +228,11 @@ func CreateAppointment(c *gin.Context) {
 		return
 	}
 
+	if professionalBusy(professionalID, startTime, endTime) || professionalOnTimeOff(professionalID, startTime, endTime) {
+		c.JSON(http.StatusConflict, gin.H{"error": "The professional is not available at that time"})
+		return
+	}
+
 	// Get client ID
 	var clientID uint
 	switch v := userID.(type) {
@@ -111,10 +282,29 @@ func CreateAppointment(c *gin.Context) {
 		PaymentID:                 input.PaymentID,
 	}
 
-	if err := db.Create(&appointment).Error; err != nil {
+	var paymentErr error
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if input.PaymentID != nil {
+			paymentErr = consumeAppointmentPayment(tx, *input.PaymentID, clientID)
+			if paymentErr != nil {
+				return paymentErr
+			}
+			appointment.PaymentStatus = "paid"
+		}
+		if err := tx.Create(&appointment).Error; err != nil {
+			return err
+		}
+		return notifyAppointment(tx, appointment, EventAppointmentBooked)
+	})
+	if paymentErr != nil {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": paymentErr.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create appointment: " + err.Error()})
 		return
 	}
+	wakeCalendarSync(professionalID)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message":        "Appointment created successfully",
@@ -223,7 +413,18 @@ func CancelAppointment(c *gin.Context) {
 	}
 
 	appointment.Status = "cancelled"
-	db.Save(&appointment)
+	appointment.CalendarSequence++
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&appointment).Error; err != nil {
+			return err
+		}
+		return notifyAppointment(tx, appointment, EventAppointmentCancelled)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel appointment"})
+		return
+	}
+	wakeCalendarSync(appointment.ProfessionalID)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Appointment cancelled successfully"})
 }
@@ -276,10 +477,27 @@ func RescheduleAppointment(c *gin.Context) {
 		return
 	}
 
+	if professionalBusy(appointment.ProfessionalID, startTime, endTime) ||
+		professionalOnTimeOff(appointment.ProfessionalID, startTime, endTime) {
+		c.JSON(http.StatusConflict, gin.H{"error": "The professional is not available at that time"})
+		return
+	}
+
 	appointment.StartTime = startTime
 	appointment.EndTime = endTime
 	appointment.Status = "rescheduled"
-	db.Save(&appointment)
+	appointment.CalendarSequence++
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&appointment).Error; err != nil {
+			return err
+		}
+		return notifyAppointment(tx, appointment, EventAppointmentRescheduled)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reschedule appointment"})
+		return
+	}
+	wakeCalendarSync(appointment.ProfessionalID)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Appointment rescheduled successfully"})
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupAppointmentDB migrates what appointments, their payments and their
+// notifications need. User 1 is the client and hears by push only, as email
+// templates aren't set up here.
+func setupAppointmentDB(t *testing.T) {
+	setupInvoiceDB(t)
+	require.NoError(t, db.AutoMigrate(&NotificationPreference{}, &NotificationSettings{}, &PushSubscription{}, &Notification{}))
+	// SQLite has no gen_random_uuid(), so create the UUID-keyed tables first
+	// and let AutoMigrate add the remaining columns
+	for _, table := range []string{"professionals", "services", "appointments"} {
+		require.NoError(t, db.Exec("CREATE TABLE "+table+" (id TEXT PRIMARY KEY)").Error)
+	}
+	require.NoError(t, db.AutoMigrate(&Professional{}, &Service{}, &Appointment{}))
+
+	require.NoError(t, db.Create(&PushSubscription{UserID: 1, Endpoint: "https://push.example.com/1"}).Error)
+	for _, event := range []string{EventAppointmentBooked, EventAppointmentDeclined} {
+		require.NoError(t, db.Create(&NotificationPreference{UserID: 1, Event: event, Channel: "email"}).Error)
+	}
+}
+
+func TestCreateAppointmentPayment(t *testing.T) {
+	setupAppointmentDB(t)
+
+	servicePayment := Payment{UserID: 1, OrderID: "order_1", Amount: 80, Currency: "AUD", Status: "success", ProductType: ProductService}
+	othersPayment := Payment{UserID: 2, OrderID: "order_2", Amount: 80, Currency: "AUD", Status: "success", ProductType: ProductService}
+	giftCardPayment := Payment{UserID: 1, OrderID: "order_3", Amount: 80, Currency: "AUD", Status: "success", ProductType: ProductGiftCard}
+	pendingPayment := Payment{UserID: 1, OrderID: "order_4", Amount: 80, Currency: "AUD", Status: "created", ProductType: ProductService}
+	for _, p := range []*Payment{&servicePayment, &othersPayment, &giftCardPayment, &pendingPayment} {
+		require.NoError(t, db.Create(p).Error)
+	}
+
+	start := time.Now().Add(24 * time.Hour)
+	book := func(paymentID uint) int {
+		return callHandler(CreateAppointment, nil, CreateAppointmentInput{
+			ProfessionalID: uuid.NewString(),
+			ServiceID:      uuid.NewString(),
+			StartTime:      start.Format(time.RFC3339),
+			EndTime:        start.Add(time.Hour).Format(time.RFC3339),
+			PaymentID:      &paymentID,
+		}).Code
+	}
+	cases := []struct {
+		name    string
+		payment uint
+		want    int
+	}{
+		{"another user's payment", othersPayment.ID, http.StatusPaymentRequired},
+		{"payment for a gift card", giftCardPayment.ID, http.StatusPaymentRequired},
+		{"payment not completed", pendingPayment.ID, http.StatusPaymentRequired},
+		{"missing payment", 999, http.StatusPaymentRequired},
+		{"the client's service payment", servicePayment.ID, http.StatusCreated},
+		{"the same payment again", servicePayment.ID, http.StatusPaymentRequired},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, book(tc.payment), tc.name)
+	}
+
+	db.First(&servicePayment, servicePayment.ID)
+	assert.Equal(t, "appointment", servicePayment.ConsumedBy)
+	var appointments []Appointment
+	db.Find(&appointments)
+	require.Len(t, appointments, 1)
+	assert.Equal(t, "paid", appointments[0].PaymentStatus)
+	db.First(&giftCardPayment, giftCardPayment.ID)
+	assert.Nil(t, giftCardPayment.ConsumedAt)
+}
+
+func TestSettleDeclinedAppointments(t *testing.T) {
+	setupAppointmentDB(t)
+
+	now := time.Now()
+	payment := func(userID uint, order, productType string, consumedBy string) Payment {
+		p := Payment{UserID: userID, OrderID: order, Amount: 80, Currency: "AUD", Status: "success", ProductType: productType}
+		if consumedBy != "" {
+			p.ConsumedAt, p.ConsumedBy = &now, consumedBy
+		}
+		require.NoError(t, db.Create(&p).Error)
+		return p
+	}
+	paid := payment(1, "order_1", ProductService, "appointment")
+	othersPayment := payment(2, "order_2", ProductService, "appointment")
+	keptPayment := payment(1, "order_3", ProductService, "appointment")
+	giftCardPayment := payment(1, "order_4", ProductGiftCard, "")
+	classPayment := payment(1, "order_5", ProductClass, "booking")
+
+	book := func(ref string, paymentID uint, declinedAt *time.Time) Appointment {
+		a := Appointment{ID: uuid.New(), ReferenceCode: ref, ClientID: 1, ProfessionalID: uuid.New(), ServiceID: uuid.New(),
+			StartTime: now.Add(24 * time.Hour), EndTime: now.Add(25 * time.Hour), Status: "cancelled",
+			PaymentStatus: "paid", PaymentID: &paymentID, DeclinedAt: declinedAt}
+		require.NoError(t, db.Create(&a).Error)
+		return a
+	}
+	declined := book("APT-1", paid.ID, &now)
+	notTheirs := book("APT-2", othersPayment.ID, &now)
+	cancelled := book("APT-3", keptPayment.ID, nil) // Cancelled by the client, not declined
+	giftCard := book("APT-4", giftCardPayment.ID, &now)
+	class := book("APT-5", classPayment.ID, &now)
+
+	assert.Equal(t, 4, SettleDeclinedAppointments())
+	assert.Zero(t, SettleDeclinedAppointments(), "each decline is settled once")
+
+	// No gateway reference on the test payment, so the refund falls back to the wallet
+	db.First(&paid, paid.ID)
+	assert.Equal(t, "refunded", paid.Status)
+	assert.Equal(t, 80.0, paid.RefundedToWallet)
+	assert.Equal(t, 80.0, WalletBalance(db, 1, "AUD"))
+	for _, p := range []*Payment{&othersPayment, &keptPayment, &giftCardPayment, &classPayment} {
+		db.First(p, p.ID)
+		assert.Equal(t, "success", p.Status, p.OrderID)
+	}
+
+	appointment := func(id uuid.UUID) (a Appointment) {
+		db.First(&a, "id = ?", id)
+		return a
+	}
+	got := appointment(declined.ID)
+	assert.Equal(t, "refunded", got.PaymentStatus)
+	assert.NotNil(t, got.DeclineSettledAt)
+	assert.Equal(t, 1, got.CalendarSequence)
+	for _, id := range []uuid.UUID{notTheirs.ID, giftCard.ID, class.ID} {
+		got = appointment(id)
+		assert.Equal(t, "paid", got.PaymentStatus, got.ReferenceCode)
+		assert.NotNil(t, got.DeclineSettledAt, got.ReferenceCode)
+	}
+	assert.Nil(t, appointment(cancelled.ID).DeclineSettledAt)
+
+	// Told about every decline, whether or not there was anything to refund
+	var notified int64
+	db.Model(&Notification{}).Where("user_id = ? AND event = ?", 1, EventAppointmentDeclined).Count(&notified)
+	assert.Equal(t, int64(4), notified)
+}
@@ -22,12 +22,16 @@ type User struct {
 	Role     string `json:"role" gorm:"default:'user'"` // 'user' or 'admin' (Legacy)
 
 	// New Marketplace Fields
-	UserType           string    `json:"user_type" gorm:"default:'client'"` // client, professional, admin
-	IsVerified         bool      `json:"is_verified" gorm:"default:false"`
-	ProfileImageURL    string    `json:"profile_image_url"`
-	CurrencyPreference string    `json:"currency_preference" gorm:"default:'AUD'"`
-	Timezone           string    `json:"timezone" gorm:"default:'UTC'"`
-	LastLoginAt        time.Time `json:"last_login_at"`
+	UserType           string     `json:"user_type" gorm:"default:'client'"` // client, professional, admin
+	IsVerified         bool       `json:"is_verified" gorm:"default:false"`
+	ProfileImageURL    string     `json:"profile_image_url"`
+	CurrencyPreference string     `json:"currency_preference" gorm:"default:'AUD'"`
+	CountryCode        string     `json:"country_code"` // Detected from the signup IP
+	Timezone           string     `json:"timezone" gorm:"default:'UTC'"`
+	Locale             string     `json:"locale" gorm:"default:'en'"` // Language for emails, e.g. en, hi, es
+	TaxID              string     `json:"tax_id"`                     // GSTIN/ABN/VAT number for business invoices
+	TaxIDVerifiedAt    *time.Time `json:"tax_id_verified_at"`         // Set by an admin; cleared when TaxID changes
+	LastLoginAt        time.Time  `json:"last_login_at"`
 
 	// Relationships
 	Professional *Professional `json:"professional,omitempty" gorm:"foreignKey:UserID"`
@@ -46,8 +50,10 @@ type SignupInput struct {
 }
 
 type UpdateProfileInput struct {
-	Name  string `json:"name"`
-	Phone string `json:"phone"`
+	Name   string `json:"name"`
+	Phone  string `json:"phone"`
+	TaxID  string `json:"tax_id"`
+	Locale string `json:"locale"`
 }
 
 type LoginInput struct {
@@ -71,12 +77,16 @@ func SignUp(c *gin.Context) {
 		return
 	}
 
-	// Create User
+	// Create User, starting in the currency of the country they signed up from
+	country := detectCountry(c)
 	user := User{
-		Name:     input.Name,
-		Email:    input.Email,
-		Password: string(hashedPassword),
-		Phone:    input.Phone,
+		Name:               input.Name,
+		Email:              input.Email,
+		Password:           string(hashedPassword),
+		Phone:              input.Phone,
+		CountryCode:        country,
+		CurrencyPreference: currencyForCountry(country),
+		Locale:             requestLocale(c),
 	}
 	if result := db.Create(&user); result.Error != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Email already exists"})
@@ -146,6 +156,18 @@ func UpdateProfile(c *gin.Context) {
 	if input.Phone != "" {
 		user.Phone = input.Phone
 	}
+	if input.TaxID != "" && input.TaxID != user.TaxID {
+		user.TaxID = input.TaxID
+		user.TaxIDVerifiedAt = nil
+	}
+	if input.Locale != "" {
+		locale := normalizeLocale(input.Locale)
+		if locale == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid locale, use a language tag such as en, hi or es-mx"})
+			return
+		}
+		user.Locale = locale
+	}
 
 	db.Save(&user)
 	c.JSON(http.StatusOK, gin.H{"message": "Profile updated", "user": user})
@@ -204,6 +226,25 @@ func AdminMiddleware() gin.HandlerFunc {
 	}
 }
 
+// currentUserID safely converts the userID set by AuthMiddleware.
+// JWT claims decode numbers as float64, but handlers may also set uint/int.
+func currentUserID(c *gin.Context) (uint, bool) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		return 0, false
+	}
+	switch v := userID.(type) {
+	case float64:
+		return uint(v), true
+	case uint:
+		return v, true
+	case int:
+		return uint(v), true
+	default:
+		return 0, false
+	}
+}
+
 // --- JWT Helper ---
 var jwtSecret = []byte("super_secret_key_change_this_in_production")
 
@@ -3,12 +3,14 @@ package main
 import (
 	"errors"
 	"fmt"
+	"kaivaliyayoga/internal/mailer"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // --- Model ---
@@ -42,6 +44,39 @@ type BookingInput struct {
 	PaymentID *uint `json:"payment_id"` // Optional
 }
 
+// --- Logic ---
+
+// notifyBooking sends the confirmation or cancellation, with a calendar
+// invitation, in the booking's transaction
+func notifyBooking(tx *gorm.DB, booking Booking, class Class) error {
+	var user User
+	tx.First(&user, booking.UserID)
+	when := class.Day + " " + class.Time
+	var attachments []mailer.Attachment
+	if event, err := bookingEvent(booking, class); err == nil {
+		attachments = append(attachments, calendarInvite(event, user))
+	}
+
+	if booking.Status != "cancelled" {
+		return Notify(tx, user.ID, EventBookingConfirmed, NotificationContent{
+			Title: "Booking confirmed",
+			Body:  fmt.Sprintf("You're booked into %s, %s.", class.Name, when),
+			URL:   frontendURL() + "/bookings",
+			Email: func(tx *gorm.DB, to string) error {
+				return SendBookingConfirmation(tx, to, user.Name, class.Name, when, attachments...)
+			},
+		})
+	}
+	return Notify(tx, user.ID, EventBookingCancelled, NotificationContent{
+		Title: "Booking cancelled",
+		Body:  fmt.Sprintf("Your booking for %s, %s has been cancelled.", class.Name, when),
+		URL:   frontendURL() + "/bookings",
+		Email: func(tx *gorm.DB, to string) error {
+			return SendBookingCancellation(tx, to, user.Name, class.Name, when, attachments...)
+		},
+	})
+}
+
 // --- Handlers ---
 
 // CreateBooking - Protected - Book a class
@@ -67,15 +102,16 @@ func CreateBooking(c *gin.Context) {
 			return errors.New("invalid user id type")
 		}
 
+		// Locked against concurrent bookings and seat holds for the class
 		var class Class
-		if err := tx.First(&class, input.ClassID).Error; err != nil {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&class, input.ClassID).Error; err != nil {
 			return errors.New("class not found")
 		}
 
-		// Check capacity
+		// Check capacity, counting seats held by other customers mid-checkout
 		var count int64
 		tx.Model(&Booking{}).Where("class_id = ? AND status = ?", input.ClassID, "confirmed").Count(&count)
-		if count >= int64(class.Capacity) {
+		if count+heldSeats(tx, input.ClassID, uid) >= int64(class.Capacity) {
 			return errors.New("class is full")
 		}
 
@@ -85,73 +121,72 @@ func CreateBooking(c *gin.Context) {
 			return errors.New("already booked this class")
 		}
 
-		// 1. Check Active Membership
-		var memberships []Membership
-		if err := tx.Where("user_id = ? AND status = ?", uid, "active").Order("end_date asc").Find(&memberships).Error; err == nil {
-			for i := range memberships {
-				mem := &memberships[i]
-
-				// Check Expiration
-				if time.Now().After(mem.EndDate) {
-					mem.Status = "expired"
-					tx.Save(mem)
-					continue
+		// 1. Check Active Membership (own first, then shared household passes)
+		memberships, err := BookableMemberships(tx, uid)
+		if err != nil {
+			return err
+		}
+		var limitErr error
+		for i := range memberships {
+			mem := &memberships[i]
+
+			// Check Expiration
+			if time.Now().After(mem.EndDate) {
+				if err := expireMembership(tx, mem); err != nil {
+					return err
 				}
+				continue
+			}
+
+			// Unlimited (Monthly/Quarterly) or Credit Pack with credits left
+			if mem.Credits != -1 && mem.Credits <= 0 {
+				continue
+			}
 
-				// Check Credits
-				if mem.Credits == -1 {
-					// Unlimited Membership (Monthly/Quarterly)
-					booking := Booking{
-						UserID:       uid,
-						ClassID:      input.ClassID,
-						Status:       "confirmed",
-						MembershipID: &mem.ID,
-					}
-					if err := tx.Create(&booking).Error; err != nil {
-						return err
-					}
-					return nil // Success via Membership
-
-				} else if mem.Credits > 0 {
-					// Drop-in / Credit Pack
-					mem.Credits -= 1
-					if mem.Credits == 0 {
-						mem.Status = "expired"
-					}
-					if err := tx.Save(mem).Error; err != nil {
-						return err
-					}
-
-					booking := Booking{
-						UserID:       uid,
-						ClassID:      input.ClassID,
-						Status:       "confirmed",
-						MembershipID: &mem.ID,
-					}
-					if err := tx.Create(&booking).Error; err != nil {
-						return err
-					}
-					return nil // Success via Credit
+			if err := CheckHouseholdLimits(tx, mem, uid); err != nil {
+				limitErr = err
+				continue
+			}
+
+			booking := Booking{
+				UserID:       uid,
+				ClassID:      input.ClassID,
+				Status:       "confirmed",
+				MembershipID: &mem.ID,
+			}
+			if err := tx.Create(&booking).Error; err != nil {
+				return err
+			}
+
+			if err := RecordCreditEntry(tx, mem, CreditLedgerEntry{
+				UserID:    uid,
+				Type:      CreditUse,
+				Delta:     -1,
+				BookingID: &booking.ID,
+				Reason:    describeHouseholdBooking(mem, uid, class.Name),
+			}); err != nil {
+				return err
+			}
+			if mem.Credits == 0 {
+				mem.Status = "expired"
+				if err := tx.Model(mem).Update("status", "expired").Error; err != nil {
+					return err
 				}
 			}
+			return notifyBooking(tx, booking, class) // Success via Membership
 		}
 
 		// 2. Fallback: Pay-Per-Class (Direct Payment)
 		if input.PaymentID != nil && *input.PaymentID > 0 {
-			// Check Payment
-			var payment Payment
-			if err := tx.First(&payment, *input.PaymentID).Error; err != nil {
-				return errors.New("payment not found")
-			}
-
-			if payment.UserID != uid {
-				return errors.New("payment does not belong to user")
-			}
-			if payment.Status != "success" {
-				return errors.New("payment not completed")
+			// Check Payment was for this class and spend it
+			payment, err := ConsumePayment(tx, *input.PaymentID, "booking", func(p *Payment) error {
+				return checkProductPayment(p, uid, ProductClass, input.ClassID)
+			})
+			if err != nil {
+				return err
 			}
 
-			// Ensure payment is not already used
+			// Payments spent before ConsumedAt was recorded
 			var existingBookingByPayment Booking
 			if err := tx.Where("payment_id = ?", *input.PaymentID).First(&existingBookingByPayment).Error; err == nil {
 				return errors.New("payment already used for another booking")
@@ -168,9 +203,15 @@ func CreateBooking(c *gin.Context) {
 			if err := tx.Create(&booking).Error; err != nil {
 				return err
 			}
-			return nil // Success via Payment
+			if err := ConvertSeatHold(tx, payment.ID); err != nil {
+				return err
+			}
+			return notifyBooking(tx, booking, class) // Success via Payment
 		}
 
+		if limitErr != nil {
+			return limitErr
+		}
 		return errors.New("no active membership or valid payment provided")
 	})
 
@@ -185,23 +226,6 @@ func CreateBooking(c *gin.Context) {
 		return
 	}
 
-	// Send Email Confirmation (Async)
-	go func() {
-		var user User
-		if err := db.Where("id = ?", uid).First(&user).Error; err != nil {
-			fmt.Printf("EMAIL ERROR: Could not find user %d: %v\n", uid, err)
-			return
-		}
-		if user.Email == "" {
-			fmt.Printf("EMAIL ERROR: User %d has no email\n", uid)
-			return
-		}
-		var class Class
-		db.First(&class, input.ClassID)
-		fmt.Printf("Sending confirmation to %s for class %s\n", user.Email, class.Name)
-		SendBookingConfirmation(user.Email, user.Name, class.Name, class.Day+" "+class.Time)
-	}()
-
 	c.JSON(http.StatusCreated, gin.H{"message": "Booking successful"})
 }
 
@@ -228,28 +252,50 @@ func CancelBooking(c *gin.Context) {
 		return
 	}
 
+	if booking.Status == "cancelled" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Booking already cancelled"})
+		return
+	}
+
 	// Check if cancellation is allowed (e.g., specific time logic)
-	// For now, just cancel
-	booking.Status = "cancelled"
-	db.Save(&booking)
-
-	// Send Cancellation Email
-	go func() {
-		var user User
-		// Safely cast userID from context
-		var uid uint
-		if idFloat, ok := userID.(float64); ok {
-			uid = uint(idFloat)
-		} else if idUint, ok := userID.(uint); ok {
-			uid = idUint
+	// For now, just cancel and return the credit if one was used
+	err := db.Transaction(func(tx *gorm.DB) error {
+		booking.Status = "cancelled"
+		if err := tx.Save(&booking).Error; err != nil {
+			return err
+		}
+		var class Class
+		tx.First(&class, booking.ClassID)
+		if err := notifyBooking(tx, booking, class); err != nil {
+			return err
+		}
+		if booking.MembershipID == nil {
+			return nil
 		}
 
-		if db.First(&user, uid).Error == nil {
-			var class Class
-			db.First(&class, booking.ClassID)
-			SendBookingCancellation(user.Email, user.Name, class.Name, class.Day+" "+class.Time)
+		var mem Membership
+		if err := tx.First(&mem, *booking.MembershipID).Error; err != nil {
+			return nil // Membership removed, nothing to refund
 		}
-	}()
+		if err := RecordCreditEntry(tx, &mem, CreditLedgerEntry{
+			UserID:    booking.UserID,
+			Type:      CreditRefund,
+			Delta:     1,
+			BookingID: &booking.ID,
+			Reason:    "booking cancelled",
+		}); err != nil {
+			return err
+		}
+		// Re-open a depleted pack that still has time left
+		if mem.Status == "expired" && mem.Credits > 0 && mem.EndDate.After(time.Now()) {
+			return tx.Model(&mem).Update("status", "active").Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel booking"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Booking cancelled"})
 }
@@ -0,0 +1,769 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kaivaliyayoga/internal/caldav"
+	"kaivaliyayoga/internal/ical"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Calendar connection statuses
+const (
+	CalendarSyncActive = "active"
+	CalendarSyncError  = "error"
+)
+
+// Sync conflict kinds. The platform is the source of truth for appointments,
+// so conflicts are recorded for the professional to review rather than
+// blocking the sync.
+const (
+	ConflictRemoteModified = "remote_modified" // An appointment event was edited in the external calendar and has been overwritten
+	ConflictRemoteDeleted  = "remote_deleted"  // An appointment event was deleted in the external calendar and has been put back
+	ConflictDoubleBooked   = "double_booked"   // An external event overlaps a booked appointment
+)
+
+// How long a sync may hold a connection before another worker may take it over
+const calendarSyncClaimTimeout = 5 * time.Minute
+
+// calendarSyncQueue holds professionals whose appointments changed, so their
+// calendars are updated without waiting for the next poll
+var calendarSyncQueue = struct {
+	sync.Mutex
+	ids  map[uuid.UUID]bool
+	wake chan struct{}
+}{ids: map[uuid.UUID]bool{}, wake: make(chan struct{}, 1)}
+
+// --- Models ---
+
+// CalendarConnection links a professional to a calendar collection on their
+// own CalDAV server (Radicale, Nextcloud, Fastmail, iCloud and so on)
+type CalendarConnection struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	ProfessionalID uuid.UUID  `json:"professional_id" gorm:"type:uuid;uniqueIndex;not null"`
+	CalendarURL    string     `json:"calendar_url" gorm:"not null"`
+	Username       string     `json:"username"`
+	Password       string     `json:"-"`
+	WriteBack      bool       `json:"write_back"` // Copy appointments into the calendar
+	SyncToken      string     `json:"-"`
+	Status         string     `json:"status" gorm:"default:'active'"`
+	LastError      string     `json:"last_error"`
+	LastSyncedAt   *time.Time `json:"last_synced_at"`
+	LockedUntil    *time.Time `json:"-"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// ExternalCalendarObject is our copy of an event from the external calendar,
+// kept so each sync only needs to fetch what changed
+type ExternalCalendarObject struct {
+	ID           uint   `gorm:"primaryKey"`
+	ConnectionID uint   `gorm:"uniqueIndex:idx_external_calendar_object;not null"`
+	Href         string `gorm:"uniqueIndex:idx_external_calendar_object;not null"`
+	ETag         string
+	Data         string `gorm:"type:text"`
+	UpdatedAt    time.Time
+}
+
+// ExternalBusyBlock is a time the professional is busy elsewhere. Blocks are
+// rebuilt from the external calendar on every sync.
+type ExternalBusyBlock struct {
+	ID             uint      `json:"-" gorm:"primaryKey"`
+	ConnectionID   uint      `json:"-" gorm:"index;not null"`
+	ProfessionalID uuid.UUID `json:"-" gorm:"type:uuid;index;not null"`
+	StartTime      time.Time `json:"start_time" gorm:"index"`
+	EndTime        time.Time `json:"end_time"`
+}
+
+// AppointmentCalendarEvent records where an appointment was written in the
+// external calendar, and which version of it
+type AppointmentCalendarEvent struct {
+	ID            uint      `gorm:"primaryKey"`
+	ConnectionID  uint      `gorm:"uniqueIndex:idx_appointment_calendar_event;not null"`
+	AppointmentID uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_appointment_calendar_event;not null"`
+	Href          string    `gorm:"index"`
+	ETag          string
+	Sequence      int // Appointment.CalendarSequence when written; -1 forces a rewrite
+	UpdatedAt     time.Time
+}
+
+// CalendarSyncConflict is something the professional should look at
+type CalendarSyncConflict struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	ConnectionID   uint       `json:"-" gorm:"index"`
+	ProfessionalID uuid.UUID  `json:"-" gorm:"type:uuid;index"`
+	AppointmentID  *uuid.UUID `json:"appointment_id" gorm:"type:uuid"`
+	Kind           string     `json:"kind"`
+	Detail         string     `json:"detail"`
+	ResolvedAt     *time.Time `json:"resolved_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// --- DTOs ---
+
+type CalendarConnectionInput struct {
+	CalendarURL string `json:"calendar_url" binding:"required,url"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	WriteBack   *bool  `json:"write_back"` // Defaults to true
+}
+
+// CalendarSyncResult counts what one sync did
+type CalendarSyncResult struct {
+	Fetched   int  `json:"fetched"`   // External events added or changed
+	Removed   int  `json:"removed"`   // External events deleted
+	Busy      int  `json:"busy"`      // Busy blocks within the horizon
+	Written   int  `json:"written"`   // Appointments written to the calendar
+	Deleted   int  `json:"deleted"`   // Cancelled appointments removed from the calendar
+	Conflicts int  `json:"conflicts"` // New conflicts
+	FullSync  bool `json:"full_sync"` // The sync token had expired
+}
+
+// --- Logic ---
+
+func caldavClient(conn CalendarConnection) *caldav.Client {
+	return caldav.New(conn.CalendarURL, conn.Username, conn.Password)
+}
+
+// calendarSyncHorizon is how far ahead busy blocks are expanded
+func calendarSyncHorizon(now time.Time) (time.Time, time.Time) {
+	return now.AddDate(0, 0, -1), now.AddDate(0, 0, envInt("CALDAV_HORIZON_DAYS", 90))
+}
+
+// professionalLocation is the zone for external events without one, such as
+// all-day events: the professional's own timezone, or the studio's
+func professionalLocation(professionalID uuid.UUID) *time.Location {
+	var user User
+	if db.Joins("JOIN professionals ON professionals.user_id = users.id").
+		Where("professionals.id = ?", professionalID).First(&user).Error == nil && user.Timezone != "" {
+		if loc, err := time.LoadLocation(user.Timezone); err == nil {
+			return loc
+		}
+	}
+	return studioLocation()
+}
+
+// professionalBusy reports whether the professional's external calendar has
+// anything between start and end
+func professionalBusy(professionalID uuid.UUID, start, end time.Time) bool {
+	var count int64
+	db.Model(&ExternalBusyBlock{}).
+		Where("professional_id = ? AND start_time < ? AND end_time > ?", professionalID, end, start).
+		Count(&count)
+	return count > 0
+}
+
+// wakeCalendarSync asks for the professional's calendar to be synced soon
+func wakeCalendarSync(professionalID uuid.UUID) {
+	calendarSyncQueue.Lock()
+	calendarSyncQueue.ids[professionalID] = true
+	calendarSyncQueue.Unlock()
+	select {
+	case calendarSyncQueue.wake <- struct{}{}:
+	default:
+	}
+}
+
+// claimCalendarConnection takes a connection for this worker, so two servers
+// never write the same calendar at once
+func claimCalendarConnection(id uint) (CalendarConnection, bool) {
+	now := time.Now()
+	lockedUntil := now.Add(calendarSyncClaimTimeout)
+	res := db.Model(&CalendarConnection{}).
+		Where("id = ? AND (locked_until IS NULL OR locked_until < ?)", id, now).
+		Update("locked_until", &lockedUntil)
+	if res.Error != nil || res.RowsAffected != 1 {
+		return CalendarConnection{}, false
+	}
+	var conn CalendarConnection
+	if db.First(&conn, id).Error != nil {
+		return CalendarConnection{}, false
+	}
+	return conn, true
+}
+
+// runCalendarSync syncs a claimed connection and records the outcome
+func runCalendarSync(conn CalendarConnection) (CalendarSyncResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), calendarSyncClaimTimeout)
+	defer cancel()
+	result, err := syncCalendarConnection(ctx, &conn)
+
+	updates := map[string]interface{}{"locked_until": nil, "sync_token": conn.SyncToken}
+	if err != nil {
+		updates["status"] = CalendarSyncError
+		updates["last_error"] = err.Error()
+	} else {
+		now := time.Now()
+		updates["status"] = CalendarSyncActive
+		updates["last_error"] = ""
+		updates["last_synced_at"] = &now
+	}
+	db.Model(&CalendarConnection{}).Where("id = ?", conn.ID).Updates(updates)
+	return result, err
+}
+
+// syncCalendarConnection pulls changes from the external calendar, rebuilds
+// the busy blocks and pushes appointments back. conn.SyncToken is advanced
+// once the changes are stored.
+func syncCalendarConnection(ctx context.Context, conn *CalendarConnection) (CalendarSyncResult, error) {
+	var result CalendarSyncResult
+	client := caldavClient(*conn)
+
+	changes, err := client.Sync(ctx, conn.SyncToken)
+	if errors.Is(err, caldav.ErrSyncTokenInvalid) {
+		result.FullSync = true
+		changes, err = client.Sync(ctx, "")
+	}
+	if conn.SyncToken == "" {
+		result.FullSync = true
+	}
+	if err != nil {
+		return result, err
+	}
+
+	var written []AppointmentCalendarEvent
+	db.Where("connection_id = ?", conn.ID).Find(&written)
+	ours := map[string]*AppointmentCalendarEvent{}
+	for i := range written {
+		ours[written[i].Href] = &written[i]
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		seen := map[string]bool{}
+		if result.FullSync {
+			if err := tx.Where("connection_id = ?", conn.ID).Delete(&ExternalCalendarObject{}).Error; err != nil {
+				return err
+			}
+		}
+		for _, obj := range changes.Updated {
+			seen[obj.Href] = true
+			if ev, ok := ours[obj.Href]; ok {
+				// Our own write comes back with the ETag we stored; anything
+				// else was edited in the calendar app
+				if conn.WriteBack && ev.ETag != "" && obj.ETag != ev.ETag {
+					result.Conflicts += recordCalendarConflict(tx, *conn, &ev.AppointmentID, ConflictRemoteModified,
+						"The appointment was edited in your calendar, and has been restored to match the booking")
+					ev.Sequence = -1
+				}
+				ev.ETag = obj.ETag
+				if err := tx.Save(ev).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			record := ExternalCalendarObject{ConnectionID: conn.ID, Href: obj.Href}
+			if err := tx.Where(record).Assign(ExternalCalendarObject{ETag: obj.ETag, Data: string(obj.Data)}).
+				FirstOrCreate(&record).Error; err != nil {
+				return err
+			}
+			result.Fetched++
+		}
+
+		deleted := changes.Deleted
+		if result.FullSync {
+			for href := range ours {
+				if !seen[href] {
+					deleted = append(deleted, href)
+				}
+			}
+		}
+		for _, href := range deleted {
+			if ev, ok := ours[href]; ok {
+				var appointment Appointment
+				tx.Select("status").Where("id = ?", ev.AppointmentID).First(&appointment)
+				if conn.WriteBack && appointment.Status != "cancelled" {
+					result.Conflicts += recordCalendarConflict(tx, *conn, &ev.AppointmentID, ConflictRemoteDeleted,
+						"The appointment was deleted from your calendar, and has been put back. Cancel it here to remove it.")
+				}
+				if err := tx.Delete(ev).Error; err != nil {
+					return err
+				}
+				delete(ours, href)
+				continue
+			}
+			res := tx.Where("connection_id = ? AND href = ?", conn.ID, href).Delete(&ExternalCalendarObject{})
+			if res.Error != nil {
+				return res.Error
+			}
+			result.Removed += int(res.RowsAffected)
+		}
+
+		busy, err := rebuildBusyBlocks(tx, *conn)
+		result.Busy = busy
+		return err
+	})
+	if err != nil {
+		return result, err
+	}
+	conn.SyncToken = changes.SyncToken
+
+	result.Conflicts += detectDoubleBookings(*conn)
+	if conn.WriteBack {
+		err = writeBackAppointments(ctx, client, *conn, &result)
+	}
+	return result, err
+}
+
+// rebuildBusyBlocks replaces the connection's busy blocks with those in the
+// stored external events
+func rebuildBusyBlocks(tx *gorm.DB, conn CalendarConnection) (int, error) {
+	var objects []ExternalCalendarObject
+	if err := tx.Where("connection_id = ?", conn.ID).Find(&objects).Error; err != nil {
+		return 0, err
+	}
+	loc := professionalLocation(conn.ProfessionalID)
+	from, until := calendarSyncHorizon(time.Now())
+	ownUID := "@" + calendarHost()
+
+	var blocks []ExternalBusyBlock
+	for _, obj := range objects {
+		events, err := ical.Parse([]byte(obj.Data), loc)
+		if err != nil {
+			fmt.Printf("Calendar sync: skipping %s for connection %d: %v\n", obj.Href, conn.ID, err)
+			continue
+		}
+		// Our own appointments, e.g. copied there by an earlier connection,
+		// are already blocked
+		kept := events[:0]
+		for _, e := range events {
+			if !(strings.HasPrefix(e.UID, "appointment-") && strings.HasSuffix(e.UID, ownUID)) {
+				kept = append(kept, e)
+			}
+		}
+		for _, p := range ical.Busy(kept, from, until) {
+			blocks = append(blocks, ExternalBusyBlock{
+				ConnectionID:   conn.ID,
+				ProfessionalID: conn.ProfessionalID,
+				StartTime:      p.Start,
+				EndTime:        p.End,
+			})
+		}
+	}
+
+	if err := tx.Where("connection_id = ?", conn.ID).Delete(&ExternalBusyBlock{}).Error; err != nil {
+		return 0, err
+	}
+	if len(blocks) > 0 {
+		if err := tx.CreateInBatches(blocks, 200).Error; err != nil {
+			return 0, err
+		}
+	}
+	return len(blocks), nil
+}
+
+// detectDoubleBookings flags upcoming appointments that now overlap something
+// in the external calendar
+func detectDoubleBookings(conn CalendarConnection) int {
+	var appointments []Appointment
+	db.Where("professional_id = ? AND status <> ? AND end_time > ?", conn.ProfessionalID, "cancelled", time.Now()).
+		Find(&appointments)
+
+	conflicts := 0
+	for _, a := range appointments {
+		if !professionalBusy(conn.ProfessionalID, a.StartTime, a.EndTime) {
+			continue
+		}
+		conflicts += recordCalendarConflict(db, conn, &a.ID, ConflictDoubleBooked,
+			fmt.Sprintf("Appointment %s overlaps an event in your calendar", a.ReferenceCode))
+	}
+	return conflicts
+}
+
+// recordCalendarConflict adds a conflict unless the same one is still open,
+// returning how many were added
+func recordCalendarConflict(tx *gorm.DB, conn CalendarConnection, appointmentID *uuid.UUID, kind, detail string) int {
+	var open int64
+	tx.Model(&CalendarSyncConflict{}).
+		Where("connection_id = ? AND appointment_id = ? AND kind = ? AND resolved_at IS NULL", conn.ID, appointmentID, kind).
+		Count(&open)
+	if open > 0 {
+		return 0
+	}
+	tx.Create(&CalendarSyncConflict{
+		ConnectionID:   conn.ID,
+		ProfessionalID: conn.ProfessionalID,
+		AppointmentID:  appointmentID,
+		Kind:           kind,
+		Detail:         detail,
+	})
+	return 1
+}
+
+// writeBackAppointments puts new and changed appointments into the external
+// calendar and removes cancelled ones. Where the calendar copy was edited,
+// the booking wins.
+func writeBackAppointments(ctx context.Context, client *caldav.Client, conn CalendarConnection, result *CalendarSyncResult) error {
+	from, _ := calendarSyncHorizon(time.Now())
+	var appointments []Appointment
+	db.Preload("Service").Preload("Client").
+		Where("professional_id = ? AND end_time > ?", conn.ProfessionalID, from).Find(&appointments)
+
+	var written []AppointmentCalendarEvent
+	db.Where("connection_id = ?", conn.ID).Find(&written)
+	byAppointment := map[uuid.UUID]AppointmentCalendarEvent{}
+	for _, ev := range written {
+		byAppointment[ev.AppointmentID] = ev
+	}
+
+	var failures []string
+	for _, a := range appointments {
+		ev, exists := byAppointment[a.ID]
+		var err error
+		switch {
+		case a.Status == "cancelled" && exists:
+			err = removeAppointmentEvent(ctx, client, ev)
+			if err == nil {
+				result.Deleted++
+			}
+		case a.Status != "cancelled" && (!exists || ev.Sequence != a.CalendarSequence):
+			err = putAppointmentEvent(ctx, client, conn, a, ev, exists)
+			if err == nil {
+				result.Written++
+			}
+		}
+		if err != nil {
+			failures = append(failures, a.ReferenceCode+": "+err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("could not update %d appointments: %s", len(failures), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func putAppointmentEvent(ctx context.Context, client *caldav.Client, conn CalendarConnection, a Appointment, ev AppointmentCalendarEvent, exists bool) error {
+	event := appointmentEvent(a, a.Service.Name, a.Client.Name)
+	data := ical.Calendar{Events: []ical.Event{event}}.Bytes()
+	if !exists {
+		ev = AppointmentCalendarEvent{ConnectionID: conn.ID, AppointmentID: a.ID, Href: client.Href("appointment-" + a.ID.String() + ".ics")}
+	}
+
+	etag, err := client.Put(ctx, ev.Href, data, ev.ETag)
+	if errors.Is(err, caldav.ErrPreconditionFailed) {
+		// Changed since we last saw it, or already there from an earlier
+		// connection: overwrite whatever is in the calendar now
+		current, getErr := client.Get(ctx, ev.Href)
+		switch {
+		case errors.Is(getErr, caldav.ErrNotFound):
+			etag, err = client.Put(ctx, ev.Href, data, "")
+		case getErr != nil:
+			return getErr
+		default:
+			etag, err = client.Put(ctx, ev.Href, data, current.ETag)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if etag == "" {
+		// The server didn't say; read it back so the next sync recognises our write
+		if current, err := client.Get(ctx, ev.Href); err == nil {
+			etag = current.ETag
+		}
+	}
+	ev.ETag = etag
+	ev.Sequence = a.CalendarSequence
+	return db.Save(&ev).Error
+}
+
+func removeAppointmentEvent(ctx context.Context, client *caldav.Client, ev AppointmentCalendarEvent) error {
+	err := client.Delete(ctx, ev.Href, ev.ETag)
+	if errors.Is(err, caldav.ErrPreconditionFailed) {
+		err = client.Delete(ctx, ev.Href, "") // The appointment is cancelled whatever the calendar says
+	}
+	if err != nil && !errors.Is(err, caldav.ErrNotFound) {
+		return err
+	}
+	return db.Delete(&ev).Error
+}
+
+// SyncCalendarConnections syncs every connected calendar, or only those of
+// the given professionals
+func SyncCalendarConnections(professionalIDs ...uuid.UUID) (synced, failed int) {
+	query := db.Model(&CalendarConnection{})
+	if len(professionalIDs) > 0 {
+		query = query.Where("professional_id IN ?", professionalIDs)
+	}
+	var ids []uint
+	query.Pluck("id", &ids)
+
+	for _, id := range ids {
+		conn, ok := claimCalendarConnection(id)
+		if !ok {
+			continue
+		}
+		if _, err := runCalendarSync(conn); err != nil {
+			fmt.Printf("Calendar sync: connection %d failed: %v\n", conn.ID, err)
+			failed++
+		} else {
+			synced++
+		}
+	}
+	return synced, failed
+}
+
+// startCalendarSync syncs all calendars every CALDAV_SYNC_MINUTES, and those
+// of professionals whose appointments changed as soon as they are woken
+func startCalendarSync() {
+	interval := time.Duration(envInt("CALDAV_SYNC_MINUTES", 10)) * time.Minute
+	go func() {
+		next := time.Now()
+		for {
+			if !time.Now().Before(next) {
+				if synced, failed := SyncCalendarConnections(); synced+failed > 0 {
+					fmt.Printf("Background Job: Synced %d external calendars (%d failed)\n", synced, failed)
+				}
+				next = time.Now().Add(interval)
+			}
+			select {
+			case <-calendarSyncQueue.wake:
+				calendarSyncQueue.Lock()
+				var ids []uuid.UUID
+				for id := range calendarSyncQueue.ids {
+					ids = append(ids, id)
+				}
+				calendarSyncQueue.ids = map[uuid.UUID]bool{}
+				calendarSyncQueue.Unlock()
+				if len(ids) > 0 {
+					SyncCalendarConnections(ids...)
+				}
+			case <-time.After(time.Until(next)):
+			}
+		}
+	}()
+}
+
+// currentProfessional is the professional profile of the signed-in user
+func currentProfessional(c *gin.Context) (Professional, bool) {
+	uid, _ := currentUserID(c)
+	var professional Professional
+	if db.Where("user_id = ?", uid).First(&professional).Error != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only professionals can connect a calendar"})
+		return professional, false
+	}
+	return professional, true
+}
+
+// --- Handlers ---
+
+// GetCalendarConnection - Protected - The professional's CalDAV connection and open conflicts
+func GetCalendarConnection(c *gin.Context) {
+	professional, ok := currentProfessional(c)
+	if !ok {
+		return
+	}
+	var conn CalendarConnection
+	if err := db.Where("professional_id = ?", professional.ID).First(&conn).Error; err != nil {
+		c.JSON(http.StatusOK, gin.H{"connected": false})
+		return
+	}
+	var busy, conflicts int64
+	db.Model(&ExternalBusyBlock{}).Where("connection_id = ? AND end_time > ?", conn.ID, time.Now()).Count(&busy)
+	db.Model(&CalendarSyncConflict{}).Where("connection_id = ? AND resolved_at IS NULL", conn.ID).Count(&conflicts)
+	c.JSON(http.StatusOK, gin.H{
+		"connected":      true,
+		"connection":     conn,
+		"busy_blocks":    busy,
+		"open_conflicts": conflicts,
+	})
+}
+
+// ConnectCalendar - Protected - Connect (or change) the professional's CalDAV calendar and run a first sync
+func ConnectCalendar(c *gin.Context) {
+	professional, ok := currentProfessional(c)
+	if !ok {
+		return
+	}
+	var input CalendarConnectionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var conn CalendarConnection
+	existing := db.Where("professional_id = ?", professional.ID).First(&conn).Error == nil
+	if existing && input.Password == "" && input.Username == conn.Username {
+		input.Password = conn.Password // Keep the saved password when only other settings change
+	}
+	candidate := CalendarConnection{CalendarURL: input.CalendarURL, Username: input.Username, Password: input.Password}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+	if err := caldavClient(candidate).Check(ctx); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not open the calendar: " + err.Error()})
+		return
+	}
+
+	movedCalendar := existing && (conn.CalendarURL != input.CalendarURL || conn.Username != input.Username)
+	conn.ProfessionalID = professional.ID
+	conn.CalendarURL = input.CalendarURL
+	conn.Username = input.Username
+	conn.Password = input.Password
+	conn.WriteBack = input.WriteBack == nil || *input.WriteBack
+	conn.Status = CalendarSyncActive
+	conn.LastError = ""
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if movedCalendar {
+			// Start afresh: nothing from the old calendar applies any more
+			conn.SyncToken = ""
+			for _, model := range []interface{}{&ExternalCalendarObject{}, &ExternalBusyBlock{}, &AppointmentCalendarEvent{}} {
+				if err := tx.Where("connection_id = ?", conn.ID).Delete(model).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Save(&conn).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save calendar connection"})
+		return
+	}
+
+	wakeCalendarSync(professional.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Calendar connected; the first sync is under way", "connection": conn})
+}
+
+// DisconnectCalendar - Protected - Stop syncing. Events already written to the calendar are left there.
+func DisconnectCalendar(c *gin.Context) {
+	professional, ok := currentProfessional(c)
+	if !ok {
+		return
+	}
+	var conn CalendarConnection
+	if err := db.Where("professional_id = ?", professional.ID).First(&conn).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No calendar connected"})
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&ExternalCalendarObject{}, &ExternalBusyBlock{}, &AppointmentCalendarEvent{}, &CalendarSyncConflict{}} {
+			if err := tx.Where("connection_id = ?", conn.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&conn).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disconnect calendar"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Calendar disconnected"})
+}
+
+// SyncCalendarNow - Protected - Sync the professional's calendar straight away
+func SyncCalendarNow(c *gin.Context) {
+	professional, ok := currentProfessional(c)
+	if !ok {
+		return
+	}
+	var conn CalendarConnection
+	if err := db.Where("professional_id = ?", professional.ID).First(&conn).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No calendar connected"})
+		return
+	}
+	conn, claimed := claimCalendarConnection(conn.ID)
+	if !claimed {
+		c.JSON(http.StatusConflict, gin.H{"error": "A sync is already running"})
+		return
+	}
+	result, err := runCalendarSync(conn)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Calendar sync failed: " + err.Error(), "result": result})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Calendar synced", "result": result})
+}
+
+// GetCalendarConflicts - Protected - Sync conflicts, open ones only unless ?all=true
+func GetCalendarConflicts(c *gin.Context) {
+	professional, ok := currentProfessional(c)
+	if !ok {
+		return
+	}
+	query := db.Where("professional_id = ?", professional.ID)
+	if c.Query("all") != "true" {
+		query = query.Where("resolved_at IS NULL")
+	}
+	var conflicts []CalendarSyncConflict
+	query.Order("created_at desc").Limit(200).Find(&conflicts)
+	c.JSON(http.StatusOK, gin.H{"data": conflicts})
+}
+
+// ResolveCalendarConflict - Protected - Mark a conflict as dealt with
+func ResolveCalendarConflict(c *gin.Context) {
+	professional, ok := currentProfessional(c)
+	if !ok {
+		return
+	}
+	var conflict CalendarSyncConflict
+	if err := db.Where("id = ? AND professional_id = ?", c.Param("id"), professional.ID).First(&conflict).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conflict not found"})
+		return
+	}
+	now := time.Now()
+	db.Model(&conflict).Update("resolved_at", &now)
+	c.JSON(http.StatusOK, gin.H{"message": "Conflict resolved"})
+}
+
+// GetProfessionalBusyTimes - Public - When a professional can't be booked between from and to (RFC3339), without any details
+func GetProfessionalBusyTimes(c *gin.Context) {
+	professionalID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid professional ID"})
+		return
+	}
+	from, until := time.Now(), time.Now().AddDate(0, 0, 14)
+	if s := c.Query("from"); s != "" {
+		if from, err = time.Parse(time.RFC3339, s); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from, use RFC3339"})
+			return
+		}
+	}
+	if s := c.Query("to"); s != "" {
+		if until, err = time.Parse(time.RFC3339, s); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to, use RFC3339"})
+			return
+		}
+	}
+	if !until.After(from) || until.Sub(from) > 62*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must be after from, and at most 62 days later"})
+		return
+	}
+
+	var busy []ical.Period
+	var blocks []ExternalBusyBlock
+	db.Where("professional_id = ? AND start_time < ? AND end_time > ?", professionalID, until, from).Find(&blocks)
+	for _, b := range blocks {
+		busy = append(busy, ical.Period{Start: b.StartTime, End: b.EndTime})
+	}
+	var appointments []Appointment
+	db.Where("professional_id = ? AND status <> ? AND start_time < ? AND end_time > ?", professionalID, "cancelled", until, from).
+		Find(&appointments)
+	for _, a := range appointments {
+		busy = append(busy, ical.Period{Start: a.StartTime, End: a.EndTime})
+	}
+	c.JSON(http.StatusOK, gin.H{"data": mergePeriods(busy)})
+}
+
+// mergePeriods sorts periods and joins those that overlap or touch
+func mergePeriods(periods []ical.Period) []ical.Period {
+	sort.Slice(periods, func(i, j int) bool { return periods[i].Start.Before(periods[j].Start) })
+	merged := []ical.Period{}
+	for _, p := range periods {
+		if n := len(merged); n > 0 && !p.Start.After(merged[n-1].End) {
+			if p.End.After(merged[n-1].End) {
+				merged[n-1].End = p.End
+			}
+			continue
+		}
+		merged = append(merged, p)
+	}
+	return merged
+}
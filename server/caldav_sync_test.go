@@ -0,0 +1,27 @@
+package main
+
+import (
+	"kaivaliyayoga/internal/ical"
+	"testing"
+	"time"
+)
+
+func TestMergePeriods(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2026, 10, 20, hour, 0, 0, 0, time.UTC) }
+	merged := mergePeriods([]ical.Period{
+		{Start: at(14), End: at(15)},
+		{Start: at(9), End: at(11)},
+		{Start: at(10), End: at(12)}, // Overlaps
+		{Start: at(12), End: at(13)}, // Touches
+		{Start: at(10), End: at(11)}, // Inside
+	})
+	want := []ical.Period{{Start: at(9), End: at(13)}, {Start: at(14), End: at(15)}}
+	if len(merged) != len(want) {
+		t.Fatalf("merged = %v, want %v", merged, want)
+	}
+	for i := range want {
+		if !merged[i].Start.Equal(want[i].Start) || !merged[i].End.Equal(want[i].End) {
+			t.Errorf("period %d = %v, want %v", i, merged[i], want[i])
+		}
+	}
+}
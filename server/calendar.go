@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"kaivaliyayoga/internal/ical"
+	"kaivaliyayoga/internal/mailer"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Calendar feed kinds
+const (
+	FeedPersonal     = "personal"     // The user's bookings and appointments
+	FeedProfessional = "professional" // A professional's appointments and classes
+)
+
+// How long calendar apps should wait between refreshes of a feed
+const calendarFeedRefresh = time.Hour
+
+// --- Models ---
+
+// CalendarFeed is a private, revocable link to one of a user's calendars.
+// Calendar apps can't log in, so the token is the only credential.
+type CalendarFeed struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	UserID         uint       `json:"user_id" gorm:"uniqueIndex:idx_calendar_feed"`
+	Kind           string     `json:"kind" gorm:"uniqueIndex:idx_calendar_feed"`
+	Token          string     `json:"-" gorm:"uniqueIndex;not null"`
+	LastAccessedAt *time.Time `json:"last_accessed_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// --- Logic ---
+
+// calendarHost qualifies event UIDs so they are globally unique
+func calendarHost() string {
+	if u, err := url.Parse(frontendURL()); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "kaivaliyayoga.com"
+}
+
+// studioOrganizer is the ORGANIZER of invitations, the address emails come from
+func studioOrganizer() *ical.Person {
+	email := os.Getenv("SMTP_FROM")
+	if email == "" {
+		email = os.Getenv("SMTP_USER")
+	}
+	if email == "" {
+		return nil
+	}
+	return &ical.Person{Name: "Kaivaliya Yoga", Email: email}
+}
+
+// classLocation is where a class happens: its meeting link when online
+func classLocation(class Class) string {
+	if class.LocationType != "in-person" && class.MeetingURL != "" {
+		return class.MeetingURL
+	}
+	if class.Address != "" {
+		if class.City != "" && !strings.Contains(class.Address, class.City) {
+			return class.Address + ", " + class.City
+		}
+		return class.Address
+	}
+	if address := os.Getenv("STUDIO_ADDRESS"); address != "" {
+		return address
+	}
+	return "Kaivaliya Yoga studio"
+}
+
+// classDuration falls back to an hour for classes without a duration
+func classDuration(class Class) time.Duration {
+	if class.Duration <= 0 {
+		return time.Hour
+	}
+	return time.Duration(class.Duration) * time.Minute
+}
+
+// bookingEvent is the session a booking is for. A booking is never moved, so
+// the cancellation is its only update.
+func bookingEvent(booking Booking, class Class) (ical.Event, error) {
+	start, err := classOccurrenceAfter(class, booking.CreatedAt, studioLocation())
+	if err != nil {
+		return ical.Event{}, err
+	}
+	event := ical.Event{
+		UID:         fmt.Sprintf("booking-%d@%s", booking.ID, calendarHost()),
+		Start:       start,
+		End:         start.Add(classDuration(class)),
+		Summary:     class.Name,
+		Description: strings.TrimSpace(fmt.Sprintf("%s class with %s.\n%s", class.Level, class.Teacher, class.Description)),
+		Location:    classLocation(class),
+		URL:         frontendURL() + "/bookings",
+		Status:      ical.StatusConfirmed,
+	}
+	if booking.Status == "cancelled" {
+		event.Sequence = 1
+		event.Status = ical.StatusCancelled
+	}
+	return event, nil
+}
+
+// appointmentEvent describes an appointment for its client, or for the
+// professional when withName is the client's name
+func appointmentEvent(appointment Appointment, serviceName, withName string) ical.Event {
+	if serviceName == "" {
+		serviceName = "Appointment"
+	}
+	summary := serviceName
+	if withName != "" {
+		summary += " with " + withName
+	}
+	event := ical.Event{
+		UID:         "appointment-" + appointment.ID.String() + "@" + calendarHost(),
+		Sequence:    appointment.CalendarSequence,
+		Start:       appointment.StartTime,
+		End:         appointment.EndTime,
+		Summary:     summary,
+		Description: "Reference " + appointment.ReferenceCode,
+		Location:    appointment.MeetingLink,
+		URL:         frontendURL() + "/appointments",
+		Status:      ical.StatusConfirmed,
+	}
+	switch appointment.Status {
+	case "cancelled":
+		event.Status = ical.StatusCancelled
+	case "pending":
+		event.Status = ical.StatusTentative
+	}
+	return event
+}
+
+// calendarInvite wraps one event as an email attachment: a REQUEST, or a
+// CANCEL for cancelled events
+func calendarInvite(event ical.Event, attendee User) mailer.Attachment {
+	method := ical.MethodRequest
+	if event.Status == ical.StatusCancelled {
+		method = ical.MethodCancel
+	}
+	event.Organizer = studioOrganizer()
+	event.Attendees = []ical.Person{{Name: attendee.Name, Email: attendee.Email}}
+	cal := ical.Calendar{Method: method, Events: []ical.Event{event}}
+	return mailer.Attachment{Filename: "invite.ics", ContentType: cal.ContentType(), Data: cal.Bytes()}
+}
+
+// personalCalendar lists a user's recent and upcoming bookings and appointments
+func personalCalendar(user User, now time.Time) []ical.Event {
+	since := now.AddDate(0, 0, -envInt("CALENDAR_FEED_PAST_DAYS", 60))
+	var events []ical.Event
+
+	var bookings []Booking
+	db.Preload("Class").Where("user_id = ? AND created_at > ?", user.ID, since).Find(&bookings)
+	for _, booking := range bookings {
+		if event, err := bookingEvent(booking, booking.Class); err == nil {
+			events = append(events, event)
+		}
+	}
+
+	var appointments []Appointment
+	db.Preload("Service").Where("client_id = ? AND start_time > ?", user.ID, since).Find(&appointments)
+	for _, appointment := range appointments {
+		events = append(events, appointmentEvent(appointment, appointment.Service.Name, ""))
+	}
+	return events
+}
+
+// classOccurrences expands a weekly class into its sessions between from and until
+func classOccurrences(class Class, from, until time.Time) []ical.Event {
+	var events []ical.Event
+	loc := studioLocation()
+	start, err := classOccurrenceAfter(class, from, loc)
+	for err == nil && start.Before(until) {
+		events = append(events, ical.Event{
+			UID:         fmt.Sprintf("class-%d-%s@%s", class.ID, start.Format("20060102"), calendarHost()),
+			Start:       start,
+			End:         start.Add(classDuration(class)),
+			Summary:     class.Name,
+			Description: strings.TrimSpace(fmt.Sprintf("%s class with %s.\n%s", class.Level, class.Teacher, class.Description)),
+			Location:    classLocation(class),
+			URL:         frontendURL() + "/classes",
+			Status:      ical.StatusConfirmed,
+		})
+		start, err = classOccurrenceAfter(class, start, loc)
+	}
+	return events
+}
+
+// professionalCalendar lists a professional's appointments and the classes they teach
+func professionalCalendar(professional Professional, now time.Time) []ical.Event {
+	since := now.AddDate(0, 0, -envInt("CALENDAR_FEED_PAST_DAYS", 60))
+	var events []ical.Event
+
+	var appointments []Appointment
+	db.Preload("Service").Preload("Client").
+		Where("professional_id = ? AND start_time > ?", professional.ID, since).Find(&appointments)
+	for _, appointment := range appointments {
+		events = append(events, appointmentEvent(appointment, appointment.Service.Name, appointment.Client.Name))
+	}
+
+	var classes []Class
+	db.Where("professional_id = ?", professional.ID).Find(&classes)
+	until := now.AddDate(0, 0, 7*envInt("CALENDAR_FEED_WEEKS", 8))
+	for _, class := range classes {
+		events = append(events, classOccurrences(class, now.AddDate(0, 0, -7), until)...)
+	}
+	return events
+}
+
+// calendarFeedURLs are the links to give calendar apps for a feed token
+func calendarFeedURLs(token string) gin.H {
+	https := apiURL() + "/api/calendar/feed/" + token + ".ics"
+	webcal := https
+	if i := strings.Index(webcal, "://"); i >= 0 {
+		webcal = "webcal" + webcal[i:]
+	}
+	return gin.H{"url": https, "webcal": webcal}
+}
+
+// calendarFeed returns the user's feed of a kind, creating it on first use
+func calendarFeed(userID uint, kind string) (CalendarFeed, error) {
+	feed := CalendarFeed{UserID: userID, Kind: kind}
+	err := db.Where("user_id = ? AND kind = ?", userID, kind).
+		Attrs(CalendarFeed{Token: newInviteToken()}).
+		FirstOrCreate(&feed).Error
+	return feed, err
+}
+
+func writeCalendar(c *gin.Context, cal ical.Calendar, filename string) {
+	c.Header("Content-Disposition", `inline; filename="`+filename+`"`)
+	c.Data(http.StatusOK, cal.ContentType(), cal.Bytes())
+}
+
+// --- Handlers ---
+
+// GetCalendarFeeds - Protected - The user's private feed links, plus their schedule feed if they are a professional
+func GetCalendarFeeds(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	personal, err := calendarFeed(uid, FeedPersonal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create calendar feed"})
+		return
+	}
+	feeds := gin.H{FeedPersonal: calendarFeedURLs(personal.Token)}
+
+	var professional Professional
+	if db.Where("user_id = ?", uid).First(&professional).Error == nil {
+		schedule, err := calendarFeed(uid, FeedProfessional)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create calendar feed"})
+			return
+		}
+		feeds[FeedProfessional] = calendarFeedURLs(schedule.Token)
+	}
+	feeds["timetable"] = gin.H{"url": apiURL() + "/api/calendar/classes.ics"}
+	c.JSON(http.StatusOK, feeds)
+}
+
+// RotateCalendarFeed - Protected - Replace a feed's token, cutting off anyone holding the old link
+func RotateCalendarFeed(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	kind := c.Param("kind")
+	if kind != FeedPersonal && kind != FeedProfessional {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown calendar feed"})
+		return
+	}
+
+	feed, err := calendarFeed(uid, kind)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate calendar feed"})
+		return
+	}
+	feed.Token = newInviteToken()
+	if err := db.Model(&feed).Update("token", feed.Token).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate calendar feed"})
+		return
+	}
+	c.JSON(http.StatusOK, calendarFeedURLs(feed.Token))
+}
+
+// GetCalendarFeed - Public (token) - A private feed as iCalendar
+func GetCalendarFeed(c *gin.Context) {
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+	var feed CalendarFeed
+	if token == "" || db.Where("token = ?", token).First(&feed).Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+		return
+	}
+	var user User
+	if err := db.First(&user, feed.UserID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+		return
+	}
+
+	now := time.Now()
+	cal := ical.Calendar{Method: ical.MethodPublish, Refresh: calendarFeedRefresh}
+	switch feed.Kind {
+	case FeedProfessional:
+		var professional Professional
+		if err := db.Where("user_id = ?", user.ID).First(&professional).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+			return
+		}
+		cal.Name = "Kaivaliya Yoga - Teaching schedule"
+		cal.Events = professionalCalendar(professional, now)
+	default:
+		cal.Name = "Kaivaliya Yoga - My bookings"
+		cal.Events = personalCalendar(user, now)
+	}
+
+	db.Model(&feed).Update("last_accessed_at", &now)
+	c.Header("Cache-Control", "private, max-age=300")
+	writeCalendar(c, cal, "kaivaliya-yoga.ics")
+}
+
+// GetClassTimetableFeed - Public - The studio's weekly classes for the next CALENDAR_FEED_WEEKS weeks
+func GetClassTimetableFeed(c *gin.Context) {
+	var classes []Class
+	db.Find(&classes)
+
+	now := time.Now()
+	until := now.AddDate(0, 0, 7*envInt("CALENDAR_FEED_WEEKS", 8))
+	cal := ical.Calendar{Method: ical.MethodPublish, Name: "Kaivaliya Yoga - Class timetable", Refresh: calendarFeedRefresh}
+	for _, class := range classes {
+		cal.Events = append(cal.Events, classOccurrences(class, now.AddDate(0, 0, -1), until)...)
+	}
+	c.Header("Cache-Control", "public, max-age=900")
+	writeCalendar(c, cal, "kaivaliya-yoga-classes.ics")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"kaivaliyayoga/internal/ical"
+	"testing"
+	"time"
+)
+
+func TestBookingEventCancellationKeepsUID(t *testing.T) {
+	t.Setenv("STUDIO_TIMEZONE", "Australia/Sydney")
+	sydney, _ := time.LoadLocation("Australia/Sydney")
+	class := Class{ID: 3, Name: "Hatha Flow", Day: "Monday", Time: "07:00", Duration: 60}
+	booking := Booking{ID: 42, Status: "confirmed", CreatedAt: time.Date(2026, 10, 15, 12, 0, 0, 0, sydney)}
+
+	confirmed, err := bookingEvent(booking, class)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 10, 19, 7, 0, 0, 0, sydney); !confirmed.Start.Equal(want) || !confirmed.End.Equal(want.Add(time.Hour)) {
+		t.Errorf("event runs %v to %v, want the Monday 7am session", confirmed.Start, confirmed.End)
+	}
+
+	booking.Status = "cancelled"
+	cancelled, _ := bookingEvent(booking, class)
+	if cancelled.UID != confirmed.UID || cancelled.Sequence <= confirmed.Sequence || cancelled.Status != ical.StatusCancelled {
+		t.Errorf("cancellation = %+v, want the same UID with a higher sequence", cancelled)
+	}
+	if invite := calendarInvite(cancelled, User{Name: "Asha", Email: "asha@example.com"}); invite.ContentType != "text/calendar; charset=utf-8; method=CANCEL" {
+		t.Errorf("cancellation invite content type = %q", invite.ContentType)
+	}
+}
+
+func TestClassOccurrences(t *testing.T) {
+	t.Setenv("STUDIO_TIMEZONE", "Australia/Sydney")
+	sydney, _ := time.LoadLocation("Australia/Sydney")
+	class := Class{ID: 7, Name: "Yin", Day: "Wednesday", Time: "6:30 PM", Duration: 75}
+	from := time.Date(2026, 9, 28, 0, 0, 0, 0, sydney) // Spans the October DST change
+
+	events := classOccurrences(class, from, from.AddDate(0, 0, 28))
+	if len(events) != 4 {
+		t.Fatalf("got %d sessions, want 4", len(events))
+	}
+	seen := map[string]bool{}
+	for _, e := range events {
+		local := e.Start.In(sydney)
+		if local.Weekday() != time.Wednesday || local.Hour() != 18 || local.Minute() != 30 {
+			t.Errorf("session at %v, want Wednesdays 6:30pm Sydney time", local)
+		}
+		if seen[e.UID] {
+			t.Errorf("duplicate UID %s", e.UID)
+		}
+		seen[e.UID] = true
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// OrderPricingInput is what a checkout needs to price an order server-side
+type OrderPricingInput struct {
+	UserID      uint
+	ProductType string
+	ProductID   uint
+	Subtotal    float64 // Client amount before discount and exclusive tax; ignored for catalogue products and instalments
+	Currency    string
+	PromoCode   string
+	CountryCode string
+	RegionCode  string
+	TaxID       string
+	UseWallet   bool // Pay what the wallet balance covers
+}
+
+// OrderPricing is the server-side breakdown of what the customer is charged
+type OrderPricing struct {
+	Subtotal float64
+	Promo    *PromoCode
+	Discount float64
+	Tax      TaxResult
+	Total    float64
+
+	WalletAmount  float64 // Part of Total paid from wallet balance
+	BaseAmountAUD float64 // Price list base price, when the product has one
+}
+
+// PriceOrder applies promo codes and tax to a checkout amount
+func PriceOrder(tx *gorm.DB, input OrderPricingInput) (OrderPricing, error) {
+	pricing := OrderPricing{Subtotal: input.Subtotal}
+	currency := strings.ToUpper(input.Currency)
+
+	// Gift cards sell at face value; tax is charged when the credit is spent
+	if input.ProductType == ProductGiftCard {
+		amount, err := priceGiftCard(tx, input)
+		if err != nil {
+			return pricing, err
+		}
+		pricing.Subtotal = amount
+		pricing.Tax = TaxResult{Net: amount, Gross: amount}
+		pricing.Total = amount
+		return pricing, nil
+	}
+
+	// Deposits and instalments are charged what the schedule says
+	if input.ProductType == ProductInstalmentPlan || input.ProductType == ProductInstalment {
+		amount, err := priceInstalment(tx, input)
+		if err != nil {
+			return pricing, err
+		}
+		pricing.Subtotal = amount
+	}
+
+	// Catalogue products are charged their price list price, not the client amount
+	item, listed, err := catalogItem(tx, input.ProductType, input.ProductID)
+	if err != nil {
+		return pricing, err
+	}
+	if listed {
+		priced, err := LocalizePrice(tx, item, currency)
+		if err != nil {
+			return pricing, err
+		}
+		pricing.Subtotal = priced.Amount
+		if item.BaseCurrency == rateBase {
+			pricing.BaseAmountAUD = item.BaseAmount
+		}
+	}
+
+	amount := pricing.Subtotal
+	if input.PromoCode != "" {
+		promo, discount, err := ApplyPromoCode(tx, input.PromoCode, input.UserID, input.ProductType, amount, currency)
+		if err != nil {
+			return pricing, err
+		}
+		pricing.Promo = promo
+		pricing.Discount = discount
+		amount -= discount
+	}
+
+	taxID := input.TaxID
+	if taxID == "" {
+		var user User
+		if tx.First(&user, input.UserID).Error == nil {
+			taxID = user.TaxID
+		}
+	}
+	pricing.Tax = CalculateTax(tx, input.CountryCode, input.RegionCode, amount, taxID, input.UserID)
+	pricing.Total = pricing.Tax.Gross
+
+	if pricing.Total <= 0 {
+		return pricing, errors.New("order total must be greater than zero")
+	}
+
+	if input.UseWallet {
+		pricing.WalletAmount = math.Min(WalletBalance(tx, input.UserID, currency), pricing.Total)
+	}
+	return pricing, nil
+}
+
+// GatewayAmount is what is left to charge at the payment gateway
+func (p OrderPricing) GatewayAmount() float64 {
+	return roundMoney(p.Total - p.WalletAmount)
+}
+
+// Apply copies the pricing breakdown onto a payment record
+func (p OrderPricing) Apply(payment *Payment) {
+	payment.Amount = p.GatewayAmount()
+	payment.WalletAmount = p.WalletAmount
+	if p.BaseAmountAUD > 0 {
+		payment.BaseAmountAUD = p.BaseAmountAUD
+	}
+	payment.SubtotalAmount = p.Subtotal
+	payment.DiscountAmount = p.Discount
+	payment.NetAmount = p.Tax.Net
+	payment.TaxAmount = p.Tax.Tax
+	payment.TaxInclusive = p.Tax.Inclusive
+	payment.ReverseCharge = p.Tax.ReverseCharge
+	payment.TaxIDCheck = p.Tax.TaxIDCheck
+	payment.TaxIDCheckedAt = p.Tax.TaxIDCheckedAt
+	if p.Promo != nil {
+		payment.PromoCode = p.Promo.Code
+	}
+}
+
+// CreateOrderPayment saves the payment with its promo redemption and tax
+// lines, and takes any wallet share up front
+func CreateOrderPayment(payment *Payment, pricing OrderPricing) error {
+	pricing.Apply(payment)
+	stampExchangeRate(payment)
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(payment).Error; err != nil {
+			return err
+		}
+
+		if payment.WalletAmount > 0 {
+			if err := debitWalletForPayment(tx, payment); err != nil {
+				return err
+			}
+		}
+
+		// Hold the seat while the customer pays for a single class
+		if payment.ProductType == ProductClass && payment.ProductID > 0 {
+			if err := HoldSeat(tx, payment.ProductID, payment.UserID, payment.ID); err != nil {
+				return err
+			}
+		}
+
+		for _, line := range pricing.Tax.Lines {
+			line.PaymentID = payment.ID
+			if err := tx.Create(&line).Error; err != nil {
+				return err
+			}
+		}
+
+		if pricing.Promo == nil {
+			return nil
+		}
+		return redeemPromoCode(tx, pricing.Promo.ID, payment)
+	})
+}
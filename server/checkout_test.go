@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriceOrderUsesServerPrices(t *testing.T) {
+	setupTestDB(t, &User{}, &ClassPack{}, &Class{}, &Program{}, &PriceOverride{}, &CurrencyRoundingRule{},
+		&TaxRule{}, &PromoCode{}, &PromoRedemption{}, &InstalmentPlan{}, &Instalment{}, &Wallet{})
+	useTestRates(t, map[string]float64{"AUD": 1, "INR": 50})
+
+	pack := ClassPack{Code: "10_class", Credits: 10, PriceAUD: 150, IsActive: true}
+	retired := ClassPack{Code: "old", Credits: 5, PriceAUD: 60}
+	paidClass := Class{Name: "Workshop", Capacity: 10, PriceCents: 2500, Currency: "AUD"}
+	freeClass := Class{Name: "Community", Capacity: 10}
+	program := Program{Name: "Foundation", Price: 8000, MaxStudents: 10}
+	unpriced := Program{Name: "Invite only", MaxStudents: 10}
+	for _, row := range []interface{}{&pack, &retired, &paidClass, &freeClass, &program, &unpriced} {
+		require.NoError(t, db.Create(row).Error)
+	}
+	plan := InstalmentPlan{ProgramID: program.ID, Name: "Monthly", DepositAmount: 2000, Instalments: 3, Currency: "INR", IsActive: true}
+	require.NoError(t, db.Create(&plan).Error)
+	inst := Instalment{EnrollmentID: 1, UserID: 1, Sequence: 1, Amount: 2100, Currency: "INR", Status: "overdue"}
+	paid := Instalment{EnrollmentID: 1, UserID: 1, Sequence: 0, Amount: 2000, Currency: "INR", Status: "paid"}
+	require.NoError(t, db.Create(&inst).Error)
+	require.NoError(t, db.Create(&paid).Error)
+
+	cases := []struct {
+		name        string
+		productType string
+		productID   uint
+		currency    string
+		promo       string
+		subtotal    float64
+		err         string
+	}{
+		{"class pack", ProductMembership, pack.ID, "AUD", "", 150, ""},
+		{"class pack converted", ProductMembership, pack.ID, "INR", "", 7500, ""},
+		{"membership without a pack", ProductMembership, 0, "AUD", "", 0, "product_id is required for membership"},
+		{"retired pack", ProductMembership, retired.ID, "AUD", "", 0, "class pack not found"},
+		{"paid class", ProductClass, paidClass.ID, "AUD", "", 25, ""},
+		{"class without an id", ProductClass, 0, "AUD", "", 0, "product_id is required for class"},
+		{"free class", ProductClass, freeClass.ID, "AUD", "", 0, "class is not sold individually"},
+		{"program", ProductProgram, program.ID, "INR", "", 8000, ""},
+		{"program converted", ProductProgram, program.ID, "AUD", "", 160, ""},
+		{"program without an id", ProductProgram, 0, "INR", "", 0, "product_id is required for program"},
+		{"unknown program", ProductProgram, 404, "INR", "", 0, "program not found"},
+		{"unpriced program", ProductProgram, unpriced.ID, "INR", "", 0, "program is not for sale"},
+		{"deposit", ProductInstalmentPlan, plan.ID, "INR", "", 2000, ""},
+		{"instalment", ProductInstalment, inst.ID, "INR", "", 2100, ""},
+		{"paid instalment", ProductInstalment, paid.ID, "INR", "", 0, "instalment already paid"},
+		{"instalment in another currency", ProductInstalment, inst.ID, "AUD", "", 0, "instalments must be paid in INR"},
+		{"discounted instalment", ProductInstalment, inst.ID, "INR", "SAVE10", 0, "instalments can't be paid with promo codes or wallet credit"},
+		{"service keeps the client amount", ProductService, 0, "INR", "", 1, ""},
+	}
+	for _, tc := range cases {
+		pricing, err := PriceOrder(db, OrderPricingInput{
+			UserID: 1, ProductType: tc.productType, ProductID: tc.productID,
+			Subtotal: 1, Currency: tc.currency, PromoCode: tc.promo,
+		})
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, tc.subtotal, pricing.Subtotal, tc.name)
+		assert.Equal(t, tc.subtotal, pricing.Total, tc.name)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// --- Model ---
+
+// ClassPack is an admin-defined membership package (drop-in, 10-class, monthly...)
+type ClassPack struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Code        string `json:"code" gorm:"uniqueIndex;not null"` // Used as Membership.Type, e.g. "10_class"
+	Name        string `json:"name"`
+	Description string `json:"description"`
+
+	Credits        int `json:"credits"`         // Number of classes, -1 for unlimited
+	ValidityDays   int `json:"validity_days"`   // Added to ValidityMonths
+	ValidityMonths int `json:"validity_months"` // Calendar months, e.g. monthly/quarterly plans
+
+	PriceAUD  float64 `json:"price_aud"`
+	IsActive  bool    `json:"is_active"`
+	SortOrder int     `json:"sort_order"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// Validity returns the start and end date of a pack bought now
+func (p *ClassPack) Validity(start time.Time) (time.Time, time.Time) {
+	return start, start.AddDate(0, p.ValidityMonths, p.ValidityDays)
+}
+
+func validateClassPack(p *ClassPack) error {
+	if p.Code == "" || p.Name == "" {
+		return errors.New("code and name are required")
+	}
+	if p.Credits == 0 || p.Credits < -1 {
+		return errors.New("credits must be positive, or -1 for unlimited")
+	}
+	if p.ValidityDays <= 0 && p.ValidityMonths <= 0 {
+		return errors.New("validity_days or validity_months is required")
+	}
+	return nil
+}
+
+// --- Handlers ---
+
+// GetClassPacks - Public - List packs available for purchase
+func GetClassPacks(c *gin.Context) {
+	var packs []ClassPack
+	db.Where("is_active = ?", true).Order("sort_order asc, id asc").Find(&packs)
+	c.JSON(http.StatusOK, packs)
+}
+
+// GetAdminClassPacks - Admin - List all packs including inactive ones
+func GetAdminClassPacks(c *gin.Context) {
+	var packs []ClassPack
+	db.Order("sort_order asc, id asc").Find(&packs)
+	c.JSON(http.StatusOK, packs)
+}
+
+// CreateClassPack - Admin - Define a new pack
+func CreateClassPack(c *gin.Context) {
+	input := ClassPack{IsActive: true} // On sale unless is_active is false
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateClassPack(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.Create(&input).Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "A pack with this code already exists"})
+		return
+	}
+	c.JSON(http.StatusCreated, input)
+}
+
+// UpdateClassPack - Admin - Update a pack. Existing memberships are not affected.
+func UpdateClassPack(c *gin.Context) {
+	var pack ClassPack
+	if err := db.First(&pack, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Class pack not found"})
+		return
+	}
+
+	var input ClassPack
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateClassPack(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pack.Code = input.Code
+	pack.Name = input.Name
+	pack.Description = input.Description
+	pack.Credits = input.Credits
+	pack.ValidityDays = input.ValidityDays
+	pack.ValidityMonths = input.ValidityMonths
+	pack.PriceAUD = input.PriceAUD
+	pack.IsActive = input.IsActive
+	pack.SortOrder = input.SortOrder
+
+	if err := db.Save(&pack).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update class pack"})
+		return
+	}
+	c.JSON(http.StatusOK, pack)
+}
+
+// DeleteClassPack - Admin - Remove a pack from sale
+func DeleteClassPack(c *gin.Context) {
+	var pack ClassPack
+	if err := db.First(&pack, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Class pack not found"})
+		return
+	}
+	db.Delete(&pack)
+	c.JSON(http.StatusOK, gin.H{"message": "Class pack deleted"})
+}
+
+// --- Seeder ---
+
+// SeedClassPacks creates the default packs, including the legacy
+// drop_in/monthly/quarterly package types.
+func SeedClassPacks() {
+	var count int64
+	db.Model(&ClassPack{}).Count(&count)
+	if count > 0 {
+		return // Already seeded
+	}
+
+	packs := []ClassPack{
+		{Code: "drop_in", Name: "Drop-in Class", Credits: 1, ValidityDays: 1, PriceAUD: 29, SortOrder: 1, IsActive: true},
+		{Code: "5_class", Name: "5-Class Pack", Credits: 5, ValidityDays: 60, PriceAUD: 129, SortOrder: 2, IsActive: true},
+		{Code: "10_class", Name: "10-Class Pack", Credits: 10, ValidityDays: 120, PriceAUD: 239, SortOrder: 3, IsActive: true},
+		{Code: "20_class", Name: "20-Class Pack", Credits: 20, ValidityDays: 240, PriceAUD: 449, SortOrder: 4, IsActive: true},
+		{Code: "monthly", Name: "Monthly Unlimited", Credits: -1, ValidityMonths: 1, PriceAUD: 99, SortOrder: 5, IsActive: true},
+		{Code: "quarterly", Name: "Quarterly Unlimited", Credits: -1, ValidityMonths: 3, PriceAUD: 249, SortOrder: 6, IsActive: true},
+	}
+
+	for _, p := range packs {
+		db.Create(&p)
+	}
+	println("Class packs seeded successfully.")
+}
@@ -29,6 +29,14 @@ type Class struct {
 	LocationType   string     `json:"location_type" gorm:"default:'online'"` // online, in-person, hybrid
 	MeetingURL     string     `json:"meeting_url"`
 
+	// Where in-person classes meet. Coordinates are geocoded from City when
+	// not given, falling back to the studio's.
+	Address   string  `json:"address"`
+	City      string  `json:"city"`
+	Country   string  `json:"country"`
+	Latitude  float64 `json:"latitude" gorm:"index:idx_classes_location"`
+	Longitude float64 `json:"longitude" gorm:"index:idx_classes_location"`
+
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
@@ -87,6 +95,7 @@ func CreateClass(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	locateClass(&input)
 
 	if result := db.Create(&input); result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create class"})
@@ -121,6 +130,21 @@ func UpdateClass(c *gin.Context) {
 	class.Capacity = input.Capacity
 	class.Level = input.Level
 
+	// Location; a new city is geocoded again unless coordinates came with it
+	if (input.City != class.City || input.Country != class.Country) &&
+		input.Latitude == class.Latitude && input.Longitude == class.Longitude {
+		input.Latitude, input.Longitude = 0, 0
+	}
+	if input.LocationType != "" {
+		class.LocationType = input.LocationType
+	}
+	class.Address = input.Address
+	class.City = input.City
+	class.Country = input.Country
+	class.Latitude = input.Latitude
+	class.Longitude = input.Longitude
+	locateClass(&class)
+
 	db.Save(&class)
 	c.JSON(http.StatusOK, class)
 }
@@ -9,6 +9,7 @@ import (
 	"kaivaliyayoga/internal/handlers"
 	"kaivaliyayoga/internal/middleware"
 	"kaivaliyayoga/internal/models"
+	"kaivaliyayoga/internal/services"
 	"kaivaliyayoga/pkg/database"
 
 	"github.com/gin-contrib/cors"
@@ -31,9 +32,15 @@ func main() {
 		&models.Appointment{},
 		&models.ProfessionalApplication{},
 		&models.ProfessionalAvailability{},
+		&models.ProfessionalTimeOff{},
 		&models.ProfessionalCertification{},
 	)
 
+	// Full-text index for professional search
+	if err := services.SetupSearch(db); err != nil {
+		fmt.Println("Failed to set up professional search:", err)
+	}
+
 	// 4. Seed dummy data for testing
 	database.SeedProfessionals()
 
@@ -104,9 +111,32 @@ func main() {
 			protected.DELETE("/appointments/:id", apptHandler.Cancel)
 
 			// Professional Management
+			// Scoped to the caller's own Professional record
 			pro := protected.Group("/professional")
 			{
 				pro.POST("/profile", proHandler.CreateProfile)
+
+				pro.GET("/services", proHandler.ListServices)
+				pro.POST("/services", proHandler.CreateService)
+				pro.PUT("/services/:id", proHandler.UpdateService)
+				pro.DELETE("/services/:id", proHandler.DeleteService)
+
+				pro.GET("/availability", proHandler.ListAvailability)
+				pro.POST("/availability", proHandler.CreateAvailability)
+				pro.PUT("/availability/:id", proHandler.UpdateAvailability)
+				pro.DELETE("/availability/:id", proHandler.DeleteAvailability)
+
+				pro.GET("/time-off", proHandler.ListTimeOff)
+				pro.POST("/time-off", proHandler.CreateTimeOff)
+				pro.DELETE("/time-off/:id", proHandler.DeleteTimeOff)
+
+				pro.GET("/appointments", proHandler.ListAppointments)
+				pro.GET("/appointments/:id", proHandler.GetAppointment)
+				pro.POST("/appointments/:id/confirm", proHandler.AppointmentAction(services.ActionConfirm))
+				pro.POST("/appointments/:id/decline", proHandler.AppointmentAction(services.ActionDecline))
+				pro.POST("/appointments/:id/complete", proHandler.AppointmentAction(services.ActionComplete))
+				pro.POST("/appointments/:id/no-show", proHandler.AppointmentAction(services.ActionNoShow))
+				pro.PUT("/appointments/:id/notes", proHandler.UpdateAppointmentNotes)
 			}
 		}
 	}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Ledger entry types
+const (
+	CreditGrant      = "grant"
+	CreditUse        = "use"
+	CreditRefund     = "refund"
+	CreditExpiry     = "expiry"
+	CreditAdjustment = "adjustment"
+)
+
+// --- Model ---
+
+// CreditLedgerEntry is an append-only record of every change to a membership's
+// credits. The balance of a membership is the sum of its entries' Delta.
+type CreditLedgerEntry struct {
+	ID           uint   `json:"id" gorm:"primaryKey"`
+	MembershipID uint   `json:"membership_id" gorm:"index"`
+	UserID       uint   `json:"user_id" gorm:"index"` // User whose action caused the entry
+	Type         string `json:"type"`                 // grant, use, refund, expiry, adjustment
+	Delta        int    `json:"delta"`                // 0 for usage of unlimited memberships
+	BalanceAfter int    `json:"balance_after"`
+	BookingID    *uint  `json:"booking_id" gorm:"index"`
+	Reason       string `json:"reason"`
+	CreatedBy    *uint  `json:"created_by"` // Admin user for manual adjustments
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// --- DTO ---
+type CreditAdjustmentInput struct {
+	Delta  int    `json:"delta" binding:"required"`
+	Reason string `json:"reason" binding:"required"`
+}
+
+// --- Logic ---
+
+// CreditBalance derives a membership's balance from the ledger
+func CreditBalance(tx *gorm.DB, membershipID uint) int {
+	var balance int
+	tx.Model(&CreditLedgerEntry{}).Where("membership_id = ?", membershipID).
+		Select("COALESCE(SUM(delta), 0)").Scan(&balance)
+	return balance
+}
+
+// RecordCreditEntry appends a ledger entry and refreshes the cached
+// Membership.Credits. Unlimited memberships keep Credits at -1. The
+// membership row is locked first so concurrent bookings can't both spend
+// the last credit.
+func RecordCreditEntry(tx *gorm.DB, mem *Membership, entry CreditLedgerEntry) error {
+	var locked Membership
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "credits").First(&locked, mem.ID).Error; err != nil {
+		return err
+	}
+	unlimited := locked.Credits == -1
+	if unlimited {
+		entry.Delta = 0
+	}
+
+	balance := CreditBalance(tx, mem.ID) + entry.Delta
+	if balance < 0 {
+		return errors.New("insufficient credits")
+	}
+
+	entry.MembershipID = mem.ID
+	entry.BalanceAfter = balance
+	if unlimited {
+		entry.BalanceAfter = -1
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return err
+	}
+
+	if unlimited {
+		return nil
+	}
+	mem.Credits = balance
+	return tx.Model(mem).Update("credits", balance).Error
+}
+
+// expireMembership closes a membership and writes off any remaining credits
+func expireMembership(tx *gorm.DB, mem *Membership) error {
+	if mem.Credits != -1 {
+		if remaining := CreditBalance(tx, mem.ID); remaining > 0 {
+			if err := RecordCreditEntry(tx, mem, CreditLedgerEntry{
+				UserID: mem.UserID,
+				Type:   CreditExpiry,
+				Delta:  -remaining,
+				Reason: "membership expired",
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	mem.Status = "expired"
+	return tx.Model(mem).Update("status", "expired").Error
+}
+
+// ExpireMemberships expires all active memberships past their end date
+func ExpireMemberships() int {
+	var due []Membership
+	db.Where("status = ? AND end_date < ?", "active", time.Now()).Find(&due)
+
+	expired := 0
+	for i := range due {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return expireMembership(tx, &due[i])
+		})
+		if err == nil {
+			expired++
+		}
+	}
+	return expired
+}
+
+// BackfillCreditLedger writes an opening balance for memberships created
+// before the ledger existed so that derived balances match.
+func BackfillCreditLedger() {
+	var memberships []Membership
+	db.Where("credits > 0 AND id NOT IN (?)", db.Model(&CreditLedgerEntry{}).Select("membership_id")).Find(&memberships)
+	for _, mem := range memberships {
+		db.Create(&CreditLedgerEntry{
+			MembershipID: mem.ID,
+			UserID:       mem.UserID,
+			Type:         CreditGrant,
+			Delta:        mem.Credits,
+			BalanceAfter: mem.Credits,
+			Reason:       "opening balance",
+		})
+	}
+}
+
+// --- Handlers ---
+
+// GetMembershipLedger - Protected - Credit history for one of the user's memberships
+func GetMembershipLedger(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var mem Membership
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), uid).First(&mem).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Membership not found"})
+		return
+	}
+	respondWithLedger(c, mem)
+}
+
+// GetAdminMembershipLedger - Admin - Credit history for any membership
+func GetAdminMembershipLedger(c *gin.Context) {
+	var mem Membership
+	if err := db.First(&mem, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Membership not found"})
+		return
+	}
+	respondWithLedger(c, mem)
+}
+
+func respondWithLedger(c *gin.Context, mem Membership) {
+	var entries []CreditLedgerEntry
+	db.Where("membership_id = ?", mem.ID).Order("created_at asc, id asc").Find(&entries)
+
+	balance := CreditBalance(db, mem.ID)
+	if mem.Credits == -1 {
+		balance = -1
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"membership": mem,
+		"balance":    balance,
+		"entries":    entries,
+	})
+}
+
+// AdjustMembershipCredits - Admin - Manually add or remove credits with a reason
+func AdjustMembershipCredits(c *gin.Context) {
+	adminID, _ := currentUserID(c)
+
+	var input CreditAdjustmentInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var mem Membership
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&mem, c.Param("id")).Error; err != nil {
+			return errors.New("membership not found")
+		}
+		if mem.Credits == -1 {
+			return errors.New("cannot adjust credits on an unlimited membership")
+		}
+		if err := RecordCreditEntry(tx, &mem, CreditLedgerEntry{
+			UserID:    mem.UserID,
+			Type:      CreditAdjustment,
+			Delta:     input.Delta,
+			Reason:    input.Reason,
+			CreatedBy: &adminID,
+		}); err != nil {
+			return err
+		}
+		// Re-open a depleted membership that still has time left
+		if mem.Status == "expired" && mem.Credits > 0 && mem.EndDate.After(time.Now()) {
+			mem.Status = "active"
+			return tx.Model(&mem).Update("status", "active").Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Credits adjusted", "membership": mem})
+}
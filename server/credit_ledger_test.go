@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordCreditEntry(t *testing.T) {
+	setupTestDB(t, &Membership{}, &CreditLedgerEntry{})
+
+	pack := Membership{UserID: 1, Type: "5_class", PaymentID: 1, Status: "active"}
+	unlimited := Membership{UserID: 1, Type: "monthly", PaymentID: 2, Status: "active", Credits: -1}
+	require.NoError(t, db.Create(&pack).Error)
+	require.NoError(t, db.Create(&unlimited).Error)
+
+	cases := []struct {
+		name    string
+		mem     *Membership
+		entry   CreditLedgerEntry
+		balance int
+		err     string
+	}{
+		{"grant", &pack, CreditLedgerEntry{Type: CreditGrant, Delta: 2}, 2, ""},
+		{"use", &pack, CreditLedgerEntry{Type: CreditUse, Delta: -1}, 1, ""},
+		{"last credit", &pack, CreditLedgerEntry{Type: CreditUse, Delta: -1}, 0, ""},
+		{"overspend", &pack, CreditLedgerEntry{Type: CreditUse, Delta: -1}, 0, "insufficient credits"},
+		{"refund", &pack, CreditLedgerEntry{Type: CreditRefund, Delta: 1}, 1, ""},
+		{"unlimited use", &unlimited, CreditLedgerEntry{Type: CreditUse, Delta: -1}, -1, ""},
+	}
+	for _, tc := range cases {
+		err := RecordCreditEntry(db, tc.mem, tc.entry)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, tc.name)
+		} else {
+			require.NoError(t, err, tc.name)
+		}
+		var stored Membership
+		db.First(&stored, tc.mem.ID)
+		assert.Equal(t, tc.balance, stored.Credits, tc.name)
+	}
+
+	// A booking holding a stale copy of the membership still can't spend a
+	// credit that another booking already used
+	stale := pack
+	require.NoError(t, RecordCreditEntry(db, &pack, CreditLedgerEntry{Type: CreditUse, Delta: -1}))
+	assert.EqualError(t, RecordCreditEntry(db, &stale, CreditLedgerEntry{Type: CreditUse, Delta: -1}), "insufficient credits")
+	assert.Equal(t, 0, CreditBalance(db, pack.ID))
+
+	var unlimitedUses []CreditLedgerEntry
+	db.Where("membership_id = ?", unlimited.ID).Find(&unlimitedUses)
+	require.Len(t, unlimitedUses, 1)
+	assert.Equal(t, 0, unlimitedUses[0].Delta)
+}
+
+func TestCheckMembershipPayment(t *testing.T) {
+	pack := ClassPack{ID: 4, Code: "monthly", Credits: -1}
+	paid := Payment{UserID: 1, Status: "success", ProductType: ProductMembership, ProductID: 4}
+
+	cases := []struct {
+		name   string
+		change func(p *Payment)
+		err    string
+	}{
+		{"paid for this pack", func(p *Payment) {}, ""},
+		{"someone else's payment", func(p *Payment) { p.UserID = 2 }, "payment belongs to another user"},
+		{"unpaid", func(p *Payment) { p.Status = "created" }, "payment not successful"},
+		{"cheaper pack", func(p *Payment) { p.ProductID = 1 }, "payment was not for this package"},
+		{"not a membership", func(p *Payment) { p.ProductType = ProductClass }, "payment was not for this package"},
+		{"no product", func(p *Payment) { p.ProductID = 0 }, "payment was not for this package"},
+	}
+	for _, tc := range cases {
+		payment := paid
+		tc.change(&payment)
+		err := checkMembershipPayment(&payment, 1, &pack)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.name)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.name)
+		}
+	}
+}
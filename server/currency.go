@@ -2,114 +2,566 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
 )
 
+// All rates are quoted against AUD, the reference currency for prices
+const rateBase = "AUD"
+
+// Supported currencies to fetch against AUD
+var supportedCurrencies = []string{"INR", "USD", "GBP", "CAD", "SGD", "AED", "NZD", "EUR"}
+
+var errHistoricalUnsupported = errors.New("provider does not serve historical rates")
+
+// --- Model ---
+
+// DailyExchangeRate is one AUD-based rate for a day, kept so that any rate
+// used on a payment can be traced back to where it came from
+type DailyExchangeRate struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Date      string    `json:"date" gorm:"size:10;uniqueIndex:idx_daily_rate"` // YYYY-MM-DD as published by the source
+	Base      string    `json:"base" gorm:"uniqueIndex:idx_daily_rate"`
+	Currency  string    `json:"currency" gorm:"uniqueIndex:idx_daily_rate"`
+	Rate      float64   `json:"rate"`
+	Source    string    `json:"source"`
+	FetchedAt time.Time `json:"fetched_at"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// --- Providers ---
+
+// RateSet is a day's AUD-based rates from one source
+type RateSet struct {
+	Date  string             `json:"date"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+// ExchangeRateProvider is a source of AUD-based exchange rates. A zero date
+// asks for the latest rates.
+type ExchangeRateProvider interface {
+	Name() string
+	FetchRates(date time.Time) (RateSet, error)
+}
+
+var rateHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+func getRatesJSON(url string, out interface{}) error {
+	resp, err := rateHTTPClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// frankfurterProvider uses frankfurter.app (ECB reference rates, free, no key)
+type frankfurterProvider struct{}
+
+func (frankfurterProvider) Name() string { return "frankfurter" }
+
+func (frankfurterProvider) FetchRates(date time.Time) (RateSet, error) {
+	baseURL := os.Getenv("FRANKFURTER_URL")
+	if baseURL == "" {
+		baseURL = "https://api.frankfurter.app"
+	}
+	day := "latest"
+	if !date.IsZero() {
+		day = date.Format("2006-01-02")
+	}
+	url := fmt.Sprintf("%s/%s?from=%s&to=%s", strings.TrimRight(baseURL, "/"), day, rateBase, strings.Join(supportedCurrencies, ","))
+
+	var result struct {
+		Base  string             `json:"base"`
+		Date  string             `json:"date"`
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := getRatesJSON(url, &result); err != nil {
+		return RateSet{}, err
+	}
+	return RateSet{Date: result.Date, Rates: result.Rates}, nil
+}
+
+// openERProvider uses open.er-api.com, which only serves the latest rates
+type openERProvider struct{}
+
+func (openERProvider) Name() string { return "open_er_api" }
+
+func (openERProvider) FetchRates(date time.Time) (RateSet, error) {
+	if !date.IsZero() {
+		return RateSet{}, errHistoricalUnsupported
+	}
+
+	var result struct {
+		Result             string             `json:"result"`
+		TimeLastUpdateUnix int64              `json:"time_last_update_unix"`
+		Rates              map[string]float64 `json:"rates"`
+	}
+	if err := getRatesJSON("https://open.er-api.com/v6/latest/"+rateBase, &result); err != nil {
+		return RateSet{}, err
+	}
+	if result.Result != "success" {
+		return RateSet{}, fmt.Errorf("open.er-api.com returned %q", result.Result)
+	}
+	return RateSet{
+		Date:  time.Unix(result.TimeLastUpdateUnix, 0).UTC().Format("2006-01-02"),
+		Rates: result.Rates,
+	}, nil
+}
+
+// fileRateProvider reads rates from a JSON file for offline use. The file
+// holds one RateSet or a list of them.
+type fileRateProvider struct {
+	path string
+}
+
+func (p fileRateProvider) Name() string { return "file" }
+
+func (p fileRateProvider) FetchRates(date time.Time) (RateSet, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return RateSet{}, err
+	}
+
+	var sets []RateSet
+	if err := json.Unmarshal(data, &sets); err != nil {
+		var single RateSet
+		if err := json.Unmarshal(data, &single); err != nil {
+			return RateSet{}, fmt.Errorf("invalid rates file: %v", err)
+		}
+		sets = []RateSet{single}
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].Date > sets[j].Date })
+
+	// Latest set on or before the requested day
+	for _, set := range sets {
+		if date.IsZero() || set.Date <= date.Format("2006-01-02") {
+			return set, nil
+		}
+	}
+	return RateSet{}, errors.New("no rates in file for that date")
+}
+
+// exchangeRateProviders returns providers in the order they are tried, from
+// EXCHANGE_RATE_PROVIDERS (comma separated). The file provider is always the
+// last resort when EXCHANGE_RATES_FILE is set.
+func exchangeRateProviders() []ExchangeRateProvider {
+	names := os.Getenv("EXCHANGE_RATE_PROVIDERS")
+	if names == "" {
+		names = "frankfurter,open_er_api"
+	}
+	file := os.Getenv("EXCHANGE_RATES_FILE")
+
+	var providers []ExchangeRateProvider
+	hasFile := false
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "frankfurter":
+			providers = append(providers, frankfurterProvider{})
+		case "open_er_api":
+			providers = append(providers, openERProvider{})
+		case "file":
+			if file != "" {
+				providers = append(providers, fileRateProvider{path: file})
+				hasFile = true
+			}
+		}
+	}
+	if file != "" && !hasFile {
+		providers = append(providers, fileRateProvider{path: file})
+	}
+	return providers
+}
+
+// fetchFromProviders tries each provider in turn and keeps supported currencies only
+func fetchFromProviders(date time.Time) (RateSet, string, error) {
+	var failures []string
+	for _, provider := range exchangeRateProviders() {
+		set, err := provider.FetchRates(date)
+		if err == nil && len(set.Rates) == 0 {
+			err = errors.New("no rates returned")
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", provider.Name(), err))
+			continue
+		}
+
+		rates := map[string]float64{rateBase: 1.0}
+		for _, curr := range supportedCurrencies {
+			if rate, ok := set.Rates[curr]; ok && rate > 0 {
+				rates[curr] = rate
+			}
+		}
+		if set.Date == "" {
+			set.Date = time.Now().UTC().Format("2006-01-02")
+		}
+		return RateSet{Date: set.Date, Rates: rates}, provider.Name(), nil
+	}
+	if len(failures) == 0 {
+		return RateSet{}, "", errors.New("no exchange rate providers configured")
+	}
+	return RateSet{}, "", errors.New(strings.Join(failures, "; "))
+}
+
+// storeRates saves a day's rates, replacing any earlier fetch for that day
+func storeRates(set RateSet, source string, fetchedAt time.Time) error {
+	rows := make([]DailyExchangeRate, 0, len(set.Rates))
+	for curr, rate := range set.Rates {
+		rows = append(rows, DailyExchangeRate{
+			Date:      set.Date,
+			Base:      rateBase,
+			Currency:  curr,
+			Rate:      rate,
+			Source:    source,
+			FetchedAt: fetchedAt,
+		})
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "date"}, {Name: "base"}, {Name: "currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "source", "fetched_at", "updated_at"}),
+	}).Create(&rows).Error
+}
+
+// storedRatesOn loads the latest stored rates on or before a day
+func storedRatesOn(day string) (RateSet, string, time.Time, error) {
+	var latest DailyExchangeRate
+	if err := db.Where("base = ? AND date <= ?", rateBase, day).Order("date desc").First(&latest).Error; err != nil {
+		return RateSet{}, "", time.Time{}, errors.New("no stored exchange rates")
+	}
+
+	var rows []DailyExchangeRate
+	db.Where("base = ? AND date = ?", rateBase, latest.Date).Find(&rows)
+	set := RateSet{Date: latest.Date, Rates: map[string]float64{}}
+	for _, row := range rows {
+		set.Rates[row.Currency] = row.Rate
+	}
+	return set, latest.Source, latest.FetchedAt, nil
+}
+
 // --- Exchange Rate Service ---
 
 type ExchangeRateResponse struct {
-	Amount float64            `json:"amount"`
-	Base   string             `json:"base"`
-	Date   string             `json:"date"`
-	Rates  map[string]float64 `json:"rates"`
+	Base      string             `json:"base"`
+	Date      string             `json:"date"`
+	Source    string             `json:"source"`
+	Rates     map[string]float64 `json:"rates"`
+	UpdatedAt time.Time          `json:"updated_at"`
+	Stale     bool               `json:"stale"`
 }
 
 var (
 	exchangeRatesCache     map[string]float64
-	exchangeRatesCacheTime time.Time
+	exchangeRatesCacheTime time.Time // When the cached rates were fetched
+	exchangeRatesDate      string
+	exchangeRatesSource    string
+	ratesLastAttempt       time.Time
+	ratesLastError         string
+	ratesLastAlert         time.Time
 	ratesMutex             sync.RWMutex
 )
 
-// Supported currencies to fetch against AUD
-var supportedCurrencies = []string{"INR", "USD", "GBP", "CAD", "SGD", "AED", "NZD", "EUR"}
+func exchangeRateStaleAfter() time.Duration {
+	return time.Duration(envInt("EXCHANGE_RATE_STALE_HOURS", 36)) * time.Hour
+}
 
+// FetchExchangeRates refreshes the latest rates once a day. When every
+// provider fails it keeps serving the last stored rates and alerts admins
+// once they go stale.
 func FetchExchangeRates() error {
-	// Rate limit check: Update only if cache is older than 24 hours
 	ratesMutex.RLock()
-	if time.Since(exchangeRatesCacheTime) < 24*time.Hour && exchangeRatesCache != nil {
-		ratesMutex.RUnlock()
+	haveRates := exchangeRatesCache != nil
+	fresh := haveRates && time.Since(exchangeRatesCacheTime) < 24*time.Hour
+	recentlyTried := time.Since(ratesLastAttempt) < 15*time.Minute
+	ratesMutex.RUnlock()
+	if fresh || (recentlyTried && haveRates) {
 		return nil
 	}
-	ratesMutex.RUnlock()
+	return refreshExchangeRates()
+}
 
-	// Using frankfurter.app (Free, no key)
-	// Base: AUD
-	url := "https://api.frankfurter.app/latest?from=AUD&to=" // Fetch all or specify
-	for i, curr := range supportedCurrencies {
-		if i > 0 {
-			url += ","
-		}
-		url += curr
-	}
+func refreshExchangeRates() error {
+	ratesMutex.Lock()
+	ratesLastAttempt = time.Now()
+	ratesMutex.Unlock()
 
-	resp, err := http.Get(url)
-	if err != nil {
-		fmt.Printf("Error fetching rates: %v. Using Fallback.\n", err)
-		ratesMutex.Lock()
-		// Fallback rates (Approximate)
-		exchangeRatesCache = map[string]float64{
-			"AUD": 1.0, "USD": 0.67, "INR": 55.0, "GBP": 0.53, "CAD": 0.90, "SGD": 0.89, "AED": 2.45, "EUR": 0.62,
+	set, source, err := fetchFromProviders(time.Time{})
+	if err == nil {
+		now := time.Now()
+		if storeErr := storeRates(set, source, now); storeErr != nil {
+			fmt.Println("WARNING: Failed to store exchange rates:", storeErr)
 		}
-		exchangeRatesCacheTime = time.Now()
+		ratesMutex.Lock()
+		exchangeRatesCache = set.Rates
+		exchangeRatesCacheTime = now
+		exchangeRatesDate = set.Date
+		exchangeRatesSource = source
+		ratesLastError = ""
 		ratesMutex.Unlock()
+		fmt.Printf("Exchange rates updated from %s for %s: %v\n", source, set.Date, set.Rates)
 		return nil
 	}
-	defer resp.Body.Close()
 
-	var result ExchangeRateResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return fmt.Errorf("failed to decode rates: %v", err)
+	fmt.Println("Error fetching exchange rates:", err)
+	ratesMutex.Lock()
+	ratesLastError = err.Error()
+	ratesMutex.Unlock()
+
+	// Fall back to the last rates we stored, never to made-up ones
+	if loadStoredExchangeRates() != nil {
+		alertStaleRates("No exchange rates are available: " + err.Error())
+		return fmt.Errorf("no exchange rates available: %v", err)
+	}
+	if exchangeRatesStale() {
+		alertStaleRates(err.Error())
+	}
+	return nil
+}
+
+// loadStoredExchangeRates fills the cache from the database if it is empty
+func loadStoredExchangeRates() error {
+	ratesMutex.RLock()
+	loaded := exchangeRatesCache != nil
+	ratesMutex.RUnlock()
+	if loaded {
+		return nil
 	}
 
+	set, source, fetchedAt, err := storedRatesOn(time.Now().UTC().Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
 	ratesMutex.Lock()
-	exchangeRatesCache = result.Rates
-	// Add AUD itself
-	exchangeRatesCache["AUD"] = 1.0
-	exchangeRatesCacheTime = time.Now()
+	exchangeRatesCache = set.Rates
+	exchangeRatesCacheTime = fetchedAt
+	exchangeRatesDate = set.Date
+	exchangeRatesSource = source
 	ratesMutex.Unlock()
-
-	fmt.Println("Exchange rates updated:", exchangeRatesCache)
 	return nil
 }
 
+func exchangeRatesStale() bool {
+	ratesMutex.RLock()
+	defer ratesMutex.RUnlock()
+	return exchangeRatesCache == nil || time.Since(exchangeRatesCacheTime) > exchangeRateStaleAfter()
+}
+
+// alertStaleRates emails admins, at most once a day
+func alertStaleRates(reason string) {
+	ratesMutex.Lock()
+	if time.Since(ratesLastAlert) < 24*time.Hour {
+		ratesMutex.Unlock()
+		return
+	}
+	ratesLastAlert = time.Now()
+	lastUpdate := "never"
+	if !exchangeRatesCacheTime.IsZero() {
+		lastUpdate = exchangeRatesCacheTime.Format(time.RFC1123)
+	}
+	ratesMutex.Unlock()
+
+	fmt.Printf("ALERT: Exchange rates are stale (last update %s): %s\n", lastUpdate, reason)
+	var admins []User
+	db.Where("role = ?", "admin").Find(&admins)
+	for _, admin := range admins {
+		SendExchangeRateAlert(db, admin.Email, lastUpdate, reason)
+	}
+}
+
+// currentExchangeRate returns the AUD-based rate for a currency with where it came from
+func currentExchangeRate(currency string) (rate float64, source, date string, err error) {
+	if err := FetchExchangeRates(); err != nil {
+		return 0, "", "", err
+	}
+	ratesMutex.RLock()
+	defer ratesMutex.RUnlock()
+	rate, ok := exchangeRatesCache[strings.ToUpper(currency)]
+	if !ok {
+		return 0, "", "", fmt.Errorf("no exchange rate for %s", currency)
+	}
+	return rate, exchangeRatesSource, exchangeRatesDate, nil
+}
+
+// stampExchangeRate records the rate in force when a payment was created
+func stampExchangeRate(payment *Payment) {
+	rate, source, date, err := currentExchangeRate(payment.Currency)
+	if err != nil {
+		fmt.Printf("WARNING: No exchange rate recorded for payment in %s: %v\n", payment.Currency, err)
+		return
+	}
+	payment.ExchangeRate = rate
+	payment.ExchangeRateSource = source
+	payment.ExchangeRateDate = date
+}
+
+// RatesOn returns the rates for a day: stored rates for that exact day,
+// otherwise fetched from a provider that serves history, otherwise the
+// latest stored rates before it
+func RatesOn(day time.Time) (RateSet, string, error) {
+	dayStr := day.Format("2006-01-02")
+	if set, source, _, err := storedRatesOn(dayStr); err == nil && set.Date == dayStr {
+		return set, source, nil
+	}
+
+	if set, source, err := fetchFromProviders(day); err == nil {
+		if storeErr := storeRates(set, source, time.Now()); storeErr != nil {
+			fmt.Println("WARNING: Failed to store exchange rates:", storeErr)
+		}
+		return set, source, nil
+	}
+
+	set, source, _, err := storedRatesOn(dayStr)
+	return set, source, err
+}
+
+// ConvertCurrency converts an amount between two supported currencies using the
+// cached AUD-based rates.
+func ConvertCurrency(amount float64, from, to string) (float64, error) {
+	from = strings.ToUpper(from)
+	to = strings.ToUpper(to)
+	if from == to {
+		return amount, nil
+	}
+
+	if err := FetchExchangeRates(); err != nil {
+		return 0, err
+	}
+
+	ratesMutex.RLock()
+	defer ratesMutex.RUnlock()
+	fromRate, ok := exchangeRatesCache[from]
+	if !ok || fromRate == 0 {
+		return 0, fmt.Errorf("no exchange rate for %s", from)
+	}
+	toRate, ok := exchangeRatesCache[to]
+	if !ok {
+		return 0, fmt.Errorf("no exchange rate for %s", to)
+	}
+	return amount / fromRate * toRate, nil
+}
+
 // --- Handlers ---
 
+// GetExchangeRates - Public - Latest AUD-based rates
 func GetExchangeRates(c *gin.Context) {
-	// Attempt to refresh if needed (non-blocking in bg ideally, but simple here)
-	// For production, this should be a background cron.
-	// Here we trigger it if stale, but don't block too long if API is slow?
-	// Actually, let's just call it.
+	if err := FetchExchangeRates(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Exchange rates are unavailable"})
+		return
+	}
 
-	// Check cache first
+	stale := exchangeRatesStale()
 	ratesMutex.RLock()
-	isStale := time.Since(exchangeRatesCacheTime) > 24*time.Hour || exchangeRatesCache == nil
+	response := ExchangeRateResponse{
+		Base:      rateBase,
+		Date:      exchangeRatesDate,
+		Source:    exchangeRatesSource,
+		Rates:     exchangeRatesCache,
+		UpdatedAt: exchangeRatesCacheTime,
+		Stale:     stale,
+	}
 	ratesMutex.RUnlock()
+	c.JSON(http.StatusOK, response)
+}
 
-	if isStale {
-		if err := FetchExchangeRates(); err != nil {
-			// If fail, return stale data if we have it, else error
-			ratesMutex.RLock()
-			if exchangeRatesCache != nil {
-				c.JSON(http.StatusOK, gin.H{"rates": exchangeRatesCache, "updated_at": exchangeRatesCacheTime, "warning": "using stale data"})
-				ratesMutex.RUnlock()
-				return
-			}
-			ratesMutex.RUnlock()
-			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to fetch exchange rates"})
-			return
-		}
+// GetExchangeRatesOnDate - Public - Stored rates in force on a date (YYYY-MM-DD).
+// Never calls a provider; missing days are backfilled by an admin.
+func GetExchangeRatesOnDate(c *gin.Context) {
+	day, err := time.Parse("2006-01-02", c.Param("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Date must be YYYY-MM-DD"})
+		return
+	}
+	if day.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Date is in the future"})
+		return
+	}
+
+	set, source, _, err := storedRatesOn(c.Param("date"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No exchange rates for that date"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"base":      rateBase,
+		"requested": c.Param("date"),
+		"date":      set.Date, // May be earlier, e.g. weekends use Friday's rates
+		"source":    source,
+		"rates":     set.Rates,
+	})
+}
+
+// GetExchangeRateStatus - Admin - Provider health and rate freshness
+func GetExchangeRateStatus(c *gin.Context) {
+	var names []string
+	for _, p := range exchangeRateProviders() {
+		names = append(names, p.Name())
 	}
 
+	stale := exchangeRatesStale()
 	ratesMutex.RLock()
 	defer ratesMutex.RUnlock()
 	c.JSON(http.StatusOK, gin.H{
-		"base":       "AUD",
-		"rates":      exchangeRatesCache,
-		"updated_at": exchangeRatesCacheTime,
+		"providers":    names,
+		"date":         exchangeRatesDate,
+		"source":       exchangeRatesSource,
+		"updated_at":   exchangeRatesCacheTime,
+		"stale":        stale,
+		"last_attempt": ratesLastAttempt,
+		"last_error":   ratesLastError,
 	})
 }
+
+// BackfillExchangeRates - Admin - Fetch and store the rates for a past date,
+// within EXCHANGE_RATE_HISTORY_DAYS of today
+func BackfillExchangeRates(c *gin.Context) {
+	day, err := time.Parse("2006-01-02", c.Param("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Date must be YYYY-MM-DD"})
+		return
+	}
+	if day.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Date is in the future"})
+		return
+	}
+	historyDays := envInt("EXCHANGE_RATE_HISTORY_DAYS", 366)
+	if day.Before(time.Now().AddDate(0, 0, -historyDays)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Rates can only be backfilled for the last %d days", historyDays)})
+		return
+	}
+
+	set, source, err := RatesOn(day)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"requested": c.Param("date"), "date": set.Date, "source": source, "rates": set.Rates})
+}
+
+// RefreshExchangeRates - Admin - Fetch the latest rates now
+func RefreshExchangeRates(c *gin.Context) {
+	if err := refreshExchangeRates(); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	ratesMutex.RLock()
+	defer ratesMutex.RUnlock()
+	if ratesLastError != "" {
+		c.JSON(http.StatusBadGateway, gin.H{"error": ratesLastError, "serving_from": exchangeRatesDate})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Exchange rates refreshed", "date": exchangeRatesDate, "source": exchangeRatesSource})
+}
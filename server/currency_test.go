@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useRatesFile serves rates from a file instead of the network
+func useRatesFile(t *testing.T, contents string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rates.json")
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+	t.Setenv("EXCHANGE_RATE_PROVIDERS", "file")
+	t.Setenv("EXCHANGE_RATES_FILE", path)
+}
+
+func TestFetchFromProviders(t *testing.T) {
+	useRatesFile(t, `[{"date":"2026-03-06","rates":{"INR":55.1,"USD":0.66,"JPY":98,"GBP":0}},{"date":"2026-03-09","rates":{"INR":55.4}}]`)
+
+	set, source, err := fetchFromProviders(time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, "file", source)
+	assert.Equal(t, "2026-03-06", set.Date, "weekends use the last published day")
+	assert.Equal(t, map[string]float64{"AUD": 1, "INR": 55.1, "USD": 0.66}, set.Rates, "unsupported and zero rates are dropped")
+
+	t.Setenv("EXCHANGE_RATE_PROVIDERS", "none")
+	t.Setenv("EXCHANGE_RATES_FILE", "")
+	_, _, err = fetchFromProviders(time.Time{})
+	assert.EqualError(t, err, "no exchange rate providers configured")
+}
+
+func TestGetExchangeRatesOnDate(t *testing.T) {
+	setupTestDB(t, &DailyExchangeRate{})
+	require.NoError(t, storeRates(RateSet{Date: "2026-03-06", Rates: map[string]float64{"AUD": 1, "INR": 55.1}}, "frankfurter", time.Now()))
+	// A provider would have rates for any day, but the public route must not ask it
+	useRatesFile(t, `[{"date":"2026-01-02","rates":{"INR":54}},{"date":"2026-03-09","rates":{"INR":55.4}}]`)
+
+	cases := []struct {
+		date   string
+		status int
+		served string
+	}{
+		{"2026-03-06", http.StatusOK, "2026-03-06"},
+		{"2026-03-08", http.StatusOK, "2026-03-06"},
+		{"2026-03-09", http.StatusOK, "2026-03-06"},
+		{"2026-01-02", http.StatusNotFound, ""},
+		{"06-03-2026", http.StatusBadRequest, ""},
+		{time.Now().AddDate(0, 0, 2).Format("2006-01-02"), http.StatusBadRequest, ""},
+	}
+	for _, tc := range cases {
+		w := callHandler(GetExchangeRatesOnDate, gin.Params{{Key: "date", Value: tc.date}}, nil)
+		assert.Equal(t, tc.status, w.Code, tc.date)
+		if tc.served != "" {
+			assert.Contains(t, w.Body.String(), `"date":"`+tc.served+`"`, tc.date)
+		}
+	}
+
+	var days int64
+	db.Model(&DailyExchangeRate{}).Distinct("date").Count(&days)
+	assert.Equal(t, int64(1), days, "public lookups store nothing")
+}
+
+func TestBackfillExchangeRates(t *testing.T) {
+	setupTestDB(t, &DailyExchangeRate{})
+	t.Setenv("EXCHANGE_RATE_HISTORY_DAYS", "30")
+	recent := time.Now().AddDate(0, 0, -3).Format("2006-01-02")
+	useRatesFile(t, `[{"date":"`+recent+`","rates":{"INR":55.4}},{"date":"2020-01-02","rates":{"INR":50}}]`)
+
+	cases := []struct {
+		date   string
+		status int
+	}{
+		{recent, http.StatusOK},
+		{"2020-01-02", http.StatusBadRequest},
+		{time.Now().AddDate(0, 0, 1).Format("2006-01-02"), http.StatusBadRequest},
+		{"yesterday", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		w := callHandler(BackfillExchangeRates, gin.Params{{Key: "date", Value: tc.date}}, nil)
+		assert.Equal(t, tc.status, w.Code, tc.date)
+	}
+
+	set, source, _, err := storedRatesOn(recent)
+	require.NoError(t, err)
+	assert.Equal(t, "file", source)
+	assert.Equal(t, 55.4, set.Rates["INR"])
+}
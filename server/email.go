@@ -1,178 +1,109 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
-	"html/template"
-	"log"
+	"kaivaliyayoga/internal/mailer"
 	"os"
 	"strconv"
 	"strings"
 
-	"gopkg.in/gomail.v2"
+	"gorm.io/gorm"
 )
 
-// EmailJob struct for the queue
+// EmailJob is an email to queue in the outbox (see queueEmail)
 type EmailJob struct {
-	To      string
-	Subject string
-	Html    string
+	To          string
+	Subject     string
+	Html        string
+	Category    string // transactional (default) or marketing
+	Attachments []mailer.Attachment
 }
 
-// Queue
-var emailQueue = make(chan EmailJob, 100)
-
-// StartEmailWorker - Background worker to process emails
-func _startEmailWorkerFromInit() {
-	go func() {
-		for job := range emailQueue {
-			sendEmailInternal(job)
-		}
-	}()
+// InitEmailService starts the outbox workers
+func InitEmailService() {
+	fmt.Println("Starting Email Workers...")
+	startOutboxWorkers(smtpMailerFromEnv())
 }
 
-// Internal sender using gomail
-func sendEmailInternal(job EmailJob) {
-	host := os.Getenv("SMTP_HOST")
-	portStr := os.Getenv("SMTP_PORT")
-	user := os.Getenv("SMTP_USER")
-	pass := os.Getenv("SMTP_PASSWORD")
-	from := os.Getenv("SMTP_FROM")
-
-	if host == "" {
-		host = "smtp.gmail.com" // Default
-	}
-	if portStr == "" {
-		portStr = "587" // Default
-	}
-	if from == "" {
-		from = user // Fallback
-	}
+// --- PUBLIC SENDERS ---
+// Each renders its template in the recipient's locale (see email_templates.go)
 
-	// Sanitize App Password (remove spaces)
-	pass = strings.ReplaceAll(pass, " ", "")
+// 1. Welcome Email
+func SendWelcomeEmail(tx *gorm.DB, to string, name string) error {
+	return sendTemplatedEmail(tx, to, "welcome", map[string]string{"Name": name, "Link": frontendURL() + "/profile"})
+}
 
-	port, _ := strconv.Atoi(portStr)
+// 2. Booking Confirmation
+func SendBookingConfirmation(tx *gorm.DB, to string, name string, className string, time string, attachments ...mailer.Attachment) error {
+	return sendTemplatedEmail(tx, to, "booking_confirmed", map[string]string{"Name": name, "ClassName": className, "Time": time}, attachments...)
+}
 
-	m := gomail.NewMessage()
-	m.SetHeader("From", from)
-	m.SetHeader("To", job.To)
-	m.SetHeader("Subject", job.Subject)
-	m.SetBody("text/html", job.Html)
+// 2b. Booking Cancellation
+func SendBookingCancellation(tx *gorm.DB, to string, name string, className string, time string, attachments ...mailer.Attachment) error {
+	return sendTemplatedEmail(tx, to, "booking_cancelled", map[string]string{"Name": name, "ClassName": className, "Time": time}, attachments...)
+}
 
-	d := gomail.NewDialer(host, port, user, pass)
+// 2c. Class Reminder (also used for appointments)
+func SendClassReminder(tx *gorm.DB, to string, name string, className string, time string, meetingURL string) error {
+	return sendTemplatedEmail(tx, to, "class_reminder", map[string]string{"Name": name, "ClassName": className, "Time": time, "MeetingURL": meetingURL})
+}
 
-	if err := d.DialAndSend(m); err != nil {
-		log.Printf("ERROR: Failed to send email to %s: %v\n", job.To, err)
-	} else {
-		log.Printf("INFO: Email sent to %s: %s\n", job.To, job.Subject)
-	}
+// 3. Payment Receipt
+func SendPaymentReceipt(tx *gorm.DB, to string, name string, amount string, orderId string) error {
+	return sendTemplatedEmail(tx, to, "payment_receipt", map[string]string{"Name": name, "Amount": amount, "OrderId": orderId})
 }
 
-// InitEmailService starts the worker
-func InitEmailService() {
-	fmt.Println("Starting Email Worker...")
-	_startEmailWorkerFromInit()
+// 4. Household Invitation
+func SendHouseholdInvite(tx *gorm.DB, to string, inviterName string, householdName string, token string) error {
+	link := frontendURL() + "/household/join?token=" + token
+	return sendTemplatedEmail(tx, to, "household_invite", map[string]string{"Inviter": inviterName, "Household": householdName, "Link": link})
 }
 
-// --- PUBLIC SENDERS ---
+// 5. Abandoned Checkout
+func SendAbandonedCheckout(tx *gorm.DB, to string, name string, item string, amount string) error {
+	return sendTemplatedEmail(tx, to, "abandoned_checkout", map[string]string{"Name": name, "Item": item, "Amount": amount, "Link": frontendURL() + "/pricing"})
+}
 
-// 1. Welcome Email
-func SendWelcomeEmail(to string, name string) {
-	tmpl := `
-	<div style="font-family: sans-serif; padding: 20px; color: #333;">
-		<h2 style="color: #2E7D32;">Welcome to Kaivaliya Yoga! 🌿</h2>
-		<p>Namaste <strong>{{.Name}}</strong>,</p>
-		<p>We are thrilled to have you join our community. Your journey to wellness begins here.</p>
-		<p>You can now book classes, enroll in courses, and purchase memberships.</p>
-		<a href="http://localhost:5173/profile" style="background: #2E7D32; color: white; padding: 10px 20px; text-decoration: none; border-radius: 5px;">Go to Profile</a>
-	</div>`
+// 6. Instalment Reminder
+func SendInstalmentReminder(tx *gorm.DB, to string, name string, program string, amount string, dueDate string) error {
+	return sendTemplatedEmail(tx, to, "instalment_reminder", map[string]string{"Name": name, "Program": program, "Amount": amount, "DueDate": dueDate, "Link": frontendURL() + "/programs/my"})
+}
 
-	html := parseTemplate(tmpl, map[string]string{"Name": name})
-	emailQueue <- EmailJob{To: to, Subject: "Welcome to Kaivaliya Yoga", Html: html}
+// 7. Gift Card
+func SendGiftCard(tx *gorm.DB, to string, name string, fromName string, code string, amount string, message string, expires string) error {
+	if name == "" {
+		name = "there"
+	}
+	return sendTemplatedEmail(tx, to, "gift_card", map[string]string{"Name": name, "From": fromName, "Amount": amount, "Message": message, "Code": code, "Expires": expires, "Link": frontendURL() + "/wallet/redeem"})
 }
 
-// 2. Booking Confirmation
-func SendBookingConfirmation(to string, name string, className string, time string) {
-	tmpl := `
-	<div style="font-family: sans-serif; padding: 20px; color: #333;">
-		<h2 style="color: #2E7D32;">Booking Confirmed! ✅</h2>
-		<p>Hi <strong>{{.Name}}</strong>,</p>
-		<p>Your spot is reserved for:</p>
-		<div style="background: #f5f5f5; padding: 15px; border-radius: 8px; margin: 10px 0;">
-			<h3 style="margin: 0;">{{.ClassName}}</h3>
-			<p style="margin: 5px 0;">⏰ {{.Time}}</p>
-		</div>
-		<p>Please arrive 10 minutes early. See you on the mat!</p>
-	</div>`
-
-	html := parseTemplate(tmpl, map[string]string{"Name": name, "ClassName": className, "Time": time})
-	emailQueue <- EmailJob{To: to, Subject: "Booking Confirmed: " + className, Html: html}
+// 8. Exchange Rate Alert (Admin)
+func SendExchangeRateAlert(tx *gorm.DB, to string, lastUpdate string, reason string) error {
+	return sendTemplatedEmail(tx, to, "exchange_rate_alert", map[string]string{"LastUpdate": lastUpdate, "Reason": reason})
 }
 
-// 2b. Booking Cancellation
-func SendBookingCancellation(to string, name string, className string, time string) {
-	tmpl := `
-	<div style="font-family: sans-serif; padding: 20px; color: #333;">
-		<h2 style="color: #D32F2F;">Booking Cancelled ❌</h2>
-		<p>Hi <strong>{{.Name}}</strong>,</p>
-		<p>Your booking has been cancelled as requested.</p>
-		<div style="background: #f5f5f5; padding: 15px; border-radius: 8px; margin: 10px 0;">
-			<h3 style="margin: 0;">{{.ClassName}}</h3>
-			<p style="margin: 5px 0;">⏰ {{.Time}}</p>
-		</div>
-		<p>We hope to see you in another class soon!</p>
-	</div>`
-
-	html := parseTemplate(tmpl, map[string]string{"Name": name, "ClassName": className, "Time": time})
-	emailQueue <- EmailJob{To: to, Subject: "Booking Cancelled: " + className, Html: html}
-}
-
-// 2c. Class Reminder
-func SendClassReminder(to string, name string, className string, time string) {
-	tmpl := `
-	<div style="font-family: sans-serif; padding: 20px; color: #333;">
-		<h2 style="color: #1976D2;">Class Reminder 🔔</h2>
-		<p>Hi <strong>{{.Name}}</strong>,</p>
-		<p>Just a friendly reminder that you have a class coming up soon!</p>
-		<div style="background: #E3F2FD; padding: 15px; border-radius: 8px; margin: 10px 0;">
-			<h3 style="margin: 0;">{{.ClassName}}</h3>
-			<p style="margin: 5px 0;">⏰ {{.Time}}</p>
-		</div>
-		<p>Don't forget your mat!</p>
-	</div>`
-
-	html := parseTemplate(tmpl, map[string]string{"Name": name, "ClassName": className, "Time": time})
-	emailQueue <- EmailJob{To: to, Subject: "Reminder: " + className, Html: html}
+// 9. Appointment Update
+func SendAppointmentUpdate(tx *gorm.DB, to string, name string, heading string, message string, when string, reference string, attachments ...mailer.Attachment) error {
+	return sendTemplatedEmail(tx, to, "appointment_update", map[string]string{"Name": name, "Heading": heading, "Message": message, "When": when, "Reference": reference, "Link": frontendURL() + "/appointments"}, attachments...)
 }
 
-// 3. Payment Receipt
-func SendPaymentReceipt(to string, name string, amount string, orderId string) {
-	tmpl := `
-	<div style="font-family: sans-serif; padding: 20px; color: #333;">
-		<h2 style="color: #2E7D32;">Payment Receipt 🧾</h2>
-		<p>Hi <strong>{{.Name}}</strong>,</p>
-		<p>Thank you for your payment.</p>
-		<table style="width: 100%; text-align: left;">
-			<tr><th>Order ID</th><td>{{.OrderId}}</td></tr>
-			<tr><th>Amount</th><td>₹{{.Amount}}</td></tr>
-			<tr><th>Status</th><td style="color: green;">Success</td></tr>
-		</table>
-	</div>`
-
-	html := parseTemplate(tmpl, map[string]string{"Name": name, "Amount": amount, "OrderId": orderId})
-	emailQueue <- EmailJob{To: to, Subject: "Payment Receipt - Kaivaliya Yoga", Html: html}
+func SendNewMessage(tx *gorm.DB, to string, name string, senderName string, count int, preview string, link string) error {
+	return sendTemplatedEmail(tx, to, "new_message", map[string]string{"Name": name, "SenderName": senderName, "Count": strconv.Itoa(count), "Preview": preview, "Link": link})
 }
 
 // Helper
-func parseTemplate(tmplStr string, data interface{}) string {
-	t, err := template.New("email").Parse(tmplStr)
-	if err != nil {
-		return "Error parsing template"
+func frontendURL() string {
+	if url := os.Getenv("FRONTEND_URL"); url != "" {
+		return strings.TrimRight(url, "/")
 	}
-	var buf bytes.Buffer
-	if err := t.Execute(&buf, data); err != nil {
-		return "Error executing template"
+	return "http://localhost:5173"
+}
+
+// apiURL is where this server is reachable from the outside (API_URL), for
+// links that must hit the API rather than the frontend
+func apiURL() string {
+	if url := os.Getenv("API_URL"); url != "" {
+		return strings.TrimRight(url, "/")
 	}
-	return buf.String()
+	return "http://localhost:8080"
 }
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"kaivaliyayoga/internal/mailer"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Outbox email states
+const (
+	EmailPending  = "pending"  // Waiting for its first attempt
+	EmailSending  = "sending"  // Claimed by a worker
+	EmailRetrying = "retrying" // Last attempt failed, retried at NextAttemptAt
+	EmailSent     = "sent"
+	EmailDead     = "dead"       // Out of attempts or bounced; an admin can resend it
+	EmailBlocked  = "suppressed" // Address is on the suppression list
+)
+
+// How long a worker holds a claimed email before another worker may retry it,
+// e.g. after a crash mid-send
+const emailClaimTimeout = 5 * time.Minute
+
+// outboxWake lets queueEmail nudge an idle worker instead of waiting for the next poll
+var outboxWake = make(chan struct{}, 1)
+
+// --- Models ---
+
+// OutboxEmail is an email waiting to be sent. It is written in the same
+// transaction as the event that triggered it, so nothing is lost on restart.
+type OutboxEmail struct {
+	ID            uint           `json:"id" gorm:"primaryKey"`
+	Recipient     string         `json:"recipient" gorm:"index;not null"`
+	Subject       string         `json:"subject"`
+	Html          string         `json:"html,omitempty" gorm:"type:text"`
+	Category      string         `json:"category" gorm:"default:'transactional'"`
+	Attachments   datatypes.JSON `json:"attachments,omitempty"` // []mailer.Attachment
+	Status        string         `json:"status" gorm:"index;default:'pending'"`
+	Attempts      int            `json:"attempts"`
+	MaxAttempts   int            `json:"max_attempts"`
+	NextAttemptAt time.Time      `json:"next_attempt_at" gorm:"index"`
+	LockedUntil   *time.Time     `json:"locked_until"`
+	LastError     string         `json:"last_error"`
+	SentAt        *time.Time     `json:"sent_at"`
+	ResentBy      *uint          `json:"resent_by"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// --- Logic ---
+
+// queueEmail adds an email to the outbox using tx, so it is only sent if the
+// surrounding transaction commits. Email to a suppressed address is recorded
+// but never sent.
+func queueEmail(tx *gorm.DB, job EmailJob) error {
+	if job.To == "" {
+		log.Printf("WARNING: Dropping email %q with no recipient\n", job.Subject)
+		return nil
+	}
+	if job.Category == "" {
+		job.Category = EmailTransactional
+	}
+	email := OutboxEmail{
+		Recipient:     job.To,
+		Subject:       job.Subject,
+		Html:          job.Html,
+		Category:      job.Category,
+		Status:        EmailPending,
+		MaxAttempts:   envInt("EMAIL_MAX_ATTEMPTS", 8),
+		NextAttemptAt: time.Now(),
+	}
+	if len(job.Attachments) > 0 {
+		attachments, err := json.Marshal(job.Attachments)
+		if err != nil {
+			return fmt.Errorf("failed to queue email: %w", err)
+		}
+		email.Attachments = datatypes.JSON(attachments)
+	}
+	if entry, blocked := suppressionFor(tx, job.To, job.Category); blocked {
+		email.Status = EmailBlocked
+		email.LastError = "suppressed: " + entry.Reason
+	}
+	if err := tx.Create(&email).Error; err != nil {
+		return fmt.Errorf("failed to queue email: %w", err)
+	}
+	wakeOutbox()
+	return nil
+}
+
+// wakeOutbox prompts an idle worker to look for new emails straight away
+func wakeOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// emailRetryDelay backs off exponentially from EMAIL_RETRY_BASE_SECONDS, capped at 6 hours
+func emailRetryDelay(attempts int) time.Duration {
+	delay := time.Duration(envInt("EMAIL_RETRY_BASE_SECONDS", 30)) * time.Second
+	for i := 1; i < attempts && delay < 6*time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > 6*time.Hour {
+		delay = 6 * time.Hour
+	}
+	return delay
+}
+
+// claimNextEmail takes the oldest due email for this worker. The claim only
+// succeeds if no other worker changed the row first.
+func claimNextEmail() (OutboxEmail, bool) {
+	for tries := 0; tries < 3; tries++ {
+		now := time.Now()
+		var due []OutboxEmail
+		db.Where("(status IN ? AND next_attempt_at <= ?) OR (status = ? AND locked_until < ?)",
+			[]string{EmailPending, EmailRetrying}, now, EmailSending, now).
+			Order("next_attempt_at asc").Limit(1).Find(&due)
+		if len(due) == 0 {
+			return OutboxEmail{}, false
+		}
+		email := due[0]
+
+		lockedUntil := now.Add(emailClaimTimeout)
+		res := db.Model(&OutboxEmail{}).
+			Where("id = ? AND status = ? AND attempts = ?", email.ID, email.Status, email.Attempts).
+			Updates(map[string]interface{}{
+				"status":       EmailSending,
+				"attempts":     email.Attempts + 1,
+				"locked_until": &lockedUntil,
+			})
+		if res.Error == nil && res.RowsAffected == 1 {
+			email.Status = EmailSending
+			email.Attempts++
+			return email, true
+		}
+	}
+	return OutboxEmail{}, false
+}
+
+// deliverEmail sends a claimed email and records the outcome. The suppression
+// list is checked again as the address may have bounced since it was queued.
+func deliverEmail(m mailer.Mailer, email OutboxEmail) {
+	if entry, blocked := suppressionFor(db, email.Recipient, email.Category); blocked {
+		db.Model(&email).Updates(map[string]interface{}{
+			"status":       EmailBlocked,
+			"locked_until": nil,
+			"last_error":   "suppressed: " + entry.Reason,
+		})
+		return
+	}
+
+	msg := mailer.Message{To: email.Recipient, Subject: email.Subject, HTML: email.Html}
+	if email.Category == EmailMarketing {
+		msg.Headers = listUnsubscribeHeaders(email.Recipient)
+	}
+	if len(email.Attachments) > 0 {
+		json.Unmarshal(email.Attachments, &msg.Attachments)
+	}
+	err := m.Send(msg)
+	now := time.Now()
+	if err == nil {
+		db.Model(&email).Updates(map[string]interface{}{
+			"status":       EmailSent,
+			"sent_at":      &now,
+			"locked_until": nil,
+			"last_error":   "",
+		})
+		log.Printf("INFO: Email sent to %s: %s\n", email.Recipient, email.Subject)
+		return
+	}
+
+	updates := map[string]interface{}{"locked_until": nil, "last_error": err.Error()}
+	if mailer.IsRecipientRejected(err) {
+		// Hard bounce: retrying won't help, and nothing else should go there
+		updates["status"] = EmailDead
+		suppressEmail(db, EmailSuppression{Email: email.Recipient, Scope: SuppressAll, Reason: SuppressHardBounce, Source: "smtp", Detail: err.Error()})
+		log.Printf("ERROR: Email %d to %s bounced: %v\n", email.ID, email.Recipient, err)
+	} else if email.Attempts >= email.MaxAttempts {
+		updates["status"] = EmailDead
+		log.Printf("ERROR: Giving up on email %d to %s after %d attempts: %v\n", email.ID, email.Recipient, email.Attempts, err)
+	} else {
+		updates["status"] = EmailRetrying
+		updates["next_attempt_at"] = now.Add(emailRetryDelay(email.Attempts))
+		log.Printf("WARNING: Email %d to %s failed (attempt %d of %d): %v\n", email.ID, email.Recipient, email.Attempts, email.MaxAttempts, err)
+	}
+	db.Model(&email).Updates(updates)
+}
+
+// startOutboxWorkers runs EMAIL_WORKERS workers that drain the outbox
+func startOutboxWorkers(m mailer.Mailer) {
+	workers := envInt("EMAIL_WORKERS", 4)
+	if workers < 1 {
+		workers = 1
+	}
+	poll := time.Duration(envInt("EMAIL_POLL_SECONDS", 5)) * time.Second
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				email, ok := claimNextEmail()
+				if !ok {
+					select {
+					case <-outboxWake:
+					case <-time.After(poll):
+					}
+					continue
+				}
+				deliverEmail(m, email)
+			}
+		}()
+	}
+	fmt.Printf("Started %d email outbox workers\n", workers)
+}
+
+// PruneSentEmails deletes sent emails older than EMAIL_OUTBOX_RETENTION_DAYS
+func PruneSentEmails() int {
+	cutoff := time.Now().AddDate(0, 0, -envInt("EMAIL_OUTBOX_RETENTION_DAYS", 30))
+	res := db.Where("status = ? AND sent_at < ?", EmailSent, cutoff).Delete(&OutboxEmail{})
+	return int(res.RowsAffected)
+}
+
+// smtpMailerFromEnv configures the SMTP relay from SMTP_* variables
+func smtpMailerFromEnv() mailer.Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		host = "smtp.gmail.com" // Default
+	}
+	port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	if err != nil {
+		port = 587 // Default
+	}
+	return mailer.NewSMTP(mailer.SMTPConfig{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USER"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	})
+}
+
+// --- Handlers ---
+
+// GetOutboxEmails - Admin - Outbox emails, optionally by ?status= and ?recipient=, with counts per status
+func GetOutboxEmails(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset := (page - 1) * limit
+
+	query := db.Model(&OutboxEmail{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if recipient := c.Query("recipient"); recipient != "" {
+		query = query.Where("recipient = ?", recipient)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var emails []OutboxEmail
+	query.Omit("html", "attachments").Order("created_at desc").Limit(limit).Offset(offset).Find(&emails)
+
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	db.Model(&OutboxEmail{}).Select("status, count(*) as count").Group("status").Scan(&rows)
+	counts := map[string]int64{}
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":   emails,
+		"total":  total,
+		"page":   page,
+		"limit":  limit,
+		"counts": counts,
+	})
+}
+
+// GetOutboxEmail - Admin - One email with its body and last error
+func GetOutboxEmail(c *gin.Context) {
+	var email OutboxEmail
+	if err := db.First(&email, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+		return
+	}
+	c.JSON(http.StatusOK, email)
+}
+
+// ResendOutboxEmail - Admin - Queue a failed email for a fresh set of attempts
+func ResendOutboxEmail(c *gin.Context) {
+	adminID, _ := currentUserID(c)
+
+	var email OutboxEmail
+	if err := db.First(&email, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+		return
+	}
+	if email.Status != EmailDead && email.Status != EmailRetrying && email.Status != EmailBlocked {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only failed emails can be resent"})
+		return
+	}
+	if entry, blocked := suppressionFor(db, email.Recipient, email.Category); blocked {
+		c.JSON(http.StatusConflict, gin.H{"error": "Address is suppressed (" + entry.Reason + "), remove it from the suppression list first"})
+		return
+	}
+
+	res := db.Model(&OutboxEmail{}).Where("id = ? AND status = ?", email.ID, email.Status).Updates(map[string]interface{}{
+		"status":          EmailPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+		"resent_by":       adminID,
+	})
+	if res.Error != nil || res.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email changed while resending, try again"})
+		return
+	}
+	wakeOutbox()
+	db.First(&email, email.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Email queued for resend", "email": email})
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Email categories. Transactional email (receipts, bookings, reminders) is
+// part of a service the recipient asked for; marketing email can be
+// unsubscribed from.
+const (
+	EmailTransactional = "transactional"
+	EmailMarketing     = "marketing"
+)
+
+// Suppression scopes
+const (
+	SuppressAll       = "all"       // Nothing is sent to the address
+	SuppressMarketing = "marketing" // Only marketing email is held back
+)
+
+// Suppression reasons
+const (
+	SuppressHardBounce  = "hard_bounce"
+	SuppressComplaint   = "complaint"
+	SuppressUnsubscribe = "unsubscribe"
+	SuppressManual      = "manual"
+)
+
+// Template keys sent as marketing email
+var marketingEmails = map[string]bool{
+	"abandoned_checkout": true,
+}
+
+// --- Models ---
+
+// EmailSuppression stops email to an address. Bounces and complaints stop
+// everything; an unsubscribe stops marketing email only.
+type EmailSuppression struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Email     string    `json:"email" gorm:"uniqueIndex:idx_email_suppression;not null"` // Lowercased
+	Scope     string    `json:"scope" gorm:"uniqueIndex:idx_email_suppression"`          // all, marketing
+	Reason    string    `json:"reason" gorm:"index"`                                     // hard_bounce, complaint, unsubscribe, manual
+	Source    string    `json:"source"`                                                  // smtp, webhook, link, admin
+	Detail    string    `json:"detail"`
+	CreatedBy *uint     `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// --- DTOs ---
+
+type SuppressionInput struct {
+	Email  string `json:"email" binding:"required,email"`
+	Scope  string `json:"scope"` // Defaults to all
+	Detail string `json:"detail"`
+}
+
+// EmailEventInput is the payload of the inbound bounce/complaint webhook. The
+// mail provider (or a small relay in front of it) posts events in this form,
+// signed with EMAIL_WEBHOOK_SECRET.
+type EmailEventInput struct {
+	Events []struct {
+		Type       string `json:"type"` // bounce, complaint, unsubscribe
+		Email      string `json:"email"`
+		BounceType string `json:"bounce_type"` // permanent, transient
+		Detail     string `json:"detail"`
+	} `json:"events"`
+}
+
+// --- Logic ---
+
+// emailCategory is the category a template is sent as
+func emailCategory(key string) string {
+	if marketingEmails[key] {
+		return EmailMarketing
+	}
+	return EmailTransactional
+}
+
+// suppressionFor returns the suppression that blocks a category of email to
+// an address, if any
+func suppressionFor(tx *gorm.DB, email, category string) (EmailSuppression, bool) {
+	scopes := []string{SuppressAll}
+	if category == EmailMarketing {
+		scopes = append(scopes, SuppressMarketing)
+	}
+	var found []EmailSuppression
+	tx.Where("email = ? AND scope IN ?", strings.ToLower(strings.TrimSpace(email)), scopes).
+		Order("id asc").Limit(1).Find(&found)
+	if len(found) == 0 {
+		return EmailSuppression{}, false
+	}
+	return found[0], true
+}
+
+// suppressEmail adds an address to the suppression list; an existing entry
+// for the same scope is kept as it was
+func suppressEmail(tx *gorm.DB, entry EmailSuppression) error {
+	entry.Email = strings.ToLower(strings.TrimSpace(entry.Email))
+	if entry.Scope == "" {
+		entry.Scope = SuppressAll
+	}
+	res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry)
+	if res.Error == nil && res.RowsAffected > 0 {
+		log.Printf("INFO: Suppressed %s for %s email (%s via %s)\n", entry.Email, entry.Scope, entry.Reason, entry.Source)
+	}
+	return res.Error
+}
+
+// unsubscribeSecret signs unsubscribe links (EMAIL_UNSUBSCRIBE_SECRET)
+func unsubscribeSecret() []byte {
+	if secret := os.Getenv("EMAIL_UNSUBSCRIBE_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return jwtSecret
+}
+
+func unsubscribeSignature(email string) string {
+	mac := hmac.New(sha256.New, unsubscribeSecret())
+	mac.Write([]byte("unsubscribe|" + strings.ToLower(email)))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// unsubscribeURL is the signed one-click unsubscribe link for an address
+func unsubscribeURL(email string) string {
+	q := url.Values{
+		"e": {base64.RawURLEncoding.EncodeToString([]byte(strings.ToLower(email)))},
+		"t": {unsubscribeSignature(email)},
+	}
+	return apiURL() + "/api/email/unsubscribe?" + q.Encode()
+}
+
+// unsubscribeEmail checks an unsubscribe link's parameters and returns the address
+func unsubscribeEmail(c *gin.Context) (string, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(c.Query("e"))
+	if err != nil || len(raw) == 0 {
+		return "", false
+	}
+	email := string(raw)
+	if !hmac.Equal([]byte(c.Query("t")), []byte(unsubscribeSignature(email))) {
+		return "", false
+	}
+	return email, true
+}
+
+// listUnsubscribeHeaders are the RFC 8058 one-click unsubscribe headers
+func listUnsubscribeHeaders(email string) map[string]string {
+	return map[string]string{
+		"List-Unsubscribe":      "<" + unsubscribeURL(email) + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Kaivaliya Yoga</title></head>
+<body style="font-family: sans-serif; padding: 40px; color: #333; max-width: 480px; margin: auto;">
+	<h2 style="color: #2E7D32;">{{.Heading}}</h2>
+	<p>{{.Message}}</p>
+	{{if .Action}}<form method="POST" action="{{.Action}}">
+		<button type="submit" style="background: #2E7D32; color: white; padding: 10px 20px; border: 0; border-radius: 5px;">Unsubscribe</button>
+	</form>{{end}}
+</body></html>`))
+
+func renderUnsubscribePage(c *gin.Context, status int, heading, message, action string) {
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	unsubscribePage.Execute(c.Writer, map[string]string{"Heading": heading, "Message": message, "Action": action})
+}
+
+// --- Handlers ---
+
+// GetUnsubscribe - Public - Confirmation page for an unsubscribe link. Mail
+// scanners follow links, so unsubscribing needs the POST.
+func GetUnsubscribe(c *gin.Context) {
+	email, ok := unsubscribeEmail(c)
+	if !ok {
+		renderUnsubscribePage(c, http.StatusBadRequest, "Link not valid", "This unsubscribe link is incomplete or has been changed.", "")
+		return
+	}
+	renderUnsubscribePage(c, http.StatusOK, "Unsubscribe",
+		"Stop marketing emails to "+email+"? You will still receive booking confirmations, receipts and reminders.",
+		c.Request.URL.RequestURI())
+}
+
+// PostUnsubscribe - Public - One-click unsubscribe (RFC 8058) and the confirmation page's button
+func PostUnsubscribe(c *gin.Context) {
+	email, ok := unsubscribeEmail(c)
+	if !ok {
+		renderUnsubscribePage(c, http.StatusBadRequest, "Link not valid", "This unsubscribe link is incomplete or has been changed.", "")
+		return
+	}
+	err := suppressEmail(db, EmailSuppression{Email: email, Scope: SuppressMarketing, Reason: SuppressUnsubscribe, Source: "link"})
+	if err != nil {
+		renderUnsubscribePage(c, http.StatusInternalServerError, "Something went wrong", "Please try again in a moment.", "")
+		return
+	}
+	renderUnsubscribePage(c, http.StatusOK, "You're unsubscribed", email+" will no longer receive marketing emails from Kaivaliya Yoga.", "")
+}
+
+// HandleEmailEvents - Public (signed) - Bounce, complaint and unsubscribe events from the mail provider
+func HandleEmailEvents(c *gin.Context) {
+	secret := os.Getenv("EMAIL_WEBHOOK_SECRET")
+	if secret == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Email webhook is not configured"})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal([]byte(c.GetHeader("X-Signature")), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return
+	}
+
+	var input EmailEventInput
+	if err := json.Unmarshal(body, &input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	suppressed, ignored := 0, 0
+	for _, event := range input.Events {
+		entry := EmailSuppression{Email: event.Email, Source: "webhook", Detail: event.Detail}
+		switch {
+		case event.Email == "":
+			ignored++
+			continue
+		case event.Type == "bounce" && event.BounceType != "transient":
+			entry.Scope, entry.Reason = SuppressAll, SuppressHardBounce
+		case event.Type == "complaint":
+			entry.Scope, entry.Reason = SuppressAll, SuppressComplaint
+		case event.Type == "unsubscribe":
+			entry.Scope, entry.Reason = SuppressMarketing, SuppressUnsubscribe
+		default: // Transient bounces are retried by the outbox
+			ignored++
+			continue
+		}
+		if err := suppressEmail(db, entry); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record event"})
+			return
+		}
+		suppressed++
+	}
+	c.JSON(http.StatusOK, gin.H{"suppressed": suppressed, "ignored": ignored})
+}
+
+// ResubscribeMarketingEmail - Protected - Undo the user's own marketing unsubscribe
+func ResubscribeMarketingEmail(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	var user User
+	if err := db.First(&user, uid).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	db.Where("email = ? AND scope = ? AND reason = ?", strings.ToLower(user.Email), SuppressMarketing, SuppressUnsubscribe).
+		Delete(&EmailSuppression{})
+
+	// Bounces and complaints need an admin to clear them
+	if entry, blocked := suppressionFor(db, user.Email, EmailMarketing); blocked {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email to this address is blocked (" + entry.Reason + "), contact the studio"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Subscribed to marketing emails"})
+}
+
+// GetEmailSuppressions - Admin - Suppressed addresses, by ?email=, ?reason= and ?scope=
+func GetEmailSuppressions(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset := (page - 1) * limit
+
+	query := db.Model(&EmailSuppression{})
+	if email := c.Query("email"); email != "" {
+		query = query.Where("email LIKE ?", "%"+strings.ToLower(email)+"%")
+	}
+	if reason := c.Query("reason"); reason != "" {
+		query = query.Where("reason = ?", reason)
+	}
+	if scope := c.Query("scope"); scope != "" {
+		query = query.Where("scope = ?", scope)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var suppressions []EmailSuppression
+	query.Order("created_at desc").Limit(limit).Offset(offset).Find(&suppressions)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  suppressions,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// CreateEmailSuppression - Admin - Suppress an address by hand
+func CreateEmailSuppression(c *gin.Context) {
+	adminID, _ := currentUserID(c)
+	var input SuppressionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Scope == "" {
+		input.Scope = SuppressAll
+	}
+	if input.Scope != SuppressAll && input.Scope != SuppressMarketing {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Scope must be all or marketing"})
+		return
+	}
+
+	entry := EmailSuppression{
+		Email:     input.Email,
+		Scope:     input.Scope,
+		Reason:    SuppressManual,
+		Source:    "admin",
+		Detail:    input.Detail,
+		CreatedBy: &adminID,
+	}
+	if err := suppressEmail(db, entry); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to suppress address"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Address suppressed"})
+}
+
+// DeleteEmailSuppression - Admin - Allow email to an address again, e.g. after a mailbox is fixed
+func DeleteEmailSuppression(c *gin.Context) {
+	res := db.Delete(&EmailSuppression{}, c.Param("id"))
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Suppression not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Suppression removed"})
+}
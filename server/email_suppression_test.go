@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUnsubscribeLink(t *testing.T) {
+	parse := func(link string) (string, bool) {
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", u.RequestURI(), nil)
+		return unsubscribeEmail(c)
+	}
+
+	link := unsubscribeURL("Asha@Example.com")
+	if email, ok := parse(link); !ok || email != "asha@example.com" {
+		t.Fatalf("unsubscribeEmail(%s) = %q, %v", link, email, ok)
+	}
+
+	u, _ := url.Parse(link)
+	q := u.Query()
+	q.Set("e", "cmF2aUBleGFtcGxlLmNvbQ") // ravi@example.com with Asha's signature
+	u.RawQuery = q.Encode()
+	if email, ok := parse(u.String()); ok {
+		t.Errorf("tampered link accepted for %q", email)
+	}
+}
+
+func TestEmailCategory(t *testing.T) {
+	if emailCategory("abandoned_checkout") != EmailMarketing {
+		t.Error("abandoned checkout should be marketing email")
+	}
+	if emailCategory("payment_receipt") != EmailTransactional {
+		t.Error("payment receipts should be transactional email")
+	}
+}
@@ -0,0 +1,706 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"kaivaliyayoga/internal/mailer"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Built-in templates, one file per key and locale (<key>.<locale>.html). The
+// first line is "Subject: ..." followed by a blank line and the HTML body.
+//
+//go:embed email_templates/*.html
+var defaultEmailTemplates embed.FS
+
+// The locale every template has, used when nothing closer exists
+const defaultEmailLocale = "en"
+
+// Template variable types, checked when preview or test data is supplied
+const (
+	VarString   = "string"
+	VarURL      = "url"
+	VarDateTime = "datetime" // Already formatted for the recipient, e.g. "Mon 2 Jun, 7:00 AM AEST"
+	VarMoney    = "money"    // Amount with its currency, e.g. "20.00 AUD"
+	VarCode     = "code"     // Reference or voucher code
+)
+
+// TemplateVar is one variable a template may use
+type TemplateVar struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required"` // Every version must use it
+	Sample   string `json:"sample"`
+}
+
+// EmailTemplateSchema describes what an email is for and the data it is rendered with
+type EmailTemplateSchema struct {
+	Key         string        `json:"key"`
+	Description string        `json:"description"`
+	Vars        []TemplateVar `json:"vars"`
+}
+
+var emailTemplateSchemas = []EmailTemplateSchema{
+	{"welcome", "Sent when an account is created", []TemplateVar{
+		{"Name", VarString, false, "Asha"},
+		{"Link", VarURL, true, "https://kaivaliyayoga.com/profile"},
+	}},
+	{"booking_confirmed", "A class booking was confirmed", []TemplateVar{
+		{"Name", VarString, false, "Asha"},
+		{"ClassName", VarString, true, "Hatha Flow"},
+		{"Time", VarDateTime, true, "Monday 07:00"},
+	}},
+	{"booking_cancelled", "A class booking was cancelled", []TemplateVar{
+		{"Name", VarString, false, "Asha"},
+		{"ClassName", VarString, true, "Hatha Flow"},
+		{"Time", VarDateTime, true, "Monday 07:00"},
+	}},
+	{"class_reminder", "Reminder before a class or appointment", []TemplateVar{
+		{"Name", VarString, false, "Asha"},
+		{"ClassName", VarString, true, "Hatha Flow"},
+		{"Time", VarDateTime, true, "Mon 2 Jun, 7:00 AM AEST"},
+		{"MeetingURL", VarURL, false, "https://meet.example.com/hatha"},
+	}},
+	{"payment_receipt", "Receipt for a successful payment", []TemplateVar{
+		{"Name", VarString, false, "Asha"},
+		{"Amount", VarMoney, true, "150.00 AUD"},
+		{"OrderId", VarCode, true, "order_Nx3K9"},
+	}},
+	{"household_invite", "Invitation to join a household", []TemplateVar{
+		{"Inviter", VarString, true, "Ravi"},
+		{"Household", VarString, true, "The Sharmas"},
+		{"Link", VarURL, true, "https://kaivaliyayoga.com/household/join?token=sample"},
+	}},
+	{"abandoned_checkout", "Nudge after a checkout was left unpaid", []TemplateVar{
+		{"Name", VarString, false, "Asha"},
+		{"Item", VarString, true, "10 Class Pack"},
+		{"Amount", VarMoney, false, "150.00 AUD"},
+		{"Link", VarURL, true, "https://kaivaliyayoga.com/pricing"},
+		{"UnsubscribeURL", VarURL, true, "https://api.kaivaliyayoga.com/api/email/unsubscribe?e=sample&t=sample"},
+	}},
+	{"instalment_reminder", "An instalment for a program is due", []TemplateVar{
+		{"Name", VarString, false, "Asha"},
+		{"Program", VarString, true, "200hr Teacher Training"},
+		{"Amount", VarMoney, true, "500.00 AUD"},
+		{"DueDate", VarDateTime, true, "2 Jun 2026"},
+		{"Link", VarURL, true, "https://kaivaliyayoga.com/programs/my"},
+	}},
+	{"gift_card", "A gift card sent to its recipient", []TemplateVar{
+		{"Name", VarString, false, "Asha"},
+		{"From", VarString, true, "Ravi"},
+		{"Amount", VarMoney, true, "50.00 AUD"},
+		{"Message", VarString, false, "Happy birthday!"},
+		{"Code", VarCode, true, "GIFT-7K2P-QX9M"},
+		{"Expires", VarDateTime, false, "2 Jun 2027"},
+		{"Link", VarURL, false, "https://kaivaliyayoga.com/wallet/redeem"},
+	}},
+	{"exchange_rate_alert", "Admin alert when exchange rates are stale", []TemplateVar{
+		{"LastUpdate", VarDateTime, true, "2 Jun 2026 07:00 UTC"},
+		{"Reason", VarString, true, "rate provider returned 503"},
+	}},
+	{"appointment_update", "An appointment was booked, moved or cancelled", []TemplateVar{
+		{"Name", VarString, false, "Asha"},
+		{"Heading", VarString, true, "Appointment Confirmed"},
+		{"Message", VarString, false, "Your session has been booked."},
+		{"When", VarDateTime, true, "Mon 2 Jun, 7:00 AM AEST"},
+		{"Reference", VarCode, true, "APT-4821"},
+		{"Link", VarURL, false, "https://kaivaliyayoga.com/appointments"},
+	}},
+	{"new_message", "Unread messages, sent when the recipient was offline", []TemplateVar{
+		{"Name", VarString, false, "Asha"},
+		{"SenderName", VarString, true, "Dr. Meera Rao"},
+		{"Count", VarString, true, "2"},
+		{"Preview", VarString, true, "Happy to move our session to Thursday if that suits you."},
+		{"Link", VarURL, true, "https://kaivaliyayoga.com/messages/12"},
+	}},
+}
+
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// --- Models ---
+
+// EmailTemplate is an admin-edited version of a built-in template for one
+// locale. At most one version per key and locale is active; with none active
+// the built-in template is used.
+type EmailTemplate struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Key       string    `json:"key" gorm:"uniqueIndex:idx_email_template_version;not null"`
+	Locale    string    `json:"locale" gorm:"uniqueIndex:idx_email_template_version;not null"`
+	Version   int       `json:"version" gorm:"uniqueIndex:idx_email_template_version"`
+	Subject   string    `json:"subject"`
+	Html      string    `json:"html" gorm:"type:text"`
+	IsActive  bool      `json:"is_active" gorm:"index"`
+	Notes     string    `json:"notes"`
+	CreatedBy uint      `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// --- DTOs ---
+
+type EmailTemplateVersionInput struct {
+	Locale   string `json:"locale" binding:"required"`
+	Subject  string `json:"subject" binding:"required"`
+	Html     string `json:"html" binding:"required"`
+	Notes    string `json:"notes"`
+	Activate bool   `json:"activate"`
+}
+
+type EmailTemplatePreviewInput struct {
+	Locale string            `json:"locale"`
+	Data   map[string]string `json:"data"` // Overrides the sample values
+	// Render an unsaved draft instead of the template in use
+	Subject string `json:"subject"`
+	Html    string `json:"html"`
+}
+
+type EmailTemplateTestSendInput struct {
+	EmailTemplatePreviewInput
+	To string `json:"to"` // Defaults to the admin's own address
+}
+
+// --- Logic ---
+
+// emailTemplateSchema looks up the schema for a template key
+func emailTemplateSchema(key string) (EmailTemplateSchema, bool) {
+	for _, schema := range emailTemplateSchemas {
+		if schema.Key == key {
+			return schema, true
+		}
+	}
+	return EmailTemplateSchema{}, false
+}
+
+// normalizeLocale turns "es_MX" or "ES-mx" into "es-mx"; empty if invalid
+func normalizeLocale(locale string) string {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	if !localePattern.MatchString(locale) {
+		return ""
+	}
+	return locale
+}
+
+// localeFallbacks lists the locales to try for a recipient, e.g. es-mx, es, en
+func localeFallbacks(locale string) []string {
+	var chain []string
+	if locale = normalizeLocale(locale); locale != "" {
+		chain = append(chain, locale)
+		if base, _, found := strings.Cut(locale, "-"); found {
+			chain = append(chain, base)
+		}
+	}
+	if len(chain) == 0 || chain[len(chain)-1] != defaultEmailLocale {
+		chain = append(chain, defaultEmailLocale)
+	}
+	return chain
+}
+
+// requestLocale picks the first language in Accept-Language that has templates
+func requestLocale(c *gin.Context) string {
+	available := map[string]bool{}
+	for _, schema := range emailTemplateSchemas {
+		for _, locale := range builtinLocales(schema.Key) {
+			available[locale] = true
+		}
+	}
+	for _, part := range strings.Split(c.GetHeader("Accept-Language"), ",") {
+		tag, _, _ := strings.Cut(part, ";")
+		for _, locale := range localeFallbacks(tag) {
+			if available[locale] && locale != defaultEmailLocale {
+				return locale
+			}
+		}
+	}
+	return defaultEmailLocale
+}
+
+// builtinTemplate reads the embedded template for a key in exactly one locale
+func builtinTemplate(key, locale string) (subject, html string, ok bool) {
+	raw, err := defaultEmailTemplates.ReadFile("email_templates/" + key + "." + locale + ".html")
+	if err != nil {
+		return "", "", false
+	}
+	header, body, _ := strings.Cut(string(raw), "\n")
+	return strings.TrimSpace(strings.TrimPrefix(header, "Subject:")), strings.TrimLeft(body, "\n"), true
+}
+
+// builtinLocales lists the locales a key has an embedded template for
+func builtinLocales(key string) []string {
+	entries, _ := defaultEmailTemplates.ReadDir("email_templates")
+	var locales []string
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".html")
+		if k, locale, ok := strings.Cut(name, "."); ok && k == key {
+			locales = append(locales, locale)
+		}
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// templateFields collects the top-level fields ({{.Name}}) a template refers to
+func templateFields(node parse.Node, fields map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			for _, child := range n.Nodes {
+				templateFields(child, fields)
+			}
+		}
+	case *parse.ActionNode:
+		templateFields(n.Pipe, fields)
+	case *parse.PipeNode:
+		if n != nil {
+			for _, cmd := range n.Cmds {
+				templateFields(cmd, fields)
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			templateFields(arg, fields)
+		}
+	case *parse.FieldNode:
+		fields[n.Ident[0]] = true
+	case *parse.ChainNode:
+		templateFields(n.Node, fields)
+	case *parse.IfNode:
+		templateFields(n.Pipe, fields)
+		templateFields(n.List, fields)
+		templateFields(n.ElseList, fields)
+	case *parse.RangeNode:
+		templateFields(n.Pipe, fields)
+		templateFields(n.List, fields)
+		templateFields(n.ElseList, fields)
+	case *parse.WithNode:
+		templateFields(n.Pipe, fields)
+		templateFields(n.List, fields)
+		templateFields(n.ElseList, fields)
+	case *parse.TemplateNode:
+		templateFields(n.Pipe, fields)
+	}
+}
+
+// renderTemplatePair renders a subject (plain text) and body (HTML, escaped
+// by context). Unknown variables are an error rather than "<no value>".
+func renderTemplatePair(subject, html string, data map[string]string) (string, string, error) {
+	st, err := texttemplate.New("subject").Option("missingkey=error").Parse(subject)
+	if err != nil {
+		return "", "", fmt.Errorf("subject: %w", err)
+	}
+	ht, err := htmltemplate.New("html").Option("missingkey=error").Parse(html)
+	if err != nil {
+		return "", "", fmt.Errorf("html: %w", err)
+	}
+
+	var subjectBuf, htmlBuf bytes.Buffer
+	if err := st.Execute(&subjectBuf, data); err != nil {
+		return "", "", fmt.Errorf("subject: %w", err)
+	}
+	if err := ht.Execute(&htmlBuf, data); err != nil {
+		return "", "", fmt.Errorf("html: %w", err)
+	}
+	// Subjects are a single header line
+	renderedSubject := strings.Join(strings.Fields(subjectBuf.String()), " ")
+	return renderedSubject, htmlBuf.String(), nil
+}
+
+// sampleTemplateData is the schema's sample values with any overrides applied
+func sampleTemplateData(schema EmailTemplateSchema, overrides map[string]string) map[string]string {
+	data := map[string]string{}
+	for _, v := range schema.Vars {
+		data[v.Name] = v.Sample
+	}
+	for name, value := range overrides {
+		data[name] = value
+	}
+	return data
+}
+
+// validateTemplateData checks supplied values against the variable types
+func validateTemplateData(schema EmailTemplateSchema, data map[string]string) error {
+	types := map[string]string{}
+	for _, v := range schema.Vars {
+		types[v.Name] = v.Type
+	}
+	for name, value := range data {
+		typ, ok := types[name]
+		if !ok {
+			return fmt.Errorf("%s has no variable %q", schema.Key, name)
+		}
+		if value == "" {
+			continue
+		}
+		switch typ {
+		case VarURL:
+			u, err := url.Parse(value)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("%s must be an http(s) URL", name)
+			}
+		case VarMoney:
+			amount, _, _ := strings.Cut(value, " ")
+			if _, err := strconv.ParseFloat(amount, 64); err != nil {
+				return fmt.Errorf("%s must be an amount such as \"20.00 AUD\"", name)
+			}
+		case VarCode:
+			if strings.ContainsAny(value, " \t\n") {
+				return fmt.Errorf("%s must not contain spaces", name)
+			}
+		}
+	}
+	return nil
+}
+
+// validateEmailTemplate checks a template against its schema: both parts must
+// parse, use only known variables, use every required one and render with
+// the sample data
+func validateEmailTemplate(schema EmailTemplateSchema, subject, html string) error {
+	if strings.TrimSpace(subject) == "" || strings.TrimSpace(html) == "" {
+		return errors.New("subject and html are required")
+	}
+	st, err := texttemplate.New("subject").Parse(subject)
+	if err != nil {
+		return fmt.Errorf("subject: %w", err)
+	}
+	ht, err := texttemplate.New("html").Parse(html)
+	if err != nil {
+		return fmt.Errorf("html: %w", err)
+	}
+
+	used := map[string]bool{}
+	templateFields(st.Tree.Root, used)
+	templateFields(ht.Tree.Root, used)
+	known := map[string]bool{}
+	for _, v := range schema.Vars {
+		known[v.Name] = true
+		if v.Required && !used[v.Name] {
+			return fmt.Errorf("template must use {{.%s}}", v.Name)
+		}
+	}
+	var unknown []string
+	for name := range used {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown variables for %s: %s", schema.Key, strings.Join(unknown, ", "))
+	}
+
+	_, _, err = renderTemplatePair(subject, html, sampleTemplateData(schema, nil))
+	return err
+}
+
+// renderedEmail is a template rendered for one recipient
+type renderedEmail struct {
+	Subject string `json:"subject"`
+	Html    string `json:"html"`
+	Text    string `json:"text"`
+	Locale  string `json:"locale"`
+	Version int    `json:"version"` // 0 for the built-in template
+}
+
+// renderEmail renders the template a recipient with locale should get: for
+// each locale in the fallback chain, the active admin version and then the
+// built-in one. A version that fails to render is skipped so a bad edit never
+// blocks the email.
+func renderEmail(tx *gorm.DB, key, locale string, data map[string]string) (renderedEmail, error) {
+	for _, candidate := range localeFallbacks(locale) {
+		var active []EmailTemplate
+		tx.Where("key = ? AND locale = ? AND is_active = ?", key, candidate, true).Limit(1).Find(&active)
+		if len(active) > 0 {
+			subject, html, err := renderTemplatePair(active[0].Subject, active[0].Html, data)
+			if err == nil {
+				return renderedEmail{subject, html, mailer.HTMLToText(html), candidate, active[0].Version}, nil
+			}
+			log.Printf("ERROR: Email template %s/%s v%d failed, using built-in: %v\n", key, candidate, active[0].Version, err)
+		}
+		if subject, html, ok := builtinTemplate(key, candidate); ok {
+			subject, html, err := renderTemplatePair(subject, html, data)
+			if err != nil {
+				return renderedEmail{}, fmt.Errorf("email template %s/%s: %w", key, candidate, err)
+			}
+			return renderedEmail{subject, html, mailer.HTMLToText(html), candidate, 0}, nil
+		}
+	}
+	return renderedEmail{}, fmt.Errorf("no email template %q", key)
+}
+
+// sendTemplatedEmail renders key in the recipient's locale and queues it
+// using tx. Recipients without an account get the default locale, and
+// marketing email gets the recipient's unsubscribe link.
+func sendTemplatedEmail(tx *gorm.DB, to, key string, data map[string]string, attachments ...mailer.Attachment) error {
+	category := emailCategory(key)
+	if category == EmailMarketing {
+		data["UnsubscribeURL"] = unsubscribeURL(to)
+	}
+
+	var locales []string
+	tx.Model(&User{}).Where("email = ?", to).Limit(1).Pluck("locale", &locales)
+	locale := defaultEmailLocale
+	if len(locales) > 0 && locales[0] != "" {
+		locale = locales[0]
+	}
+
+	email, err := renderEmail(tx, key, locale, data)
+	if err != nil {
+		return err
+	}
+	return queueEmail(tx, EmailJob{To: to, Subject: email.Subject, Html: email.Html, Category: category, Attachments: attachments})
+}
+
+// emailTemplatePreview renders a draft or the template in use with sample data
+func emailTemplatePreview(schema EmailTemplateSchema, input EmailTemplatePreviewInput) (renderedEmail, error) {
+	if err := validateTemplateData(schema, input.Data); err != nil {
+		return renderedEmail{}, err
+	}
+	data := sampleTemplateData(schema, input.Data)
+	locale := normalizeLocale(input.Locale)
+	if locale == "" {
+		locale = defaultEmailLocale
+	}
+
+	if input.Subject != "" || input.Html != "" {
+		if err := validateEmailTemplate(schema, input.Subject, input.Html); err != nil {
+			return renderedEmail{}, err
+		}
+		subject, html, err := renderTemplatePair(input.Subject, input.Html, data)
+		if err != nil {
+			return renderedEmail{}, err
+		}
+		return renderedEmail{subject, html, mailer.HTMLToText(html), locale, 0}, nil
+	}
+	return renderEmail(db, schema.Key, locale, data)
+}
+
+// --- Handlers ---
+
+// GetEmailTemplates - Admin - Every template with its variables, built-in locales and active versions
+func GetEmailTemplates(c *gin.Context) {
+	var active []EmailTemplate
+	db.Omit("html").Where("is_active = ?", true).Find(&active)
+	activeByKey := map[string]map[string]int{}
+	for _, t := range active {
+		if activeByKey[t.Key] == nil {
+			activeByKey[t.Key] = map[string]int{}
+		}
+		activeByKey[t.Key][t.Locale] = t.Version
+	}
+
+	var templates []gin.H
+	for _, schema := range emailTemplateSchemas {
+		templates = append(templates, gin.H{
+			"key":             schema.Key,
+			"description":     schema.Description,
+			"category":        emailCategory(schema.Key),
+			"vars":            schema.Vars,
+			"builtin_locales": builtinLocales(schema.Key),
+			"active_versions": activeByKey[schema.Key],
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"data": templates})
+}
+
+// GetEmailTemplate - Admin - One template's built-in content per locale and its saved versions
+func GetEmailTemplate(c *gin.Context) {
+	schema, ok := emailTemplateSchema(c.Param("key"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email template not found"})
+		return
+	}
+
+	builtin := gin.H{}
+	for _, locale := range builtinLocales(schema.Key) {
+		subject, html, _ := builtinTemplate(schema.Key, locale)
+		builtin[locale] = gin.H{"subject": subject, "html": html}
+	}
+
+	query := db.Where("key = ?", schema.Key)
+	if locale := c.Query("locale"); locale != "" {
+		query = query.Where("locale = ?", normalizeLocale(locale))
+	}
+	var versions []EmailTemplate
+	query.Order("locale asc, version desc").Find(&versions)
+
+	c.JSON(http.StatusOK, gin.H{"template": schema, "builtin": builtin, "versions": versions})
+}
+
+// CreateEmailTemplateVersion - Admin - Save a new version for a locale, validated against the schema
+func CreateEmailTemplateVersion(c *gin.Context) {
+	adminID, _ := currentUserID(c)
+	schema, ok := emailTemplateSchema(c.Param("key"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email template not found"})
+		return
+	}
+
+	var input EmailTemplateVersionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	locale := normalizeLocale(input.Locale)
+	if locale == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid locale, use a language tag such as en, hi or es-mx"})
+		return
+	}
+	if err := validateEmailTemplate(schema, input.Subject, input.Html); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	version := EmailTemplate{
+		Key:       schema.Key,
+		Locale:    locale,
+		Subject:   strings.TrimSpace(input.Subject),
+		Html:      input.Html,
+		Notes:     input.Notes,
+		CreatedBy: adminID,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var latest int
+		tx.Model(&EmailTemplate{}).Where("key = ? AND locale = ?", schema.Key, locale).
+			Select("COALESCE(MAX(version), 0)").Scan(&latest)
+		version.Version = latest + 1
+		if err := tx.Create(&version).Error; err != nil {
+			return err
+		}
+		if input.Activate {
+			return activateEmailTemplate(tx, &version)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to save template version, try again"})
+		return
+	}
+	c.JSON(http.StatusCreated, version)
+}
+
+// activateEmailTemplate makes version the only active one for its key and locale
+func activateEmailTemplate(tx *gorm.DB, version *EmailTemplate) error {
+	if err := tx.Model(&EmailTemplate{}).
+		Where("key = ? AND locale = ? AND id <> ?", version.Key, version.Locale, version.ID).
+		Update("is_active", false).Error; err != nil {
+		return err
+	}
+	version.IsActive = true
+	return tx.Model(version).Update("is_active", true).Error
+}
+
+// ActivateEmailTemplateVersion - Admin - Use a saved version (also used to roll back)
+func ActivateEmailTemplateVersion(c *gin.Context) {
+	schema, ok := emailTemplateSchema(c.Param("key"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email template not found"})
+		return
+	}
+	var version EmailTemplate
+	if err := db.Where("id = ? AND key = ?", c.Param("id"), schema.Key).First(&version).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template version not found"})
+		return
+	}
+	// The schema may have gained required variables since this was saved
+	if err := validateEmailTemplate(schema, version.Subject, version.Html); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error { return activateEmailTemplate(tx, &version) }); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to activate template version"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Template version activated", "template": version})
+}
+
+// RevertEmailTemplate - Admin - Go back to the built-in template for a locale
+func RevertEmailTemplate(c *gin.Context) {
+	schema, ok := emailTemplateSchema(c.Param("key"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email template not found"})
+		return
+	}
+	var input struct {
+		Locale string `json:"locale" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := db.Model(&EmailTemplate{}).
+		Where("key = ? AND locale = ? AND is_active = ?", schema.Key, normalizeLocale(input.Locale), true).
+		Update("is_active", false)
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revert template"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Using the built-in template", "deactivated": res.RowsAffected})
+}
+
+// PreviewEmailTemplate - Admin - Render a template or an unsaved draft with sample data
+func PreviewEmailTemplate(c *gin.Context) {
+	schema, ok := emailTemplateSchema(c.Param("key"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email template not found"})
+		return
+	}
+	var input EmailTemplatePreviewInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	email, err := emailTemplatePreview(schema, input)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, email)
+}
+
+// TestSendEmailTemplate - Admin - Queue a rendered template to an address, marked as a test
+func TestSendEmailTemplate(c *gin.Context) {
+	adminID, _ := currentUserID(c)
+	schema, ok := emailTemplateSchema(c.Param("key"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email template not found"})
+		return
+	}
+	var input EmailTemplateTestSendInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.To == "" {
+		var admin User
+		db.First(&admin, adminID)
+		input.To = admin.Email
+	}
+
+	email, err := emailTemplatePreview(schema, input.EmailTemplatePreviewInput)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	job := EmailJob{To: input.To, Subject: "[Test] " + email.Subject, Html: email.Html, Category: emailCategory(schema.Key)}
+	if err := queueEmail(db, job); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue test email"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Test email queued", "to": input.To, "email": email})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinEmailTemplatesValidate(t *testing.T) {
+	for _, schema := range emailTemplateSchemas {
+		locales := builtinLocales(schema.Key)
+		if len(locales) == 0 || !strings.Contains(strings.Join(locales, ","), defaultEmailLocale) {
+			t.Errorf("%s has no %s template (has %v)", schema.Key, defaultEmailLocale, locales)
+		}
+		for _, locale := range locales {
+			subject, html, _ := builtinTemplate(schema.Key, locale)
+			if err := validateEmailTemplate(schema, subject, html); err != nil {
+				t.Errorf("%s.%s: %v", schema.Key, locale, err)
+			}
+		}
+	}
+}
+
+func TestLocaleFallbacks(t *testing.T) {
+	cases := map[string][]string{
+		"es_MX":     {"es-mx", "es", "en"},
+		"hi":        {"hi", "en"},
+		"en-AU":     {"en-au", "en"},
+		"en":        {"en"},
+		"":          {"en"},
+		"not a tag": {"en"},
+	}
+	for locale, want := range cases {
+		if got := localeFallbacks(locale); !reflect.DeepEqual(got, want) {
+			t.Errorf("localeFallbacks(%q) = %v, want %v", locale, got, want)
+		}
+	}
+}
+
+func TestValidateEmailTemplate(t *testing.T) {
+	schema, _ := emailTemplateSchema("booking_confirmed")
+	cases := []struct {
+		name, subject, html, wantErr string
+	}{
+		{"valid", "Booked: {{.ClassName}}", "<p>{{.Name}}, see you {{.Time}}</p>", ""},
+		{"missing required", "Booked", "<p>{{.Time}}</p>", "{{.ClassName}}"},
+		{"unknown variable", "Booked: {{.ClassName}}", "<p>{{.Time}} in {{.Room}}</p>", "Room"},
+		{"parse error", "Booked: {{.ClassName", "<p>{{.Time}}</p>", "subject"},
+		{"unsafe html context", "{{.ClassName}}", "<p>{{.Time}}</p><script>var x = '{{.Name}}</script>", "html"},
+	}
+	for _, tc := range cases {
+		err := validateEmailTemplate(schema, tc.subject, tc.html)
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("%s: error = %v, want one mentioning %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestRenderTemplatePairEscapesBody(t *testing.T) {
+	subject, html, err := renderTemplatePair("Hi {{.Name}}", `<a href="{{.Link}}">{{.Name}}</a>`,
+		map[string]string{"Name": "<Asha & Ravi>", "Link": "javascript:alert(1)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Hi <Asha & Ravi>" {
+		t.Errorf("subject = %q", subject)
+	}
+	if strings.Contains(html, "<Asha") || strings.Contains(html, "javascript:") {
+		t.Errorf("html not escaped: %s", html)
+	}
+}
+
+func TestValidateTemplateData(t *testing.T) {
+	schema, _ := emailTemplateSchema("gift_card")
+	if err := validateTemplateData(schema, map[string]string{"Amount": "20.00 AUD", "Link": "https://example.com"}); err != nil {
+		t.Errorf("valid data rejected: %v", err)
+	}
+	for _, data := range []map[string]string{
+		{"Amount": "twenty"},
+		{"Link": "example.com/redeem"},
+		{"Code": "GIFT 1234"},
+		{"Colour": "green"},
+	} {
+		if err := validateTemplateData(schema, data); err == nil {
+			t.Errorf("validateTemplateData(%v) accepted invalid data", data)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"kaivaliyayoga/internal/geoip"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// geoReader is nil when no GeoIP database is configured; detection is then skipped
+var geoReader *geoip.Reader
+
+// Currency for each country we price in. Everywhere else pays in AUD.
+var countryCurrencies = map[string]string{
+	"AU": "AUD", "IN": "INR", "US": "USD", "GB": "GBP", "CA": "CAD",
+	"SG": "SGD", "AE": "AED", "NZ": "NZD",
+	// Eurozone
+	"AT": "EUR", "BE": "EUR", "CY": "EUR", "DE": "EUR", "EE": "EUR", "ES": "EUR",
+	"FI": "EUR", "FR": "EUR", "GR": "EUR", "HR": "EUR", "IE": "EUR", "IT": "EUR",
+	"LT": "EUR", "LU": "EUR", "LV": "EUR", "MT": "EUR", "NL": "EUR", "PT": "EUR",
+	"SI": "EUR", "SK": "EUR",
+}
+
+// --- DTO ---
+type FraudReviewInput struct {
+	Action string `json:"action" binding:"required"` // clear, confirm
+	Notes  string `json:"notes"`
+}
+
+// --- Logic ---
+
+// initGeoIP loads the local MaxMind-format database from GEOIP_DB_PATH
+func initGeoIP() {
+	path := os.Getenv("GEOIP_DB_PATH")
+	if path == "" {
+		path = "data/GeoLite2-Country.mmdb"
+	}
+	reader, err := geoip.Open(path)
+	if err != nil {
+		fmt.Printf("GeoIP disabled: %v\n", err)
+		return
+	}
+	geoReader = reader
+	fmt.Printf("GeoIP database loaded: %s (built %s)\n", reader.Metadata.DatabaseType,
+		time.Unix(int64(reader.Metadata.BuildEpoch), 0).Format("2006-01-02"))
+}
+
+// useTrustedProxies limits which peers may set the client IP through
+// X-Forwarded-For to TRUSTED_PROXIES (comma separated IPs or CIDRs). With none
+// set the header is ignored and the client IP is the connecting address.
+func useTrustedProxies(r *gin.Engine) error {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return r.SetTrustedProxies(proxies)
+}
+
+// detectCountry returns the ISO country code for the request's client IP, or ""
+func detectCountry(c *gin.Context) string {
+	if geoReader == nil {
+		return ""
+	}
+	ip := net.ParseIP(c.ClientIP())
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() {
+		return ""
+	}
+	country, err := geoReader.Country(ip)
+	if err != nil {
+		return ""
+	}
+	return country
+}
+
+func currencyForCountry(country string) string {
+	if currency, ok := countryCurrencies[strings.ToUpper(country)]; ok {
+		return currency
+	}
+	return rateBase
+}
+
+// gatewayForCountry routes Indian customers to Razorpay and everyone else to PayPal
+func gatewayForCountry(country string) string {
+	if strings.ToUpper(country) == "IN" {
+		return GatewayRazorpay
+	}
+	return GatewayPayPal
+}
+
+// checkGatewayRouting rejects checkouts on the wrong gateway for the detected
+// country when GEOIP_ENFORCE_ROUTING is enabled
+func checkGatewayRouting(detected, gateway string) error {
+	if detected == "" || os.Getenv("GEOIP_ENFORCE_ROUTING") != "true" {
+		return nil
+	}
+	if want := gatewayForCountry(detected); want != gateway {
+		return fmt.Errorf("payments from %s must use %s", detected, want)
+	}
+	return nil
+}
+
+// resolveCheckoutCountry fills in a missing declared country from the client
+// IP and returns the detected country for the payment record
+func resolveCheckoutCountry(c *gin.Context, declared *string) string {
+	detected := detectCountry(c)
+	*declared = strings.ToUpper(strings.TrimSpace(*declared))
+	if *declared == "" {
+		*declared = detected
+	}
+	return detected
+}
+
+// flagCountryMismatch queues a payment for fraud review when the country the
+// customer declared is not where their IP is
+func flagCountryMismatch(payment *Payment, detected string) {
+	payment.DetectedCountry = detected
+	if detected == "" || payment.CountryCode == "" || payment.CountryCode == detected {
+		return
+	}
+	payment.FraudReviewStatus = "pending"
+	payment.FraudReason = fmt.Sprintf("Declared country %s but IP is in %s", payment.CountryCode, detected)
+}
+
+// --- Handlers ---
+
+// GetGeoInfo - Public - Detected country with the currency and gateway to use
+func GetGeoInfo(c *gin.Context) {
+	country := detectCountry(c)
+	c.JSON(http.StatusOK, gin.H{
+		"country":  country,
+		"detected": country != "",
+		"currency": currencyForCountry(country),
+		"gateway":  gatewayForCountry(country),
+	})
+}
+
+// GetFraudReviewQueue - Admin - Payments flagged for review, pending first
+func GetFraudReviewQueue(c *gin.Context) {
+	status := c.DefaultQuery("status", "pending")
+	var payments []Payment
+	db.Where("fraud_review_status = ?", status).Order("created_at desc").Find(&payments)
+	c.JSON(http.StatusOK, payments)
+}
+
+// ReviewFlaggedPayment - Admin - Clear or confirm a flagged payment
+func ReviewFlaggedPayment(c *gin.Context) {
+	adminID, _ := currentUserID(c)
+
+	var input FraudReviewInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	status := map[string]string{"clear": "cleared", "confirm": "confirmed"}[input.Action]
+	if status == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action must be clear or confirm"})
+		return
+	}
+
+	var payment Payment
+	if err := db.First(&payment, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+	if payment.FraudReviewStatus == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment is not flagged for review"})
+		return
+	}
+
+	now := time.Now()
+	reason := payment.FraudReason
+	if input.Notes != "" {
+		reason += ". Review: " + input.Notes
+	}
+	db.Model(&payment).Updates(map[string]interface{}{
+		"fraud_review_status": status,
+		"fraud_reason":        reason,
+		"fraud_reviewed_by":   adminID,
+		"fraud_reviewed_at":   now,
+	})
+	db.First(&payment, payment.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Review recorded", "payment": payment})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUseTrustedProxies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		name    string
+		proxies string
+		peer    string
+		want    string
+	}{
+		{"no proxies trusted by default", "", "203.0.113.7:4000", "203.0.113.7"},
+		{"untrusted peer", "10.0.0.0/8", "203.0.113.7:4000", "203.0.113.7"},
+		{"loopback is not trusted by default", "", "127.0.0.1:4000", "127.0.0.1"},
+		{"trusted proxy", "10.0.0.0/8, 192.0.2.1", "10.1.2.3:4000", "198.51.100.9"},
+		{"trusted proxy by address", "10.0.0.0/8, 192.0.2.1", "192.0.2.1:4000", "198.51.100.9"},
+	}
+	for _, tc := range cases {
+		t.Setenv("TRUSTED_PROXIES", tc.proxies)
+		r := gin.New()
+		require.NoError(t, useTrustedProxies(r), tc.name)
+		r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.peer
+		req.Header.Set("X-Forwarded-For", "198.51.100.9")
+		req.Header.Set("X-Real-IP", "198.51.100.9")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, tc.want, w.Body.String(), tc.name)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "not-an-ip")
+	assert.Error(t, useTrustedProxies(gin.New()))
+}
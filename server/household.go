@@ -0,0 +1,451 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// --- Models ---
+
+// Household groups a primary payer with the people they buy for. Memberships
+// shared with a household can be drawn on by any active member.
+type Household struct {
+	ID      uint              `json:"id" gorm:"primaryKey"`
+	Name    string            `json:"name"`
+	OwnerID uint              `json:"owner_id" gorm:"uniqueIndex"` // Primary payer
+	Members []HouseholdMember `json:"members" gorm:"foreignKey:HouseholdID"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type HouseholdMember struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	HouseholdID uint   `json:"household_id" gorm:"index"`
+	UserID      *uint  `json:"user_id" gorm:"index"` // Set once the invite is accepted
+	User        *User  `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	InviteEmail string `json:"invite_email"`
+	InviteToken string `json:"-" gorm:"uniqueIndex"`
+	Role        string `json:"role"`   // owner, member
+	Status      string `json:"status"` // invited, active, removed
+
+	// Per-member limits on shared memberships, 0 = no limit
+	CreditLimit        int `json:"credit_limit"`         // Max credits drawn from each shared pack
+	WeeklyBookingLimit int `json:"weekly_booking_limit"` // Max bookings per rolling 7 days
+
+	JoinedAt  *time.Time `json:"joined_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// --- DTOs ---
+type CreateHouseholdInput struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type HouseholdInviteInput struct {
+	Email              string `json:"email" binding:"required,email"`
+	CreditLimit        int    `json:"credit_limit"`
+	WeeklyBookingLimit int    `json:"weekly_booking_limit"`
+}
+
+type HouseholdMemberLimitsInput struct {
+	CreditLimit        int `json:"credit_limit"`
+	WeeklyBookingLimit int `json:"weekly_booking_limit"`
+}
+
+// --- Logic ---
+
+func newInviteToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// activeHouseholdMember returns the user's active membership of any household
+func activeHouseholdMember(tx *gorm.DB, uid uint) (*HouseholdMember, error) {
+	var member HouseholdMember
+	if err := tx.Where("user_id = ? AND status = ?", uid, "active").First(&member).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// BookableMemberships lists active memberships a user can book with: their
+// own first, then any shared with their household, soonest expiry first.
+func BookableMemberships(tx *gorm.DB, uid uint) ([]Membership, error) {
+	query := tx.Where("status = ?", "active")
+	if member, err := activeHouseholdMember(tx, uid); err == nil {
+		query = query.Where("user_id = ? OR household_id = ?", uid, member.HouseholdID)
+	} else {
+		query = query.Where("user_id = ?", uid)
+	}
+
+	var memberships []Membership
+	if err := query.Order("end_date asc").Find(&memberships).Error; err != nil {
+		return nil, err
+	}
+	sort.SliceStable(memberships, func(i, j int) bool {
+		return memberships[i].UserID == uid && memberships[j].UserID != uid
+	})
+	return memberships, nil
+}
+
+// creditsUsedBy counts credits a user has drawn from a membership, net of refunds
+func creditsUsedBy(tx *gorm.DB, membershipID, uid uint) int {
+	var used int
+	tx.Model(&CreditLedgerEntry{}).
+		Where("membership_id = ? AND user_id = ? AND type IN ?", membershipID, uid, []string{CreditUse, CreditRefund}).
+		Select("COALESCE(-SUM(delta), 0)").Scan(&used)
+	return used
+}
+
+// CheckHouseholdLimits enforces the member's limits before they draw on a
+// shared membership. The membership owner is never limited.
+func CheckHouseholdLimits(tx *gorm.DB, mem *Membership, uid uint) error {
+	if mem.HouseholdID == nil || mem.UserID == uid {
+		return nil
+	}
+
+	var member HouseholdMember
+	if err := tx.Where("household_id = ? AND user_id = ? AND status = ?", *mem.HouseholdID, uid, "active").
+		First(&member).Error; err != nil {
+		return errors.New("not a member of this household")
+	}
+
+	if member.CreditLimit > 0 && mem.Credits != -1 && creditsUsedBy(tx, mem.ID, uid) >= member.CreditLimit {
+		return errors.New("household credit limit reached")
+	}
+
+	if member.WeeklyBookingLimit > 0 {
+		var count int64
+		tx.Model(&Booking{}).
+			Joins("JOIN memberships ON memberships.id = bookings.membership_id").
+			Where("bookings.user_id = ? AND bookings.status = ? AND bookings.created_at > ?", uid, "confirmed", time.Now().AddDate(0, 0, -7)).
+			Where("memberships.household_id = ? AND memberships.user_id != ?", *mem.HouseholdID, uid).
+			Count(&count)
+		if count >= int64(member.WeeklyBookingLimit) {
+			return errors.New("household weekly booking limit reached")
+		}
+	}
+	return nil
+}
+
+// ownedHousehold loads the household the user is primary payer of
+func ownedHousehold(uid uint) (*Household, error) {
+	var household Household
+	if err := db.Where("owner_id = ?", uid).First(&household).Error; err != nil {
+		return nil, errors.New("you do not own a household")
+	}
+	return &household, nil
+}
+
+// --- Handlers ---
+
+// CreateHousehold - Protected - Start a household with the caller as primary payer
+func CreateHousehold(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var input CreateHouseholdInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := activeHouseholdMember(db, uid); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "You already belong to a household"})
+		return
+	}
+
+	var user User
+	db.First(&user, uid)
+
+	now := time.Now()
+	household := Household{
+		Name:    input.Name,
+		OwnerID: uid,
+		Members: []HouseholdMember{{
+			UserID:      &uid,
+			InviteEmail: user.Email,
+			InviteToken: newInviteToken(),
+			Role:        "owner",
+			Status:      "active",
+			JoinedAt:    &now,
+		}},
+	}
+	if err := db.Create(&household).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create household"})
+		return
+	}
+	c.JSON(http.StatusCreated, household)
+}
+
+// GetMyHousehold - Protected - The caller's household, members and shared memberships
+func GetMyHousehold(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	member, err := activeHouseholdMember(db, uid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "You are not in a household"})
+		return
+	}
+
+	var household Household
+	db.Preload("Members", "status != ?", "removed").Preload("Members.User").First(&household, member.HouseholdID)
+
+	var shared []Membership
+	db.Where("household_id = ?", household.ID).Order("end_date desc").Find(&shared)
+
+	c.JSON(http.StatusOK, gin.H{
+		"household":   household,
+		"role":        member.Role,
+		"memberships": shared,
+	})
+}
+
+// InviteHouseholdMember - Protected - Primary payer invites someone by email
+func InviteHouseholdMember(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var input HouseholdInviteInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.CreditLimit < 0 || input.WeeklyBookingLimit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Limits cannot be negative"})
+		return
+	}
+
+	household, err := ownedHousehold(uid)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(input.Email))
+	var existing HouseholdMember
+	if db.Where("household_id = ? AND LOWER(invite_email) = ? AND status != ?", household.ID, email, "removed").
+		First(&existing).Error == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "This person is already invited"})
+		return
+	}
+
+	member := HouseholdMember{
+		HouseholdID:        household.ID,
+		InviteEmail:        email,
+		InviteToken:        newInviteToken(),
+		Role:               "member",
+		Status:             "invited",
+		CreditLimit:        input.CreditLimit,
+		WeeklyBookingLimit: input.WeeklyBookingLimit,
+	}
+
+	var owner User
+	db.First(&owner, uid)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&member).Error; err != nil {
+			return err
+		}
+		return SendHouseholdInvite(tx, email, owner.Name, household.Name, member.InviteToken)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, member)
+}
+
+// AcceptHouseholdInvite - Protected - Join a household from an emailed invite
+func AcceptHouseholdInvite(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var user User
+	if err := db.First(&user, uid).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var member HouseholdMember
+	if err := db.Where("invite_token = ? AND status = ?", c.Param("token"), "invited").First(&member).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found or already used"})
+		return
+	}
+	if !strings.EqualFold(member.InviteEmail, user.Email) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This invite was sent to a different email address"})
+		return
+	}
+	if _, err := activeHouseholdMember(db, uid); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "You already belong to a household"})
+		return
+	}
+
+	now := time.Now()
+	member.UserID = &uid
+	member.Status = "active"
+	member.JoinedAt = &now
+	db.Save(&member)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Joined household", "member": member})
+}
+
+// UpdateHouseholdMember - Protected - Primary payer sets a member's limits
+func UpdateHouseholdMember(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var input HouseholdMemberLimitsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.CreditLimit < 0 || input.WeeklyBookingLimit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Limits cannot be negative"})
+		return
+	}
+
+	household, err := ownedHousehold(uid)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	var member HouseholdMember
+	if err := db.Where("id = ? AND household_id = ? AND status != ?", c.Param("id"), household.ID, "removed").
+		First(&member).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	if member.Role == "owner" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The primary payer has no limits"})
+		return
+	}
+
+	member.CreditLimit = input.CreditLimit
+	member.WeeklyBookingLimit = input.WeeklyBookingLimit
+	db.Save(&member)
+	c.JSON(http.StatusOK, member)
+}
+
+// RemoveHouseholdMember - Protected - Primary payer removes a member, or a member leaves
+func RemoveHouseholdMember(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var member HouseholdMember
+	if err := db.Where("id = ? AND status != ?", c.Param("id"), "removed").First(&member).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	var household Household
+	db.First(&household, member.HouseholdID)
+
+	isSelf := member.UserID != nil && *member.UserID == uid
+	if household.OwnerID != uid && !isSelf {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	if member.Role == "owner" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The primary payer cannot leave their own household"})
+		return
+	}
+
+	member.Status = "removed"
+	db.Save(&member)
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+// ShareMembership - Protected - Share one of the caller's memberships with their household
+func ShareMembership(c *gin.Context) {
+	setMembershipShared(c, true)
+}
+
+// UnshareMembership - Protected - Stop sharing a membership with the household
+func UnshareMembership(c *gin.Context) {
+	setMembershipShared(c, false)
+}
+
+func setMembershipShared(c *gin.Context, shared bool) {
+	uid, _ := currentUserID(c)
+
+	var mem Membership
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), uid).First(&mem).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Membership not found"})
+		return
+	}
+
+	if !shared {
+		db.Model(&mem).Update("household_id", nil)
+		mem.HouseholdID = nil
+		c.JSON(http.StatusOK, mem)
+		return
+	}
+
+	household, err := ownedHousehold(uid)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	db.Model(&mem).Update("household_id", household.ID)
+	mem.HouseholdID = &household.ID
+	c.JSON(http.StatusOK, mem)
+}
+
+// GetHouseholdUsage - Protected - Who used which credit. The primary payer
+// sees the whole household; members see their own usage.
+func GetHouseholdUsage(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	member, err := activeHouseholdMember(db, uid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "You are not in a household"})
+		return
+	}
+
+	var membershipIDs []uint
+	db.Model(&Membership{}).Where("household_id = ?", member.HouseholdID).Pluck("id", &membershipIDs)
+
+	scope := func() *gorm.DB {
+		q := db.Model(&CreditLedgerEntry{}).Where("membership_id IN ? AND type IN ?", membershipIDs, []string{CreditUse, CreditRefund})
+		if member.Role != "owner" {
+			q = q.Where("user_id = ?", uid)
+		}
+		return q
+	}
+
+	type memberUsage struct {
+		UserID      uint `json:"user_id"`
+		CreditsUsed int  `json:"credits_used"`
+		Bookings    int  `json:"bookings"`
+	}
+	var summary []memberUsage
+	scope().Select("user_id, COALESCE(-SUM(delta), 0) as credits_used, " +
+		"SUM(CASE WHEN type = 'use' THEN 1 ELSE -1 END) as bookings").
+		Group("user_id").Order("user_id").Scan(&summary)
+
+	var entries []CreditLedgerEntry
+	scope().Order("created_at desc").Limit(200).Find(&entries)
+
+	c.JSON(http.StatusOK, gin.H{
+		"household_id": member.HouseholdID,
+		"summary":      summary,
+		"entries":      entries,
+	})
+}
+
+// describeHouseholdBooking is used in ledger reasons for shared credits
+func describeHouseholdBooking(mem *Membership, uid uint, className string) string {
+	if mem.HouseholdID != nil && mem.UserID != uid {
+		return fmt.Sprintf("booked class %s (household member %d)", className, uid)
+	}
+	return "booked class " + className
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckHouseholdLimits(t *testing.T) {
+	setupTestDB(t, &User{}, &Class{}, &Membership{}, &CreditLedgerEntry{}, &Booking{}, &Household{}, &HouseholdMember{})
+
+	household := Household{Name: "Family", OwnerID: 1}
+	require.NoError(t, db.Create(&household).Error)
+	member := func(uid uint, status string, credits, weekly int) {
+		require.NoError(t, db.Create(&HouseholdMember{HouseholdID: household.ID, UserID: &uid, InviteToken: newInviteToken(),
+			Role: "member", Status: status, CreditLimit: credits, WeeklyBookingLimit: weekly}).Error)
+	}
+	member(2, "active", 2, 0)
+	member(3, "active", 0, 2)
+	member(4, "removed", 0, 0)
+	member(5, "active", 0, 0)
+	member(6, "active", 1, 0)
+
+	shared := Membership{UserID: 1, Type: "10_class", PaymentID: 1, Status: "active", Credits: 10, HouseholdID: &household.ID}
+	unlimited := Membership{UserID: 1, Type: "monthly", PaymentID: 2, Status: "active", Credits: -1, HouseholdID: &household.ID}
+	private := Membership{UserID: 1, Type: "5_class", PaymentID: 3, Status: "active", Credits: 5}
+	require.NoError(t, db.Create(&shared).Error)
+	require.NoError(t, db.Create(&unlimited).Error)
+	require.NoError(t, db.Create(&private).Error)
+
+	// Member 2 has used their two credits, one was refunded then used again
+	for _, e := range []CreditLedgerEntry{{Delta: -1, Type: CreditUse}, {Delta: -1, Type: CreditUse}, {Delta: 1, Type: CreditRefund}, {Delta: -1, Type: CreditUse}} {
+		e.MembershipID, e.UserID = shared.ID, 2
+		require.NoError(t, db.Create(&e).Error)
+	}
+	// Member 3 booked twice this week on the shared pack, plus older and cancelled bookings that don't count
+	bookings := []Booking{
+		{UserID: 3, ClassID: 1, MembershipID: &shared.ID, Status: "confirmed"},
+		{UserID: 3, ClassID: 2, MembershipID: &shared.ID, Status: "confirmed"},
+		{UserID: 6, ClassID: 3, MembershipID: &shared.ID, Status: "cancelled"},
+		{UserID: 6, ClassID: 4, MembershipID: &shared.ID, Status: "confirmed", CreatedAt: time.Now().AddDate(0, 0, -8)},
+	}
+	require.NoError(t, db.Create(&bookings).Error)
+
+	cases := []struct {
+		name string
+		mem  *Membership
+		uid  uint
+		err  string
+	}{
+		{"owner is never limited", &shared, 1, ""},
+		{"unshared membership", &private, 2, ""},
+		{"credit limit reached", &shared, 2, "household credit limit reached"},
+		{"credit limit ignored on unlimited packs", &unlimited, 2, ""},
+		{"weekly limit reached", &shared, 3, "household weekly booking limit reached"},
+		{"removed member", &shared, 4, "not a member of this household"},
+		{"outsider", &shared, 9, "not a member of this household"},
+		{"no limits", &shared, 5, ""},
+		{"old and cancelled bookings don't count", &shared, 6, ""},
+	}
+	for _, tc := range cases {
+		err := CheckHouseholdLimits(db, tc.mem, tc.uid)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.name)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.name)
+		}
+	}
+}
+
+func TestBookableMemberships(t *testing.T) {
+	setupTestDB(t, &Membership{}, &Household{}, &HouseholdMember{})
+
+	household := Household{Name: "Family", OwnerID: 1}
+	require.NoError(t, db.Create(&household).Error)
+	uid := uint(2)
+	require.NoError(t, db.Create(&HouseholdMember{HouseholdID: household.ID, UserID: &uid, InviteToken: newInviteToken(), Status: "active"}).Error)
+
+	now := time.Now()
+	memberships := []Membership{
+		{UserID: 1, PaymentID: 1, Status: "active", EndDate: now.AddDate(0, 0, 5), HouseholdID: &household.ID},
+		{UserID: 2, PaymentID: 2, Status: "active", EndDate: now.AddDate(0, 0, 30)},
+		{UserID: 2, PaymentID: 3, Status: "active", EndDate: now.AddDate(0, 0, 10)},
+		{UserID: 1, PaymentID: 4, Status: "active", EndDate: now.AddDate(0, 0, 1)},                             // Not shared
+		{UserID: 1, PaymentID: 5, Status: "frozen", EndDate: now.AddDate(0, 0, 1), HouseholdID: &household.ID}, // Not bookable
+	}
+	require.NoError(t, db.Create(&memberships).Error)
+
+	got, err := BookableMemberships(db, 2)
+	require.NoError(t, err)
+	var ids []uint
+	for _, m := range got {
+		ids = append(ids, m.PaymentID)
+	}
+	assert.Equal(t, []uint{3, 2, 1}, ids, "own memberships first, soonest expiry first")
+
+	got, err = BookableMemberships(db, 3)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Checkout product types for programs paid in instalments
+const (
+	ProductInstalmentPlan = "instalment_plan" // Deposit, ProductID is the InstalmentPlan
+	ProductInstalment     = "instalment"      // Scheduled payment, ProductID is the Instalment
+)
+
+// --- Models ---
+
+// InstalmentPlan lets a program be paid as a deposit plus equal scheduled
+// instalments
+type InstalmentPlan struct {
+	ID             uint    `json:"id" gorm:"primaryKey"`
+	ProgramID      uint    `json:"program_id" gorm:"index"`
+	Name           string  `json:"name" binding:"required"`
+	DepositAmount  float64 `json:"deposit_amount"`
+	Instalments    int     `json:"instalments" binding:"required"` // Number of payments after the deposit
+	IntervalMonths int     `json:"interval_months" gorm:"default:1"`
+	Surcharge      float64 `json:"surcharge"`  // Added to the program price when paying in instalments
+	GraceDays      int     `json:"grace_days"` // Days overdue before access is suspended
+	ReminderDays   int     `json:"reminder_days" gorm:"default:3"`
+	Currency       string  `json:"currency" gorm:"default:'INR'"`
+	IsActive       bool    `json:"is_active"` // No column default, so a plan created inactive stays inactive
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Instalment is one scheduled payment on an enrollment. Sequence 0 is the deposit.
+type Instalment struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	EnrollmentID   uint       `json:"enrollment_id" gorm:"index"`
+	UserID         uint       `json:"user_id" gorm:"index"`
+	Sequence       int        `json:"sequence"`
+	Amount         float64    `json:"amount"`
+	Currency       string     `json:"currency"`
+	DueDate        time.Time  `json:"due_date" gorm:"index"`
+	Status         string     `json:"status" gorm:"index"` // pending, overdue, paid
+	PaymentID      *uint      `json:"payment_id" gorm:"uniqueIndex"`
+	PaidAt         *time.Time `json:"paid_at"`
+	Note           string     `json:"note"` // e.g. reason for an offline payment
+	ReminderSentAt *time.Time `json:"reminder_sent_at"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// --- DTOs ---
+type PayInstalmentInput struct {
+	PaymentID uint `json:"payment_id" binding:"required"`
+}
+
+type MarkInstalmentPaidInput struct {
+	Note string `json:"note" binding:"required"`
+}
+
+// --- Logic ---
+
+// Total is what a customer pays for the program on this plan
+func (p InstalmentPlan) Total(price float64) float64 {
+	return roundMoney(price + p.Surcharge)
+}
+
+// Schedule splits a program price into the deposit and instalments. The
+// last instalment absorbs any rounding difference.
+func (p InstalmentPlan) Schedule(price float64, start time.Time) []Instalment {
+	total := p.Total(price)
+	remaining := roundMoney(total - p.DepositAmount)
+	each := math.Floor(remaining/float64(p.Instalments)*100) / 100
+
+	schedule := []Instalment{{Sequence: 0, Amount: p.DepositAmount, Currency: p.Currency, DueDate: start}}
+	for i := 1; i <= p.Instalments; i++ {
+		amount := each
+		if i == p.Instalments {
+			amount = roundMoney(remaining - each*float64(p.Instalments-1))
+		}
+		schedule = append(schedule, Instalment{
+			Sequence: i,
+			Amount:   amount,
+			Currency: p.Currency,
+			DueDate:  start.AddDate(0, i*p.IntervalMonths, 0),
+		})
+	}
+	return schedule
+}
+
+func validateInstalmentPlan(plan *InstalmentPlan, price float64) error {
+	if plan.Instalments < 1 {
+		return errors.New("a plan needs at least one instalment")
+	}
+	if plan.IntervalMonths < 1 {
+		plan.IntervalMonths = 1
+	}
+	if plan.DepositAmount <= 0 || plan.DepositAmount >= plan.Total(price) {
+		return errors.New("deposit must be more than zero and less than the total")
+	}
+	if plan.GraceDays < 0 || plan.ReminderDays < 0 || plan.Surcharge < 0 {
+		return errors.New("grace days, reminder days and surcharge cannot be negative")
+	}
+	return nil
+}
+
+// priceInstalment returns what a deposit or instalment checkout must charge:
+// the plan's deposit, or the caller's unpaid instalment
+func priceInstalment(tx *gorm.DB, input OrderPricingInput) (float64, error) {
+	if input.PromoCode != "" || input.UseWallet {
+		return 0, errors.New("instalments can't be paid with promo codes or wallet credit")
+	}
+
+	var amount float64
+	var currency string
+	switch input.ProductType {
+	case ProductInstalmentPlan:
+		var plan InstalmentPlan
+		if err := tx.Where("id = ? AND is_active = ?", input.ProductID, true).First(&plan).Error; err != nil {
+			return 0, errors.New("instalment plan not found")
+		}
+		amount, currency = plan.DepositAmount, plan.Currency
+	case ProductInstalment:
+		var inst Instalment
+		if err := tx.Where("id = ? AND user_id = ?", input.ProductID, input.UserID).First(&inst).Error; err != nil {
+			return 0, errors.New("instalment not found")
+		}
+		if inst.Status == "paid" {
+			return 0, errors.New("instalment already paid")
+		}
+		amount, currency = inst.Amount, inst.Currency
+	}
+
+	if currency != strings.ToUpper(input.Currency) {
+		return 0, fmt.Errorf("instalments must be paid in %s", currency)
+	}
+	return amount, nil
+}
+
+// verifyProgramPayment checks a payment was taken for this deposit or
+// instalment and covers it, and marks it spent
+func verifyProgramPayment(tx *gorm.DB, paymentID, uid uint, productType string, productID uint, amount float64, currency string) (*Payment, error) {
+	consumer := "enrollment"
+	if productType == ProductInstalment {
+		consumer = "instalment"
+	}
+	payment, err := ConsumePayment(tx, paymentID, consumer, func(p *Payment) error {
+		if err := checkProductPayment(p, uid, productType, productID); err != nil {
+			return err
+		}
+		if p.Currency != currency || p.Total()+0.005 < amount {
+			return fmt.Errorf("payment must cover %.2f %s", amount, currency)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Payments spent before ConsumedAt was recorded
+	var used int64
+	tx.Model(&Instalment{}).Where("payment_id = ?", paymentID).Count(&used)
+	if used == 0 {
+		tx.Model(&ProgramEnrollment{}).Where("payment_id = ?", paymentID).Count(&used)
+	}
+	if used > 0 {
+		return nil, errors.New("payment already used")
+	}
+	return payment, nil
+}
+
+// EnrollWithInstalments creates an enrollment funded by a deposit payment
+// and schedules the remaining instalments
+func EnrollWithInstalments(tx *gorm.DB, uid uint, program *Program, planID, depositPaymentID uint) (*ProgramEnrollment, error) {
+	var plan InstalmentPlan
+	if err := tx.Where("id = ? AND program_id = ? AND is_active = ?", planID, program.ID, true).First(&plan).Error; err != nil {
+		return nil, errors.New("instalment plan not found")
+	}
+
+	if _, err := verifyProgramPayment(tx, depositPaymentID, uid, ProductInstalmentPlan, plan.ID, plan.DepositAmount, plan.Currency); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	enrollment := ProgramEnrollment{
+		UserID:           uid,
+		ProgramID:        program.ID,
+		PaymentStatus:    "partially_paid",
+		PaymentID:        depositPaymentID,
+		AccessStatus:     "active",
+		InstalmentPlanID: &plan.ID,
+		TotalAmount:      plan.Total(program.Price),
+		AmountPaid:       plan.DepositAmount,
+		EnrollmentDate:   now,
+	}
+	if err := tx.Create(&enrollment).Error; err != nil {
+		return nil, err
+	}
+
+	for _, inst := range plan.Schedule(program.Price, now) {
+		inst.EnrollmentID = enrollment.ID
+		inst.UserID = uid
+		inst.Status = "pending"
+		if inst.Sequence == 0 {
+			inst.Status = "paid"
+			inst.PaymentID = &depositPaymentID
+			inst.PaidAt = &now
+		}
+		if err := tx.Create(&inst).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &enrollment, nil
+}
+
+// settleInstalment marks an instalment paid and refreshes the enrollment
+func settleInstalment(tx *gorm.DB, inst *Instalment, paymentID *uint, note string) error {
+	if inst.Status == "paid" {
+		return errors.New("instalment already paid")
+	}
+
+	now := time.Now()
+	inst.Status = "paid"
+	inst.PaymentID = paymentID
+	inst.PaidAt = &now
+	inst.Note = note
+	if err := tx.Save(inst).Error; err != nil {
+		return err
+	}
+	return refreshEnrollmentPayments(tx, inst.EnrollmentID)
+}
+
+// refreshEnrollmentPayments recomputes paid totals and lifts a suspension
+// once nothing is overdue
+func refreshEnrollmentPayments(tx *gorm.DB, enrollmentID uint) error {
+	var enrollment ProgramEnrollment
+	if err := tx.First(&enrollment, enrollmentID).Error; err != nil {
+		return err
+	}
+
+	var paid float64
+	tx.Model(&Instalment{}).Where("enrollment_id = ? AND status = ?", enrollmentID, "paid").
+		Select("COALESCE(SUM(amount), 0)").Scan(&paid)
+	var unpaid, overdue int64
+	tx.Model(&Instalment{}).Where("enrollment_id = ? AND status != ?", enrollmentID, "paid").Count(&unpaid)
+	tx.Model(&Instalment{}).Where("enrollment_id = ? AND status = ?", enrollmentID, "overdue").Count(&overdue)
+
+	updates := map[string]interface{}{"amount_paid": roundMoney(paid)}
+	if unpaid == 0 {
+		updates["payment_status"] = "paid"
+	}
+	if overdue == 0 && enrollment.AccessStatus == "suspended" {
+		updates["access_status"] = "active"
+	}
+	return tx.Model(&enrollment).Updates(updates).Error
+}
+
+// ProcessInstalments sends reminders, flags overdue instalments and suspends
+// access once the plan's grace period has passed. Called from the hourly job.
+func ProcessInstalments() (reminded, overdue, suspended int) {
+	now := time.Now()
+
+	var upcoming []Instalment
+	db.Where("status = ? AND reminder_sent_at IS NULL AND due_date > ?", "pending", now).Find(&upcoming)
+	for i := range upcoming {
+		inst := &upcoming[i]
+		var enrollment ProgramEnrollment
+		if db.Preload("Program").First(&enrollment, inst.EnrollmentID).Error != nil || enrollment.InstalmentPlanID == nil {
+			continue
+		}
+		var plan InstalmentPlan
+		db.First(&plan, *enrollment.InstalmentPlanID)
+		if inst.DueDate.After(now.AddDate(0, 0, plan.ReminderDays)) {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var user User
+			tx.First(&user, inst.UserID)
+			amount := fmt.Sprintf("%.2f %s", inst.Amount, inst.Currency)
+			dueDate := inst.DueDate.Format("02 Jan 2006")
+			err := Notify(tx, inst.UserID, EventInstalmentReminder, NotificationContent{
+				Title: "Instalment due " + dueDate,
+				Body:  fmt.Sprintf("Your instalment of %s for %s is due on %s.", amount, enrollment.Program.Name, dueDate),
+				URL:   frontendURL() + "/programs/my",
+				Email: func(tx *gorm.DB, to string) error {
+					return SendInstalmentReminder(tx, to, user.Name, enrollment.Program.Name, amount, dueDate)
+				},
+			})
+			if err != nil {
+				return err
+			}
+			return tx.Model(inst).Update("reminder_sent_at", now).Error
+		})
+		if err == nil {
+			reminded++
+		}
+	}
+
+	res := db.Model(&Instalment{}).Where("status = ? AND due_date < ?", "pending", now).Update("status", "overdue")
+	overdue = int(res.RowsAffected)
+
+	var late []Instalment
+	db.Where("status = ?", "overdue").Find(&late)
+	for _, inst := range late {
+		var enrollment ProgramEnrollment
+		if db.First(&enrollment, inst.EnrollmentID).Error != nil || enrollment.AccessStatus == "suspended" || enrollment.InstalmentPlanID == nil {
+			continue
+		}
+		var plan InstalmentPlan
+		db.First(&plan, *enrollment.InstalmentPlanID)
+		if !now.After(inst.DueDate.AddDate(0, 0, plan.GraceDays)) {
+			continue
+		}
+		// Skip instalments paid since they were loaded
+		res := db.Model(&ProgramEnrollment{}).
+			Where("id = ? AND access_status = ?", enrollment.ID, "active").
+			Where("EXISTS (?)", db.Model(&Instalment{}).Select("1").Where("id = ? AND status = ?", inst.ID, "overdue")).
+			Update("access_status", "suspended")
+		if res.RowsAffected > 0 {
+			suspended++
+		}
+	}
+	return reminded, overdue, suspended
+}
+
+// --- Handlers ---
+
+// GetProgramInstalmentPlans - Public - Active plans with their payment schedule
+func GetProgramInstalmentPlans(c *gin.Context) {
+	var program Program
+	if err := db.First(&program, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Program not found"})
+		return
+	}
+
+	var plans []InstalmentPlan
+	db.Where("program_id = ? AND is_active = ?", program.ID, true).Find(&plans)
+
+	type planWithSchedule struct {
+		InstalmentPlan
+		Total    float64      `json:"total"`
+		Schedule []Instalment `json:"schedule"`
+	}
+	response := make([]planWithSchedule, 0, len(plans))
+	for _, plan := range plans {
+		response = append(response, planWithSchedule{
+			InstalmentPlan: plan,
+			Total:          plan.Total(program.Price),
+			Schedule:       plan.Schedule(program.Price, time.Now()),
+		})
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetEnrollmentInstalments - Protected - Schedule for one of the caller's enrollments
+func GetEnrollmentInstalments(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var enrollment ProgramEnrollment
+	if err := db.Preload("Program").Where("id = ? AND user_id = ?", c.Param("id"), uid).First(&enrollment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Enrollment not found"})
+		return
+	}
+
+	var instalments []Instalment
+	db.Where("enrollment_id = ?", enrollment.ID).Order("sequence asc").Find(&instalments)
+	c.JSON(http.StatusOK, gin.H{"enrollment": enrollment, "instalments": instalments})
+}
+
+// PayInstalment - Protected - Apply a completed payment to an instalment
+func PayInstalment(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var input PayInstalmentInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var inst Instalment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Locked so two payments can't both settle the same instalment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND enrollment_id = ? AND user_id = ?", c.Param("instalmentId"), c.Param("id"), uid).
+			First(&inst).Error; err != nil {
+			return errors.New("instalment not found")
+		}
+		if inst.Status == "paid" {
+			return errors.New("instalment already paid")
+		}
+		if _, err := verifyProgramPayment(tx, input.PaymentID, uid, ProductInstalment, inst.ID, inst.Amount, inst.Currency); err != nil {
+			return err
+		}
+		return settleInstalment(tx, &inst, &input.PaymentID, "")
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Instalment paid", "instalment": inst})
+}
+
+// GetAdminInstalmentPlans - Admin - All plans for a program
+func GetAdminInstalmentPlans(c *gin.Context) {
+	var plans []InstalmentPlan
+	db.Where("program_id = ?", c.Param("id")).Order("id asc").Find(&plans)
+	c.JSON(http.StatusOK, plans)
+}
+
+// CreateInstalmentPlan - Admin - Add a plan to a program
+func CreateInstalmentPlan(c *gin.Context) {
+	var program Program
+	if err := db.First(&program, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Program not found"})
+		return
+	}
+
+	plan := InstalmentPlan{IsActive: true} // Offered unless is_active is false
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	plan.ID = 0
+	plan.ProgramID = program.ID
+	if plan.Currency == "" {
+		plan.Currency = "INR"
+	}
+	if err := validateInstalmentPlan(&plan, program.Price); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.Create(&plan).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create instalment plan"})
+		return
+	}
+	c.JSON(http.StatusCreated, plan)
+}
+
+// UpdateInstalmentPlan - Admin - Change a plan. Existing schedules are not affected.
+func UpdateInstalmentPlan(c *gin.Context) {
+	var plan InstalmentPlan
+	if err := db.First(&plan, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Instalment plan not found"})
+		return
+	}
+
+	input := InstalmentPlan{IsActive: plan.IsActive} // Unchanged unless is_active is sent
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input.ID = plan.ID
+	input.ProgramID = plan.ProgramID
+	input.CreatedAt = plan.CreatedAt
+	if input.Currency == "" {
+		input.Currency = plan.Currency
+	}
+
+	var program Program
+	db.First(&program, plan.ProgramID)
+	if err := validateInstalmentPlan(&input, program.Price); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.Save(&input).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update instalment plan"})
+		return
+	}
+	c.JSON(http.StatusOK, input)
+}
+
+// DeleteInstalmentPlan - Admin - Retire a plan. Existing enrollments keep their schedule.
+func DeleteInstalmentPlan(c *gin.Context) {
+	var plan InstalmentPlan
+	if err := db.First(&plan, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Instalment plan not found"})
+		return
+	}
+	db.Model(&plan).Update("is_active", false)
+	c.JSON(http.StatusOK, gin.H{"message": "Instalment plan deactivated"})
+}
+
+// MarkInstalmentPaid - Admin - Record an offline payment (cash, bank transfer)
+func MarkInstalmentPaid(c *gin.Context) {
+	var input MarkInstalmentPaidInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var inst Instalment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&inst, c.Param("id")).Error; err != nil {
+			return errors.New("instalment not found")
+		}
+		return settleInstalment(tx, &inst, nil, input.Note)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Instalment marked paid", "instalment": inst})
+}
+
+// GET /admin/reports/receivables
+// Outstanding instalments with overdue ageing.
+func GetReceivablesReport(c *gin.Context) {
+	var open []Instalment
+	db.Where("status IN ?", []string{"pending", "overdue"}).Order("due_date asc").Find(&open)
+
+	type ageing struct {
+		Current      float64 `json:"current"`
+		Overdue1_30  float64 `json:"overdue_1_30"`
+		Overdue31_60 float64 `json:"overdue_31_60"`
+		Overdue61    float64 `json:"overdue_61_plus"`
+		Total        float64 `json:"total"`
+	}
+	byCurrency := map[string]*ageing{}
+
+	type overdueRow struct {
+		InstalmentID uint      `json:"instalment_id"`
+		EnrollmentID uint      `json:"enrollment_id"`
+		UserID       uint      `json:"user_id"`
+		UserName     string    `json:"user_name"`
+		UserEmail    string    `json:"user_email"`
+		ProgramName  string    `json:"program_name"`
+		Amount       float64   `json:"amount"`
+		Currency     string    `json:"currency"`
+		DueDate      time.Time `json:"due_date"`
+		DaysOverdue  int       `json:"days_overdue"`
+		AccessStatus string    `json:"access_status"`
+	}
+	var overdue []overdueRow
+
+	now := time.Now()
+	for _, inst := range open {
+		a, ok := byCurrency[inst.Currency]
+		if !ok {
+			a = &ageing{}
+			byCurrency[inst.Currency] = a
+		}
+		a.Total = roundMoney(a.Total + inst.Amount)
+
+		days := int(now.Sub(inst.DueDate).Hours() / 24)
+		switch {
+		case days <= 0:
+			a.Current = roundMoney(a.Current + inst.Amount)
+			continue
+		case days <= 30:
+			a.Overdue1_30 = roundMoney(a.Overdue1_30 + inst.Amount)
+		case days <= 60:
+			a.Overdue31_60 = roundMoney(a.Overdue31_60 + inst.Amount)
+		default:
+			a.Overdue61 = roundMoney(a.Overdue61 + inst.Amount)
+		}
+
+		var enrollment ProgramEnrollment
+		db.Preload("Program").First(&enrollment, inst.EnrollmentID)
+		var user User
+		db.First(&user, inst.UserID)
+		overdue = append(overdue, overdueRow{
+			InstalmentID: inst.ID,
+			EnrollmentID: inst.EnrollmentID,
+			UserID:       inst.UserID,
+			UserName:     user.Name,
+			UserEmail:    user.Email,
+			ProgramName:  enrollment.Program.Name,
+			Amount:       inst.Amount,
+			Currency:     inst.Currency,
+			DueDate:      inst.DueDate,
+			DaysOverdue:  days,
+			AccessStatus: enrollment.AccessStatus,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"outstanding": byCurrency,
+		"overdue":     overdue,
+	})
+}
+
+// --- Seeder ---
+
+// SeedInstalmentPlans offers the teacher training as a deposit plus monthly payments
+func SeedInstalmentPlans() {
+	var count int64
+	db.Model(&InstalmentPlan{}).Count(&count)
+	if count > 0 {
+		return
+	}
+
+	var program Program
+	if db.Where("name = ?", "200Hr Teacher Training").First(&program).Error != nil {
+		return
+	}
+	db.Create(&InstalmentPlan{
+		ProgramID:      program.ID,
+		Name:           "Deposit + 4 monthly payments",
+		DepositAmount:  9000,
+		Instalments:    4,
+		IntervalMonths: 1,
+		GraceDays:      7,
+		ReminderDays:   3,
+		Currency:       "INR",
+		IsActive:       true,
+	})
+}
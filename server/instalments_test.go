@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstalmentSchedule(t *testing.T) {
+	start := time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name    string
+		plan    InstalmentPlan
+		price   float64
+		amounts []float64
+	}{
+		{"even split", InstalmentPlan{DepositAmount: 9000, Instalments: 4, IntervalMonths: 1}, 45000, []float64{9000, 9000, 9000, 9000, 9000}},
+		{"last absorbs rounding", InstalmentPlan{DepositAmount: 100, Instalments: 3, IntervalMonths: 1}, 1000.01, []float64{100, 300, 300, 300.01}},
+		{"surcharge", InstalmentPlan{DepositAmount: 500, Instalments: 2, IntervalMonths: 2, Surcharge: 100}, 5000, []float64{500, 2300, 2300}},
+	}
+	for _, tc := range cases {
+		schedule := tc.plan.Schedule(tc.price, start)
+		var amounts []float64
+		total := 0.0
+		for i, inst := range schedule {
+			assert.Equal(t, i, inst.Sequence, tc.name)
+			assert.Equal(t, start.AddDate(0, i*tc.plan.IntervalMonths, 0), inst.DueDate, tc.name)
+			amounts = append(amounts, inst.Amount)
+			total += inst.Amount
+		}
+		assert.Equal(t, tc.amounts, amounts, tc.name)
+		assert.InDelta(t, tc.plan.Total(tc.price), total, 0.001, tc.name)
+	}
+}
+
+func TestValidateInstalmentPlan(t *testing.T) {
+	cases := []struct {
+		name string
+		plan InstalmentPlan
+		err  string
+	}{
+		{"valid", InstalmentPlan{DepositAmount: 1000, Instalments: 3}, ""},
+		{"no instalments", InstalmentPlan{DepositAmount: 1000}, "a plan needs at least one instalment"},
+		{"no deposit", InstalmentPlan{Instalments: 3}, "deposit must be more than zero and less than the total"},
+		{"deposit is the whole price", InstalmentPlan{DepositAmount: 5000, Instalments: 3}, "deposit must be more than zero and less than the total"},
+		{"negative grace", InstalmentPlan{DepositAmount: 1000, Instalments: 3, GraceDays: -1}, "grace days, reminder days and surcharge cannot be negative"},
+	}
+	for _, tc := range cases {
+		err := validateInstalmentPlan(&tc.plan, 5000)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.name)
+			assert.Equal(t, 1, tc.plan.IntervalMonths, tc.name)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.name)
+		}
+	}
+}
+
+func TestInstalmentSuspensionAndReinstatement(t *testing.T) {
+	setupTestDB(t, &User{}, &Payment{}, &Program{}, &ProgramEnrollment{}, &InstalmentPlan{}, &Instalment{})
+
+	program := Program{Name: "Teacher Training", Price: 45000, MaxStudents: 10}
+	require.NoError(t, db.Create(&program).Error)
+	plan := InstalmentPlan{ProgramID: program.ID, Name: "Monthly", DepositAmount: 9000, Instalments: 4, IntervalMonths: 1,
+		GraceDays: 7, ReminderDays: 3, Currency: "INR", IsActive: true}
+	require.NoError(t, db.Create(&plan).Error)
+	retired := InstalmentPlan{ProgramID: program.ID, Name: "Retired", DepositAmount: 9000, Instalments: 2, Currency: "INR"}
+	require.NoError(t, db.Create(&retired).Error)
+
+	pay := func(productType string, productID uint, amount float64) uint {
+		p := Payment{UserID: 1, Amount: amount, Currency: "INR", Status: "success", ProductType: productType, ProductID: productID}
+		require.NoError(t, db.Create(&p).Error)
+		return p.ID
+	}
+
+	// Inactive plans stay inactive, and a full-price program payment is not a deposit
+	_, err := EnrollWithInstalments(db, 1, &program, retired.ID, pay(ProductInstalmentPlan, retired.ID, 9000))
+	assert.EqualError(t, err, "instalment plan not found")
+	_, err = EnrollWithInstalments(db, 1, &program, plan.ID, pay(ProductProgram, program.ID, 45000))
+	assert.EqualError(t, err, "payment was not for this product")
+	_, err = EnrollWithInstalments(db, 1, &program, plan.ID, pay(ProductInstalmentPlan, plan.ID, 5000))
+	assert.EqualError(t, err, "payment must cover 9000.00 INR")
+
+	enrollment, err := EnrollWithInstalments(db, 1, &program, plan.ID, pay(ProductInstalmentPlan, plan.ID, 9000))
+	require.NoError(t, err)
+	assert.True(t, enrollment.HasAccess())
+
+	var first Instalment
+	db.Where("enrollment_id = ? AND sequence = ?", enrollment.ID, 1).First(&first)
+	access := func() int {
+		return callHandler(GetProgramAccess, gin.Params{{Key: "id", Value: fmt.Sprint(program.ID)}}, nil).Code
+	}
+
+	// Overdue within the grace period keeps access
+	db.Model(&first).Update("due_date", time.Now().AddDate(0, 0, -3))
+	reminded, overdue, suspended := ProcessInstalments()
+	assert.Equal(t, []int{0, 1, 0}, []int{reminded, overdue, suspended})
+	assert.Equal(t, http.StatusOK, access())
+
+	// Past the grace period access is suspended, once
+	db.Model(&first).Update("due_date", time.Now().AddDate(0, 0, -10))
+	_, _, suspended = ProcessInstalments()
+	assert.Equal(t, 1, suspended)
+	_, _, suspended = ProcessInstalments()
+	assert.Equal(t, 0, suspended)
+	assert.Equal(t, http.StatusForbidden, access())
+
+	// A payment for another instalment can't settle this one
+	var second Instalment
+	db.Where("enrollment_id = ? AND sequence = ?", enrollment.ID, 2).First(&second)
+	params := gin.Params{{Key: "id", Value: fmt.Sprint(enrollment.ID)}, {Key: "instalmentId", Value: fmt.Sprint(first.ID)}}
+	w := callHandler(PayInstalment, params, PayInstalmentInput{PaymentID: pay(ProductInstalment, second.ID, 9000)})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "payment was not for this product")
+
+	// Paying the overdue instalment reinstates access
+	paymentID := pay(ProductInstalment, first.ID, 9000)
+	w = callHandler(PayInstalment, params, PayInstalmentInput{PaymentID: paymentID})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusOK, access())
+	db.First(enrollment, enrollment.ID)
+	assert.Equal(t, 18000.0, enrollment.AmountPaid)
+	assert.Equal(t, "partially_paid", enrollment.PaymentStatus)
+
+	// The same payment can't be spent again
+	params[1].Value = fmt.Sprint(second.ID)
+	w = callHandler(PayInstalment, params, PayInstalmentInput{PaymentID: paymentID})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestEnrollProgramPaymentBinding(t *testing.T) {
+	setupTestDB(t, &User{}, &Payment{}, &Program{}, &ProgramEnrollment{}, &InstalmentPlan{}, &Instalment{})
+
+	foundation := Program{Name: "Foundation", Price: 8000, MaxStudents: 10}
+	training := Program{Name: "Teacher Training", Price: 45000, MaxStudents: 10}
+	require.NoError(t, db.Create(&foundation).Error)
+	require.NoError(t, db.Create(&training).Error)
+	cheap := Payment{UserID: 1, Amount: 8000, Currency: "INR", Status: "success", ProductType: ProductProgram, ProductID: foundation.ID}
+	require.NoError(t, db.Create(&cheap).Error)
+
+	enroll := func(programID uint) int {
+		return callHandler(EnrollProgram, nil, gin.H{"program_id": programID, "payment_id": cheap.ID}).Code
+	}
+	assert.Equal(t, http.StatusBadRequest, enroll(training.ID), "paid for a cheaper program")
+	assert.Equal(t, http.StatusCreated, enroll(foundation.ID))
+	assert.Equal(t, http.StatusConflict, enroll(foundation.ID))
+
+	var count int64
+	db.Model(&ProgramEnrollment{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
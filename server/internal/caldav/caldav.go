@@ -0,0 +1,367 @@
+// Package caldav is a small CalDAV (RFC 4791) client for keeping a copy of
+// one calendar collection in step with the server. Changes are fetched
+// incrementally with WebDAV sync (RFC 6578), and writes use ETags so that
+// edits made on the server since the last sync are never silently lost.
+package caldav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrSyncTokenInvalid means the server no longer recognises the sync
+	// token, and the caller should start again with a full sync
+	ErrSyncTokenInvalid = errors.New("caldav: sync token is no longer valid")
+	// ErrPreconditionFailed means the object changed on the server since its
+	// ETag was read (or already exists, when creating)
+	ErrPreconditionFailed = errors.New("caldav: object was changed on the server")
+	ErrNotFound           = errors.New("caldav: object not found")
+	ErrNotCalendar        = errors.New("caldav: URL is not a calendar collection")
+)
+
+// maxResponse bounds how much of a response is read
+const maxResponse = 32 << 20
+
+// StatusError is an unexpected HTTP response
+type StatusError struct {
+	Method string
+	Status int
+	Body   string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("caldav: %s returned %d: %s", e.Method, e.Status, e.Body)
+}
+
+// Object is a calendar object resource: one .ics file in the collection
+type Object struct {
+	Href string
+	ETag string
+	Data []byte
+}
+
+// Changes are the differences since a sync token
+type Changes struct {
+	SyncToken string   // Pass to the next Sync
+	Updated   []Object // New or changed objects, with their data
+	Deleted   []string // Hrefs of removed objects
+}
+
+// Client talks to one calendar collection
+type Client struct {
+	CalendarURL string // The collection, e.g. https://dav.example.com/alice/work/
+	Username    string
+	Password    string
+	HTTP        *http.Client
+}
+
+// New returns a client for the collection at calendarURL
+func New(calendarURL, username, password string) *Client {
+	if !strings.HasSuffix(calendarURL, "/") {
+		calendarURL += "/"
+	}
+	return &Client{
+		CalendarURL: calendarURL,
+		Username:    username,
+		Password:    password,
+		HTTP:        &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Href is the path for a new object named name in the collection
+func (c *Client) Href(name string) string {
+	u, err := url.Parse(c.CalendarURL)
+	if err != nil {
+		return name
+	}
+	return u.EscapedPath() + url.PathEscape(name)
+}
+
+// Check confirms the URL is a calendar collection the credentials can read
+func (c *Client) Check(ctx context.Context) error {
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/></d:prop></d:propfind>`
+	ms, err := c.multistatus(ctx, "PROPFIND", c.CalendarURL, "0", body)
+	if err != nil {
+		return err
+	}
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if ps.Prop.ResourceType.Calendar != nil {
+				return nil
+			}
+		}
+	}
+	return ErrNotCalendar
+}
+
+// Sync returns what changed since token, or everything when token is empty.
+// Objects reported without their data are fetched with a multiget.
+func (c *Client) Sync(ctx context.Context, token string) (Changes, error) {
+	var changes Changes
+	for page := 0; page < 100; page++ {
+		body := `<?xml version="1.0" encoding="utf-8"?>
+<d:sync-collection xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:sync-token>` + xmlEscape(token) + `</d:sync-token>
+  <d:sync-level>1</d:sync-level>
+  <d:prop><d:getetag/><c:calendar-data/></d:prop>
+</d:sync-collection>`
+		ms, err := c.multistatus(ctx, "REPORT", c.CalendarURL, "1", body)
+		var se *StatusError
+		if errors.As(err, &se) && se.Status >= 400 && se.Status < 500 && strings.Contains(se.Body, "valid-sync-token") {
+			return changes, ErrSyncTokenInvalid
+		}
+		if err != nil {
+			return changes, err
+		}
+
+		truncated := false
+		var missing []string
+		for _, r := range ms.Responses {
+			code := statusCode(r.Status)
+			switch {
+			case code == http.StatusInsufficientStorage:
+				truncated = true // More changes follow from the new token
+			case code == http.StatusNotFound:
+				changes.Deleted = append(changes.Deleted, r.Href)
+			case strings.HasSuffix(r.Href, "/"):
+				// The collection itself
+			default:
+				if ps, ok := r.ok(); ok && ps.Prop.CalendarData != "" {
+					changes.Updated = append(changes.Updated, Object{Href: r.Href, ETag: ps.Prop.ETag, Data: []byte(ps.Prop.CalendarData)})
+				} else {
+					missing = append(missing, r.Href)
+				}
+			}
+		}
+		if len(missing) > 0 {
+			objects, err := c.Multiget(ctx, missing)
+			if err != nil {
+				return changes, err
+			}
+			changes.Updated = append(changes.Updated, objects...)
+		}
+
+		changes.SyncToken = ms.SyncToken
+		if !truncated || ms.SyncToken == "" || ms.SyncToken == token {
+			return changes, nil
+		}
+		token = ms.SyncToken
+	}
+	return changes, nil
+}
+
+// Multiget fetches several objects in one request. Objects that no longer
+// exist are left out.
+func (c *Client) Multiget(ctx context.Context, hrefs []string) ([]Object, error) {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<c:calendar-multiget xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:prop><d:getetag/><c:calendar-data/></d:prop>`)
+	for _, href := range hrefs {
+		b.WriteString("\n  <d:href>" + xmlEscape(href) + "</d:href>")
+	}
+	b.WriteString("\n</c:calendar-multiget>")
+
+	ms, err := c.multistatus(ctx, "REPORT", c.CalendarURL, "1", b.String())
+	if err != nil {
+		return nil, err
+	}
+	var objects []Object
+	for _, r := range ms.Responses {
+		if ps, ok := r.ok(); ok {
+			objects = append(objects, Object{Href: r.Href, ETag: ps.Prop.ETag, Data: []byte(ps.Prop.CalendarData)})
+		}
+	}
+	return objects, nil
+}
+
+// Get fetches one object
+func (c *Client) Get(ctx context.Context, href string) (Object, error) {
+	resp, body, err := c.do(ctx, "GET", href, nil, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return Object{Href: href, ETag: resp.Header.Get("ETag"), Data: body}, nil
+	case http.StatusNotFound:
+		return Object{}, ErrNotFound
+	}
+	return Object{}, statusError("GET", resp, body)
+}
+
+// Put writes an object and returns its new ETag, which some servers leave
+// out. An empty etag creates the object only if it doesn't exist; otherwise
+// the write only succeeds if the object is unchanged since etag was read.
+func (c *Client) Put(ctx context.Context, href string, data []byte, etag string) (string, error) {
+	header := http.Header{"Content-Type": {"text/calendar; charset=utf-8"}}
+	if etag == "" {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", etag)
+	}
+	resp, body, err := c.do(ctx, "PUT", href, header, data)
+	if err != nil {
+		return "", err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return resp.Header.Get("ETag"), nil
+	case http.StatusPreconditionFailed:
+		return "", ErrPreconditionFailed
+	}
+	return "", statusError("PUT", resp, body)
+}
+
+// Delete removes an object if it is unchanged since etag was read, or
+// regardless when etag is empty
+func (c *Client) Delete(ctx context.Context, href, etag string) error {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-Match", etag)
+	}
+	resp, body, err := c.do(ctx, "DELETE", href, header, nil)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusAccepted:
+		return nil
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusPreconditionFailed:
+		return ErrPreconditionFailed
+	}
+	return statusError("DELETE", resp, body)
+}
+
+// --- Transport ---
+
+func (c *Client) do(ctx context.Context, method, href string, header http.Header, body []byte) (*http.Response, []byte, error) {
+	target, err := c.resolve(href)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	return resp, data, err
+}
+
+func (c *Client) multistatus(ctx context.Context, method, href, depth, body string) (multistatus, error) {
+	header := http.Header{
+		"Content-Type": {"application/xml; charset=utf-8"},
+		"Depth":        {depth},
+	}
+	var ms multistatus
+	resp, data, err := c.do(ctx, method, href, header, []byte(body))
+	if err != nil {
+		return ms, err
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return ms, statusError(method, resp, data)
+	}
+	if err := xml.Unmarshal(data, &ms); err != nil {
+		return ms, fmt.Errorf("caldav: bad %s response: %w", method, err)
+	}
+	return ms, nil
+}
+
+// resolve makes an href absolute against the collection URL
+func (c *Client) resolve(href string) (string, error) {
+	base, err := url.Parse(c.CalendarURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+func statusError(method string, resp *http.Response, body []byte) error {
+	text := strings.TrimSpace(string(body))
+	if len(text) > 500 {
+		text = text[:500]
+	}
+	return &StatusError{Method: method, Status: resp.StatusCode, Body: text}
+}
+
+// --- XML ---
+
+type multistatus struct {
+	Responses []response `xml:"DAV: response"`
+	SyncToken string     `xml:"DAV: sync-token"`
+}
+
+type response struct {
+	Href      string     `xml:"DAV: href"`
+	Status    string     `xml:"DAV: status"`
+	Propstats []propstat `xml:"DAV: propstat"`
+}
+
+type propstat struct {
+	Status string `xml:"DAV: status"`
+	Prop   struct {
+		ETag         string `xml:"DAV: getetag"`
+		CalendarData string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+		ResourceType struct {
+			Calendar *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar"`
+		} `xml:"DAV: resourcetype"`
+	} `xml:"DAV: prop"`
+}
+
+// ok is the response's successful propstat
+func (r response) ok() (propstat, bool) {
+	for _, ps := range r.Propstats {
+		if statusCode(ps.Status) == http.StatusOK {
+			return ps, true
+		}
+	}
+	return propstat{}, false
+}
+
+// statusCode reads the code from a status line such as "HTTP/1.1 404 Not Found"
+func statusCode(line string) int {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return 0
+	}
+	code, _ := strconv.Atoi(fields[1])
+	return code
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,315 @@
+package caldav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer is an in-memory calendar collection at /cal/ that supports
+// enough of CalDAV for the client: sync tokens, multiget and ETags
+type fakeServer struct {
+	mu          sync.Mutex
+	version     int
+	objects     map[string]fakeObject // By href
+	deleted     map[string]int        // Href to the version it was deleted at
+	omitData    bool                  // Leave calendar-data out of sync reports
+	forgetToken bool                  // Reject every non-empty sync token
+}
+
+type fakeObject struct {
+	data    string
+	version int
+}
+
+func newFakeServer(t *testing.T) (*fakeServer, *Client) {
+	f := &fakeServer{objects: map[string]fakeObject{}, deleted: map[string]int{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, New(srv.URL+"/cal", "alice", "secret")
+}
+
+func (f *fakeServer) etag(href string) string {
+	return fmt.Sprintf(`"%d"`, f.objects[href].version)
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user, pass, _ := r.BasicAuth(); user != "alice" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	href := r.URL.Path
+	obj, exists := f.objects[href]
+
+	switch r.Method {
+	case "PROPFIND":
+		f.multistatus(w, "", `<d:response><d:href>/cal/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/><c:calendar/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`)
+	case "REPORT":
+		if strings.Contains(string(body), "calendar-multiget") {
+			var req struct {
+				Hrefs []string `xml:"DAV: href"`
+			}
+			xml.Unmarshal(body, &req)
+			var out strings.Builder
+			for _, h := range req.Hrefs {
+				out.WriteString(f.objectResponse(h, true))
+			}
+			f.multistatus(w, "", out.String())
+			return
+		}
+		var req struct {
+			Token string `xml:"DAV: sync-token"`
+		}
+		xml.Unmarshal(body, &req)
+		since := 0
+		if req.Token != "" {
+			since, _ = strconv.Atoi(strings.TrimPrefix(req.Token, "tok-"))
+			if f.forgetToken || since > f.version {
+				w.WriteHeader(http.StatusForbidden)
+				io.WriteString(w, `<?xml version="1.0"?><d:error xmlns:d="DAV:"><d:valid-sync-token/></d:error>`)
+				return
+			}
+		}
+		var out strings.Builder
+		for _, h := range f.sortedHrefs() {
+			if f.objects[h].version > since {
+				out.WriteString(f.objectResponse(h, !f.omitData))
+			}
+		}
+		for h, v := range f.deleted {
+			if v > since && req.Token != "" {
+				out.WriteString(`<d:response><d:href>` + h + `</d:href><d:status>HTTP/1.1 404 Not Found</d:status></d:response>`)
+			}
+		}
+		f.multistatus(w, fmt.Sprintf("tok-%d", f.version), out.String())
+	case "GET":
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", f.etag(href))
+		io.WriteString(w, obj.data)
+	case "PUT":
+		if (r.Header.Get("If-None-Match") == "*" && exists) ||
+			(r.Header.Get("If-Match") != "" && (!exists || r.Header.Get("If-Match") != f.etag(href))) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		f.version++
+		f.objects[href] = fakeObject{string(body), f.version}
+		delete(f.deleted, href)
+		w.Header().Set("ETag", f.etag(href))
+		w.WriteHeader(http.StatusCreated)
+	case "DELETE":
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if m := r.Header.Get("If-Match"); m != "" && m != f.etag(href) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		f.version++
+		delete(f.objects, href)
+		f.deleted[href] = f.version
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// edit changes an object as if from another calendar app
+func (f *fakeServer) edit(href, data string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version++
+	f.objects[href] = fakeObject{data, f.version}
+}
+
+func (f *fakeServer) sortedHrefs() []string {
+	var hrefs []string
+	for h := range f.objects {
+		hrefs = append(hrefs, h)
+	}
+	sort.Strings(hrefs)
+	return hrefs
+}
+
+func (f *fakeServer) objectResponse(href string, withData bool) string {
+	obj, ok := f.objects[href]
+	if !ok {
+		return `<d:response><d:href>` + href + `</d:href><d:status>HTTP/1.1 404 Not Found</d:status></d:response>`
+	}
+	data := ""
+	if withData {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(obj.data))
+		data = "<c:calendar-data>" + b.String() + "</c:calendar-data>"
+	}
+	return `<d:response><d:href>` + href + `</d:href><d:propstat><d:prop><d:getetag>` + f.etag(href) + `</d:getetag>` +
+		data + `</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`
+}
+
+func (f *fakeServer) multistatus(w http.ResponseWriter, token, responses string) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">`+responses)
+	if token != "" {
+		io.WriteString(w, "<d:sync-token>"+token+"</d:sync-token>")
+	}
+	io.WriteString(w, "</d:multistatus>")
+}
+
+func event(uid, summary string) []byte {
+	return []byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:" + uid +
+		"\r\nDTSTAMP:20261019T000000Z\r\nDTSTART:20261020T090000Z\r\nDTEND:20261020T100000Z\r\nSUMMARY:" + summary +
+		"\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+}
+
+func TestSyncIsIncremental(t *testing.T) {
+	f, client := newFakeServer(t)
+	ctx := context.Background()
+	if err := client.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	dentist, team := client.Href("dentist.ics"), client.Href("team.ics")
+	f.edit(dentist, string(event("dentist", "Dentist & check-up")))
+	f.edit(team, string(event("team", "Team lunch")))
+
+	changes, err := client.Sync(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Updated) != 2 || !strings.Contains(string(changes.Updated[0].Data), "SUMMARY:Dentist & check-up") {
+		t.Fatalf("first sync = %+v", changes)
+	}
+
+	f.edit(team, string(event("team", "Team lunch, moved")))
+	client.Delete(ctx, dentist, "")
+	f.omitData = true // Make the client fall back to a multiget
+
+	changes, err = client.Sync(ctx, changes.SyncToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Updated) != 1 || changes.Updated[0].Href != team || !strings.Contains(string(changes.Updated[0].Data), "moved") {
+		t.Errorf("updated = %+v, want just the moved lunch", changes.Updated)
+	}
+	if len(changes.Deleted) != 1 || changes.Deleted[0] != dentist {
+		t.Errorf("deleted = %v, want the dentist", changes.Deleted)
+	}
+
+	f.forgetToken = true
+	if _, err := client.Sync(ctx, changes.SyncToken); !errors.Is(err, ErrSyncTokenInvalid) {
+		t.Errorf("sync with a forgotten token = %v, want ErrSyncTokenInvalid", err)
+	}
+}
+
+func TestPutUsesETags(t *testing.T) {
+	f, client := newFakeServer(t)
+	ctx := context.Background()
+	href := client.Href("appointment-1.ics")
+
+	etag, err := client.Put(ctx, href, event("appointment-1", "Massage"), "")
+	if err != nil || etag == "" {
+		t.Fatalf("create: etag %q, %v", etag, err)
+	}
+	if _, err := client.Put(ctx, href, event("appointment-1", "Massage"), ""); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("creating twice = %v, want ErrPreconditionFailed", err)
+	}
+
+	f.edit(href, string(event("appointment-1", "Massage (edited on phone)")))
+	if _, err := client.Put(ctx, href, event("appointment-1", "Massage at 10"), etag); !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("stale update = %v, want ErrPreconditionFailed", err)
+	}
+	if err := client.Delete(ctx, href, etag); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("stale delete = %v, want ErrPreconditionFailed", err)
+	}
+
+	current, err := client.Get(ctx, href)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Put(ctx, href, event("appointment-1", "Massage at 10"), current.ETag); err != nil {
+		t.Errorf("update with the current etag: %v", err)
+	}
+	if err := client.Delete(ctx, href, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(ctx, href); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get after delete = %v, want ErrNotFound", err)
+	}
+}
+
+// TestServer runs against a real CalDAV server, e.g. Radicale:
+//
+//	radicale --storage-filesystem-folder=/tmp/radicale --auth-type=none
+//	CALDAV_TEST_URL=http://localhost:5232/test/cal/ go test ./internal/caldav
+//
+// The collection is created if needed and should be otherwise unused.
+func TestServer(t *testing.T) {
+	calendarURL := os.Getenv("CALDAV_TEST_URL")
+	if calendarURL == "" {
+		t.Skip("CALDAV_TEST_URL not set")
+	}
+	client := New(calendarURL, os.Getenv("CALDAV_TEST_USERNAME"), os.Getenv("CALDAV_TEST_PASSWORD"))
+	ctx := context.Background()
+	if client.Check(ctx) != nil {
+		resp, _, err := client.do(ctx, "MKCALENDAR", calendarURL, nil, nil)
+		if err != nil || resp.StatusCode != http.StatusCreated {
+			t.Fatalf("creating the calendar: %v %v", resp, err)
+		}
+	}
+
+	start, err := client.Sync(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := fmt.Sprintf("caldav-test-%d", time.Now().UnixNano())
+	href := client.Href(uid + ".ics")
+	etag, err := client.Put(ctx, href, event(uid, "Integration test"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag == "" {
+		obj, _ := client.Get(ctx, href)
+		etag = obj.ETag
+	}
+
+	changes, err := client.Sync(ctx, start.SyncToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Updated) != 1 || changes.Updated[0].Href != href {
+		t.Fatalf("sync after create = %+v", changes)
+	}
+	if _, err := client.Put(ctx, href, event(uid, "Stale"), `"not-the-etag"`); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("stale put = %v, want ErrPreconditionFailed", err)
+	}
+	if err := client.Delete(ctx, href, etag); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err = client.Sync(ctx, changes.SyncToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Deleted) != 1 || changes.Deleted[0] != href {
+		t.Errorf("sync after delete = %+v", changes)
+	}
+}
@@ -0,0 +1,236 @@
+package geo
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed data/cities.tsv data/countries.tsv
+var bundled embed.FS
+
+// Place is a gazetteer entry
+type Place struct {
+	Name    string `json:"name"`
+	Country string `json:"country"` // ISO 3166-1 alpha-2
+	Region  string `json:"region"`
+	Point
+	Population int `json:"population"`
+}
+
+// Gazetteer geocodes place names without a network call. Cities with the
+// same name are told apart by country, then by population.
+type Gazetteer struct {
+	places    []Place
+	byName    map[string][]int // Normalised name or alternate name -> places, largest first
+	countries map[string]string
+}
+
+var (
+	defaultGazetteer *Gazetteer
+	defaultOnce      sync.Once
+	defaultErr       error
+)
+
+// Default is the bundled gazetteer of major cities, extended with the
+// entries in GEO_GAZETTEER_PATH (same tab-separated format) when set
+func Default() (*Gazetteer, error) {
+	defaultOnce.Do(func() {
+		defaultGazetteer, defaultErr = loadDefault()
+	})
+	return defaultGazetteer, defaultErr
+}
+
+func loadDefault() (*Gazetteer, error) {
+	cities, err := bundled.Open("data/cities.tsv")
+	if err != nil {
+		return nil, err
+	}
+	defer cities.Close()
+	countries, err := bundled.Open("data/countries.tsv")
+	if err != nil {
+		return nil, err
+	}
+	defer countries.Close()
+
+	g := &Gazetteer{byName: map[string][]int{}, countries: map[string]string{}}
+	if err := g.loadCountries(countries); err != nil {
+		return nil, err
+	}
+	if err := g.Load(cities); err != nil {
+		return nil, err
+	}
+	if path := os.Getenv("GEO_GAZETTEER_PATH"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := g.Load(f); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return g, nil
+}
+
+// Load adds places from tab-separated lines of name, alternate names
+// (comma separated), country code, region, latitude, longitude and
+// population. Blank lines and lines starting with # are skipped.
+func (g *Gazetteer) Load(r io.Reader) error {
+	if g.byName == nil {
+		g.byName = map[string][]int{}
+	}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("geo: line %d: want 7 fields, got %d", line, len(fields))
+		}
+		lat, err1 := strconv.ParseFloat(fields[4], 64)
+		lng, err2 := strconv.ParseFloat(fields[5], 64)
+		population, _ := strconv.Atoi(fields[6])
+		place := Place{Name: fields[0], Country: strings.ToUpper(fields[2]), Region: fields[3],
+			Point: Point{Lat: lat, Lng: lng}, Population: population}
+		if err1 != nil || err2 != nil || !place.Valid() {
+			return fmt.Errorf("geo: line %d: invalid coordinates", line)
+		}
+
+		g.places = append(g.places, place)
+		index := len(g.places) - 1
+		names := append([]string{fields[0]}, strings.Split(fields[1], ",")...)
+		seen := map[string]bool{}
+		for _, name := range names {
+			if key := normalize(name); key != "" && !seen[key] {
+				seen[key] = true
+				g.byName[key] = append(g.byName[key], index)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, indexes := range g.byName {
+		sort.SliceStable(indexes, func(i, j int) bool {
+			return g.places[indexes[i]].Population > g.places[indexes[j]].Population
+		})
+	}
+	return nil
+}
+
+func (g *Gazetteer) loadCountries(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		code := strings.ToUpper(fields[0])
+		g.countries[normalize(code)] = code
+		for _, field := range fields[1:] {
+			for _, name := range strings.Split(field, ",") {
+				if key := normalize(name); key != "" {
+					g.countries[key] = code
+				}
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// CountryCode resolves a country name or code ("Australia", "au") to its
+// ISO code, or "" if unknown
+func (g *Gazetteer) CountryCode(country string) string {
+	return g.countries[normalize(country)]
+}
+
+// Lookup finds a place by name, optionally within a country given by name
+// or code. A name may include the country after a comma: "Perth, UK".
+func (g *Gazetteer) Lookup(name, country string) (Place, bool) {
+	if country == "" {
+		if i := strings.LastIndex(name, ","); i > 0 {
+			if code := g.CountryCode(name[i+1:]); code != "" {
+				name, country = name[:i], code
+			}
+		}
+	}
+	code := ""
+	if country != "" {
+		if code = g.CountryCode(country); code == "" {
+			return Place{}, false
+		}
+	}
+	for _, i := range g.byName[normalize(name)] {
+		if code == "" || g.places[i].Country == code {
+			return g.places[i], true
+		}
+	}
+	return Place{}, false
+}
+
+// Suggest lists places whose name or alternate name starts with prefix,
+// largest first, for autocompleting a location box. country, a name or
+// code, narrows the list when not empty.
+func (g *Gazetteer) Suggest(prefix, country string, limit int) []Place {
+	key := normalize(prefix)
+	code := g.CountryCode(country)
+	if key == "" || (country != "" && code == "") {
+		return nil
+	}
+	seen := map[int]bool{}
+	var matches []int
+	for name, indexes := range g.byName {
+		if strings.HasPrefix(name, key) {
+			for _, i := range indexes {
+				if !seen[i] && (code == "" || g.places[i].Country == code) {
+					seen[i] = true
+					matches = append(matches, i)
+				}
+			}
+		}
+	}
+	sort.Slice(matches, func(a, b int) bool {
+		pa, pb := g.places[matches[a]], g.places[matches[b]]
+		if pa.Population != pb.Population {
+			return pa.Population > pb.Population
+		}
+		return matches[a] < matches[b]
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	places := make([]Place, len(matches))
+	for i, m := range matches {
+		places[i] = g.places[m]
+	}
+	return places
+}
+
+// Latin letters with diacritics, folded so "Zurich" finds "Zürich"
+var foldAccents = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ä", "a", "ã", "a", "å", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "ö", "o", "õ", "o", "ø", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ñ", "n", "ç", "c", "ß", "ss", "æ", "ae", "œ", "oe",
+)
+
+// normalize lowercases, folds accents and collapses punctuation and spaces
+func normalize(s string) string {
+	s = foldAccents.Replace(strings.ToLower(s))
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == '.' || r == '-' || r == '\'' || r == '\t'
+	})
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,84 @@
+// Package geo does the distance maths for "near me" searches and geocodes
+// place names offline from a bundled gazetteer.
+//
+// Distances are great-circle (haversine) on a spherical Earth, which is
+// within 0.5% of the true distance; plenty for finding a nearby studio.
+package geo
+
+import "math"
+
+// EarthRadiusKm is the mean radius of the Earth
+const EarthRadiusKm = 6371.0088
+
+// Point is a WGS 84 coordinate in degrees
+type Point struct {
+	Lat float64 `json:"latitude"`
+	Lng float64 `json:"longitude"`
+}
+
+// Valid reports whether p is a real coordinate. (0, 0) counts as unset,
+// since that is what an empty latitude/longitude column holds.
+func (p Point) Valid() bool {
+	return p.Lat >= -90 && p.Lat <= 90 && p.Lng >= -180 && p.Lng <= 180 && (p.Lat != 0 || p.Lng != 0)
+}
+
+// Distance is the great-circle distance between a and b in kilometres
+func Distance(a, b Point) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat := lat2 - lat1
+	dLng := radians(b.Lng - a.Lng)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * EarthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// Box is a latitude/longitude rectangle. When it crosses the antimeridian
+// MinLng is greater than MaxLng.
+type Box struct {
+	MinLat, MaxLat float64
+	MinLng, MaxLng float64
+}
+
+// BoundingBox is the smallest Box holding every point within radiusKm of
+// center. It is a cheap prefilter that an index can serve; Distance then
+// trims the corners.
+func BoundingBox(center Point, radiusKm float64) Box {
+	dLat := radiusKm / EarthRadiusKm * 180 / math.Pi
+	box := Box{MinLat: center.Lat - dLat, MaxLat: center.Lat + dLat, MinLng: -180, MaxLng: 180}
+	if box.MinLat <= -90 || box.MaxLat >= 90 {
+		// The circle covers a pole, so every longitude is in range
+		box.MinLat, box.MaxLat = math.Max(box.MinLat, -90), math.Min(box.MaxLat, 90)
+		return box
+	}
+
+	// The widest point of the circle, which is not at center's latitude
+	// (Chamberlain, "Find points within a distance of a latitude/longitude")
+	dLng := math.Asin(math.Sin(radiusKm/EarthRadiusKm)/math.Cos(radians(center.Lat))) * 180 / math.Pi
+	box.MinLng, box.MaxLng = center.Lng-dLng, center.Lng+dLng
+	if box.MinLng < -180 {
+		box.MinLng += 360
+	}
+	if box.MaxLng > 180 {
+		box.MaxLng -= 360
+	}
+	return box
+}
+
+// WrapsAntimeridian reports whether the box crosses longitude ±180
+func (b Box) WrapsAntimeridian() bool {
+	return b.MinLng > b.MaxLng
+}
+
+// Contains reports whether p is inside the box
+func (b Box) Contains(p Point) bool {
+	if p.Lat < b.MinLat || p.Lat > b.MaxLat {
+		return false
+	}
+	if b.WrapsAntimeridian() {
+		return p.Lng >= b.MinLng || p.Lng <= b.MaxLng
+	}
+	return p.Lng >= b.MinLng && p.Lng <= b.MaxLng
+}
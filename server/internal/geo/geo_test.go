@@ -0,0 +1,111 @@
+package geo
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+var (
+	sydney    = Point{Lat: -33.8688, Lng: 151.2093}
+	melbourne = Point{Lat: -37.8136, Lng: 144.9631}
+)
+
+func TestDistance(t *testing.T) {
+	if d := Distance(sydney, melbourne); math.Abs(d-713.4) > 2 {
+		t.Errorf("Sydney to Melbourne = %.1f km, want about 713", d)
+	}
+	if d := Distance(sydney, sydney); d != 0 {
+		t.Errorf("distance to self = %v", d)
+	}
+	// Either side of the antimeridian in Fiji
+	if d := Distance(Point{Lat: -17, Lng: 179.9}, Point{Lat: -17, Lng: -179.9}); d > 25 {
+		t.Errorf("across the antimeridian = %.1f km", d)
+	}
+}
+
+func TestBoundingBox(t *testing.T) {
+	box := BoundingBox(sydney, 50)
+	if !box.Contains(sydney) || box.Contains(melbourne) {
+		t.Errorf("box %+v", box)
+	}
+	// Every point on the circle is inside the box
+	for bearing := 0.0; bearing < 360; bearing += 15 {
+		p := destination(sydney, bearing, 49.9)
+		if !box.Contains(p) {
+			t.Errorf("bearing %v: %+v outside %+v", bearing, p, box)
+		}
+	}
+
+	fiji := BoundingBox(Point{Lat: -17, Lng: 179.9}, 100)
+	if !fiji.WrapsAntimeridian() || !fiji.Contains(Point{Lat: -17, Lng: -179.5}) || fiji.Contains(Point{Lat: -17, Lng: 0}) {
+		t.Errorf("antimeridian box %+v", fiji)
+	}
+
+	pole := BoundingBox(Point{Lat: 89.9, Lng: 0}, 50)
+	if pole.MaxLat != 90 || !pole.Contains(Point{Lat: 89.8, Lng: 180}) {
+		t.Errorf("polar box %+v", pole)
+	}
+}
+
+// destination walks distanceKm from p on a bearing in degrees
+func destination(p Point, bearing, distanceKm float64) Point {
+	d := distanceKm / EarthRadiusKm
+	lat1, lng1, b := radians(p.Lat), radians(p.Lng), radians(bearing)
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(b))
+	lng2 := lng1 + math.Atan2(math.Sin(b)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat2))
+	return Point{Lat: lat2 * 180 / math.Pi, Lng: lng2 * 180 / math.Pi}
+}
+
+func TestGazetteer(t *testing.T) {
+	g, err := Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name, country string
+		wantCountry   string
+		wantRegion    string
+	}{
+		{"Perth", "", "AU", "Western Australia"}, // The larger Perth
+		{"perth", "United Kingdom", "GB", "Scotland"},
+		{"Perth, UK", "", "GB", "Scotland"},
+		{"London", "Canada", "CA", "Ontario"},
+		{"Bangalore", "", "IN", "Karnataka"}, // Alternate name
+		{"Zurich", "ch", "CH", "Zurich"},     // Accents folded
+		{"  new   york ", "USA", "US", "New York"},
+	}
+	for _, c := range cases {
+		place, ok := g.Lookup(c.name, c.country)
+		if !ok || place.Country != c.wantCountry || place.Region != c.wantRegion {
+			t.Errorf("Lookup(%q, %q) = %+v, %v", c.name, c.country, place, ok)
+		}
+	}
+	if _, ok := g.Lookup("Sydney", "Atlantis"); ok {
+		t.Error("found a city in an unknown country")
+	}
+	if _, ok := g.Lookup("Nowhere", ""); ok {
+		t.Error("found an unknown city")
+	}
+
+	suggestions := g.Suggest("syd", "", 5)
+	if len(suggestions) != 1 || suggestions[0].Name != "Sydney" {
+		t.Errorf("Suggest(syd) = %+v", suggestions)
+	}
+	if got := g.Suggest("b", "", 3); len(got) != 3 || got[0].Population < got[2].Population {
+		t.Errorf("Suggest(b) = %+v, want the 3 largest", got)
+	}
+	if got := g.Suggest("b", "Australia", 10); len(got) != 5 || got[0].Name != "Brisbane" {
+		t.Errorf("Suggest(b, Australia) = %+v", got)
+	}
+}
+
+func TestLoadRejectsBadLines(t *testing.T) {
+	g := &Gazetteer{}
+	if err := g.Load(strings.NewReader("Atlantis\t\tXX\t\t95\t0\t0\n")); err == nil {
+		t.Error("accepted a latitude of 95")
+	}
+	if err := g.Load(strings.NewReader("Atlantis\tXX\n")); err == nil {
+		t.Error("accepted a short line")
+	}
+}
@@ -0,0 +1,367 @@
+// Package geoip reads MaxMind DB (.mmdb) files such as GeoLite2-Country
+// without any third-party dependencies or network access.
+//
+// Format reference: https://maxmind.github.io/MaxMind-DB/
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// ErrNotFound is returned when the database has no record for an address
+var ErrNotFound = errors.New("geoip: address not found")
+
+// Metadata describes the database file
+type Metadata struct {
+	DatabaseType string
+	IPVersion    int
+	NodeCount    uint
+	RecordSize   uint
+	BuildEpoch   uint64
+}
+
+// Reader looks up addresses in an MMDB file held in memory. It is safe for
+// concurrent use.
+type Reader struct {
+	buf        []byte
+	data       []byte // Data section
+	Metadata   Metadata
+	ipv4Start  uint
+	nodeLength uint
+}
+
+// Open loads an MMDB file
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(buf)
+}
+
+// FromBytes parses an MMDB file already in memory
+func FromBytes(buf []byte) (*Reader, error) {
+	at := bytes.LastIndex(buf, metadataMarker)
+	if at == -1 {
+		return nil, errors.New("geoip: metadata section not found")
+	}
+
+	metaDecoder := decoder{buf: buf[at+len(metadataMarker):]}
+	raw, _, err := metaDecoder.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: invalid metadata: %v", err)
+	}
+	meta, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("geoip: metadata is not a map")
+	}
+
+	r := &Reader{buf: buf}
+	r.Metadata.DatabaseType, _ = meta["database_type"].(string)
+	r.Metadata.IPVersion = int(toUint(meta["ip_version"]))
+	r.Metadata.NodeCount = uint(toUint(meta["node_count"]))
+	r.Metadata.RecordSize = uint(toUint(meta["record_size"]))
+	r.Metadata.BuildEpoch = toUint(meta["build_epoch"])
+
+	switch r.Metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("geoip: unsupported record size %d", r.Metadata.RecordSize)
+	}
+	r.nodeLength = r.Metadata.RecordSize / 4
+
+	treeSize := r.Metadata.NodeCount * r.nodeLength
+	if treeSize+16 > uint(at) {
+		return nil, errors.New("geoip: search tree is larger than the file")
+	}
+	r.data = buf[treeSize+16 : at]
+
+	// IPv4 addresses live under ::/96 in an IPv6 tree
+	if r.Metadata.IPVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.Metadata.NodeCount; i++ {
+			node = r.readNode(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// Lookup returns the decoded record for an address, usually a map
+func (r *Reader) Lookup(ip net.IP) (interface{}, error) {
+	pointer, err := r.lookupPointer(ip)
+	if err != nil {
+		return nil, err
+	}
+	offset := pointer - r.Metadata.NodeCount - 16
+	if offset >= uint(len(r.data)) {
+		return nil, errors.New("geoip: record points outside the data section")
+	}
+	d := decoder{buf: r.data}
+	value, _, err := d.decode(offset)
+	return value, err
+}
+
+// Country returns the ISO 3166-1 alpha-2 country code for an address,
+// falling back to the registered country (e.g. for anycast ranges)
+func (r *Reader) Country(ip net.IP) (string, error) {
+	record, err := r.Lookup(ip)
+	if err != nil {
+		return "", err
+	}
+	fields, _ := record.(map[string]interface{})
+	for _, key := range []string{"country", "registered_country"} {
+		if country, ok := fields[key].(map[string]interface{}); ok {
+			if code, ok := country["iso_code"].(string); ok && code != "" {
+				return code, nil
+			}
+		}
+	}
+	return "", ErrNotFound
+}
+
+func (r *Reader) lookupPointer(ip net.IP) (uint, error) {
+	if ip == nil {
+		return 0, errors.New("geoip: invalid address")
+	}
+
+	bitCount := 128
+	node := uint(0)
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+		bitCount = 32
+		if r.Metadata.IPVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if r.Metadata.IPVersion == 4 {
+		return 0, errors.New("geoip: IPv6 lookup in an IPv4-only database")
+	}
+
+	for i := 0; i < bitCount && node < r.Metadata.NodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-uint(i%8))) & 1
+		node = r.readNode(node, bit)
+	}
+
+	switch {
+	case node == r.Metadata.NodeCount:
+		return 0, ErrNotFound
+	case node > r.Metadata.NodeCount:
+		return node, nil
+	}
+	return 0, errors.New("geoip: invalid search tree")
+}
+
+// readNode returns the left (bit 0) or right (bit 1) record of a node
+func (r *Reader) readNode(node, bit uint) uint {
+	b := r.buf[node*r.nodeLength:]
+	switch r.Metadata.RecordSize {
+	case 24:
+		off := bit * 3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		off := bit * 4
+		return uint(binary.BigEndian.Uint32(b[off:]))
+	}
+}
+
+// --- Data section decoder ---
+
+const (
+	typeExtended = 0
+	typePointer  = 1
+	typeString   = 2
+	typeDouble   = 3
+	typeBytes    = 4
+	typeUint16   = 5
+	typeUint32   = 6
+	typeMap      = 7
+	typeInt32    = 8
+	typeUint64   = 9
+	typeUint128  = 10
+	typeArray    = 11
+	typeBool     = 14
+	typeFloat    = 15
+)
+
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) byteAt(offset uint) (byte, error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, errors.New("unexpected end of data")
+	}
+	return d.buf[offset], nil
+}
+
+func (d *decoder) slice(offset, size uint) ([]byte, error) {
+	if offset+size > uint(len(d.buf)) {
+		return nil, errors.New("unexpected end of data")
+	}
+	return d.buf[offset : offset+size], nil
+}
+
+// decode reads the value at offset and returns it with the offset just past it
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	ctrl, err := d.byteAt(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset++
+
+	kind := uint(ctrl >> 5)
+	if kind == typePointer {
+		target, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(target)
+		return value, next, err
+	}
+	if kind == typeExtended {
+		ext, err := d.byteAt(offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		kind = uint(ext) + 7
+		offset++
+	}
+
+	size := uint(ctrl & 0x1F)
+	if size >= 29 {
+		extra := size - 28
+		b, err := d.slice(offset, extra)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += extra
+		n := uint(0)
+		for _, v := range b {
+			n = n<<8 | uint(v)
+		}
+		switch size {
+		case 29:
+			size = 29 + n
+		case 30:
+			size = 285 + n
+		default:
+			size = 65821 + n
+		}
+	}
+
+	switch kind {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, after, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, _ := key.(string)
+			m[k] = value
+			offset = after
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	b, err := d.slice(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += size
+
+	switch kind {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return append([]byte(nil), b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		n := uint64(0)
+		for _, v := range b {
+			n = n<<8 | uint64(v)
+		}
+		return n, offset, nil
+	case typeInt32:
+		n := int32(0)
+		for _, v := range b {
+			n = n<<8 | int32(v)
+		}
+		return n, offset, nil
+	case typeUint128:
+		// Only needed for some ISP databases; keep the raw bytes
+		return append([]byte(nil), b...), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", kind)
+}
+
+// pointer resolves a pointer's target offset and returns the offset after it
+func (d *decoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	sizeBits := uint(ctrl>>3) & 0x3
+	b, err := d.slice(offset, sizeBits+1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var target uint
+	switch sizeBits {
+	case 0:
+		target = uint(ctrl&0x7)<<8 | uint(b[0])
+	case 1:
+		target = (uint(ctrl&0x7)<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+	case 2:
+		target = (uint(ctrl&0x7)<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+	default:
+		target = uint(binary.BigEndian.Uint32(b))
+	}
+	return target, offset + sizeBits + 1, nil
+}
+
+func toUint(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int32:
+		return uint64(n)
+	}
+	return 0
+}
@@ -0,0 +1,182 @@
+package geoip
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// --- Minimal MMDB writer for tests ---
+
+func ctrl(kind, size int) []byte {
+	return []byte{byte(kind<<5 | size)}
+}
+
+func encString(s string) []byte {
+	return append(ctrl(typeString, len(s)), s...)
+}
+
+func encUint(n uint32) []byte {
+	b := []byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return append(ctrl(typeUint32, len(b)), b...)
+}
+
+func encMap(pairs ...[]byte) []byte {
+	out := ctrl(typeMap, len(pairs)/2)
+	for _, p := range pairs {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func encPointer(offset int) []byte {
+	return []byte{byte(typePointer<<5 | (offset>>8)&0x7), byte(offset)}
+}
+
+type trieNode struct {
+	child [2]*trieNode
+	data  [2]int // data offset + 1 for a terminal record, 0 if none
+}
+
+// insert maps a prefix (as 128-bit IPv6 bits) to a data offset
+func (n *trieNode) insert(ip net.IP, prefix int, dataOffset int) {
+	ip16 := ip.To16()
+	node := n
+	for i := 0; i < prefix; i++ {
+		bit := (ip16[i/8] >> (7 - uint(i%8))) & 1
+		if i == prefix-1 {
+			node.data[bit] = dataOffset + 1
+			return
+		}
+		if node.child[bit] == nil {
+			node.child[bit] = &trieNode{}
+		}
+		node = node.child[bit]
+	}
+}
+
+func buildDB(t *testing.T, entries []struct {
+	cidr   string
+	offset int
+}, data []byte) []byte {
+	t.Helper()
+	root := &trieNode{}
+	for _, e := range entries {
+		ip, network, err := net.ParseCIDR(e.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, bits := network.Mask.Size()
+		if bits == 32 {
+			ones += 96
+			ip = append(make(net.IP, 12), ip.To4()...)
+		}
+		root.insert(ip, ones, e.offset)
+	}
+
+	// Number nodes depth-first
+	var nodes []*trieNode
+	index := map[*trieNode]int{}
+	var walk func(n *trieNode)
+	walk = func(n *trieNode) {
+		index[n] = len(nodes)
+		nodes = append(nodes, n)
+		for _, c := range n.child {
+			if c != nil {
+				walk(c)
+			}
+		}
+	}
+	walk(root)
+
+	nodeCount := len(nodes)
+	var tree bytes.Buffer
+	for _, n := range nodes {
+		for bit := 0; bit < 2; bit++ {
+			record := nodeCount // empty
+			if n.child[bit] != nil {
+				record = index[n.child[bit]]
+			} else if n.data[bit] != 0 {
+				record = nodeCount + 16 + n.data[bit] - 1
+			}
+			tree.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+
+	var out bytes.Buffer
+	out.Write(tree.Bytes())
+	out.Write(make([]byte, 16))
+	out.Write(data)
+	out.Write(metadataMarker)
+	out.Write(encMap(
+		encString("node_count"), encUint(uint32(nodeCount)),
+		encString("record_size"), encUint(24),
+		encString("ip_version"), encUint(6),
+		encString("database_type"), encString("Test-Country"),
+	))
+	return out.Bytes()
+}
+
+func TestCountryLookup(t *testing.T) {
+	gb := encMap(encString("country"), encMap(encString("iso_code"), encString("GB")))
+	countryMapOffset := len(ctrl(typeMap, 1)) + len(encString("country"))
+	in := encMap(encString("country"), encMap(encString("iso_code"), encString("IN")))
+	// Registered country only, reusing GB's country map through a pointer
+	anycast := encMap(encString("registered_country"), encPointer(countryMapOffset))
+
+	var data []byte
+	data = append(data, gb...)
+	inOffset := len(data)
+	data = append(data, in...)
+	anycastOffset := len(data)
+	data = append(data, anycast...)
+
+	db := buildDB(t, []struct {
+		cidr   string
+		offset int
+	}{
+		{"81.2.69.0/24", 0},
+		{"49.36.0.0/14", inOffset},
+		{"2001:db8::/32", inOffset},
+		{"1.1.1.0/24", anycastOffset},
+	}, data)
+
+	r, err := FromBytes(db)
+	if err != nil {
+		t.Fatalf("FromBytes: %v", err)
+	}
+	if r.Metadata.DatabaseType != "Test-Country" || r.Metadata.IPVersion != 6 {
+		t.Fatalf("unexpected metadata: %+v", r.Metadata)
+	}
+
+	cases := map[string]string{
+		"81.2.69.160":      "GB",
+		"49.37.1.1":        "IN",
+		"2001:db8::1":      "IN",
+		"1.1.1.1":          "GB",
+		"8.8.8.8":          "",
+		"2001:db9::1":      "",
+		"::ffff:49.36.0.1": "IN",
+	}
+	for addr, want := range cases {
+		got, err := r.Country(net.ParseIP(addr))
+		if want == "" {
+			if err != ErrNotFound {
+				t.Errorf("Country(%s) = %q, %v; want ErrNotFound", addr, got, err)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("Country(%s) = %q, %v; want %q", addr, got, err, want)
+		}
+	}
+}
+
+func TestRejectsInvalidFile(t *testing.T) {
+	if _, err := FromBytes([]byte("not a database")); err == nil {
+		t.Fatal("expected an error for a file without metadata")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"time"
 
+	"kaivaliyayoga/internal/geo"
 	"kaivaliyayoga/internal/models"
 	"kaivaliyayoga/internal/services"
 	"kaivaliyayoga/pkg/database"
@@ -151,6 +152,11 @@ func ApproveApplication(c *gin.Context) {
 		VerificationStatus: "approved",
 		IsFeatured:         true, // Boost new pros
 	}
+	if g, err := geo.Default(); err == nil {
+		if place, ok := g.Lookup(app.City, app.Country); ok {
+			newProf.Latitude, newProf.Longitude = place.Lat, place.Lng // For nearby search
+		}
+	}
 	if err := tx.Create(&newProf).Error; err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create professional profile"})
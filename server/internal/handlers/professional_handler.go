@@ -2,6 +2,10 @@ package handlers
 
 import (
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"kaivaliyayoga/internal/models"
 	"kaivaliyayoga/internal/services"
@@ -14,16 +18,75 @@ type ProfessionalHandler struct {
 	Service services.ProfessionalService
 }
 
+// Search supports full-text q plus filters: type, language (comma separated,
+// any of), country, city, delivery, min_price/max_price in cents with an
+// optional currency, min_rating and an RFC 3339 available_from/available_to
+// window. sort is one of services.SearchSorts; page and limit paginate.
 func (h *ProfessionalHandler) Search(c *gin.Context) {
-	query := c.Query("q")
-	typeFilter := c.Query("type")
+	params := services.SearchParams{
+		Query:          c.Query("q"),
+		Type:           c.Query("type"),
+		Country:        c.Query("country"),
+		City:           c.Query("city"),
+		DeliveryMethod: c.Query("delivery"),
+		Currency:       c.Query("currency"),
+		Sort:           c.Query("sort"),
+	}
+	for _, lang := range strings.Split(c.Query("language"), ",") {
+		if lang = strings.TrimSpace(lang); lang != "" {
+			params.Languages = append(params.Languages, lang)
+		}
+	}
+
+	var err error
+	ints := map[string]*int{"min_price": &params.MinPriceCents, "max_price": &params.MaxPriceCents, "page": &params.Page, "limit": &params.Limit}
+	for name, dst := range ints {
+		if v := c.Query(name); v != "" {
+			if *dst, err = strconv.Atoi(v); err != nil || *dst < 0 {
+				utils.ErrorResponse(c, http.StatusBadRequest, "INVALID_INPUT", name+" must be a whole number")
+				return
+			}
+		}
+	}
+	if v := c.Query("min_rating"); v != "" {
+		if params.MinRating, err = strconv.ParseFloat(v, 64); err != nil || params.MinRating < 0 || params.MinRating > 5 {
+			utils.ErrorResponse(c, http.StatusBadRequest, "INVALID_INPUT", "min_rating must be between 0 and 5")
+			return
+		}
+	}
+	if params.MaxPriceCents > 0 && params.MinPriceCents > params.MaxPriceCents {
+		utils.ErrorResponse(c, http.StatusBadRequest, "INVALID_INPUT", "min_price is above max_price")
+		return
+	}
+
+	from, to := c.Query("available_from"), c.Query("available_to")
+	if from != "" || to != "" {
+		params.AvailableFrom, err = time.Parse(time.RFC3339, from)
+		if err == nil {
+			params.AvailableTo, err = time.Parse(time.RFC3339, to)
+		}
+		if err != nil || !params.AvailableTo.After(params.AvailableFrom) {
+			utils.ErrorResponse(c, http.StatusBadRequest, "INVALID_INPUT", "available_from and available_to must be RFC 3339 times, from before to")
+			return
+		}
+	}
+
+	if params.Sort != "" && !slices.Contains(services.SearchSorts, params.Sort) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "INVALID_INPUT", "sort must be one of "+strings.Join(services.SearchSorts, ", "))
+		return
+	}
 
-	pros, err := h.Service.Search(query, typeFilter)
+	result, err := h.Service.Search(params)
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "SEARCH_FAILED", err.Error())
 		return
 	}
-	utils.SuccessResponse(c, http.StatusOK, pros)
+	utils.SuccessResponse(c, http.StatusOK, result.Professionals, gin.H{
+		"total":  result.Total,
+		"page":   result.Page,
+		"limit":  result.Limit,
+		"facets": result.Facets,
+	})
 }
 
 func (h *ProfessionalHandler) GetByID(c *gin.Context) {
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"kaivaliyayoga/internal/models"
+	"kaivaliyayoga/internal/services"
+	"kaivaliyayoga/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Self-service routes under /professional. The service scopes every lookup
+// to the caller's own Professional, so another professional's IDs 404.
+
+// selfServiceError maps service errors onto responses
+func selfServiceError(c *gin.Context, err error, code string) {
+	switch {
+	case errors.Is(err, services.ErrNotProfessional):
+		utils.ErrorResponse(c, http.StatusForbidden, "NOT_PROFESSIONAL", "Only professionals can do this")
+	case errors.Is(err, services.ErrNotFound):
+		utils.ErrorResponse(c, http.StatusNotFound, "NOT_FOUND", "Not found")
+	case errors.Is(err, services.ErrInvalidTransition):
+		utils.ErrorResponse(c, http.StatusConflict, "INVALID_STATUS", err.Error())
+	default:
+		utils.ErrorResponse(c, http.StatusBadRequest, code, err.Error())
+	}
+}
+
+// paramID parses the :id route parameter, answering 400 if it isn't a UUID
+func paramID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid ID")
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// --- Services ---
+
+func (h *ProfessionalHandler) ListServices(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	list, err := h.Service.ListServices(userID.(uint))
+	if err != nil {
+		selfServiceError(c, err, "FETCH_FAILED")
+		return
+	}
+	utils.SuccessResponse(c, http.StatusOK, list)
+}
+
+func (h *ProfessionalHandler) CreateService(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	input := models.Service{IsActive: true}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	service, err := h.Service.CreateService(userID.(uint), input)
+	if err != nil {
+		selfServiceError(c, err, "CREATE_FAILED")
+		return
+	}
+	utils.SuccessResponse(c, http.StatusCreated, service)
+}
+
+func (h *ProfessionalHandler) UpdateService(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	input := models.Service{IsActive: true}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	service, err := h.Service.UpdateService(userID.(uint), id, input)
+	if err != nil {
+		selfServiceError(c, err, "UPDATE_FAILED")
+		return
+	}
+	utils.SuccessResponse(c, http.StatusOK, service)
+}
+
+func (h *ProfessionalHandler) DeleteService(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	if err := h.Service.DeleteService(userID.(uint), id); err != nil {
+		selfServiceError(c, err, "DELETE_FAILED")
+		return
+	}
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "Service removed"})
+}
+
+// --- Availability ---
+
+func (h *ProfessionalHandler) ListAvailability(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	list, err := h.Service.ListAvailability(userID.(uint))
+	if err != nil {
+		selfServiceError(c, err, "FETCH_FAILED")
+		return
+	}
+	utils.SuccessResponse(c, http.StatusOK, list)
+}
+
+func (h *ProfessionalHandler) CreateAvailability(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	input := models.ProfessionalAvailability{IsRecurring: true}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	slot, err := h.Service.CreateAvailability(userID.(uint), input)
+	if err != nil {
+		selfServiceError(c, err, "CREATE_FAILED")
+		return
+	}
+	utils.SuccessResponse(c, http.StatusCreated, slot)
+}
+
+func (h *ProfessionalHandler) UpdateAvailability(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	input := models.ProfessionalAvailability{IsRecurring: true}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	slot, err := h.Service.UpdateAvailability(userID.(uint), id, input)
+	if err != nil {
+		selfServiceError(c, err, "UPDATE_FAILED")
+		return
+	}
+	utils.SuccessResponse(c, http.StatusOK, slot)
+}
+
+func (h *ProfessionalHandler) DeleteAvailability(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	if err := h.Service.DeleteAvailability(userID.(uint), id); err != nil {
+		selfServiceError(c, err, "DELETE_FAILED")
+		return
+	}
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "Availability removed"})
+}
+
+// --- Time off ---
+
+func (h *ProfessionalHandler) ListTimeOff(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	list, err := h.Service.ListTimeOff(userID.(uint))
+	if err != nil {
+		selfServiceError(c, err, "FETCH_FAILED")
+		return
+	}
+	utils.SuccessResponse(c, http.StatusOK, list)
+}
+
+// CreateTimeOff also returns the bookings already inside the new time off
+func (h *ProfessionalHandler) CreateTimeOff(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var input models.ProfessionalTimeOff
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	timeOff, clashes, err := h.Service.CreateTimeOff(userID.(uint), input)
+	if err != nil {
+		selfServiceError(c, err, "CREATE_FAILED")
+		return
+	}
+	utils.SuccessResponse(c, http.StatusCreated, gin.H{"time_off": timeOff, "conflicting_appointments": clashes})
+}
+
+func (h *ProfessionalHandler) DeleteTimeOff(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	if err := h.Service.DeleteTimeOff(userID.(uint), id); err != nil {
+		selfServiceError(c, err, "DELETE_FAILED")
+		return
+	}
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "Time off removed"})
+}
+
+// --- Appointments ---
+
+// ListAppointments takes status (comma separated), RFC 3339 from and to,
+// page and limit
+func (h *ProfessionalHandler) ListAppointments(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	filter := services.AppointmentFilter{Status: c.Query("status")}
+	filter.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	filter.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "20"))
+	for name, dst := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				utils.ErrorResponse(c, http.StatusBadRequest, "INVALID_TIME", "Invalid "+name+" format, use RFC3339")
+				return
+			}
+			*dst = t
+		}
+	}
+
+	list, total, err := h.Service.ListAppointments(userID.(uint), filter)
+	if err != nil {
+		selfServiceError(c, err, "FETCH_FAILED")
+		return
+	}
+	utils.SuccessResponse(c, http.StatusOK, list, gin.H{"total": total, "page": filter.Page, "limit": filter.Limit})
+}
+
+func (h *ProfessionalHandler) GetAppointment(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	appointment, err := h.Service.GetAppointment(userID.(uint), id)
+	if err != nil {
+		selfServiceError(c, err, "FETCH_FAILED")
+		return
+	}
+	utils.SuccessResponse(c, http.StatusOK, appointment)
+}
+
+// AppointmentAction returns a handler for confirm, decline, complete or
+// no_show. Decline takes an optional {"reason"}.
+func (h *ProfessionalHandler) AppointmentAction(action services.AppointmentAction) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := c.Get("userID")
+		id, ok := paramID(c)
+		if !ok {
+			return
+		}
+		var input struct {
+			Reason string `json:"reason"`
+		}
+		c.ShouldBindJSON(&input)
+
+		appointment, err := h.Service.UpdateAppointmentStatus(userID.(uint), id, action, input.Reason)
+		if err != nil {
+			selfServiceError(c, err, "UPDATE_FAILED")
+			return
+		}
+		utils.SuccessResponse(c, http.StatusOK, appointment)
+	}
+}
+
+func (h *ProfessionalHandler) UpdateAppointmentNotes(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Notes string `json:"notes" binding:"max=5000"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	appointment, err := h.Service.UpdateAppointmentNotes(userID.(uint), id, input.Notes)
+	if err != nil {
+		selfServiceError(c, err, "UPDATE_FAILED")
+		return
+	}
+	utils.SuccessResponse(c, http.StatusOK, appointment)
+}
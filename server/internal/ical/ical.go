@@ -0,0 +1,178 @@
+// Package ical writes iCalendar (RFC 5545) data for email invitations
+// (iTIP, RFC 5546) and subscribable calendar feeds, and reads busy times
+// from external calendars.
+//
+// Times are always written in UTC, so no VTIMEZONE components are needed.
+// When reading, TZID parameters are resolved with the IANA database.
+package ical
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// iTIP methods
+const (
+	MethodPublish = "PUBLISH" // Feeds
+	MethodRequest = "REQUEST" // New or updated invitation
+	MethodCancel  = "CANCEL"
+)
+
+// Event statuses
+const (
+	StatusConfirmed = "CONFIRMED"
+	StatusTentative = "TENTATIVE"
+	StatusCancelled = "CANCELLED"
+)
+
+// Person is an organizer or attendee
+type Person struct {
+	Name  string
+	Email string
+}
+
+// Event is one VEVENT. Updates to an event keep its UID and raise Sequence.
+type Event struct {
+	UID         string
+	Sequence    int
+	Stamp       time.Time // DTSTAMP; defaults to now
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+	Status      string
+	Organizer   *Person
+	Attendees   []Person
+}
+
+// Calendar is a VCALENDAR with its events
+type Calendar struct {
+	ProdID  string // Defaults to -//Kaivaliya Yoga//Bookings//EN
+	Method  string
+	Name    string        // Shown by calendar apps for subscribed feeds
+	Refresh time.Duration // Suggested polling interval for feeds
+	Events  []Event
+}
+
+// ContentType is the MIME type for the calendar, including its method
+func (c Calendar) ContentType() string {
+	if c.Method == "" {
+		return "text/calendar; charset=utf-8"
+	}
+	return "text/calendar; charset=utf-8; method=" + c.Method
+}
+
+// Bytes renders the calendar with CRLF line endings and folded lines
+func (c Calendar) Bytes() []byte {
+	w := &writer{}
+	w.line("BEGIN:VCALENDAR")
+	w.line("VERSION:2.0")
+	prodID := c.ProdID
+	if prodID == "" {
+		prodID = "-//Kaivaliya Yoga//Bookings//EN"
+	}
+	w.prop("PRODID", prodID)
+	w.line("CALSCALE:GREGORIAN")
+	if c.Method != "" {
+		w.prop("METHOD", c.Method)
+	}
+	if c.Name != "" {
+		w.text("X-WR-CALNAME", c.Name)
+	}
+	if c.Refresh > 0 {
+		duration := fmt.Sprintf("PT%dM", int(c.Refresh/time.Minute))
+		w.line("REFRESH-INTERVAL;VALUE=DURATION:" + duration)
+		w.line("X-PUBLISHED-TTL:" + duration)
+	}
+
+	for _, e := range c.Events {
+		stamp := e.Stamp
+		if stamp.IsZero() {
+			stamp = time.Now()
+		}
+		w.line("BEGIN:VEVENT")
+		w.text("UID", e.UID)
+		w.line(fmt.Sprintf("SEQUENCE:%d", e.Sequence))
+		w.prop("DTSTAMP", formatTime(stamp))
+		w.prop("DTSTART", formatTime(e.Start))
+		if !e.End.IsZero() {
+			w.prop("DTEND", formatTime(e.End))
+		}
+		w.text("SUMMARY", e.Summary)
+		if e.Description != "" {
+			w.text("DESCRIPTION", e.Description)
+		}
+		if e.Location != "" {
+			w.text("LOCATION", e.Location)
+		}
+		if e.URL != "" {
+			w.prop("URL;VALUE=URI", e.URL)
+		}
+		if e.Status != "" {
+			w.prop("STATUS", e.Status)
+		}
+		if e.Organizer != nil {
+			w.prop("ORGANIZER"+nameParam(e.Organizer.Name), "mailto:"+e.Organizer.Email)
+		}
+		for _, a := range e.Attendees {
+			partstat := "ACCEPTED"
+			if e.Status == StatusCancelled {
+				partstat = "DECLINED"
+			}
+			w.prop("ATTENDEE;ROLE=REQ-PARTICIPANT;PARTSTAT="+partstat+nameParam(a.Name), "mailto:"+a.Email)
+		}
+		w.line("END:VEVENT")
+	}
+	w.line("END:VCALENDAR")
+	return []byte(w.String())
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// nameParam is a CN parameter, quoted as names may contain commas
+func nameParam(name string) string {
+	name = strings.NewReplacer(`"`, "'", "\r", "", "\n", " ").Replace(name)
+	if name == "" {
+		return ""
+	}
+	return `;CN="` + name + `"`
+}
+
+// EscapeText escapes a TEXT value (RFC 5545 section 3.3.11)
+func EscapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(s)
+}
+
+type writer struct {
+	strings.Builder
+}
+
+func (w *writer) text(name, value string) {
+	w.prop(name, EscapeText(value))
+}
+
+func (w *writer) prop(name, value string) {
+	w.line(name + ":" + value)
+}
+
+// line writes a content line, folding it at 75 octets without splitting a
+// UTF-8 sequence
+func (w *writer) line(s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+		limit = 74 // Continuation lines start with a space
+	}
+	w.WriteString(s + "\r\n")
+}
@@ -0,0 +1,69 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendarBytes(t *testing.T) {
+	sydney, _ := time.LoadLocation("Australia/Sydney")
+	start := time.Date(2026, 10, 20, 7, 0, 0, 0, sydney)
+	cal := Calendar{
+		Method: MethodRequest,
+		Events: []Event{{
+			UID:         "booking-42@kaivaliyayoga.com",
+			Sequence:    1,
+			Stamp:       time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+			Start:       start,
+			End:         start.Add(time.Hour),
+			Summary:     "Hatha Flow, beginners; bring a mat",
+			Description: "Line one\nLine two \\ done",
+			Status:      StatusConfirmed,
+			Organizer:   &Person{Name: "Kaivaliya Yoga", Email: "studio@example.com"},
+			Attendees:   []Person{{Name: "Asha, R", Email: "a@x.io"}},
+		}},
+	}
+	out := string(cal.Bytes())
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\n",
+		"METHOD:REQUEST\r\n",
+		"UID:booking-42@kaivaliyayoga.com\r\n",
+		"SEQUENCE:1\r\n",
+		"DTSTART:20261019T200000Z\r\n", // 7am AEDT is 8pm UTC the day before
+		"DTEND:20261019T210000Z\r\n",
+		`SUMMARY:Hatha Flow\, beginners\; bring a mat` + "\r\n",
+		`DESCRIPTION:Line one\nLine two \\ done` + "\r\n",
+		"ORGANIZER;CN=\"Kaivaliya Yoga\":mailto:studio@example.com\r\n",
+		"ATTENDEE;ROLE=REQ-PARTICIPANT;PARTSTAT=ACCEPTED;CN=\"Asha, R\":mailto:a@x.io\r\n",
+		"END:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("calendar is missing %q:\n%s", want, out)
+		}
+	}
+	if cal.ContentType() != "text/calendar; charset=utf-8; method=REQUEST" {
+		t.Errorf("ContentType() = %q", cal.ContentType())
+	}
+}
+
+func TestLineFolding(t *testing.T) {
+	summary := strings.Repeat("योग ", 40) // Multi-byte runes must not be split
+	out := string(Calendar{Events: []Event{{UID: "x", Summary: summary, Start: time.Now()}}}.Bytes())
+
+	var unfolded strings.Builder
+	for i, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line %d is %d octets: %q", i, len(line), line)
+		}
+		if strings.HasPrefix(line, " ") {
+			unfolded.WriteString(line[1:])
+		} else {
+			unfolded.WriteString("\n" + line)
+		}
+	}
+	if !strings.Contains(unfolded.String(), "\nSUMMARY:"+summary+"\n") {
+		t.Errorf("summary did not survive folding:\n%s", unfolded.String())
+	}
+}
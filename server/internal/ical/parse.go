@@ -0,0 +1,452 @@
+package ical
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParsedEvent is a VEVENT read from someone else's calendar, with just what
+// is needed to work out when they are busy
+type ParsedEvent struct {
+	UID          string
+	RecurrenceID time.Time // Set when the event overrides one occurrence of a recurring event
+	Start        time.Time
+	End          time.Time
+	AllDay       bool
+	Summary      string
+	Status       string
+	Transparent  bool // TRANSP:TRANSPARENT events don't take up time
+	RRule        string
+	ExDates      []time.Time
+}
+
+// Period is a span of busy time
+type Period struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Bounds on expanding a recurring event
+const (
+	maxOccurrences = 5000
+	maxPeriods     = 100000 // e.g. a rule whose BYMONTHDAY never matches
+)
+
+// Parse reads the events from an iCalendar object. Times without a zone,
+// including all-day dates, are taken to be in floating.
+func Parse(data []byte, floating *time.Location) ([]ParsedEvent, error) {
+	var events []ParsedEvent
+	var current *ParsedEvent
+	var duration string
+	depth := 0 // Nesting inside the VEVENT, e.g. VALARM
+
+	for _, line := range unfold(data) {
+		name, params, value, ok := splitLine(line)
+		if !ok {
+			continue
+		}
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			current, duration, depth = &ParsedEvent{}, "", 0
+			continue
+		case current == nil:
+			continue
+		case name == "BEGIN":
+			depth++
+			continue
+		case name == "END" && depth > 0:
+			depth--
+			continue
+		case depth > 0:
+			continue
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			if current.Start.IsZero() {
+				return nil, fmt.Errorf("ical: event %q has no DTSTART", current.UID)
+			}
+			if current.End.IsZero() {
+				switch {
+				case duration != "":
+					d, err := parseDuration(duration)
+					if err != nil {
+						return nil, err
+					}
+					current.End = current.Start.Add(d)
+				case current.AllDay:
+					current.End = current.Start.AddDate(0, 0, 1)
+				default:
+					current.End = current.Start
+				}
+			}
+			events = append(events, *current)
+			current = nil
+			continue
+		}
+
+		var err error
+		switch name {
+		case "UID":
+			current.UID = value
+		case "SUMMARY":
+			current.Summary = unescapeText(value)
+		case "STATUS":
+			current.Status = strings.ToUpper(value)
+		case "TRANSP":
+			current.Transparent = strings.EqualFold(value, "TRANSPARENT")
+		case "RRULE":
+			current.RRule = value
+		case "DURATION":
+			duration = value
+		case "DTSTART":
+			current.Start, current.AllDay, err = parseTime(value, params, floating)
+		case "DTEND":
+			current.End, _, err = parseTime(value, params, floating)
+		case "RECURRENCE-ID":
+			current.RecurrenceID, _, err = parseTime(value, params, floating)
+		case "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				var t time.Time
+				if t, _, err = parseTime(v, params, floating); err != nil {
+					break
+				}
+				current.ExDates = append(current.ExDates, t)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ical: event %q: %s: %w", current.UID, name, err)
+		}
+	}
+	return events, nil
+}
+
+// Busy lists when the events take up time between from and until, expanding
+// recurring events and applying their exceptions and overridden occurrences.
+// Free (transparent) and cancelled events are left out.
+func Busy(events []ParsedEvent, from, until time.Time) []Period {
+	overridden := map[string]bool{}
+	for _, e := range events {
+		if !e.RecurrenceID.IsZero() {
+			overridden[e.UID+"|"+e.RecurrenceID.UTC().Format(time.RFC3339)] = true
+		}
+	}
+
+	var periods []Period
+	for _, e := range events {
+		if e.Transparent || e.Status == StatusCancelled || !e.End.After(e.Start) {
+			continue
+		}
+		length := e.End.Sub(e.Start)
+		for _, start := range e.Occurrences(until) {
+			if e.RecurrenceID.IsZero() && overridden[e.UID+"|"+start.UTC().Format(time.RFC3339)] {
+				continue
+			}
+			if end := start.Add(length); end.After(from) && start.Before(until) {
+				periods = append(periods, Period{Start: start, End: end})
+			}
+		}
+	}
+	sort.Slice(periods, func(i, j int) bool { return periods[i].Start.Before(periods[j].Start) })
+	return periods
+}
+
+// Occurrences lists the start times of the event up to until. Rules support
+// FREQ, INTERVAL, COUNT, UNTIL, BYDAY and BYMONTHDAY; other rule parts are
+// ignored.
+func (e ParsedEvent) Occurrences(until time.Time) []time.Time {
+	if e.RRule == "" {
+		return []time.Time{e.Start}
+	}
+	rule, err := parseRule(e.RRule, e.Start.Location())
+	if err != nil {
+		return []time.Time{e.Start}
+	}
+	if !rule.until.IsZero() && rule.until.Before(until) {
+		until = rule.until.Add(time.Second) // UNTIL is inclusive
+	}
+	excluded := map[int64]bool{}
+	for _, t := range e.ExDates {
+		excluded[t.Unix()] = true
+	}
+
+	var starts []time.Time
+	count := 0
+	for period := 0; count < maxOccurrences && period < maxPeriods; period++ {
+		candidates := rule.candidates(e.Start, period)
+		if candidates == nil {
+			break
+		}
+		for _, t := range candidates {
+			if t.Before(e.Start) {
+				continue
+			}
+			if !t.Before(until) || (rule.count > 0 && count >= rule.count) {
+				return starts
+			}
+			count++
+			if !excluded[t.Unix()] {
+				starts = append(starts, t)
+			}
+		}
+	}
+	return starts
+}
+
+type weekdayNum struct {
+	n   int // 0 for every such weekday, or the nth (negative from the end) in the month
+	day time.Weekday
+}
+
+type rule struct {
+	freq       string
+	interval   int
+	count      int
+	until      time.Time
+	byDay      []weekdayNum
+	byMonthDay []int
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+func parseRule(s string, loc *time.Location) (rule, error) {
+	r := rule{interval: 1}
+	for _, part := range strings.Split(s, ";") {
+		key, value, _ := strings.Cut(part, "=")
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.freq = strings.ToUpper(value)
+		case "INTERVAL":
+			r.interval, err = strconv.Atoi(value)
+		case "COUNT":
+			r.count, err = strconv.Atoi(value)
+		case "UNTIL":
+			r.until, _, err = parseTime(value, nil, loc)
+		case "BYDAY":
+			for _, d := range strings.Split(value, ",") {
+				if len(d) < 2 {
+					return r, fmt.Errorf("bad BYDAY %q", d)
+				}
+				day, ok := weekdays[strings.ToUpper(d[len(d)-2:])]
+				if !ok {
+					return r, fmt.Errorf("bad BYDAY %q", d)
+				}
+				n := 0
+				if prefix := d[:len(d)-2]; prefix != "" {
+					if n, err = strconv.Atoi(prefix); err != nil {
+						return r, err
+					}
+				}
+				r.byDay = append(r.byDay, weekdayNum{n, day})
+			}
+		case "BYMONTHDAY":
+			for _, d := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(d)
+				if err != nil {
+					return r, err
+				}
+				r.byMonthDay = append(r.byMonthDay, n)
+			}
+		}
+		if err != nil {
+			return r, fmt.Errorf("bad %s: %w", key, err)
+		}
+	}
+	if r.interval < 1 {
+		r.interval = 1
+	}
+	switch r.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+		return r, nil
+	}
+	return r, fmt.Errorf("unsupported FREQ %q", r.freq)
+}
+
+// candidates are the possible starts in the nth period after start, in
+// order, at start's time of day. Nil means there are no more periods.
+func (r rule) candidates(start time.Time, n int) []time.Time {
+	y, m, d := start.Date()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	}
+	step := n * r.interval
+
+	switch r.freq {
+	case "DAILY":
+		return []time.Time{at(y, m, d+step)}
+	case "WEEKLY":
+		if len(r.byDay) == 0 {
+			return []time.Time{at(y, m, d+7*step)}
+		}
+		monday := d - (int(start.Weekday())+6)%7 + 7*step // Weeks start on Monday
+		var out []time.Time
+		for _, wd := range r.byDay {
+			out = append(out, at(y, m, monday+(int(wd.day)+6)%7))
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Before(out[j]) })
+		return out
+	case "MONTHLY":
+		first := time.Date(y, m+time.Month(step), 1, 0, 0, 0, 0, start.Location())
+		days := monthDays(r, first, d)
+		out := make([]time.Time, 0, len(days))
+		for _, day := range days {
+			out = append(out, at(first.Year(), first.Month(), day))
+		}
+		if out == nil {
+			out = []time.Time{} // A month with no matching day, e.g. the 31st in April
+		}
+		return out
+	case "YEARLY":
+		t := at(y+step, m, d)
+		if t.Day() != d {
+			return []time.Time{} // 29 February in a non-leap year
+		}
+		return []time.Time{t}
+	}
+	return nil
+}
+
+// monthDays are the days of first's month that match a monthly rule
+func monthDays(r rule, first time.Time, startDay int) []int {
+	last := first.AddDate(0, 1, -1).Day()
+	var days []int
+	for _, n := range r.byMonthDay {
+		if n < 0 {
+			n = last + 1 + n
+		}
+		if n >= 1 && n <= last {
+			days = append(days, n)
+		}
+	}
+	for _, wd := range r.byDay {
+		offset := (int(wd.day) - int(first.Weekday()) + 7) % 7
+		var matches []int
+		for day := 1 + offset; day <= last; day += 7 {
+			matches = append(matches, day)
+		}
+		switch {
+		case wd.n == 0:
+			days = append(days, matches...)
+		case wd.n > 0 && wd.n <= len(matches):
+			days = append(days, matches[wd.n-1])
+		case wd.n < 0 && -wd.n <= len(matches):
+			days = append(days, matches[len(matches)+wd.n])
+		}
+	}
+	if len(r.byMonthDay) == 0 && len(r.byDay) == 0 && startDay <= last {
+		days = append(days, startDay)
+	}
+	sort.Ints(days)
+	return days
+}
+
+// parseTime reads a DATE-TIME or DATE value, honouring TZID and VALUE=DATE
+func parseTime(value string, params map[string]string, floating *time.Location) (time.Time, bool, error) {
+	if floating == nil {
+		floating = time.UTC
+	}
+	value = strings.TrimSpace(value)
+	if strings.EqualFold(params["VALUE"], "DATE") || len(value) == 8 {
+		t, err := time.ParseInLocation("20060102", value, floating)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	loc := floating
+	if tzid := strings.TrimPrefix(params["TZID"], "/"); tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+// parseDuration reads a DURATION value such as PT1H30M or P1D
+func parseDuration(s string) (time.Duration, error) {
+	orig := s
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign, s = -1, s[1:]
+	}
+	s = strings.TrimPrefix(s, "+")
+	if !strings.HasPrefix(s, "P") {
+		return 0, fmt.Errorf("ical: bad duration %q", orig)
+	}
+	var total time.Duration
+	inTime := false
+	num := ""
+	for _, r := range s[1:] {
+		switch {
+		case r >= '0' && r <= '9':
+			num += string(r)
+			continue
+		case r == 'T':
+			inTime = true
+			continue
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return 0, fmt.Errorf("ical: bad duration %q", orig)
+		}
+		unit := map[rune]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour}
+		if inTime {
+			unit = map[rune]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+		}
+		if unit[r] == 0 {
+			return 0, fmt.Errorf("ical: bad duration %q", orig)
+		}
+		total += time.Duration(n) * unit[r]
+		num = ""
+	}
+	return sign * total, nil
+}
+
+// unfold joins folded content lines, accepting LF as well as CRLF
+func unfold(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// splitLine splits a content line into its upper-cased name, its parameters
+// and its value. Colons inside quoted parameter values don't end the name.
+func splitLine(line string) (string, map[string]string, string, bool) {
+	quoted := false
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ':' && !quoted:
+			parts := strings.Split(line[:i], ";")
+			params := map[string]string{}
+			for _, p := range parts[1:] {
+				k, v, _ := strings.Cut(p, "=")
+				params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+			}
+			return strings.ToUpper(parts[0]), params, line[i+1:], true
+		}
+	}
+	return "", nil, "", false
+}
+
+func unescapeText(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
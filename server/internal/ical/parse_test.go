@@ -0,0 +1,100 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBusy(t *testing.T) {
+	london, _ := time.LoadLocation("Europe/London")
+	data := strings.ReplaceAll(`BEGIN:VCALENDAR
+VERSION:2.0
+BEGIN:VEVENT
+UID:standup
+DTSTART;TZID=Europe/London:20261019T090000
+DTEND;TZID=Europe/London:20261019T093000
+RRULE:FREQ=WEEKLY;BYDAY=MO,WE;COUNT=5
+EXDATE;TZID=Europe/London:20261021T090000
+SUMMARY:Stand-up\, team
+BEGIN:VALARM
+TRIGGER:-PT10M
+END:VALARM
+END:VEVENT
+BEGIN:VEVENT
+UID:standup
+RECURRENCE-ID;TZID=Europe/London:20261026T090000
+DTSTART;TZID=Europe/London:20261026T140000
+DURATION:PT1H
+END:VEVENT
+BEGIN:VEVENT
+UID:holiday
+DTSTART;VALUE=DATE:20261030
+SUMMARY:Day off
+END:VEVENT
+BEGIN:VEVENT
+UID:focus
+DTSTART:20261020T100000Z
+DTEND:20261020T120000Z
+TRANSP:TRANSPARENT
+END:VEVENT
+BEGIN:VEVENT
+UID:cancelled
+DTSTART:20261020T130000Z
+DTEND:20261020T140000Z
+STATUS:CANCELLED
+END:VEVENT
+END:VCALENDAR
+`, "\n", "\r\n")
+
+	events, err := Parse([]byte(data), london)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 5 || events[0].Summary != "Stand-up, team" {
+		t.Fatalf("parsed %+v", events)
+	}
+
+	at := func(day, hour, min int) time.Time { return time.Date(2026, 10, day, hour, min, 0, 0, london) }
+	want := []Period{
+		{at(19, 9, 0), at(19, 9, 30)},
+		// 21st is excluded
+		{at(26, 14, 0), at(26, 15, 0)}, // Moved, and London is on GMT by now
+		{at(28, 9, 0), at(28, 9, 30)},
+		{at(30, 0, 0), at(31, 0, 0)}, // All day
+		{time.Date(2026, 11, 2, 9, 0, 0, 0, london), time.Date(2026, 11, 2, 9, 30, 0, 0, london)},
+	}
+	got := Busy(events, at(1, 0, 0), time.Date(2026, 12, 1, 0, 0, 0, 0, london))
+	if len(got) != len(want) {
+		t.Fatalf("busy = %v, want %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Start.Equal(want[i].Start) || !got[i].End.Equal(want[i].End) {
+			t.Errorf("period %d = %v to %v, want %v to %v", i, got[i].Start, got[i].End, want[i].Start, want[i].End)
+		}
+	}
+}
+
+func TestOccurrences(t *testing.T) {
+	start := time.Date(2026, 1, 31, 18, 0, 0, 0, time.UTC)
+	until := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		rule string
+		want []string
+	}{
+		{"FREQ=DAILY;INTERVAL=2;UNTIL=20260204T180000Z", []string{"01-31", "02-02", "02-04"}},
+		{"FREQ=MONTHLY;COUNT=4", []string{"01-31", "03-31", "05-31", "07-31"}}, // Skips months without a 31st
+		{"FREQ=MONTHLY;BYDAY=-1SA;COUNT=3", []string{"01-31", "02-28", "03-28"}},
+		{"FREQ=MONTHLY;BYMONTHDAY=1,15;COUNT=3", []string{"02-01", "02-15", "03-01"}},
+		{"FREQ=YEARLY", []string{"01-31"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, o := range (ParsedEvent{Start: start, RRule: tt.rule}).Occurrences(until) {
+			got = append(got, o.Format("01-02"))
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: got %v, want %v", tt.rule, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Package mailer delivers HTML emails with a plain-text alternative. The
+// outbox worker depends on the Mailer interface so tests can point it at a
+// fake SMTP server.
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"gopkg.in/gomail.v2"
+)
+
+// Message is a single HTML email
+type Message struct {
+	From        string // Defaults to the mailer's configured sender
+	To          string
+	Subject     string
+	HTML        string
+	Text        string            // Plain-text alternative; generated from HTML when empty
+	Headers     map[string]string // Extra headers such as List-Unsubscribe
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to a message, such as a calendar invitation
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"` // Defaults to a type guessed from Filename
+	Data        []byte `json:"data"`
+}
+
+// Mailer sends one message, returning an error if the server did not accept it
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTPConfig holds the settings for an SMTP relay
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPMailer sends through an SMTP relay, opening a connection per message
+type SMTPMailer struct {
+	config SMTPConfig
+	dialer *gomail.Dialer
+}
+
+// NewSMTP returns a Mailer for the relay in config
+func NewSMTP(config SMTPConfig) *SMTPMailer {
+	if config.From == "" {
+		config.From = config.Username
+	}
+	// App passwords are often pasted with spaces
+	config.Password = strings.ReplaceAll(config.Password, " ", "")
+	return &SMTPMailer{
+		config: config,
+		dialer: gomail.NewDialer(config.Host, config.Port, config.Username, config.Password),
+	}
+}
+
+// Send delivers msg
+func (m *SMTPMailer) Send(msg Message) error {
+	if msg.To == "" {
+		return errors.New("mailer: message has no recipient")
+	}
+	from := msg.From
+	if from == "" {
+		from = m.config.From
+	}
+
+	gm := gomail.NewMessage()
+	gm.SetHeader("From", from)
+	gm.SetHeader("To", msg.To)
+	gm.SetHeader("Subject", msg.Subject)
+	for name, value := range msg.Headers {
+		gm.SetHeader(name, value)
+	}
+	text := msg.Text
+	if text == "" {
+		text = HTMLToText(msg.HTML)
+	}
+	gm.SetBody("text/plain", text)
+	gm.AddAlternative("text/html", msg.HTML)
+	for _, a := range msg.Attachments {
+		settings := []gomail.FileSetting{gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write(a.Data)
+			return err
+		})}
+		if a.ContentType != "" {
+			settings = append(settings, gomail.SetHeader(map[string][]string{"Content-Type": {a.ContentType}}))
+		}
+		gm.Attach(a.Filename, settings...)
+	}
+	if err := m.dialer.DialAndSend(gm); err != nil {
+		if recipientRejected(err.Error()) {
+			return &RecipientRejectedError{Recipient: msg.To, Reason: err.Error()}
+		}
+		return err
+	}
+	return nil
+}
+
+// RecipientRejectedError means the server permanently refused the address
+// (a hard bounce), so retrying will not help
+type RecipientRejectedError struct {
+	Recipient string
+	Reason    string
+}
+
+func (e *RecipientRejectedError) Error() string {
+	return fmt.Sprintf("mailer: %s rejected: %s", e.Recipient, e.Reason)
+}
+
+// IsRecipientRejected reports whether err is a hard bounce for the recipient
+func IsRecipientRejected(err error) bool {
+	var rejected *RecipientRejectedError
+	return errors.As(err, &rejected)
+}
+
+// An SMTP reply code with an optional enhanced status code, e.g. "550 5.1.1"
+var reSMTPReply = regexp.MustCompile(`\b([2-5]\d\d)[ -]"?(?:([2-5])\.(\d{1,3})\.(\d{1,3})\b)?`)
+
+// recipientRejected decides from the server's reply whether the address
+// itself is bad. Enhanced codes 5.1.x (bad mailbox or domain) and 5.2.1
+// (mailbox disabled) count; policy and spam rejections (5.7.x) do not, as they
+// say nothing about the address.
+func recipientRejected(reply string) bool {
+	m := reSMTPReply.FindStringSubmatch(reply)
+	if m == nil || m[1][0] != '5' {
+		return false
+	}
+	if m[2] != "" {
+		return m[2] == "5" && (m[3] == "1" || (m[3] == "2" && m[4] == "1"))
+	}
+	// No enhanced code: only the replies that mean "no such user"
+	return m[1] == "550" || m[1] == "551" || m[1] == "553"
+}
@@ -0,0 +1,194 @@
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSMTP is a minimal SMTP server that records what it is sent and rejects
+// recipients at reject.example
+type fakeSMTP struct {
+	listener net.Listener
+	mu       sync.Mutex
+	received []string // Raw DATA of each accepted message
+	rcpts    []string
+}
+
+func startFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{listener: l}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 fake.smtp ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250-fake.smtp")
+			reply("250 8BITMIME")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			if strings.Contains(cmd, "@REJECT.EXAMPLE") {
+				reply("550 5.1.1 mailbox unavailable")
+				continue
+			}
+			s.mu.Lock()
+			s.rcpts = append(s.rcpts, strings.TrimSpace(line[len("RCPT TO:"):]))
+			s.mu.Unlock()
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 end with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.mu.Lock()
+			s.received = append(s.received, data.String())
+			s.mu.Unlock()
+			reply("250 OK queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default: // MAIL FROM, RSET, NOOP
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	server := startFakeSMTP(t)
+	m := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: server.port(), From: "studio@example.com"})
+
+	err := m.Send(Message{
+		To:      "member@example.com",
+		Subject: "Booking Confirmed",
+		HTML:    "<p>See you on the mat</p>",
+		Headers: map[string]string{"List-Unsubscribe": "<https://example.com/u>"},
+		Attachments: []Attachment{{
+			Filename:    "invite.ics",
+			ContentType: "text/calendar; charset=utf-8; method=REQUEST",
+			Data:        []byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"),
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.received) != 1 {
+		t.Fatalf("server received %d messages, want 1", len(server.received))
+	}
+	if len(server.rcpts) != 1 || server.rcpts[0] != "<member@example.com>" {
+		t.Errorf("recipients = %v", server.rcpts)
+	}
+	data := server.received[0]
+	for _, want := range []string{"From: studio@example.com", "Subject: Booking Confirmed", "List-Unsubscribe: <https://example.com/u>", "multipart/alternative", "text/plain", "text/html", "See you on the mat",
+		"Content-Type: text/calendar; charset=utf-8; method=REQUEST", `filename="invite.ics"`} {
+		if !strings.Contains(data, want) {
+			t.Errorf("message is missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestSMTPMailerRejectedRecipient(t *testing.T) {
+	server := startFakeSMTP(t)
+	m := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: server.port(), From: "studio@example.com"})
+
+	err := m.Send(Message{To: "nobody@reject.example", Subject: "Hi", HTML: "<p>Hi</p>"})
+	if err == nil {
+		t.Fatal("expected an error for a rejected recipient")
+	}
+	if !IsRecipientRejected(err) {
+		t.Errorf("IsRecipientRejected(%v) = false, want true", err)
+	}
+	if err := m.Send(Message{Subject: "Hi", HTML: "<p>Hi</p>"}); err == nil {
+		t.Fatal("expected an error for a message without a recipient")
+	}
+}
+
+func TestRecipientRejected(t *testing.T) {
+	cases := map[string]bool{
+		"gomail: could not send email 1: 550 5.1.1 mailbox unavailable":      true,
+		"gomail: could not send email 1: 550 5.2.1 mailbox disabled":         true,
+		"gomail: could not send email 1: 553 sorry, no such user":            true,
+		"gomail: could not send email 1: 550 5.7.1 message rejected as spam": false,
+		"gomail: could not send email 1: 452 4.2.2 mailbox full":             false,
+		"535 5.7.8 authentication failed":                                    false,
+		"dial tcp 127.0.0.1:25: connect: connection refused":                 false,
+	}
+	for reply, want := range cases {
+		if got := recipientRejected(reply); got != want {
+			t.Errorf("recipientRejected(%q) = %v, want %v", reply, got, want)
+		}
+	}
+}
+
+func TestSMTPMailerConnectionRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	m := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: port})
+	if err := m.Send(Message{To: "member@example.com", Subject: "Hi", HTML: "<p>Hi</p>"}); err == nil {
+		t.Fatal("expected an error when the relay is down")
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	body := `<style>p { color: red; }</style>
+	<div>
+		<h2>Booking Confirmed! &#x2705;</h2>
+		<p>Hi <strong>Asha</strong>,</p>
+		<p>Your spot is
+		reserved &amp; paid.</p>
+		<table><tr><th>Order ID</th><td>ord_1</td></tr><tr><th>Amount</th><td>20.00 AUD</td></tr></table>
+		<a href="https://example.com/profile?a=1&amp;b=2">Go to Profile</a>
+	</div>`
+
+	want := "Booking Confirmed! \u2705\n\nHi Asha,\n\nYour spot is reserved & paid.\n\n" +
+		"Order ID ord_1\nAmount 20.00 AUD\n\nGo to Profile (https://example.com/profile?a=1&b=2)\n"
+	if got := HTMLToText(body); got != want {
+		t.Errorf("HTMLToText() =\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	reHidden    = regexp.MustCompile(`(?is)<(head|style|script|title)[^>]*>.*?</(head|style|script|title)>`)
+	reLink      = regexp.MustCompile(`(?is)<a\s[^>]*href\s*=\s*["']([^"']*)["'][^>]*>(.*?)</a>`)
+	reBlock     = regexp.MustCompile(`(?i)</(p|div|h[1-6]|table|ul|ol|blockquote)>`)
+	reLineBreak = regexp.MustCompile(`(?i)<br\s*/?>|</(tr|li)>`)
+	reListItem  = regexp.MustCompile(`(?i)<li[^>]*>`)
+	reCell      = regexp.MustCompile(`(?i)</t[hd]>`)
+	reTag       = regexp.MustCompile(`(?s)<[^>]*>`)
+	reSpaces    = regexp.MustCompile(`[ \t\r\f\v]+`)
+	reBlankRuns = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLToText renders an HTML email body as readable plain text. Block
+// elements become paragraphs, list items get a dash and links keep their
+// target after the link text.
+func HTMLToText(body string) string {
+	text := reHidden.ReplaceAllString(body, "")
+	text = reLink.ReplaceAllStringFunc(text, func(a string) string {
+		m := reLink.FindStringSubmatch(a)
+		href, label := html.UnescapeString(m[1]), strings.TrimSpace(reTag.ReplaceAllString(m[2], ""))
+		if label == "" || html.UnescapeString(label) == href || strings.HasPrefix(href, "#") {
+			return label
+		}
+		return label + " (" + href + ")"
+	})
+	text = strings.NewReplacer("\r\n", "\n", "\n", " ").Replace(text) // Source line breaks are not meaningful
+	text = reBlock.ReplaceAllString(text, "\n\n")
+	text = reLineBreak.ReplaceAllString(text, "\n")
+	text = reListItem.ReplaceAllString(text, "- ")
+	text = reCell.ReplaceAllString(text, " ")
+	text = reTag.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(reSpaces.ReplaceAllString(line, " "))
+	}
+	text = reBlankRuns.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text) + "\n"
+}
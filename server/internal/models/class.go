@@ -25,6 +25,14 @@ type Class struct {
 	LocationType   string     `json:"location_type" gorm:"default:'online'"` // online, in-person, hybrid
 	MeetingURL     string     `json:"meeting_url"`
 
+	// Where in-person classes meet. Coordinates are geocoded from City when
+	// not given, falling back to the studio's.
+	Address   string  `json:"address"`
+	City      string  `json:"city"`
+	Country   string  `json:"country"`
+	Latitude  float64 `json:"latitude" gorm:"index:idx_classes_location"`
+	Longitude float64 `json:"longitude" gorm:"index:idx_classes_location"`
+
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
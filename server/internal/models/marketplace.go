@@ -50,6 +50,8 @@ type Appointment struct {
 	Timezone  string    `json:"timezone"`
 
 	Status string `json:"status"` // pending, confirmed, completed, cancelled, no_show
+	// Set when the professional declines; the main server refunds the client and tells them
+	DeclinedAt *time.Time `json:"declined_at" gorm:"index"`
 
 	// Payment
 	PriceChargedCents         int    `json:"price_charged_cents"`
@@ -0,0 +1,185 @@
+// Package notify holds the delivery providers for notification channels other
+// than email (which goes through the email outbox). Each channel has a real
+// provider and a Fake used when the provider is not configured.
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Channels
+const (
+	ChannelEmail    = "email"
+	ChannelSMS      = "sms"
+	ChannelWhatsApp = "whatsapp"
+	ChannelPush     = "push"
+)
+
+// Message is one notification for one recipient
+type Message struct {
+	To    string // Phone number in E.164 form, or a push subscription endpoint
+	Title string
+	Body  string
+	URL   string
+
+	// Web push subscription keys, passed through to the push relay
+	P256dh string
+	Auth   string
+}
+
+// Provider delivers messages on one channel
+type Provider interface {
+	Name() string
+	Send(msg Message) error
+}
+
+// --- Fake ---
+
+// Fake logs and records messages instead of sending them
+type Fake struct {
+	Channel string
+
+	mu   sync.Mutex
+	sent []Message
+}
+
+// NewFake returns a fake provider for a channel
+func NewFake(channel string) *Fake {
+	return &Fake{Channel: channel}
+}
+
+func (f *Fake) Name() string { return "fake_" + f.Channel }
+
+func (f *Fake) Send(msg Message) error {
+	f.mu.Lock()
+	f.sent = append(f.sent, msg)
+	f.mu.Unlock()
+	log.Printf("INFO: [%s] to %s: %s - %s\n", f.Channel, msg.To, msg.Title, msg.Body)
+	return nil
+}
+
+// Sent returns the messages sent so far
+func (f *Fake) Sent() []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Message(nil), f.sent...)
+}
+
+// --- Twilio (SMS and WhatsApp) ---
+
+// Twilio sends SMS or WhatsApp messages through Twilio's Messages API
+type Twilio struct {
+	AccountSID string
+	AuthToken  string
+	From       string // Sender number, without the whatsapp: prefix
+	WhatsApp   bool
+	BaseURL    string // Defaults to https://api.twilio.com
+	Client     *http.Client
+}
+
+func (t *Twilio) Name() string {
+	if t.WhatsApp {
+		return "twilio_whatsapp"
+	}
+	return "twilio_sms"
+}
+
+func (t *Twilio) Send(msg Message) error {
+	if msg.To == "" {
+		return fmt.Errorf("%s: message has no recipient", t.Name())
+	}
+	to, from := msg.To, t.From
+	if t.WhatsApp {
+		to, from = "whatsapp:"+to, "whatsapp:"+from
+	}
+	body := msg.Body
+	if msg.Title != "" {
+		body = msg.Title + ": " + body
+	}
+	if msg.URL != "" {
+		body += " " + msg.URL
+	}
+
+	form := url.Values{"To": {to}, "From": {from}, "Body": {body}}
+	base := t.BaseURL
+	if base == "" {
+		base = "https://api.twilio.com"
+	}
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", strings.TrimRight(base, "/"), t.AccountSID)
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(t.AccountSID, t.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doRequest(t.Client, req, t.Name())
+}
+
+// --- Web push relay ---
+
+// PushWebhook hands web push notifications to a push relay service, which
+// handles VAPID signing and payload encryption. Requests are signed with an
+// HMAC-SHA256 of the body in X-Signature when Secret is set.
+type PushWebhook struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+func (p *PushWebhook) Name() string { return "push_webhook" }
+
+func (p *PushWebhook) Send(msg Message) error {
+	if msg.To == "" {
+		return fmt.Errorf("%s: message has no subscription endpoint", p.Name())
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"subscription": map[string]interface{}{
+			"endpoint": msg.To,
+			"keys":     map[string]string{"p256dh": msg.P256dh, "auth": msg.Auth},
+		},
+		"notification": map[string]string{"title": msg.Title, "body": msg.Body, "url": msg.URL},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(p.Secret))
+		mac.Write(payload)
+		req.Header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+	return doRequest(p.Client, req, p.Name())
+}
+
+func doRequest(client *http.Client, req *http.Request, name string) error {
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: status %d: %s", name, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTwilioWhatsApp(t *testing.T) {
+	var form map[string]string
+	var user, pass string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		user, pass, _ = r.BasicAuth()
+		r.ParseForm()
+		form = map[string]string{"To": r.Form.Get("To"), "From": r.Form.Get("From"), "Body": r.Form.Get("Body")}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	p := &Twilio{AccountSID: "AC123", AuthToken: "secret", From: "+61400000000", WhatsApp: true, BaseURL: server.URL}
+	if err := p.Send(Message{To: "+61411111111", Title: "Booking confirmed", Body: "Hatha Flow, Mon 07:00"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if user != "AC123" || pass != "secret" {
+		t.Errorf("basic auth = %q/%q", user, pass)
+	}
+	if form["To"] != "whatsapp:+61411111111" || form["From"] != "whatsapp:+61400000000" {
+		t.Errorf("addresses = %v", form)
+	}
+	if form["Body"] != "Booking confirmed: Hatha Flow, Mon 07:00" {
+		t.Errorf("body = %q", form["Body"])
+	}
+}
+
+func TestTwilioError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"invalid To number"}`, http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	p := &Twilio{AccountSID: "AC123", From: "+61400000000", BaseURL: server.URL}
+	if err := p.Send(Message{To: "nonsense", Body: "hi"}); err == nil {
+		t.Fatal("expected an error for a rejected message")
+	}
+	if err := p.Send(Message{Body: "hi"}); err == nil {
+		t.Fatal("expected an error without a recipient")
+	}
+}
+
+func TestPushWebhookSignsPayload(t *testing.T) {
+	var body []byte
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get("X-Signature")
+	}))
+	defer server.Close()
+
+	p := &PushWebhook{URL: server.URL, Secret: "relay-secret"}
+	err := p.Send(Message{To: "https://push.example/abc", P256dh: "key", Auth: "auth", Title: "Reminder", Body: "Class at 7"})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("relay-secret"))
+	mac.Write(body)
+	if signature != hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("signature does not match body")
+	}
+	var payload struct {
+		Subscription struct {
+			Endpoint string            `json:"endpoint"`
+			Keys     map[string]string `json:"keys"`
+		} `json:"subscription"`
+		Notification map[string]string `json:"notification"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Subscription.Endpoint != "https://push.example/abc" || payload.Subscription.Keys["p256dh"] != "key" {
+		t.Errorf("subscription = %+v", payload.Subscription)
+	}
+	if payload.Notification["title"] != "Reminder" {
+		t.Errorf("notification = %v", payload.Notification)
+	}
+}
+
+func TestFakeRecords(t *testing.T) {
+	f := NewFake(ChannelSMS)
+	f.Send(Message{To: "+61411111111", Body: "one"})
+	f.Send(Message{To: "+61411111111", Body: "two"})
+	if sent := f.Sent(); len(sent) != 2 || sent[1].Body != "two" {
+		t.Fatalf("Sent() = %+v", sent)
+	}
+}
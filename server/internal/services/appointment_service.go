@@ -18,9 +18,15 @@ func (s *AppointmentService) CreateAppointment(userID uint, input models.Appoint
 	if err := database.DB.First(&service, "id = ?", input.ServiceID).Error; err != nil {
 		return nil, errors.New("service not found")
 	}
+	if !service.IsActive {
+		return nil, errors.New("this service is not currently offered")
+	}
 
 	// 2. Check Availability (Simplified for now)
 	// TODO: Check against ProfessionalAvailability table
+	if OnTimeOff(service.ProfessionalID, input.StartTime, input.EndTime) {
+		return nil, errors.New("the professional is away at that time")
+	}
 
 	// 3. Create Booking
 	input.ClientID = userID
@@ -108,6 +114,9 @@ func (s *AppointmentService) Reschedule(appointmentID uuid.UUID, userID uint, ne
 	if appointment.Status == "cancelled" || appointment.Status == "completed" {
 		return errors.New("cannot reschedule a cancelled or completed appointment")
 	}
+	if OnTimeOff(appointment.ProfessionalID, newStartTime, newEndTime) {
+		return errors.New("the professional is away at that time")
+	}
 
 	// Update times
 	appointment.StartTime = newStartTime
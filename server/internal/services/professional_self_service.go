@@ -0,0 +1,428 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"kaivaliyayoga/internal/models"
+	"kaivaliyayoga/pkg/database"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Self-service for professionals: their services, weekly availability,
+// time off and the appointments booked with them. Every method takes the
+// caller's user ID and only ever touches that user's own Professional.
+
+var (
+	ErrNotProfessional = errors.New("professional profile not found")
+	ErrNotFound        = errors.New("not found")
+	// ErrInvalidTransition is returned for a status change the appointment's
+	// current status doesn't allow, e.g. confirming a cancelled appointment
+	ErrInvalidTransition = errors.New("appointment status does not allow this")
+)
+
+// AppointmentAction is something a professional does to a booking
+type AppointmentAction string
+
+const (
+	ActionConfirm  AppointmentAction = "confirm"
+	ActionDecline  AppointmentAction = "decline"
+	ActionComplete AppointmentAction = "complete"
+	ActionNoShow   AppointmentAction = "no_show"
+)
+
+// appointmentTransitions maps each action to the statuses it may start from
+// and the status it leaves. Rescheduled bookings need confirming again.
+var appointmentTransitions = map[AppointmentAction]struct {
+	from []string
+	to   string
+}{
+	ActionConfirm:  {from: []string{"pending", "rescheduled"}, to: "confirmed"},
+	ActionDecline:  {from: []string{"pending", "rescheduled"}, to: "cancelled"},
+	ActionComplete: {from: []string{"confirmed"}, to: "completed"},
+	ActionNoShow:   {from: []string{"confirmed"}, to: "no_show"},
+}
+
+// AppointmentFilter narrows a professional's appointment list
+type AppointmentFilter struct {
+	Status string
+	From   time.Time
+	To     time.Time
+	Page   int
+	Limit  int
+}
+
+// ownProfessional is the caller's Professional record
+func ownProfessional(userID uint) (*models.Professional, error) {
+	var pro models.Professional
+	if err := database.DB.Where("user_id = ?", userID).First(&pro).Error; err != nil {
+		return nil, ErrNotProfessional
+	}
+	return &pro, nil
+}
+
+// notFound turns a missing row into ErrNotFound, so other users' records
+// look the same as ones that don't exist
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// --- Services ---
+
+func validateService(s models.Service) error {
+	switch {
+	case strings.TrimSpace(s.Name) == "":
+		return errors.New("name is required")
+	case s.DurationMinutes <= 0:
+		return errors.New("duration_minutes must be positive")
+	case s.PriceCents < 0:
+		return errors.New("price_cents cannot be negative")
+	case len(s.Currency) != 3:
+		return errors.New("currency must be a 3-letter code")
+	case s.DeliveryMethod != "online" && s.DeliveryMethod != "in-person":
+		return errors.New("delivery_method must be online or in-person")
+	}
+	return nil
+}
+
+func (s *ProfessionalService) ListServices(userID uint) ([]models.Service, error) {
+	pro, err := ownProfessional(userID)
+	if err != nil {
+		return nil, err
+	}
+	var list []models.Service
+	err = database.DB.Where("professional_id = ?", pro.ID).Order("created_at").Find(&list).Error
+	return list, err
+}
+
+func (s *ProfessionalService) CreateService(userID uint, input models.Service) (*models.Service, error) {
+	pro, err := ownProfessional(userID)
+	if err != nil {
+		return nil, err
+	}
+	input.Currency = strings.ToUpper(input.Currency)
+	if err := validateService(input); err != nil {
+		return nil, err
+	}
+	input.ID = uuid.New()
+	input.ProfessionalID = pro.ID
+	active := input.IsActive
+	if err := database.DB.Create(&input).Error; err != nil {
+		return nil, err
+	}
+	if !active {
+		// IsActive defaults to true, so GORM skipped the false and read the
+		// default back
+		database.DB.Model(&input).Update("is_active", false)
+	}
+	return &input, nil
+}
+
+func (s *ProfessionalService) UpdateService(userID uint, id uuid.UUID, input models.Service) (*models.Service, error) {
+	pro, err := ownProfessional(userID)
+	if err != nil {
+		return nil, err
+	}
+	var service models.Service
+	if err := database.DB.Where("id = ? AND professional_id = ?", id, pro.ID).First(&service).Error; err != nil {
+		return nil, notFound(err)
+	}
+
+	service.Name = input.Name
+	service.Description = input.Description
+	service.DurationMinutes = input.DurationMinutes
+	service.PriceCents = input.PriceCents
+	service.Currency = strings.ToUpper(input.Currency)
+	service.ServiceType = input.ServiceType
+	service.DeliveryMethod = input.DeliveryMethod
+	service.IsActive = input.IsActive
+	if err := validateService(service); err != nil {
+		return nil, err
+	}
+	// Booked appointments keep the price they were charged
+	if err := database.DB.Save(&service).Error; err != nil {
+		return nil, err
+	}
+	return &service, nil
+}
+
+// DeleteService soft-deletes, so past appointments still show what was booked
+func (s *ProfessionalService) DeleteService(userID uint, id uuid.UUID) error {
+	pro, err := ownProfessional(userID)
+	if err != nil {
+		return err
+	}
+	res := database.DB.Where("id = ? AND professional_id = ?", id, pro.ID).Delete(&models.Service{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// --- Availability ---
+
+func validateAvailability(a models.ProfessionalAvailability) error {
+	start, err1 := time.Parse("15:04", a.StartTime)
+	end, err2 := time.Parse("15:04", a.EndTime)
+	switch {
+	case err1 != nil || err2 != nil:
+		return errors.New("start_time and end_time must be HH:MM")
+	case !end.After(start):
+		return errors.New("end_time must be after start_time")
+	case a.IsRecurring && (a.DayOfWeek < 0 || a.DayOfWeek > 6):
+		return errors.New("day_of_week must be 0 (Sunday) to 6")
+	case !a.IsRecurring && a.SpecificDate.IsZero():
+		return errors.New("specific_date is required when is_recurring is false")
+	}
+	if a.Timezone != "" {
+		if _, err := time.LoadLocation(a.Timezone); err != nil {
+			return errors.New("unknown timezone")
+		}
+	}
+	return nil
+}
+
+func (s *ProfessionalService) ListAvailability(userID uint) ([]models.ProfessionalAvailability, error) {
+	pro, err := ownProfessional(userID)
+	if err != nil {
+		return nil, err
+	}
+	var list []models.ProfessionalAvailability
+	err = database.DB.Where("professional_id = ?", pro.ID).
+		Order("is_recurring desc, day_of_week, specific_date, start_time").Find(&list).Error
+	return list, err
+}
+
+func (s *ProfessionalService) CreateAvailability(userID uint, input models.ProfessionalAvailability) (*models.ProfessionalAvailability, error) {
+	pro, err := ownProfessional(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateAvailability(input); err != nil {
+		return nil, err
+	}
+	input.ID = uuid.New()
+	input.ProfessionalID = pro.ID
+	recurring := input.IsRecurring
+	if err := database.DB.Create(&input).Error; err != nil {
+		return nil, err
+	}
+	if !recurring {
+		// IsRecurring defaults to true, so GORM skipped the false
+		database.DB.Model(&input).Update("is_recurring", false)
+	}
+	return &input, nil
+}
+
+func (s *ProfessionalService) UpdateAvailability(userID uint, id uuid.UUID, input models.ProfessionalAvailability) (*models.ProfessionalAvailability, error) {
+	pro, err := ownProfessional(userID)
+	if err != nil {
+		return nil, err
+	}
+	var slot models.ProfessionalAvailability
+	if err := database.DB.Where("id = ? AND professional_id = ?", id, pro.ID).First(&slot).Error; err != nil {
+		return nil, notFound(err)
+	}
+
+	slot.DayOfWeek = input.DayOfWeek
+	slot.StartTime = input.StartTime
+	slot.EndTime = input.EndTime
+	slot.Timezone = input.Timezone
+	slot.IsRecurring = input.IsRecurring
+	slot.SpecificDate = input.SpecificDate
+	if err := validateAvailability(slot); err != nil {
+		return nil, err
+	}
+	if err := database.DB.Save(&slot).Error; err != nil {
+		return nil, err
+	}
+	return &slot, nil
+}
+
+func (s *ProfessionalService) DeleteAvailability(userID uint, id uuid.UUID) error {
+	pro, err := ownProfessional(userID)
+	if err != nil {
+		return err
+	}
+	res := database.DB.Where("id = ? AND professional_id = ?", id, pro.ID).Delete(&models.ProfessionalAvailability{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// --- Time off ---
+
+// ListTimeOff returns time off that hasn't ended yet
+func (s *ProfessionalService) ListTimeOff(userID uint) ([]models.ProfessionalTimeOff, error) {
+	pro, err := ownProfessional(userID)
+	if err != nil {
+		return nil, err
+	}
+	var list []models.ProfessionalTimeOff
+	err = database.DB.Where("professional_id = ? AND end_time > ?", pro.ID, time.Now()).
+		Order("start_time").Find(&list).Error
+	return list, err
+}
+
+// CreateTimeOff blocks out a period. Appointments already booked inside it
+// are left alone; they are returned so the professional can decline them.
+func (s *ProfessionalService) CreateTimeOff(userID uint, input models.ProfessionalTimeOff) (*models.ProfessionalTimeOff, []models.Appointment, error) {
+	pro, err := ownProfessional(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !input.EndTime.After(input.StartTime) {
+		return nil, nil, errors.New("end_time must be after start_time")
+	}
+	input.ID = uuid.New()
+	input.ProfessionalID = pro.ID
+	input.Reason = strings.TrimSpace(input.Reason)
+	if err := database.DB.Create(&input).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var clashes []models.Appointment
+	database.DB.Preload("Service").Preload("Client").
+		Where("professional_id = ? AND status IN ? AND start_time < ? AND end_time > ?",
+			pro.ID, []string{"pending", "rescheduled", "confirmed"}, input.EndTime, input.StartTime).
+		Order("start_time").Find(&clashes)
+	return &input, clashes, nil
+}
+
+func (s *ProfessionalService) DeleteTimeOff(userID uint, id uuid.UUID) error {
+	pro, err := ownProfessional(userID)
+	if err != nil {
+		return err
+	}
+	res := database.DB.Where("id = ? AND professional_id = ?", id, pro.ID).Delete(&models.ProfessionalTimeOff{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// OnTimeOff reports whether start to end overlaps the professional's time off
+func OnTimeOff(professionalID uuid.UUID, start, end time.Time) bool {
+	var count int64
+	database.DB.Model(&models.ProfessionalTimeOff{}).
+		Where("professional_id = ? AND start_time < ? AND end_time > ?", professionalID, end, start).
+		Count(&count)
+	return count > 0
+}
+
+// --- Appointments ---
+
+// ListAppointments returns bookings with the caller, soonest first, and the
+// total matching filter
+func (s *ProfessionalService) ListAppointments(userID uint, filter AppointmentFilter) ([]models.Appointment, int64, error) {
+	pro, err := ownProfessional(userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	query := database.DB.Model(&models.Appointment{}).Where("professional_id = ?", pro.ID)
+	if filter.Status != "" {
+		query = query.Where("status IN ?", strings.Split(filter.Status, ","))
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("end_time > ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("start_time < ?", filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.Limit < 1 || filter.Limit > 100 {
+		filter.Limit = 20
+	}
+	var list []models.Appointment
+	err = query.Preload("Service").Preload("Client").Order("start_time").
+		Limit(filter.Limit).Offset((filter.Page - 1) * filter.Limit).Find(&list).Error
+	return list, total, err
+}
+
+func (s *ProfessionalService) GetAppointment(userID uint, id uuid.UUID) (*models.Appointment, error) {
+	pro, err := ownProfessional(userID)
+	if err != nil {
+		return nil, err
+	}
+	var appointment models.Appointment
+	if err := database.DB.Preload("Service").Preload("Client").
+		Where("id = ? AND professional_id = ?", id, pro.ID).First(&appointment).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &appointment, nil
+}
+
+// UpdateAppointmentStatus confirms, declines, completes or marks a no-show.
+// A declined appointment records reason as its cancellation reason and is
+// stamped declined_at, which the main server's SettleDeclinedAppointments job
+// picks up to refund any payment and tell the client.
+// Completing and no-shows only make sense once the appointment has started.
+func (s *ProfessionalService) UpdateAppointmentStatus(userID uint, id uuid.UUID, action AppointmentAction, reason string) (*models.Appointment, error) {
+	transition, ok := appointmentTransitions[action]
+	if !ok {
+		return nil, errors.New("unknown action")
+	}
+	appointment, err := s.GetAppointment(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if (action == ActionComplete || action == ActionNoShow) && time.Now().Before(appointment.StartTime) {
+		return nil, errors.New("the appointment hasn't started yet")
+	}
+
+	updates := map[string]interface{}{"status": transition.to}
+	if action == ActionDecline {
+		updates["declined_at"] = time.Now()
+		updates["cancellation_reason"] = "Declined by professional"
+		if reason = strings.TrimSpace(reason); reason != "" {
+			updates["cancellation_reason"] = "Declined by professional: " + reason
+		}
+	}
+	// Conditional on the current status, so a client cancelling at the same
+	// moment can't be overwritten
+	res := database.DB.Model(&models.Appointment{}).
+		Where("id = ? AND status IN ?", appointment.ID, transition.from).
+		Updates(updates)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrInvalidTransition
+	}
+	return s.GetAppointment(userID, id)
+}
+
+// UpdateAppointmentNotes sets the professional's private notes on a booking
+func (s *ProfessionalService) UpdateAppointmentNotes(userID uint, id uuid.UUID, notes string) (*models.Appointment, error) {
+	appointment, err := s.GetAppointment(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := database.DB.Model(appointment).Update("professional_notes", notes).Error; err != nil {
+		return nil, err
+	}
+	return appointment, nil
+}
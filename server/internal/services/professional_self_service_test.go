@@ -0,0 +1,155 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"kaivaliyayoga/internal/models"
+	"kaivaliyayoga/pkg/database"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedProfessional creates a user with a professional profile and one service
+func seedProfessional(t *testing.T, name string) (uint, models.Professional, models.Service) {
+	user := models.User{Name: name, Email: name + "@example.com", UserType: "professional"}
+	require.NoError(t, database.DB.Create(&user).Error)
+	pro := models.Professional{ID: uuid.New(), UserID: user.ID, Slug: name, VerificationStatus: "approved"}
+	require.NoError(t, database.DB.Create(&pro).Error)
+	service := models.Service{ID: uuid.New(), ProfessionalID: pro.ID, Name: "Consultation", DurationMinutes: 60,
+		PriceCents: 8000, Currency: "AUD", DeliveryMethod: "online", IsActive: true}
+	require.NoError(t, database.DB.Create(&service).Error)
+	return user.ID, pro, service
+}
+
+func TestProfessionalService_OwnRecordsOnly(t *testing.T) {
+	setupMarketplaceDB(t)
+	service := &ProfessionalService{}
+	asha, _, ashaService := seedProfessional(t, "asha")
+	ben, _, _ := seedProfessional(t, "ben")
+
+	client := models.User{Name: "Client", Email: "client@example.com"}
+	require.NoError(t, database.DB.Create(&client).Error)
+
+	t.Run("Clients are not professionals", func(t *testing.T) {
+		_, err := service.ListServices(client.ID)
+		assert.ErrorIs(t, err, ErrNotProfessional)
+	})
+
+	t.Run("Services", func(t *testing.T) {
+		created, err := service.CreateService(asha, models.Service{Name: "Group class", DurationMinutes: 45,
+			PriceCents: 2500, Currency: "aud", DeliveryMethod: "in-person", IsActive: false})
+		require.NoError(t, err)
+		assert.Equal(t, "AUD", created.Currency)
+
+		list, err := service.ListServices(asha)
+		require.NoError(t, err)
+		require.Len(t, list, 2)
+		assert.False(t, list[1].IsActive) // Written despite the column default
+
+		_, err = service.CreateService(asha, models.Service{Name: "Free", DurationMinutes: 30, Currency: "AUD", DeliveryMethod: "carrier pigeon"})
+		assert.EqualError(t, err, "delivery_method must be online or in-person")
+
+		_, err = service.UpdateService(ben, ashaService.ID, models.Service{Name: "Mine now", DurationMinutes: 60, Currency: "AUD", DeliveryMethod: "online"})
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, service.DeleteService(ben, ashaService.ID), ErrNotFound)
+		assert.NoError(t, service.DeleteService(asha, created.ID))
+	})
+
+	t.Run("Availability", func(t *testing.T) {
+		slot, err := service.CreateAvailability(asha, models.ProfessionalAvailability{DayOfWeek: 1, StartTime: "09:00", EndTime: "12:00", IsRecurring: true, Timezone: "Australia/Sydney"})
+		require.NoError(t, err)
+
+		_, err = service.CreateAvailability(asha, models.ProfessionalAvailability{DayOfWeek: 1, StartTime: "12:00", EndTime: "09:00", IsRecurring: true})
+		assert.Error(t, err)
+		_, err = service.CreateAvailability(asha, models.ProfessionalAvailability{StartTime: "09:00", EndTime: "10:00"})
+		assert.EqualError(t, err, "specific_date is required when is_recurring is false")
+		_, err = service.CreateAvailability(asha, models.ProfessionalAvailability{StartTime: "09:00", EndTime: "10:00",
+			SpecificDate: time.Date(2026, 12, 24, 0, 0, 0, 0, time.UTC)})
+		require.NoError(t, err)
+		list, _ := service.ListAvailability(asha)
+		require.Len(t, list, 2)
+		assert.False(t, list[1].IsRecurring)
+
+		_, err = service.UpdateAvailability(ben, slot.ID, models.ProfessionalAvailability{DayOfWeek: 2, StartTime: "09:00", EndTime: "10:00", IsRecurring: true})
+		assert.ErrorIs(t, err, ErrNotFound)
+		list, _ = service.ListAvailability(ben)
+		assert.Empty(t, list)
+	})
+
+	t.Run("Time off blocks booking and reports clashes", func(t *testing.T) {
+		start := time.Now().Add(48 * time.Hour).Truncate(time.Hour)
+		booked := models.Appointment{ServiceID: ashaService.ID, StartTime: start.Add(2 * time.Hour), EndTime: start.Add(3 * time.Hour)}
+		appointment, err := (&AppointmentService{}).CreateAppointment(client.ID, booked)
+		require.NoError(t, err)
+
+		_, clashes, err := service.CreateTimeOff(asha, models.ProfessionalTimeOff{StartTime: start, EndTime: start.Add(24 * time.Hour), Reason: "Retreat"})
+		require.NoError(t, err)
+		require.Len(t, clashes, 1)
+		assert.Equal(t, appointment.ID, clashes[0].ID)
+
+		booked.StartTime, booked.EndTime = start.Add(4*time.Hour), start.Add(5*time.Hour)
+		_, err = (&AppointmentService{}).CreateAppointment(client.ID, booked)
+		assert.EqualError(t, err, "the professional is away at that time")
+	})
+}
+
+func TestProfessionalService_AppointmentStatus(t *testing.T) {
+	setupMarketplaceDB(t)
+	service := &ProfessionalService{}
+	asha, pro, ashaService := seedProfessional(t, "asha")
+	ben, _, _ := seedProfessional(t, "ben")
+	client := models.User{Name: "Client", Email: "client@example.com"}
+	require.NoError(t, database.DB.Create(&client).Error)
+
+	book := func(start time.Time) models.Appointment {
+		a := models.Appointment{ID: uuid.New(), ReferenceCode: uuid.NewString()[:8], ClientID: client.ID,
+			ProfessionalID: pro.ID, ServiceID: ashaService.ID, StartTime: start, EndTime: start.Add(time.Hour), Status: "pending"}
+		require.NoError(t, database.DB.Create(&a).Error)
+		return a
+	}
+	upcoming := book(time.Now().Add(24 * time.Hour))
+	past := book(time.Now().Add(-2 * time.Hour))
+	declined := book(time.Now().Add(48 * time.Hour))
+
+	_, err := service.UpdateAppointmentStatus(ben, upcoming.ID, ActionConfirm, "")
+	assert.ErrorIs(t, err, ErrNotFound, "another professional's booking")
+
+	// Can't complete before confirming, or before it starts
+	_, err = service.UpdateAppointmentStatus(asha, past.ID, ActionComplete, "")
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+	_, err = service.UpdateAppointmentStatus(asha, upcoming.ID, ActionConfirm, "")
+	require.NoError(t, err)
+	_, err = service.UpdateAppointmentStatus(asha, upcoming.ID, ActionComplete, "")
+	assert.EqualError(t, err, "the appointment hasn't started yet")
+
+	_, err = service.UpdateAppointmentStatus(asha, past.ID, ActionConfirm, "")
+	require.NoError(t, err)
+	updated, err := service.UpdateAppointmentStatus(asha, past.ID, ActionNoShow, "")
+	require.NoError(t, err)
+	assert.Equal(t, "no_show", updated.Status)
+	assert.Nil(t, updated.DeclinedAt)
+
+	updated, err = service.UpdateAppointmentStatus(asha, declined.ID, ActionDecline, "Fully booked")
+	require.NoError(t, err)
+	assert.Equal(t, "cancelled", updated.Status)
+	assert.Equal(t, "Declined by professional: Fully booked", updated.CancellationReason)
+	assert.NotNil(t, updated.DeclinedAt, "queued for the main server to refund and notify the client")
+	_, err = service.UpdateAppointmentStatus(asha, declined.ID, ActionConfirm, "")
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	updated, err = service.UpdateAppointmentNotes(asha, upcoming.ID, "Lower back; avoid deep twists")
+	require.NoError(t, err)
+	assert.Equal(t, "Lower back; avoid deep twists", updated.ProfessionalNotes)
+	_, err = service.UpdateAppointmentNotes(ben, upcoming.ID, "Not mine")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	list, total, err := service.ListAppointments(asha, AppointmentFilter{Status: "confirmed,no_show"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Equal(t, past.ID, list[0].ID) // Soonest first
+	_, total, _ = service.ListAppointments(ben, AppointmentFilter{})
+	assert.Zero(t, total)
+}
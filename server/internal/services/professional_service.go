@@ -8,23 +8,6 @@ import (
 
 type ProfessionalService struct{}
 
-func (s *ProfessionalService) Search(query string, typeFilter string) ([]models.Professional, error) {
-	var pros []models.Professional
-	db := database.DB.Preload("User").Preload("Services").Where("verification_status = ?", "approved") // Only approved
-
-	if query != "" {
-		db = db.Where("title ILIKE ? OR bio ILIKE ?", "%"+query+"%", "%"+query+"%")
-	}
-	if typeFilter != "" {
-		db = db.Where("professional_type = ?", typeFilter)
-	}
-
-	if err := db.Find(&pros).Error; err != nil {
-		return nil, err
-	}
-	return pros, nil
-}
-
 func (s *ProfessionalService) GetByID(id string) (*models.Professional, error) {
 	var pro models.Professional
 	if err := database.DB.Preload("User").Preload("Services").First(&pro, "id = ?", id).Error; err != nil {
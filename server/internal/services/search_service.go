@@ -0,0 +1,380 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"kaivaliyayoga/internal/models"
+	"kaivaliyayoga/pkg/database"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Professional search: full-text ranking on Postgres (a tsvector expression
+// index) and SQLite (an FTS5 table kept in step by triggers), plus filters,
+// facet counts, sorting and pagination that work on either.
+
+// SearchParams are the filters, sort and page for a professional search.
+// Zero values mean "any".
+type SearchParams struct {
+	Query          string
+	Type           string
+	Languages      []string // Speaks any of these
+	Country        string
+	City           string
+	DeliveryMethod string // online, in-person
+	MinPriceCents  int
+	MaxPriceCents  int
+	Currency       string // Restricts the price range to services in this currency
+	MinRating      float64
+	AvailableFrom  time.Time // Has a schedule that overlaps this window
+	AvailableTo    time.Time
+	Sort           string // relevance, rating, price_asc, price_desc, experience, newest
+	Page           int
+	Limit          int
+}
+
+// FacetCount is how many matching professionals have a value
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// SearchFacets count each filter's values across the other filters, so
+// choosing one type still shows how many professionals the others have
+type SearchFacets struct {
+	Types           []FacetCount `json:"types"`
+	Languages       []FacetCount `json:"languages"`
+	Countries       []FacetCount `json:"countries"`
+	Cities          []FacetCount `json:"cities"`
+	DeliveryMethods []FacetCount `json:"delivery_methods"`
+	Ratings         []FacetCount `json:"ratings"` // Professionals rated at least Value
+}
+
+type SearchResult struct {
+	Professionals []models.Professional `json:"professionals"`
+	Total         int64                 `json:"total"`
+	Page          int                   `json:"page"`
+	Limit         int                   `json:"limit"`
+	Facets        SearchFacets          `json:"facets"`
+}
+
+var SearchSorts = []string{"relevance", "rating", "price_asc", "price_desc", "experience", "newest"}
+
+// Facet dimensions, so each facet can leave its own filter out
+const (
+	facetNone     = ""
+	facetType     = "type"
+	facetLanguage = "language"
+	facetCountry  = "country"
+	facetCity     = "city"
+	facetDelivery = "delivery"
+	facetRating   = "rating"
+)
+
+var ratingBuckets = []float64{4.5, 4, 3}
+
+const maxSearchTerms = 8
+
+// The Postgres search document; titles outrank places, which outrank bios.
+// It must match the expression index in SetupSearch for the index to be used.
+const pgSearchDocument = `setweight(to_tsvector('simple', coalesce(professionals.title, '')), 'A') || ` +
+	`setweight(to_tsvector('simple', coalesce(professionals.city, '') || ' ' || coalesce(professionals.country, '') || ' ' || ` +
+	`replace(coalesce(professionals.professional_type, ''), '_', ' ')), 'B') || ` +
+	`setweight(to_tsvector('simple', coalesce(professionals.bio, '')), 'C')`
+
+// SetupSearch creates the full-text index for the database in use. It is
+// idempotent, so it runs on every start after migrations.
+func SetupSearch(db *gorm.DB) error {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return db.Exec(`CREATE INDEX IF NOT EXISTS idx_professionals_search ON professionals USING GIN ((` +
+			strings.ReplaceAll(pgSearchDocument, "professionals.", "") + `))`).Error
+	case "sqlite":
+		statements := []string{
+			`CREATE VIRTUAL TABLE IF NOT EXISTS professionals_fts USING fts5(
+				title, bio, city, country, professional_type,
+				content='professionals', tokenize='unicode61 remove_diacritics 2')`,
+			`CREATE TRIGGER IF NOT EXISTS professionals_fts_insert AFTER INSERT ON professionals BEGIN
+				INSERT INTO professionals_fts(rowid, title, bio, city, country, professional_type)
+				VALUES (new.rowid, new.title, new.bio, new.city, new.country, new.professional_type);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS professionals_fts_delete AFTER DELETE ON professionals BEGIN
+				INSERT INTO professionals_fts(professionals_fts, rowid, title, bio, city, country, professional_type)
+				VALUES ('delete', old.rowid, old.title, old.bio, old.city, old.country, old.professional_type);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS professionals_fts_update AFTER UPDATE ON professionals BEGIN
+				INSERT INTO professionals_fts(professionals_fts, rowid, title, bio, city, country, professional_type)
+				VALUES ('delete', old.rowid, old.title, old.bio, old.city, old.country, old.professional_type);
+				INSERT INTO professionals_fts(rowid, title, bio, city, country, professional_type)
+				VALUES (new.rowid, new.title, new.bio, new.city, new.country, new.professional_type);
+			END`,
+			// Index rows written before the triggers existed
+			`INSERT INTO professionals_fts(professionals_fts) VALUES ('rebuild')`,
+		}
+		for _, stmt := range statements {
+			if err := db.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return nil // Other databases fall back to substring matching
+}
+
+// searchTerms splits a query into lowercase words, dropping punctuation so
+// what remains is safe to put in a tsquery or FTS5 MATCH expression
+func searchTerms(query string) []string {
+	terms := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	return terms
+}
+
+// pgTSQuery matches every term as a prefix, so "yog" finds "yoga"
+func pgTSQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = t + ":*"
+	}
+	return strings.Join(parts, " & ")
+}
+
+// ftsMatch is the FTS5 equivalent of pgTSQuery
+func ftsMatch(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = `"` + t + `"*`
+	}
+	return strings.Join(parts, " ")
+}
+
+// availableWeekdays lists the days of the week a window touches
+func availableWeekdays(from, to time.Time) []int {
+	var days []int
+	for d := from; !d.After(to) && len(days) < 7; d = d.AddDate(0, 0, 1) {
+		days = append(days, int(d.Weekday()))
+	}
+	if len(days) < 7 && int(to.Weekday()) != days[len(days)-1] {
+		days = append(days, int(to.Weekday())) // The window ends part way into another day
+	}
+	return days
+}
+
+// Search finds approved professionals matching p
+func (s *ProfessionalService) Search(p SearchParams) (*SearchResult, error) {
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.Limit < 1 || p.Limit > 100 {
+		p.Limit = 20
+	}
+	terms := searchTerms(p.Query)
+	if p.Sort == "" {
+		p.Sort = "rating"
+		if len(terms) > 0 {
+			p.Sort = "relevance"
+		}
+	}
+
+	result := &SearchResult{Page: p.Page, Limit: p.Limit}
+	if err := s.filtered(p, terms, facetNone).Count(&result.Total).Error; err != nil {
+		return nil, err
+	}
+
+	query := s.filtered(p, terms, facetNone).Select("professionals.*").Preload("User").Preload("Services")
+	for _, order := range s.searchOrder(p, terms) {
+		query = query.Order(order)
+	}
+	if err := query.Limit(p.Limit).Offset((p.Page - 1) * p.Limit).Find(&result.Professionals).Error; err != nil {
+		return nil, err
+	}
+
+	facets, err := s.facets(p, terms)
+	if err != nil {
+		return nil, err
+	}
+	result.Facets = *facets
+	return result, nil
+}
+
+// filtered applies every filter in p except the one for skip
+func (s *ProfessionalService) filtered(p SearchParams, terms []string, skip string) *gorm.DB {
+	db := database.DB
+	q := db.Model(&models.Professional{}).Where("professionals.verification_status = ?", "approved")
+
+	if len(terms) > 0 {
+		switch db.Dialector.Name() {
+		case "postgres":
+			q = q.Where(pgSearchDocument+" @@ to_tsquery('simple', ?)", pgTSQuery(terms))
+		case "sqlite":
+			q = q.Joins("JOIN professionals_fts ON professionals_fts.rowid = professionals.rowid").
+				Where("professionals_fts MATCH ?", ftsMatch(terms))
+		default:
+			for _, t := range terms {
+				q = q.Where("LOWER(professionals.title) LIKE ? OR LOWER(professionals.bio) LIKE ?", "%"+t+"%", "%"+t+"%")
+			}
+		}
+	}
+	if p.Type != "" && skip != facetType {
+		q = q.Where("professionals.professional_type = ?", p.Type)
+	}
+	if p.Country != "" && skip != facetCountry {
+		q = q.Where("LOWER(professionals.country) = ?", strings.ToLower(p.Country))
+	}
+	if p.City != "" && skip != facetCity {
+		q = q.Where("LOWER(professionals.city) = ?", strings.ToLower(p.City))
+	}
+	if p.MinRating > 0 && skip != facetRating {
+		q = q.Where("professionals.rating >= ?", p.MinRating)
+	}
+	if len(p.Languages) > 0 && skip != facetLanguage {
+		lower := make([]string, len(p.Languages))
+		for i, l := range p.Languages {
+			lower[i] = strings.ToLower(l)
+		}
+		q = q.Where("EXISTS (SELECT 1 FROM "+languagesSource(db)+" WHERE LOWER(lang.value) IN ?)", lower)
+	}
+
+	// Delivery method and price must hold for the same service
+	var service []string
+	var serviceArgs []interface{}
+	if p.DeliveryMethod != "" && skip != facetDelivery {
+		service = append(service, "services.delivery_method = ?")
+		serviceArgs = append(serviceArgs, p.DeliveryMethod)
+	}
+	if p.MinPriceCents > 0 {
+		service = append(service, "services.price_cents >= ?")
+		serviceArgs = append(serviceArgs, p.MinPriceCents)
+	}
+	if p.MaxPriceCents > 0 {
+		service = append(service, "services.price_cents <= ?")
+		serviceArgs = append(serviceArgs, p.MaxPriceCents)
+	}
+	if p.Currency != "" && (p.MinPriceCents > 0 || p.MaxPriceCents > 0) {
+		service = append(service, "services.currency = ?")
+		serviceArgs = append(serviceArgs, strings.ToUpper(p.Currency))
+	}
+	if len(service) > 0 {
+		q = q.Where("EXISTS (SELECT 1 FROM services WHERE services.professional_id = professionals.id AND "+
+			"services.is_active = ? AND services.deleted_at IS NULL AND "+strings.Join(service, " AND ")+")",
+			append([]interface{}{true}, serviceArgs...)...)
+	}
+
+	// Availability is the weekly schedule, or one-off dates, overlapping the
+	// window in the window's own time zone, less time off. Windows within a
+	// day also check the hours.
+	if !p.AvailableFrom.IsZero() {
+		from, to := p.AvailableFrom, p.AvailableTo.In(p.AvailableFrom.Location())
+		recurring := "professional_availabilities.day_of_week IN ?"
+		args := []interface{}{true, availableWeekdays(from, to)}
+		if from.Format("2006-01-02") == to.Format("2006-01-02") {
+			recurring += " AND professional_availabilities.start_time < ? AND professional_availabilities.end_time > ?"
+			args = append(args, to.Format("15:04"), from.Format("15:04"))
+		}
+		q = q.Where("EXISTS (SELECT 1 FROM professional_availabilities WHERE professional_availabilities.professional_id = professionals.id AND "+
+			"((professional_availabilities.is_recurring = ? AND "+recurring+") OR "+
+			"(professional_availabilities.is_recurring = ? AND professional_availabilities.specific_date >= ? AND professional_availabilities.specific_date < ?)))",
+			append(args, false, time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location()), to)...)
+		// Away for the whole window
+		q = q.Where("NOT EXISTS (SELECT 1 FROM professional_time_offs WHERE professional_time_offs.professional_id = professionals.id AND "+
+			"professional_time_offs.start_time <= ? AND professional_time_offs.end_time >= ?)", from, to)
+	}
+	return q
+}
+
+// languagesSource expands professionals.languages, a JSON array, into rows
+// of lang.value
+func languagesSource(db *gorm.DB) string {
+	if db.Dialector.Name() == "postgres" {
+		return "jsonb_array_elements_text(CASE WHEN jsonb_typeof(professionals.languages) = 'array' " +
+			"THEN professionals.languages ELSE '[]'::jsonb END) AS lang(value)"
+	}
+	return "json_each(CASE WHEN json_valid(professionals.languages) THEN professionals.languages ELSE '[]' END) AS lang"
+}
+
+func (s *ProfessionalService) searchOrder(p SearchParams, terms []string) []interface{} {
+	minPrice := "(SELECT MIN(services.price_cents) FROM services WHERE services.professional_id = professionals.id " +
+		"AND services.is_active = true AND services.deleted_at IS NULL)"
+	byRating := "professionals.weighted_rating DESC, professionals.review_count DESC"
+
+	var orders []interface{}
+	switch p.Sort {
+	case "relevance":
+		if len(terms) > 0 {
+			switch database.DB.Dialector.Name() {
+			case "postgres":
+				orders = append(orders, clause.OrderBy{Expression: clause.Expr{
+					SQL:                "ts_rank(" + pgSearchDocument + ", to_tsquery('simple', ?)) DESC",
+					Vars:               []interface{}{pgTSQuery(terms)},
+					WithoutParentheses: true,
+				}})
+			case "sqlite":
+				// Lower bm25 is better; columns weighted as in pgSearchDocument
+				orders = append(orders, "bm25(professionals_fts, 10.0, 1.0, 4.0, 4.0, 4.0)")
+			}
+		}
+		orders = append(orders, byRating)
+	case "rating":
+		orders = append(orders, byRating)
+	case "price_asc":
+		orders = append(orders, "COALESCE("+minPrice+", 2147483647) ASC")
+	case "price_desc":
+		orders = append(orders, "COALESCE("+minPrice+", -1) DESC")
+	case "experience":
+		orders = append(orders, "professionals.years_experience DESC")
+	case "newest":
+		orders = append(orders, "professionals.created_at DESC")
+	}
+	// A stable order keeps pages from overlapping
+	return append(orders, "professionals.is_featured DESC", "professionals.id")
+}
+
+func (s *ProfessionalService) facets(p SearchParams, terms []string) (*SearchFacets, error) {
+	f := &SearchFacets{}
+	group := func(skip, column string, out *[]FacetCount) error {
+		return s.filtered(p, terms, skip).
+			Select(column + " AS value, COUNT(*) AS count").
+			Where(column + " <> ''").
+			Group(column).Order("count DESC, value").Scan(out).Error
+	}
+	if err := group(facetType, "professionals.professional_type", &f.Types); err != nil {
+		return nil, err
+	}
+	if err := group(facetCountry, "professionals.country", &f.Countries); err != nil {
+		return nil, err
+	}
+	if err := group(facetCity, "professionals.city", &f.Cities); err != nil {
+		return nil, err
+	}
+
+	if err := s.filtered(p, terms, facetLanguage).
+		Joins("CROSS JOIN " + languagesSource(database.DB)).
+		Select("lang.value AS value, COUNT(DISTINCT professionals.id) AS count").
+		Group("lang.value").Order("count DESC, value").Scan(&f.Languages).Error; err != nil {
+		return nil, err
+	}
+
+	if err := s.filtered(p, terms, facetDelivery).
+		Joins("JOIN services ON services.professional_id = professionals.id AND services.is_active = ? AND services.deleted_at IS NULL", true).
+		Select("services.delivery_method AS value, COUNT(DISTINCT professionals.id) AS count").
+		Where("services.delivery_method <> ''").
+		Group("services.delivery_method").Order("count DESC, value").Scan(&f.DeliveryMethods).Error; err != nil {
+		return nil, err
+	}
+
+	for _, min := range ratingBuckets {
+		var count int64
+		if err := s.filtered(p, terms, facetRating).Where("professionals.rating >= ?", min).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		f.Ratings = append(f.Ratings, FacetCount{Value: strconv.FormatFloat(min, 'f', -1, 64), Count: count})
+	}
+	return f, nil
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"kaivaliyayoga/internal/models"
+	"kaivaliyayoga/pkg/database"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// setupMarketplaceDB points database.DB at a fresh in-memory SQLite
+// database with the marketplace tables
+func setupMarketplaceDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file:marketplace_"+uuid.NewString()+"?mode=memory"), &gorm.Config{})
+	require.NoError(t, err)
+	database.DB = db
+
+	// SQLite has no gen_random_uuid(), so create the UUID-keyed tables first
+	// and let AutoMigrate add the remaining columns
+	for _, table := range []string{"professionals", "services", "professional_availabilities", "professional_time_offs", "appointments"} {
+		require.NoError(t, db.Exec("CREATE TABLE "+table+" (id TEXT PRIMARY KEY)").Error)
+	}
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Professional{}, &models.Service{},
+		&models.ProfessionalAvailability{}, &models.ProfessionalTimeOff{}, &models.Appointment{}))
+	return db
+}
+
+// setupSearchDB seeds a marketplace database with professionals to search
+func setupSearchDB(t *testing.T) map[string]uuid.UUID {
+	db := setupMarketplaceDB(t)
+	require.NoError(t, SetupSearch(db))
+
+	ids := map[string]uuid.UUID{}
+	add := func(key, title, bio, kind, city, country, languages string, rating float64, prices map[string]int) models.Professional {
+		user := models.User{Name: key, Email: key + "@example.com"}
+		require.NoError(t, db.Create(&user).Error)
+		pro := models.Professional{
+			ID:                 uuid.New(),
+			UserID:             user.ID,
+			Slug:               key,
+			Title:              title,
+			Bio:                bio,
+			ProfessionalType:   kind,
+			City:               city,
+			Country:            country,
+			Languages:          datatypes.JSON(languages),
+			Rating:             rating,
+			WeightedRating:     rating,
+			VerificationStatus: "approved",
+		}
+		require.NoError(t, db.Create(&pro).Error)
+		for method, price := range prices {
+			require.NoError(t, db.Create(&models.Service{
+				ID: uuid.New(), ProfessionalID: pro.ID, Name: title, DeliveryMethod: method,
+				PriceCents: price, Currency: "AUD", IsActive: true,
+			}).Error)
+		}
+		ids[key] = pro.ID
+		return pro
+	}
+
+	add("asha", "Hatha Yoga Therapist", "Gentle yoga for back pain", "yoga_therapist", "Sydney", "Australia",
+		`["English","Hindi"]`, 4.8, map[string]int{"online": 6000})
+	add("ben", "Vinyasa Teacher", "Dynamic flow classes with a little yoga philosophy", "yoga_therapist", "Melbourne", "Australia",
+		`["English"]`, 4.2, map[string]int{"in-person": 9000})
+	add("carmen", "Clinical Nutritionist", "Diet plans and gut health", "nutritionist", "Madrid", "Spain",
+		`["Spanish","English"]`, 3.5, map[string]int{"online": 8000, "in-person": 12000})
+	pending := add("dev", "Yoga Therapist", "Not approved yet", "yoga_therapist", "Sydney", "Australia", `["English"]`, 5, nil)
+	require.NoError(t, db.Model(&pending).Update("verification_status", "pending").Error)
+
+	// Asha works Mondays but takes a week off; Carmen works one specific date
+	require.NoError(t, db.Create(&models.ProfessionalAvailability{
+		ID: uuid.New(), ProfessionalID: ids["asha"], DayOfWeek: 1, StartTime: "09:00", EndTime: "12:00", IsRecurring: true,
+	}).Error)
+	require.NoError(t, db.Create(&models.ProfessionalTimeOff{
+		ID: uuid.New(), ProfessionalID: ids["asha"],
+		StartTime: time.Date(2026, 10, 24, 0, 0, 0, 0, time.UTC), EndTime: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC),
+	}).Error)
+	oneOff := models.ProfessionalAvailability{
+		ID: uuid.New(), ProfessionalID: ids["carmen"], SpecificDate: time.Date(2026, 10, 21, 0, 0, 0, 0, time.UTC),
+		StartTime: "10:00", EndTime: "16:00",
+	}
+	require.NoError(t, db.Create(&oneOff).Error)
+	// IsRecurring defaults to true, so false has to be written explicitly
+	require.NoError(t, db.Model(&oneOff).Update("is_recurring", false).Error)
+	return ids
+}
+
+func resultIDs(r *SearchResult) []uuid.UUID {
+	ids := make([]uuid.UUID, len(r.Professionals))
+	for i, p := range r.Professionals {
+		ids[i] = p.ID
+	}
+	return ids
+}
+
+func facet(counts []FacetCount, value string) int64 {
+	for _, c := range counts {
+		if c.Value == value {
+			return c.Count
+		}
+	}
+	return 0
+}
+
+func TestSearchTerms(t *testing.T) {
+	assert.Equal(t, []string{"yoga", "back", "pain"}, searchTerms(`  Yoga "back" pain*`))
+	assert.Empty(t, searchTerms(`"* -:`))
+	assert.Equal(t, "yoga:* & back:*", pgTSQuery([]string{"yoga", "back"}))
+	assert.Equal(t, `"yoga"* "back"*`, ftsMatch([]string{"yoga", "back"}))
+}
+
+func TestAvailableWeekdays(t *testing.T) {
+	monday := time.Date(2026, 10, 19, 22, 0, 0, 0, time.UTC)
+	assert.Equal(t, []int{1, 2}, availableWeekdays(monday, monday.Add(4*time.Hour)))
+	assert.Len(t, availableWeekdays(monday, monday.AddDate(0, 0, 10)), 7)
+}
+
+func TestProfessionalService_Search(t *testing.T) {
+	ids := setupSearchDB(t)
+	service := &ProfessionalService{}
+
+	t.Run("Full text ranks title matches first", func(t *testing.T) {
+		result, err := service.Search(SearchParams{Query: "yog"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), result.Total) // Not the pending professional
+		assert.Equal(t, []uuid.UUID{ids["asha"], ids["ben"]}, resultIDs(result))
+	})
+
+	t.Run("Every term must match", func(t *testing.T) {
+		result, err := service.Search(SearchParams{Query: "yoga back pain"})
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{ids["asha"]}, resultIDs(result))
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		cases := []struct {
+			name   string
+			params SearchParams
+			want   []uuid.UUID
+		}{
+			{"language", SearchParams{Languages: []string{"spanish"}}, []uuid.UUID{ids["carmen"]}},
+			{"city", SearchParams{City: "sydney"}, []uuid.UUID{ids["asha"]}},
+			{"country and type", SearchParams{Country: "Australia", Type: "yoga_therapist", Sort: "price_desc"}, []uuid.UUID{ids["ben"], ids["asha"]}},
+			{"delivery and price together", SearchParams{DeliveryMethod: "in-person", MaxPriceCents: 10000}, []uuid.UUID{ids["ben"]}},
+			{"rating", SearchParams{MinRating: 4}, []uuid.UUID{ids["asha"], ids["ben"]}},
+			{"weekly availability", SearchParams{
+				AvailableFrom: time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC),
+				AvailableTo:   time.Date(2026, 10, 19, 11, 0, 0, 0, time.UTC),
+			}, []uuid.UUID{ids["asha"]}},
+			{"outside weekly hours", SearchParams{
+				AvailableFrom: time.Date(2026, 10, 19, 13, 0, 0, 0, time.UTC),
+				AvailableTo:   time.Date(2026, 10, 19, 14, 0, 0, 0, time.UTC),
+			}, []uuid.UUID{}},
+			{"away for the whole window", SearchParams{
+				AvailableFrom: time.Date(2026, 10, 26, 10, 0, 0, 0, time.UTC),
+				AvailableTo:   time.Date(2026, 10, 26, 11, 0, 0, 0, time.UTC),
+			}, []uuid.UUID{}},
+			{"specific date", SearchParams{
+				AvailableFrom: time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC),
+				AvailableTo:   time.Date(2026, 10, 22, 0, 0, 0, 0, time.UTC),
+			}, []uuid.UUID{ids["carmen"]}},
+		}
+		for _, c := range cases {
+			result, err := service.Search(c.params)
+			require.NoError(t, err, c.name)
+			assert.Equal(t, c.want, resultIDs(result), c.name)
+		}
+	})
+
+	t.Run("Facets leave their own filter out", func(t *testing.T) {
+		result, err := service.Search(SearchParams{Type: "nutritionist"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), result.Total)
+		assert.Equal(t, int64(2), facet(result.Facets.Types, "yoga_therapist"))
+		assert.Equal(t, int64(1), facet(result.Facets.Types, "nutritionist"))
+		assert.Equal(t, int64(1), facet(result.Facets.Languages, "Spanish"))
+		assert.Equal(t, int64(0), facet(result.Facets.Languages, "Hindi"))
+		assert.Equal(t, int64(1), facet(result.Facets.DeliveryMethods, "online"))
+		assert.Equal(t, int64(1), facet(result.Facets.Ratings, "3"))
+		assert.Equal(t, int64(0), facet(result.Facets.Ratings, "4"))
+	})
+
+	t.Run("Pagination and sorting", func(t *testing.T) {
+		result, err := service.Search(SearchParams{Sort: "price_asc", Limit: 2, Page: 2})
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), result.Total)
+		assert.Equal(t, []uuid.UUID{ids["ben"]}, resultIDs(result))
+	})
+
+	t.Run("Index follows edits", func(t *testing.T) {
+		require.NoError(t, database.DB.Model(&models.Professional{}).Where("id = ?", ids["carmen"]).
+			Update("bio", "Ayurvedic cooking").Error)
+		result, err := service.Search(SearchParams{Query: "ayurvedic"})
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{ids["carmen"]}, resultIDs(result))
+	})
+}
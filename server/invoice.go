@@ -1,74 +1,530 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/jung-kurt/gofpdf"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// GenerateInvoice generates a PDF invoice for a specific payment
-func GenerateInvoice(c *gin.Context) {
-	idPos := c.Param("id")
-	fmt.Printf("DEBUG: GenerateInvoice called for ID: %s\n", idPos)
+// Invoice types
+const (
+	InvoiceTypeInvoice    = "invoice"
+	InvoiceTypeCreditNote = "credit_note"
+)
 
-	paymentID, err := strconv.Atoi(idPos)
-	if err != nil {
-		fmt.Println("DEBUG: Invalid payment ID format")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
-		return
+// --- Models ---
+
+// Invoice is an issued, immutable tax document. Seller and buyer details are
+// snapshotted at issue time so that re-downloads never change.
+type Invoice struct {
+	ID            uint   `json:"id" gorm:"primaryKey"`
+	Number        string `json:"number" gorm:"uniqueIndex;not null"` // e.g. INV/2025-26/000042
+	Type          string `json:"type"`                               // invoice, credit_note
+	FinancialYear string `json:"financial_year" gorm:"index"`
+	Sequence      int    `json:"sequence"`
+
+	UserID uint `json:"user_id" gorm:"index"`
+
+	// Source document (one of). A payment has at most one invoice, however
+	// many credit notes.
+	PaymentID         *uint      `json:"payment_id" gorm:"index;uniqueIndex:idx_invoices_payment_invoice,where:type = 'invoice'"`
+	AppointmentID     *uuid.UUID `json:"appointment_id" gorm:"type:uuid;index"`
+	EnrollmentID      *uint      `json:"enrollment_id" gorm:"index"`
+	OriginalInvoiceID *uint      `json:"original_invoice_id" gorm:"index"` // Credit notes only
+
+	// Seller snapshot
+	SellerName    string `json:"seller_name"`
+	SellerAddress string `json:"seller_address"`
+	SellerTaxID   string `json:"seller_tax_id"`
+
+	// Buyer snapshot
+	BuyerName  string `json:"buyer_name"`
+	BuyerEmail string `json:"buyer_email"`
+	BuyerTaxID string `json:"buyer_tax_id"`
+
+	// How the buyer's tax ID was validated when the supply was zero-rated
+	BuyerTaxIDCheck     string     `json:"buyer_tax_id_check"` // vies, admin
+	BuyerTaxIDCheckedAt *time.Time `json:"buyer_tax_id_checked_at"`
+
+	Currency       string  `json:"currency"`
+	Subtotal       float64 `json:"subtotal"`
+	DiscountAmount float64 `json:"discount_amount"`
+	DiscountLabel  string  `json:"discount_label"`
+	TaxAmount      float64 `json:"tax_amount"`
+	Total          float64 `json:"total"`
+	WalletAmount   float64 `json:"wallet_amount"` // Part of Total paid from store credit
+	ReverseCharge  bool    `json:"reverse_charge"`
+	Notes          string  `json:"notes"`
+
+	// Archived PDF
+	PDFPath   string `json:"-"`
+	PDFSHA256 string `json:"pdf_sha256" gorm:"column:pdf_sha256"`
+
+	IssuedAt time.Time     `json:"issued_at"`
+	Lines    []InvoiceLine `json:"lines" gorm:"foreignKey:InvoiceID"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// InvoiceLine is a single row on an invoice. Tax lines use Kind "tax".
+type InvoiceLine struct {
+	ID          uint    `json:"id" gorm:"primaryKey"`
+	InvoiceID   uint    `json:"invoice_id" gorm:"index"`
+	Kind        string  `json:"kind"` // item, tax
+	Description string  `json:"description"`
+	Quantity    int     `json:"quantity"`
+	UnitAmount  float64 `json:"unit_amount"`
+	TaxRate     float64 `json:"tax_rate"`
+	Amount      float64 `json:"amount"`
+}
+
+// InvoiceSequence holds the last number issued per type and financial year.
+// Numbers are allocated inside the invoice transaction so they stay gap-free.
+type InvoiceSequence struct {
+	ID            uint   `gorm:"primaryKey"`
+	Type          string `gorm:"uniqueIndex:idx_invoice_sequence"`
+	FinancialYear string `gorm:"uniqueIndex:idx_invoice_sequence"`
+	LastNumber    int
+}
+
+// --- Seller Settings ---
+
+type invoiceSeller struct {
+	Name     string
+	Address  string
+	TaxID    string
+	LogoPath string
+}
+
+func loadInvoiceSeller() invoiceSeller {
+	seller := invoiceSeller{
+		Name:     os.Getenv("INVOICE_SELLER_NAME"),
+		Address:  strings.ReplaceAll(os.Getenv("INVOICE_SELLER_ADDRESS"), "|", "\n"),
+		TaxID:    os.Getenv("INVOICE_SELLER_TAX_ID"),
+		LogoPath: os.Getenv("INVOICE_LOGO_PATH"),
 	}
+	if seller.Name == "" {
+		seller.Name = "Kaivalya Yoga Studio"
+	}
+	return seller
+}
 
-	// Fetch payment with User details
-	var payment Payment
-	// Note: We removed the FK constraint but we can still preload if we add the relationship back correctly or just do a manual join/lookup.
-	// Since we removed 'User' from Payment struct to fix the circle, we must fetch User manually.
-	if err := db.First(&payment, paymentID).Error; err != nil {
-		fmt.Printf("DEBUG: Payment %d not found: %v\n", paymentID, err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
-		return
+func invoiceStorageDir() string {
+	if dir := os.Getenv("INVOICE_STORAGE_DIR"); dir != "" {
+		return dir
+	}
+	return "data/invoices"
+}
+
+// financialYear labels the financial year containing t, e.g. "2025-26" for an
+// April start. INVOICE_FY_START_MONTH=1 gives calendar years.
+func financialYear(t time.Time) string {
+	startMonth, err := strconv.Atoi(os.Getenv("INVOICE_FY_START_MONTH"))
+	if err != nil || startMonth < 1 || startMonth > 12 {
+		startMonth = 4
+	}
+	if startMonth == 1 {
+		return strconv.Itoa(t.Year())
+	}
+	startYear := t.Year()
+	if int(t.Month()) < startMonth {
+		startYear--
+	}
+	return fmt.Sprintf("%d-%02d", startYear, (startYear+1)%100)
+}
+
+func invoiceNumberPrefix(invoiceType string) string {
+	if invoiceType == InvoiceTypeCreditNote {
+		if prefix := os.Getenv("CREDIT_NOTE_NUMBER_PREFIX"); prefix != "" {
+			return prefix
+		}
+		return "CN"
+	}
+	if prefix := os.Getenv("INVOICE_NUMBER_PREFIX"); prefix != "" {
+		return prefix
+	}
+	return "INV"
+}
+
+// --- Issuing ---
+
+// nextInvoiceNumber locks the sequence row and allocates the next number
+func nextInvoiceNumber(tx *gorm.DB, invoiceType string, issuedAt time.Time) (string, string, int, error) {
+	fy := financialYear(issuedAt)
+
+	seq := InvoiceSequence{Type: invoiceType, FinancialYear: fy}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&seq).Error; err != nil {
+		return "", "", 0, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("type = ? AND financial_year = ?", invoiceType, fy).First(&seq).Error; err != nil {
+		return "", "", 0, err
+	}
+
+	seq.LastNumber++
+	if err := tx.Model(&seq).Update("last_number", seq.LastNumber).Error; err != nil {
+		return "", "", 0, err
 	}
 
-	// Manual fetch user
+	number := fmt.Sprintf("%s/%s/%06d", invoiceNumberPrefix(invoiceType), fy, seq.LastNumber)
+	return number, fy, seq.LastNumber, nil
+}
+
+// issueInvoice numbers and saves a draft invoice with its lines
+func issueInvoice(tx *gorm.DB, inv *Invoice) error {
+	inv.IssuedAt = time.Now()
+	if inv.Type == "" {
+		inv.Type = InvoiceTypeInvoice
+	}
+
+	number, fy, sequence, err := nextInvoiceNumber(tx, inv.Type, inv.IssuedAt)
+	if err != nil {
+		return err
+	}
+	inv.Number = number
+	inv.FinancialYear = fy
+	inv.Sequence = sequence
+
+	seller := loadInvoiceSeller()
+	inv.SellerName = seller.Name
+	inv.SellerAddress = seller.Address
+	inv.SellerTaxID = seller.TaxID
+
 	var user User
-	if err := db.First(&user, payment.UserID).Error; err != nil {
-		// Log error but continue with unknown user? or fail
-		fmt.Printf("User not found for payment %d\n", payment.ID)
-		user.Name = "Unknown User"
-		user.Email = "N/A"
+	if err := tx.First(&user, inv.UserID).Error; err == nil {
+		inv.BuyerName = user.Name
+		inv.BuyerEmail = user.Email
+		if inv.BuyerTaxID == "" {
+			inv.BuyerTaxID = user.TaxID
+		}
+	}
+
+	return tx.Create(inv).Error
+}
+
+// describePayment builds a line item description from what was purchased
+func describePayment(tx *gorm.DB, payment *Payment) string {
+	switch payment.ProductType {
+	case ProductMembership:
+		var mem Membership
+		if tx.Where("payment_id = ?", payment.ID).First(&mem).Error == nil {
+			var pack ClassPack
+			if tx.Where("code = ?", mem.Type).First(&pack).Error == nil {
+				return "Membership: " + pack.Name
+			}
+			return "Membership: " + mem.Type
+		}
+		return "Membership"
+	case ProductProgram:
+		var program Program
+		if tx.First(&program, payment.ProductID).Error == nil {
+			return "Program: " + program.Name
+		}
+		return "Program enrollment"
+	case ProductClass:
+		var class Class
+		if tx.First(&class, payment.ProductID).Error == nil {
+			return "Class: " + class.Name
+		}
+		return "Class booking"
+	case ProductService:
+		return "Professional service"
+	}
+	return "Payment for Services"
+}
+
+// IssuePaymentInvoice returns the invoice for a payment, issuing it on first
+// use. The payment row is locked so concurrent callers wait and then find the
+// first one's invoice instead of taking a second number.
+func IssuePaymentInvoice(tx *gorm.DB, payment *Payment) (*Invoice, error) {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&Payment{}, payment.ID).Error; err != nil {
+		return nil, err
+	}
+	var existing Invoice
+	if err := tx.Where("payment_id = ? AND type = ?", payment.ID, InvoiceTypeInvoice).First(&existing).Error; err == nil {
+		return &existing, nil
+	}
+	if payment.Status != "success" && payment.Status != "refunded" && payment.Status != "partially_refunded" {
+		return nil, errors.New("invoices are only available for completed payments")
+	}
+
+	subtotal := payment.Total()
+	if payment.SubtotalAmount > 0 {
+		subtotal = payment.SubtotalAmount
+	}
+
+	paymentID := payment.ID
+	inv := Invoice{
+		UserID:              payment.UserID,
+		PaymentID:           &paymentID,
+		BuyerTaxID:          payment.CustomerTaxID,
+		BuyerTaxIDCheck:     payment.TaxIDCheck,
+		BuyerTaxIDCheckedAt: payment.TaxIDCheckedAt,
+		Currency:            payment.Currency,
+		Subtotal:            subtotal,
+		DiscountAmount:      payment.DiscountAmount,
+		TaxAmount:           payment.TaxAmount,
+		Total:               payment.Total(),
+		WalletAmount:        payment.WalletAmount,
+		ReverseCharge:       payment.ReverseCharge,
+		Notes:               "Order ID: " + payment.OrderID,
+		Lines: []InvoiceLine{{
+			Kind:        "item",
+			Description: describePayment(tx, payment),
+			Quantity:    1,
+			UnitAmount:  subtotal,
+			Amount:      subtotal,
+		}},
+	}
+	if payment.DiscountAmount > 0 {
+		inv.DiscountLabel = fmt.Sprintf("Discount (%s)", payment.PromoCode)
+	}
+
+	var taxLines []PaymentTaxLine
+	tx.Where("payment_id = ?", payment.ID).Find(&taxLines)
+	for _, line := range taxLines {
+		inv.Lines = append(inv.Lines, InvoiceLine{
+			Kind:        "tax",
+			Description: line.Name,
+			TaxRate:     line.Rate,
+			Amount:      line.Amount,
+		})
+	}
+
+	if err := issueInvoice(tx, &inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// issueInvoiceForPayment issues a payment's invoice in its own transaction,
+// used when a payment completes
+func issueInvoiceForPayment(payment *Payment) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		_, err := IssuePaymentInvoice(tx, payment)
+		return err
+	})
+}
+
+// IssueEnrollmentInvoice invoices a program enrollment. Enrollments paid
+// through a Payment share that payment's invoice.
+func IssueEnrollmentInvoice(tx *gorm.DB, enrollment *ProgramEnrollment) (*Invoice, error) {
+	if enrollment.PaymentID > 0 {
+		var payment Payment
+		if tx.First(&payment, enrollment.PaymentID).Error == nil {
+			return IssuePaymentInvoice(tx, &payment)
+		}
+	}
+
+	var existing Invoice
+	if err := tx.Where("enrollment_id = ? AND type = ?", enrollment.ID, InvoiceTypeInvoice).First(&existing).Error; err == nil {
+		return &existing, nil
+	}
+	if enrollment.PaymentStatus != "paid" {
+		return nil, errors.New("enrollment has not been paid")
+	}
+
+	var program Program
+	tx.First(&program, enrollment.ProgramID)
+
+	enrollmentID := enrollment.ID
+	inv := Invoice{
+		UserID:       enrollment.UserID,
+		EnrollmentID: &enrollmentID,
+		Currency:     "AUD",
+		Subtotal:     enrollment.AmountPaid,
+		Total:        enrollment.AmountPaid,
+		Lines: []InvoiceLine{{
+			Kind:        "item",
+			Description: "Program: " + program.Name,
+			Quantity:    1,
+			UnitAmount:  enrollment.AmountPaid,
+			Amount:      enrollment.AmountPaid,
+		}},
+	}
+	if err := issueInvoice(tx, &inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// errAppointmentPaymentNotFound is an appointment pointing at a payment that
+// isn't the client's payment for a service
+var errAppointmentPaymentNotFound = errors.New("payment not found")
+
+// IssueAppointmentInvoice invoices a paid appointment. Appointments paid
+// through a Payment share that payment's invoice, as long as it is the
+// client's own service payment.
+func IssueAppointmentInvoice(tx *gorm.DB, appointment *Appointment) (*Invoice, error) {
+	if appointment.PaymentID != nil {
+		var payment Payment
+		if err := tx.First(&payment, *appointment.PaymentID).Error; err != nil ||
+			payment.UserID != appointment.ClientID || payment.ProductType != ProductService {
+			return nil, errAppointmentPaymentNotFound
+		}
+		return IssuePaymentInvoice(tx, &payment)
+	}
+
+	var existing Invoice
+	if err := tx.Where("appointment_id = ? AND type = ?", appointment.ID, InvoiceTypeInvoice).First(&existing).Error; err == nil {
+		return &existing, nil
+	}
+	if appointment.PaymentStatus != "paid" {
+		return nil, errors.New("appointment has not been paid")
 	}
 
-	// Create PDF
+	var service Service
+	description := "Professional appointment " + appointment.ReferenceCode
+	if tx.Where("id = ?", appointment.ServiceID).First(&service).Error == nil {
+		description = service.Name + " (" + appointment.ReferenceCode + ")"
+	}
+
+	amount := float64(appointment.PriceChargedCents) / 100
+	appointmentID := appointment.ID
+	inv := Invoice{
+		UserID:        appointment.ClientID,
+		AppointmentID: &appointmentID,
+		Currency:      appointment.CurrencyCharged,
+		Subtotal:      amount,
+		Total:         amount,
+		Notes:         "Appointment on " + appointment.StartTime.Format("2006-01-02 15:04 MST"),
+		Lines: []InvoiceLine{{
+			Kind:        "item",
+			Description: description,
+			Quantity:    1,
+			UnitAmount:  amount,
+			Amount:      amount,
+		}},
+	}
+	if err := issueInvoice(tx, &inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// IssueCreditNote credits part or all of an invoice, e.g. after a refund.
+// Tax is credited proportionally. walletAmount is the part returned as store credit.
+func IssueCreditNote(tx *gorm.DB, original *Invoice, amount, walletAmount float64, reason string) (*Invoice, error) {
+	var credited float64
+	tx.Model(&Invoice{}).Where("original_invoice_id = ? AND type = ?", original.ID, InvoiceTypeCreditNote).
+		Select("COALESCE(SUM(total), 0)").Scan(&credited)
+	if amount <= 0 || roundMoney(credited+amount) > original.Total {
+		return nil, errors.New("credit exceeds the invoice total")
+	}
+
+	ratio := amount / original.Total
+	taxCredited := roundMoney(original.TaxAmount * ratio)
+
+	originalID := original.ID
+	note := Invoice{
+		Type:                InvoiceTypeCreditNote,
+		UserID:              original.UserID,
+		PaymentID:           original.PaymentID,
+		AppointmentID:       original.AppointmentID,
+		EnrollmentID:        original.EnrollmentID,
+		OriginalInvoiceID:   &originalID,
+		BuyerTaxID:          original.BuyerTaxID,
+		BuyerTaxIDCheck:     original.BuyerTaxIDCheck,
+		BuyerTaxIDCheckedAt: original.BuyerTaxIDCheckedAt,
+		Currency:            original.Currency,
+		Subtotal:            roundMoney(amount - taxCredited),
+		TaxAmount:           taxCredited,
+		Total:               amount,
+		WalletAmount:        walletAmount,
+		ReverseCharge:       original.ReverseCharge,
+		Notes:               fmt.Sprintf("Credit against invoice %s. %s", original.Number, reason),
+		Lines: []InvoiceLine{{
+			Kind:        "item",
+			Description: "Refund: " + reason,
+			Quantity:    1,
+			UnitAmount:  roundMoney(amount - taxCredited),
+			Amount:      roundMoney(amount - taxCredited),
+		}},
+	}
+	if taxCredited > 0 {
+		note.Lines = append(note.Lines, InvoiceLine{Kind: "tax", Description: "Tax credited", Amount: taxCredited})
+	}
+
+	if err := issueInvoice(tx, &note); err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+// --- PDF Rendering & Archive ---
+
+func renderInvoicePDF(inv *Invoice) ([]byte, error) {
+	seller := loadInvoiceSeller()
+
 	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetCreationDate(inv.IssuedAt)
+	pdf.SetModificationDate(inv.IssuedAt)
 	pdf.AddPage()
-	pdf.SetFont("Arial", "B", 16)
 
 	// Header
-	pdf.Cell(40, 10, "Kaivalya Yoga Studio")
-	pdf.Ln(10)
+	if seller.LogoPath != "" {
+		if _, err := os.Stat(seller.LogoPath); err == nil {
+			pdf.ImageOptions(seller.LogoPath, 150, 10, 40, 0, false, gofpdf.ImageOptions{ReadDpi: true}, 0, "")
+		}
+	}
+	pdf.SetFont("Arial", "B", 16)
+	pdf.Cell(40, 10, inv.SellerName)
+	pdf.Ln(8)
+	pdf.SetFont("Arial", "", 10)
+	if inv.SellerAddress != "" {
+		pdf.MultiCell(120, 5, inv.SellerAddress, "", "", false)
+	}
+	if inv.SellerTaxID != "" {
+		pdf.Cell(40, 5, "Tax ID: "+inv.SellerTaxID)
+		pdf.Ln(5)
+	}
+	pdf.Ln(4)
 	pdf.SetFont("Arial", "", 12)
-	pdf.Cell(40, 10, "Invoice")
-	pdf.Ln(20)
+	title := "Tax Invoice"
+	if inv.Type == InvoiceTypeCreditNote {
+		title = "Credit Note"
+	}
+	pdf.Cell(40, 10, title)
+	pdf.Ln(14)
 
 	// Bill To
 	pdf.SetFont("Arial", "B", 12)
 	pdf.Cell(40, 10, "Bill To:")
 	pdf.Ln(6)
 	pdf.SetFont("Arial", "", 12)
-	pdf.Cell(40, 6, user.Name)
+	pdf.Cell(40, 6, inv.BuyerName)
 	pdf.Ln(6)
-	pdf.Cell(40, 6, user.Email)
-	pdf.Ln(20)
+	pdf.Cell(40, 6, inv.BuyerEmail)
+	if inv.BuyerTaxID != "" {
+		pdf.Ln(6)
+		pdf.Cell(40, 6, "Tax ID: "+inv.BuyerTaxID)
+	}
+	pdf.Ln(14)
 
 	// Invoice Details
-	pdf.Cell(40, 6, fmt.Sprintf("Invoice #: %d", payment.ID))
-	pdf.Ln(6)
-	pdf.Cell(40, 6, fmt.Sprintf("Date: %s", payment.CreatedAt.Format("2006-01-02")))
+	pdf.Cell(40, 6, fmt.Sprintf("%s #: %s", title, inv.Number))
 	pdf.Ln(6)
-	pdf.Cell(40, 6, fmt.Sprintf("Order ID: %s", payment.OrderID))
-	pdf.Ln(20)
+	pdf.Cell(40, 6, fmt.Sprintf("Date: %s", inv.IssuedAt.Format("2006-01-02")))
+	if inv.Notes != "" {
+		pdf.Ln(6)
+		pdf.MultiCell(180, 6, inv.Notes, "", "", false)
+	}
+	pdf.Ln(10)
 
 	// Line Items Header
 	pdf.SetFillColor(240, 240, 240)
@@ -78,27 +534,265 @@ func GenerateInvoice(c *gin.Context) {
 
 	// Line Items
 	pdf.SetFont("Arial", "", 12)
-	desc := "Payment for Services" // Could be more specific if we looked up Booking/Membership
-	pdf.CellFormat(120, 10, desc, "1", 0, "", false, 0, "")
-	pdf.CellFormat(60, 10, fmt.Sprintf("%.2f %s", payment.Amount, payment.Currency), "1", 1, "R", false, 0, "")
+	var taxLines []InvoiceLine
+	for _, line := range inv.Lines {
+		if line.Kind == "tax" {
+			taxLines = append(taxLines, line)
+			continue
+		}
+		pdf.CellFormat(120, 10, line.Description, "1", 0, "", false, 0, "")
+		pdf.CellFormat(60, 10, fmt.Sprintf("%.2f %s", line.Amount, inv.Currency), "1", 1, "R", false, 0, "")
+	}
+
+	// Discount
+	if inv.DiscountAmount > 0 {
+		pdf.CellFormat(120, 10, inv.DiscountLabel, "1", 0, "", false, 0, "")
+		pdf.CellFormat(60, 10, fmt.Sprintf("-%.2f %s", inv.DiscountAmount, inv.Currency), "1", 1, "R", false, 0, "")
+	}
+
+	// Tax Breakdown
+	if len(taxLines) > 0 {
+		pdf.CellFormat(120, 10, "Net Amount", "1", 0, "R", false, 0, "")
+		pdf.CellFormat(60, 10, fmt.Sprintf("%.2f %s", roundMoney(inv.Total-inv.TaxAmount), inv.Currency), "1", 1, "R", false, 0, "")
+		for _, line := range taxLines {
+			label := line.Description
+			if line.TaxRate > 0 {
+				label = fmt.Sprintf("%s @ %.2f%%", line.Description, line.TaxRate)
+			}
+			pdf.CellFormat(120, 10, label, "1", 0, "R", false, 0, "")
+			pdf.CellFormat(60, 10, fmt.Sprintf("%.2f %s", line.Amount, inv.Currency), "1", 1, "R", false, 0, "")
+		}
+	}
 
 	// Total
 	pdf.SetFont("Arial", "B", 12)
 	pdf.CellFormat(120, 10, "Total", "1", 0, "R", false, 0, "")
-	pdf.CellFormat(60, 10, fmt.Sprintf("%.2f %s", payment.Amount, payment.Currency), "1", 1, "R", false, 0, "")
+	pdf.CellFormat(60, 10, fmt.Sprintf("%.2f %s", inv.Total, inv.Currency), "1", 1, "R", false, 0, "")
+
+	// Payment split
+	if inv.WalletAmount > 0 {
+		walletLabel, cardLabel := "Paid from wallet", "Paid by card"
+		if inv.Type == InvoiceTypeCreditNote {
+			walletLabel, cardLabel = "Credited to wallet", "Refunded to card"
+		}
+		pdf.SetFont("Arial", "", 12)
+		pdf.CellFormat(120, 10, walletLabel, "1", 0, "R", false, 0, "")
+		pdf.CellFormat(60, 10, fmt.Sprintf("%.2f %s", inv.WalletAmount, inv.Currency), "1", 1, "R", false, 0, "")
+		pdf.CellFormat(120, 10, cardLabel, "1", 0, "R", false, 0, "")
+		pdf.CellFormat(60, 10, fmt.Sprintf("%.2f %s", roundMoney(inv.Total-inv.WalletAmount), inv.Currency), "1", 1, "R", false, 0, "")
+	}
+
+	if inv.ReverseCharge {
+		pdf.Ln(6)
+		pdf.SetFont("Arial", "", 10)
+		pdf.Cell(0, 6, "Reverse charge: tax to be accounted for by the recipient.")
+		if inv.BuyerTaxIDCheck != "" && inv.BuyerTaxIDCheckedAt != nil {
+			via := map[string]string{"vies": "VIES", "admin": "manual check"}[inv.BuyerTaxIDCheck]
+			pdf.Ln(5)
+			pdf.Cell(0, 6, fmt.Sprintf("Customer tax ID validated by %s on %s.", via, inv.BuyerTaxIDCheckedAt.Format("02 Jan 2006")))
+		}
+	}
 
 	// Footer
 	pdf.SetY(-30)
 	pdf.SetFont("Arial", "I", 8)
 	pdf.Cell(0, 10, "Thank you for your business!")
 
-	// Output to response
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=invoice_%d.pdf", payment.ID))
-	c.Header("Content-Type", "application/pdf")
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// invoicePDF returns the archived PDF, rendering and archiving it on first download
+func invoicePDF(inv *Invoice) ([]byte, error) {
+	if inv.PDFPath != "" {
+		if data, err := os.ReadFile(inv.PDFPath); err == nil {
+			return data, nil
+		}
+		fmt.Printf("WARNING: Archived PDF missing for invoice %s, re-rendering\n", inv.Number)
+	}
 
-	err = pdf.Output(c.Writer)
+	db.Preload("Lines").First(inv, inv.ID)
+	data, err := renderInvoicePDF(inv)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(invoiceStorageDir(), inv.FinancialYear)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, strings.ReplaceAll(inv.Number, "/", "_")+".pdf")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	inv.PDFPath = path
+	inv.PDFSHA256 = hex.EncodeToString(sum[:])
+	db.Model(inv).Updates(map[string]interface{}{"pdf_path": inv.PDFPath, "pdf_sha256": inv.PDFSHA256})
+	return data, nil
+}
+
+func sendInvoicePDF(c *gin.Context, inv *Invoice) {
+	data, err := invoicePDF(inv)
 	if err != nil {
 		fmt.Println("PDF Generation Error:", err)
-		// Usually can't send JSON error here as headers might be sent
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate invoice PDF"})
+		return
+	}
+
+	filename := strings.ReplaceAll(inv.Number, "/", "_") + ".pdf"
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Data(http.StatusOK, "application/pdf", data)
+}
+
+func isAdminUser(uid uint) bool {
+	var user User
+	return db.First(&user, uid).Error == nil && user.Role == "admin"
+}
+
+// --- Handlers ---
+
+// GenerateInvoice - Protected - Download the invoice for one of the caller's payments
+func GenerateInvoice(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	paymentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
+
+	var payment Payment
+	if err := db.First(&payment, paymentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+	if payment.UserID != uid && !isAdminUser(uid) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	var inv *Invoice
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		inv, err = IssuePaymentInvoice(tx, &payment)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sendInvoicePDF(c, inv)
+}
+
+// GetMyInvoices - Protected - List the caller's invoices and credit notes
+func GetMyInvoices(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	var invoices []Invoice
+	db.Where("user_id = ?", uid).Preload("Lines").Order("issued_at desc").Find(&invoices)
+	c.JSON(http.StatusOK, invoices)
+}
+
+// DownloadInvoice - Protected - Download an issued invoice (owner or admin)
+func DownloadInvoice(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var inv Invoice
+	if err := db.First(&inv, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		return
+	}
+	if inv.UserID != uid && !isAdminUser(uid) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		return
+	}
+	sendInvoicePDF(c, &inv)
+}
+
+// GetEnrollmentInvoice - Protected - Invoice for one of the caller's program enrollments
+func GetEnrollmentInvoice(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var enrollment ProgramEnrollment
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), uid).First(&enrollment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Enrollment not found"})
+		return
+	}
+
+	var inv *Invoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		inv, err = IssueEnrollmentInvoice(tx, &enrollment)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sendInvoicePDF(c, inv)
+}
+
+// GetAppointmentInvoice - Protected - Invoice for one of the caller's appointments
+func GetAppointmentInvoice(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	appointmentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid appointment ID"})
+		return
+	}
+
+	var appointment Appointment
+	if err := db.Where("id = ? AND client_id = ?", appointmentID, uid).First(&appointment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found"})
+		return
+	}
+
+	var inv *Invoice
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		inv, err = IssueAppointmentInvoice(tx, &appointment)
+		return err
+	})
+	if errors.Is(err, errAppointmentPaymentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		return
 	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sendInvoicePDF(c, inv)
+}
+
+// GetAdminInvoices - Admin - List invoices, optionally by ?type= and ?financial_year=
+func GetAdminInvoices(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset := (page - 1) * limit
+
+	query := db.Model(&Invoice{})
+	if invoiceType := c.Query("type"); invoiceType != "" {
+		query = query.Where("type = ?", invoiceType)
+	}
+	if fy := c.Query("financial_year"); fy != "" {
+		query = query.Where("financial_year = ?", fy)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var invoices []Invoice
+	query.Order("issued_at desc").Limit(limit).Offset(offset).Find(&invoices)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  invoices,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
 }
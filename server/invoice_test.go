@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupInvoiceDB(t *testing.T) {
+	setupTestDB(t, &User{}, &Payment{}, &PaymentTaxLine{}, &Invoice{}, &InvoiceLine{}, &InvoiceSequence{},
+		&SeatHold{}, &Wallet{}, &WalletTransaction{})
+	t.Setenv("INVOICE_FY_START_MONTH", "1")
+	t.Setenv("INVOICE_STORAGE_DIR", t.TempDir())
+	require.NoError(t, db.Create(&User{Name: "Buyer", Email: "buyer@example.com"}).Error)
+}
+
+func TestFinancialYear(t *testing.T) {
+	cases := []struct {
+		startMonth string
+		date       time.Time
+		want       string
+	}{
+		{"", time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC), "2025-26"},
+		{"", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), "2026-27"},
+		{"7", time.Date(2026, 6, 30, 0, 0, 0, 0, time.UTC), "2025-26"},
+		{"1", time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC), "2026"},
+		{"13", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), "2026-27"},
+	}
+	for _, tc := range cases {
+		t.Setenv("INVOICE_FY_START_MONTH", tc.startMonth)
+		assert.Equal(t, tc.want, financialYear(tc.date), "start month %q", tc.startMonth)
+	}
+}
+
+func TestIssuePaymentInvoiceNumbering(t *testing.T) {
+	setupInvoiceDB(t)
+	year := time.Now().Format("2006")
+
+	var payments []Payment
+	for i := 0; i < 3; i++ {
+		p := Payment{UserID: 1, OrderID: "order", Amount: 100, Currency: "INR", Status: "success"}
+		require.NoError(t, db.Create(&p).Error)
+		payments = append(payments, p)
+	}
+	unpaid := Payment{UserID: 1, Amount: 50, Currency: "INR", Status: "created"}
+	require.NoError(t, db.Create(&unpaid).Error)
+
+	_, err := IssuePaymentInvoice(db, &unpaid)
+	assert.Error(t, err, "unpaid orders are not invoiced")
+
+	first, err := IssuePaymentInvoice(db, &payments[0])
+	require.NoError(t, err)
+	again, err := IssuePaymentInvoice(db, &payments[0])
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID, "a payment keeps its invoice")
+
+	second, err := IssuePaymentInvoice(db, &payments[1])
+	require.NoError(t, err)
+	note, err := IssueCreditNote(db, first, 40, 0, "partial refund")
+	require.NoError(t, err)
+	_, err = IssueCreditNote(db, first, 70, 0, "too much")
+	assert.EqualError(t, err, "credit exceeds the invoice total")
+	third, err := IssuePaymentInvoice(db, &payments[2])
+	require.NoError(t, err)
+
+	// Invoices and credit notes number separately and without gaps, even
+	// though the rejected credit note and unpaid order were attempted
+	assert.Equal(t, "INV/"+year+"/000001", first.Number)
+	assert.Equal(t, "INV/"+year+"/000002", second.Number)
+	assert.Equal(t, "INV/"+year+"/000003", third.Number)
+	assert.Equal(t, "CN/"+year+"/000001", note.Number)
+
+	// The database refuses a second invoice for the same payment, while
+	// several credit notes against it are fine
+	dupe := Invoice{Number: "INV/dupe", Type: InvoiceTypeInvoice, UserID: 1, PaymentID: first.PaymentID}
+	assert.Error(t, db.Create(&dupe).Error)
+	_, err = IssueCreditNote(db, first, 60, 0, "rest")
+	assert.NoError(t, err)
+}
+
+func TestVerifyRazorpayPaymentIsIdempotent(t *testing.T) {
+	setupInvoiceDB(t)
+	gin.SetMode(gin.TestMode)
+	t.Setenv("RAZORPAY_KEY_SECRET", "secret")
+
+	open := Payment{UserID: 1, OrderID: "order_open", Amount: 100, Currency: "INR", Status: "created"}
+	expired := Payment{UserID: 1, OrderID: "order_expired", Amount: 100, Currency: "INR", Status: "expired"}
+	require.NoError(t, db.Create(&open).Error)
+	require.NoError(t, db.Create(&expired).Error)
+
+	verify := func(orderID string) int {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(orderID + "|pay_1"))
+		body, _ := json.Marshal(VerifyPaymentInput{OrderID: orderID, RazorpayPaymentID: "pay_1", RazorpaySignature: hex.EncodeToString(mac.Sum(nil))})
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/payments/verify", bytes.NewReader(body))
+		VerifyRazorpayPayment(c)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, verify("order_open"))
+	assert.Equal(t, http.StatusOK, verify("order_open"))
+	assert.Equal(t, http.StatusConflict, verify("order_expired"))
+
+	var invoices int64
+	db.Model(&Invoice{}).Count(&invoices)
+	assert.Equal(t, int64(1), invoices, "the retry did not issue another invoice")
+	db.First(&expired, expired.ID)
+	assert.Equal(t, "expired", expired.Status)
+}
+
+func TestGetAppointmentInvoiceChecksThePayment(t *testing.T) {
+	setupAppointmentDB(t) // User 1 is the client
+	require.NoError(t, db.Create(&User{Name: "Someone Else", Email: "else@example.com", TaxID: "GB123456789"}).Error)
+
+	own := Payment{UserID: 1, OrderID: "order_1", Amount: 80, Currency: "AUD", Status: "success", ProductType: ProductService}
+	foreign := Payment{UserID: 2, OrderID: "order_2", Amount: 80, Currency: "AUD", Status: "success", ProductType: ProductService}
+	giftCard := Payment{UserID: 1, OrderID: "order_3", Amount: 80, Currency: "AUD", Status: "success", ProductType: ProductGiftCard}
+	for _, p := range []*Payment{&own, &foreign, &giftCard} {
+		require.NoError(t, db.Create(p).Error)
+	}
+
+	cases := []struct {
+		name    string
+		payment uint
+		want    int
+	}{
+		{"another user's payment", foreign.ID, http.StatusNotFound},
+		{"payment for another product", giftCard.ID, http.StatusNotFound},
+		{"missing payment", 999, http.StatusNotFound},
+		{"the client's service payment", own.ID, http.StatusOK},
+	}
+	for i, tc := range cases {
+		paymentID := tc.payment
+		appointment := Appointment{ID: uuid.New(), ReferenceCode: fmt.Sprintf("APT-%d", i), ClientID: 1,
+			ProfessionalID: uuid.New(), ServiceID: uuid.New(), Status: "confirmed", PaymentStatus: "paid", PaymentID: &paymentID}
+		require.NoError(t, db.Create(&appointment).Error)
+		w := callHandler(GetAppointmentInvoice, gin.Params{{Key: "id", Value: appointment.ID.String()}}, nil)
+		assert.Equal(t, tc.want, w.Code, tc.name)
+	}
+
+	// Nothing was invoiced against the payments that aren't the client's
+	var invoiced int64
+	db.Model(&Invoice{}).Where("payment_id IN ?", []uint{foreign.ID, giftCard.ID}).Count(&invoiced)
+	assert.Zero(t, invoiced)
+}
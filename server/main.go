@@ -18,6 +18,9 @@ import (
 // DB setup placeholder
 var db *gorm.DB
 
+// Frontend origins allowed to call the API from a browser
+var corsOrigins = []string{"http://localhost:5173", "http://localhost:3000"}
+
 func main() {
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
@@ -64,11 +67,55 @@ func main() {
 		&Program{},
 		&ProgramEnrollment{},
 		&PracticeSession{},
+		// Promotions
+		&PromoCode{},
+		&PromoRedemption{},
+		// Class Packs & Credits
+		&ClassPack{},
+		&CreditLedgerEntry{},
+		// Tax
+		&TaxRule{},
+		&PaymentTaxLine{},
+		&Invoice{},
+		&InvoiceLine{},
+		&InvoiceSequence{},
+		&ReconciliationRun{},
+		&ReconciliationDiscrepancy{},
+		&Household{},
+		&HouseholdMember{},
+		&MembershipFreeze{},
+		&MembershipHistory{},
+		&SeatHold{},
+		&InstalmentPlan{},
+		&Instalment{},
+		&Wallet{},
+		&WalletTransaction{},
+		&GiftCard{},
+		&DailyExchangeRate{},
+		&CurrencyRoundingRule{},
+		&PriceOverride{},
+		&OutboxEmail{},
+		&NotificationPreference{},
+		&NotificationSettings{},
+		&PushSubscription{},
+		&Notification{},
+		&SentReminder{},
+		&EmailTemplate{},
+		&EmailSuppression{},
+		&CalendarFeed{},
+		&CalendarConnection{},
+		&ExternalCalendarObject{},
+		&ExternalBusyBlock{},
+		&AppointmentCalendarEvent{},
+		&CalendarSyncConflict{},
+		&Conversation{},
+		&ReviewReport{},
 		// Marketplace Models
 		&Professional{},
 		&ProfessionalCertification{},
 		&Service{},
 		&ProfessionalAvailability{},
+		&ProfessionalTimeOff{},
 		&Appointment{},
 		&Review{},
 		&Message{},
@@ -85,13 +132,21 @@ func main() {
 
 	// Seed Data
 	SeedPrograms()
+	SeedInstalmentPlans()
+	SeedClassPacks()
+	SeedTaxRules()
+	SeedRoundingRules()
+	BackfillCreditLedger()
 
 	// Initialize Router
 	r := gin.Default()
+	if err := useTrustedProxies(r); err != nil {
+		panic("invalid TRUSTED_PROXIES: " + err.Error())
+	}
 
 	// CORS Configuration
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:5173", "http://localhost:3000"},
+		AllowOrigins:     corsOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
 		ExposeHeaders:    []string{"Content-Length", "Content-Disposition"},
@@ -118,9 +173,13 @@ func main() {
 	r.GET("/classes", GetClasses)
 	r.GET("/classes/:id", GetClass)
 
+	// Public Class Pack Routes
+	r.GET("/api/class-packs", GetClassPacks)
+
 	// Public Program Routes
 	r.GET("/api/programs", GetPrograms)
 	r.GET("/api/programs/:id", GetProgram)
+	r.GET("/api/programs/:id/instalment-plans", GetProgramInstalmentPlans)
 
 	// Protected Routes (User)
 	userRoutes := r.Group("/user")
@@ -140,6 +199,8 @@ func main() {
 		userRoutes.GET("/appointments/:id", GetAppointmentByID)
 		userRoutes.PUT("/appointments/:id", RescheduleAppointment)
 		userRoutes.DELETE("/appointments/:id", CancelAppointment)
+		userRoutes.GET("/appointments/:id/invoice", GetAppointmentInvoice)
+		userRoutes.POST("/appointments/:id/review", CreateReview)
 	}
 
 	// Initialize Razorpay
@@ -148,6 +209,9 @@ func main() {
 	// Initialize PayPal
 	initPayPal()
 
+	// Load the offline GeoIP database
+	initGeoIP()
+
 	// Payment Routes (Protected)
 	paymentRoutes := r.Group("/api/payments")
 	paymentRoutes.Use(AuthMiddleware())
@@ -165,6 +229,21 @@ func main() {
 		paymentRoutes.GET("/:id/invoice", GenerateInvoice)
 	}
 
+	// Invoices & Credit Notes (Protected)
+	invoiceRoutes := r.Group("/api/invoices")
+	invoiceRoutes.Use(AuthMiddleware())
+	{
+		invoiceRoutes.GET("", GetMyInvoices)
+		invoiceRoutes.GET("/:id/pdf", DownloadInvoice)
+	}
+
+	// Promo Codes (Protected)
+	promoRoutes := r.Group("/api/promo-codes")
+	promoRoutes.Use(AuthMiddleware())
+	{
+		promoRoutes.POST("/validate", ValidatePromo)
+	}
+
 	// Public endpoints for payment config
 	r.GET("/api/payments/config", GetRazorpayKey)
 	r.GET("/api/payments/paypal/config", GetPayPalConfig)
@@ -176,10 +255,30 @@ func main() {
 		membershipRoutes.POST("/purchase", PurchaseMembership)
 		membershipRoutes.GET("/my", GetMyMemberships)
 		membershipRoutes.GET("/validate", ValidateMembership)
+		membershipRoutes.GET("/:id/ledger", GetMembershipLedger)
+		membershipRoutes.POST("/:id/share", ShareMembership)
+		membershipRoutes.DELETE("/:id/share", UnshareMembership)
+		membershipRoutes.POST("/:id/freeze", RequestMembershipFreeze)
+		membershipRoutes.DELETE("/:id/freeze/:freezeId", CancelMembershipFreeze)
+		membershipRoutes.GET("/:id/history", GetMembershipHistory)
+	}
+
+	// Household Routes (Protected)
+	householdRoutes := r.Group("/api/household")
+	householdRoutes.Use(AuthMiddleware())
+	{
+		householdRoutes.POST("", CreateHousehold)
+		householdRoutes.GET("", GetMyHousehold)
+		householdRoutes.GET("/usage", GetHouseholdUsage)
+		householdRoutes.POST("/invites", InviteHouseholdMember)
+		householdRoutes.POST("/invites/:token/accept", AcceptHouseholdInvite)
+		householdRoutes.PUT("/members/:id", UpdateHouseholdMember)
+		householdRoutes.DELETE("/members/:id", RemoveHouseholdMember)
 	}
 
 	// Initialize Email Worker
 	InitEmailService()
+	initNotificationProviders()
 
 	// --- Routes ---
 	r.POST("/api/emails/test", func(c *gin.Context) {
@@ -191,7 +290,10 @@ func main() {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		SendWelcomeEmail(input.To, "Test User")
+		if err := SendWelcomeEmail(db, input.To, "Test User"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{"message": "Test email queued"})
 	})
 
@@ -201,25 +303,176 @@ func main() {
 	{
 		programRoutes.POST("/enroll", EnrollProgram)
 		programRoutes.GET("/my", GetMyEnrollments)
+		programRoutes.GET("/:id/access", GetProgramAccess)
+		programRoutes.GET("/enrollments/:id/invoice", GetEnrollmentInvoice)
+		programRoutes.GET("/enrollments/:id/instalments", GetEnrollmentInstalments)
+		programRoutes.POST("/enrollments/:id/instalments/:instalmentId/pay", PayInstalment)
 	}
 
 	// Start Background Job for Expiry
 	go func() {
 		for {
-			// Update status of memberships that have passed their end date
-			result := db.Model(&Membership{}).
-				Where("status = ? AND end_date < ?", "active", time.Now()).
-				Update("status", "expired")
+			// Start and finish freezes first so frozen memberships are not expired
+			if started, finished := ProcessMembershipFreezes(); started+finished > 0 {
+				fmt.Printf("Background Job: Started %d and finished %d membership freezes\n", started, finished)
+			}
 
-			if result.RowsAffected > 0 {
-				fmt.Printf("Background Job: Expired %d memberships\n", result.RowsAffected)
+			// Expire memberships that have passed their end date and write off unused credits
+			if expired := ExpireMemberships(); expired > 0 {
+				fmt.Printf("Background Job: Expired %d memberships\n", expired)
+			}
+
+			// Instalment reminders, overdue flags and access suspension
+			if reminded, overdue, suspended := ProcessInstalments(); reminded+overdue+suspended > 0 {
+				fmt.Printf("Background Job: Sent %d instalment reminders, %d now overdue, %d enrollments suspended\n",
+					reminded, overdue, suspended)
+			}
+
+			// Clear out old sent emails from the outbox
+			if pruned := PruneSentEmails(); pruned > 0 {
+				fmt.Printf("Background Job: Pruned %d sent emails from the outbox\n", pruned)
+			}
+
+			// Refresh daily exchange rates; alerts admins if they go stale
+			if err := FetchExchangeRates(); err != nil {
+				fmt.Println("Background Job: Exchange rates unavailable:", err)
+			}
+
+			// Locate professionals from their city for nearby search
+			if located := GeocodeProfessionals(); located > 0 {
+				fmt.Printf("Background Job: Geocoded %d professionals\n", located)
+			}
+
+			// Keep Bayesian ratings in step with the platform-wide mean
+			if updated := RecomputeWeightedRatings(); updated > 0 {
+				fmt.Printf("Background Job: Recomputed weighted ratings for %d professionals\n", updated)
 			}
 
 			time.Sleep(1 * time.Hour) // Run every hour
-			time.Sleep(1 * time.Hour) // Run every hour
 		}
 	}()
 
+	// Resolve checkouts left pending by abandoned or interrupted payments
+	go func() {
+		for {
+			time.Sleep(10 * time.Minute)
+			if res := SweepStalePayments(); res.Checked > 0 {
+				fmt.Printf("Background Job: Swept %d stale payments (%d succeeded, %d failed, %d expired)\n",
+					res.Checked, res.Succeeded, res.Failed, res.Expired)
+			}
+		}
+	}()
+
+	// Deliver SMS, WhatsApp and push notifications, including ones held for quiet hours,
+	// and tell offline users about unread messages and declined appointments
+	go func() {
+		for {
+			time.Sleep(1 * time.Minute)
+			if settled := SettleDeclinedAppointments(); settled > 0 {
+				fmt.Printf("Background Job: Refunded and notified %d declined appointments\n", settled)
+			}
+			if sent, failed := DeliverNotifications(); sent+failed > 0 {
+				fmt.Printf("Background Job: Delivered %d notifications (%d failed)\n", sent, failed)
+			}
+			if notified := NotifyUnreadMessages(); notified > 0 {
+				fmt.Printf("Background Job: Told %d offline users about unread messages\n", notified)
+			}
+		}
+	}()
+
+	// Class and appointment reminders at each REMINDER_OFFSETS offset
+	go func() {
+		interval := time.Duration(envInt("REMINDER_INTERVAL_MINUTES", 5)) * time.Minute
+		for {
+			if sent := SendDueReminders(); sent > 0 {
+				fmt.Printf("Background Job: Sent %d reminders\n", sent)
+			}
+			time.Sleep(interval)
+		}
+	}()
+
+	// Two-way sync with professionals' CalDAV calendars
+	startCalendarSync()
+
+	// Daily gateway reconciliation for the previous day
+	go func() {
+		for {
+			next := time.Now().UTC().Truncate(24 * time.Hour).Add(24*time.Hour + 2*time.Hour)
+			time.Sleep(time.Until(next))
+			ReconcileYesterday()
+		}
+	}()
+
+	// Notification Routes (Protected)
+	notificationRoutes := r.Group("/api/notifications")
+	notificationRoutes.Use(AuthMiddleware())
+	{
+		notificationRoutes.GET("", GetMyNotifications)
+		notificationRoutes.GET("/preferences", GetNotificationPreferences)
+		notificationRoutes.PUT("/preferences", UpdateNotificationPreferences)
+		notificationRoutes.POST("/push-subscriptions", AddPushSubscription)
+		notificationRoutes.DELETE("/push-subscriptions/:id", RemovePushSubscription)
+		notificationRoutes.POST("/email/resubscribe", ResubscribeMarketingEmail)
+	}
+
+	// Messaging Routes (Protected)
+	messageRoutes := r.Group("/api/messages")
+	r.GET("/api/messages/ws", MessagesWebSocket) // Authenticated by a ticket from /ws-ticket
+	messageRoutes.Use(AuthMiddleware())
+	{
+		messageRoutes.POST("/ws-ticket", CreateWebSocketTicket)
+		messageRoutes.GET("/unread", GetUnreadMessageCount)
+		messageRoutes.GET("/conversations", GetConversations)
+		messageRoutes.POST("/conversations", StartConversation)
+		messageRoutes.GET("/conversations/:id/messages", GetConversationMessages)
+		messageRoutes.POST("/conversations/:id/messages", SendConversationMessage)
+		messageRoutes.POST("/conversations/:id/read", MarkConversationRead)
+	}
+
+	// Calendar Feed Links (Protected)
+	calendarRoutes := r.Group("/api/calendar/feeds")
+	calendarRoutes.Use(AuthMiddleware())
+	{
+		calendarRoutes.GET("", GetCalendarFeeds)
+		calendarRoutes.POST("/:kind/rotate", RotateCalendarFeed)
+	}
+
+	// External Calendar Sync (Protected, professionals only)
+	calendarSyncRoutes := r.Group("/api/professional/calendar")
+	calendarSyncRoutes.Use(AuthMiddleware())
+	{
+		calendarSyncRoutes.GET("", GetCalendarConnection)
+		calendarSyncRoutes.PUT("", ConnectCalendar)
+		calendarSyncRoutes.DELETE("", DisconnectCalendar)
+		calendarSyncRoutes.POST("/sync", SyncCalendarNow)
+		calendarSyncRoutes.GET("/conflicts", GetCalendarConflicts)
+		calendarSyncRoutes.POST("/conflicts/:id/resolve", ResolveCalendarConflict)
+	}
+
+	// Review Routes (Protected): professional replies and reports
+	reviewRoutes := r.Group("/api/reviews")
+	reviewRoutes.Use(AuthMiddleware())
+	{
+		reviewRoutes.POST("/:id/reply", ReplyToReview)
+		reviewRoutes.POST("/:id/report", ReportReview)
+	}
+
+	// Wallet & Gift Card Routes (Protected)
+	walletRoutes := r.Group("/api/wallet")
+	walletRoutes.Use(AuthMiddleware())
+	{
+		walletRoutes.GET("", GetMyWallet)
+		walletRoutes.GET("/transactions", GetMyWalletTransactions)
+		walletRoutes.POST("/checkout", PayWithWallet)
+		walletRoutes.POST("/redeem", RedeemGiftCard)
+	}
+	giftCardRoutes := r.Group("/api/gift-cards")
+	giftCardRoutes.Use(AuthMiddleware())
+	{
+		giftCardRoutes.POST("", CreateGiftCard)
+		giftCardRoutes.GET("", GetMyGiftCards)
+	}
+
 	// AI Practice Routes (Protected)
 	aiRoutes := r.Group("/api/ai-practice")
 	aiRoutes.Use(AuthMiddleware())
@@ -231,6 +484,23 @@ func main() {
 
 	// Currency Routes (Public)
 	r.GET("/api/exchange-rates", GetExchangeRates)
+	r.GET("/api/exchange-rates/:date", GetExchangeRatesOnDate)
+	r.GET("/api/price-list", GetPriceLists)
+	r.GET("/api/price-list/:currency", GetPriceList)
+	r.GET("/api/geo", GetGeoInfo)
+	r.GET("/api/geocode", GeocodePlace)
+	r.GET("/api/nearby", GetNearby)
+
+	// Email unsubscribe (RFC 8058) and provider bounce/complaint events
+	r.GET("/api/email/unsubscribe", GetUnsubscribe)
+	r.POST("/api/email/unsubscribe", PostUnsubscribe)
+	r.POST("/api/email/events", HandleEmailEvents)
+
+	// Calendar feeds: the public timetable, and private feeds by token
+	r.GET("/api/calendar/classes.ics", GetClassTimetableFeed)
+	r.GET("/api/calendar/feed/:token", GetCalendarFeed)
+	r.GET("/api/professionals/:id/busy", GetProfessionalBusyTimes)
+	r.GET("/api/professionals/:id/reviews", GetProfessionalReviews)
 
 	// Webhooks (Public)
 	// r.POST("/api/payments/webhook", WebhookHandler) // Implement later if needed
@@ -251,6 +521,8 @@ func main() {
 		adminRoutes.GET("/bookings", GetAdminBookings)
 		adminRoutes.GET("/users", GetAdminUsers)
 		adminRoutes.PUT("/users/:id/role", UpdateUserRole)
+		adminRoutes.POST("/users/:id/tax-id/verify", VerifyUserTaxID)
+		adminRoutes.DELETE("/users/:id/tax-id/verify", UnverifyUserTaxID)
 
 		// Contact
 		adminRoutes.GET("/contact", GetAdminContact)
@@ -258,6 +530,85 @@ func main() {
 
 		// Program Admin
 		adminRoutes.POST("/programs", CreateProgram)
+		adminRoutes.GET("/programs/:id/instalment-plans", GetAdminInstalmentPlans)
+		adminRoutes.POST("/programs/:id/instalment-plans", CreateInstalmentPlan)
+		adminRoutes.PUT("/instalment-plans/:id", UpdateInstalmentPlan)
+		adminRoutes.DELETE("/instalment-plans/:id", DeleteInstalmentPlan)
+		adminRoutes.POST("/instalments/:id/mark-paid", MarkInstalmentPaid)
+		adminRoutes.GET("/reports/receivables", GetReceivablesReport)
+
+		// Class Packs & Credits
+		adminRoutes.GET("/class-packs", GetAdminClassPacks)
+		adminRoutes.POST("/class-packs", CreateClassPack)
+		adminRoutes.PUT("/class-packs/:id", UpdateClassPack)
+		adminRoutes.DELETE("/class-packs/:id", DeleteClassPack)
+		adminRoutes.GET("/memberships/:id/ledger", GetAdminMembershipLedger)
+		adminRoutes.POST("/memberships/:id/credits", AdjustMembershipCredits)
+		adminRoutes.GET("/memberships/:id/history", GetAdminMembershipHistory)
+		adminRoutes.POST("/memberships/:id/extend", ExtendMembership)
+		adminRoutes.POST("/memberships/:id/transfer", TransferMembership)
+
+		// Invoices & Refunds
+		adminRoutes.GET("/invoices", GetAdminInvoices)
+		adminRoutes.GET("/invoices/:id/pdf", DownloadInvoice)
+		adminRoutes.POST("/payments/:id/refund", RefundPayment)
+		adminRoutes.POST("/payments/sweep", RunPaymentSweep)
+		adminRoutes.GET("/emails", GetOutboxEmails)
+		adminRoutes.GET("/emails/:id", GetOutboxEmail)
+		adminRoutes.POST("/emails/:id/resend", ResendOutboxEmail)
+		adminRoutes.GET("/payments/fraud-review", GetFraudReviewQueue)
+		adminRoutes.POST("/payments/:id/fraud-review", ReviewFlaggedPayment)
+		adminRoutes.GET("/users/:id/wallet", GetAdminUserWallet)
+		adminRoutes.POST("/users/:id/wallet/adjust", AdjustWallet)
+		adminRoutes.GET("/gift-cards", GetAdminGiftCards)
+		adminRoutes.GET("/reports/abandonment", GetAbandonmentReport)
+
+		// Email templates
+		adminRoutes.GET("/email-templates", GetEmailTemplates)
+		adminRoutes.GET("/email-templates/:key", GetEmailTemplate)
+		adminRoutes.POST("/email-templates/:key/versions", CreateEmailTemplateVersion)
+		adminRoutes.POST("/email-templates/:key/versions/:id/activate", ActivateEmailTemplateVersion)
+		adminRoutes.POST("/email-templates/:key/revert", RevertEmailTemplate)
+		adminRoutes.POST("/email-templates/:key/preview", PreviewEmailTemplate)
+		adminRoutes.POST("/email-templates/:key/test-send", TestSendEmailTemplate)
+		adminRoutes.GET("/email-suppressions", GetEmailSuppressions)
+		adminRoutes.POST("/email-suppressions", CreateEmailSuppression)
+		adminRoutes.DELETE("/email-suppressions/:id", DeleteEmailSuppression)
+
+		// Review Moderation
+		adminRoutes.GET("/reviews", GetReviewModerationQueue)
+		adminRoutes.POST("/reviews/:id/hide", HideReview)
+		adminRoutes.POST("/reviews/:id/restore", RestoreReview)
+
+		// Gateway Reconciliation
+		adminRoutes.POST("/reconciliation/runs", StartReconciliation)
+		adminRoutes.GET("/reconciliation/runs", GetReconciliationRuns)
+		adminRoutes.GET("/reconciliation/discrepancies", GetReconciliationReport)
+		adminRoutes.POST("/reconciliation/discrepancies/:id/resolve", ResolveDiscrepancy)
+
+		// Exchange Rates
+		adminRoutes.GET("/exchange-rates/status", GetExchangeRateStatus)
+		adminRoutes.POST("/exchange-rates/refresh", RefreshExchangeRates)
+		adminRoutes.POST("/exchange-rates/:date", BackfillExchangeRates)
+		adminRoutes.GET("/pricing/rounding-rules", GetRoundingRules)
+		adminRoutes.PUT("/pricing/rounding-rules/:currency", SetRoundingRule)
+		adminRoutes.GET("/pricing/overrides", GetPriceOverrides)
+		adminRoutes.POST("/pricing/overrides", SetPriceOverride)
+		adminRoutes.DELETE("/pricing/overrides/:id", DeletePriceOverride)
+
+		// Tax
+		adminRoutes.GET("/tax-rules", GetAdminTaxRules)
+		adminRoutes.POST("/tax-rules", CreateTaxRule)
+		adminRoutes.PUT("/tax-rules/:id", UpdateTaxRule)
+		adminRoutes.DELETE("/tax-rules/:id", DeleteTaxRule)
+		adminRoutes.GET("/reports/tax", GetTaxSummaryReport)
+
+		// Promo Codes
+		adminRoutes.GET("/promo-codes", GetAdminPromoCodes)
+		adminRoutes.POST("/promo-codes", CreatePromoCode)
+		adminRoutes.PUT("/promo-codes/:id", UpdatePromoCode)
+		adminRoutes.DELETE("/promo-codes/:id", DeletePromoCode)
+		adminRoutes.GET("/promo-codes/:id/redemptions", GetPromoRedemptions)
 	}
 
 	// Contact Routes
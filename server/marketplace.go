@@ -53,6 +53,11 @@ type Appointment struct {
 
 	Status string `json:"status"` // pending, confirmed, completed, cancelled, no_show
 
+	// Set when the professional declines; SettleDeclinedAppointments then
+	// refunds the client and tells them
+	DeclinedAt       *time.Time `json:"declined_at" gorm:"index"`
+	DeclineSettledAt *time.Time `json:"-"`
+
 	// Payment
 	PriceChargedCents int    `json:"price_charged_cents"`
 	CurrencyCharged   string `json:"currency_charged"`
@@ -68,6 +73,7 @@ type Appointment struct {
 
 	// Meeting
 	MeetingLink       string `json:"meeting_link"`
+	CalendarSequence  int    `json:"calendar_sequence" gorm:"default:0"` // Raised on each change so calendars replace the event
 	ClientNotes       string `json:"client_notes"`
 	ProfessionalNotes string `json:"professional_notes"`
 
@@ -90,15 +96,28 @@ type Review struct {
 
 	Rating   float64 `json:"rating"` // 1.0 to 5.0
 	Comment  string  `json:"comment"`
-	IsPublic bool    `json:"is_public" gorm:"default:true"`
+	IsPublic bool    `json:"is_public" gorm:"default:true"` // False while hidden by a moderator
+
+	// Moderation
+	ReportCount  int        `json:"report_count" gorm:"default:0"`
+	HiddenReason string     `json:"hidden_reason"`
+	HiddenAt     *time.Time `json:"hidden_at"`
+	HiddenBy     *uint      `json:"hidden_by"`
+
+	// The professional's public reply
+	Reply     string     `json:"reply"`
+	RepliedAt *time.Time `json:"replied_at"`
 
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // Message represents in-platform chat
 type Message struct {
 	ID uuid.UUID `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
 
+	ConversationID uint `json:"conversation_id" gorm:"index"`
+
 	SenderID uint `json:"sender_id" gorm:"not null"`
 	Sender   User `json:"sender" gorm:"foreignKey:SenderID"`
 
@@ -108,8 +127,9 @@ type Message struct {
 	Content       string `json:"content"`
 	AttachmentURL string `json:"attachment_url"`
 
-	IsRead bool      `json:"is_read" gorm:"default:false"`
-	ReadAt time.Time `json:"read_at"`
+	IsRead     bool       `json:"is_read" gorm:"default:false"`
+	ReadAt     *time.Time `json:"read_at"`
+	NotifiedAt *time.Time `json:"-"` // When the recipient was told about it by email or push
 
 	CreatedAt time.Time      `json:"created_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
@@ -19,13 +19,16 @@ func init() {
 type Membership struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
 	UserID    uint      `json:"user_id" gorm:"index"`
-	Type      string    `json:"type"` // "drop_in", "monthly", "quarterly"
+	Type      string    `json:"type"` // ClassPack code, e.g. "drop_in", "10_class", "monthly"
 	StartDate time.Time `json:"start_date"`
 	EndDate   time.Time `json:"end_date"`
-	Credits   int       `json:"credits"`                       // 1 for drop-in, -1 for unlimited
-	Status    string    `json:"status"`                        // "active", "expired"
+	Credits   int       `json:"credits"`                       // Cached ledger balance, -1 for unlimited
+	Status    string    `json:"status"`                        // "active", "frozen", "expired"
 	PaymentID uint      `json:"payment_id" gorm:"uniqueIndex"` // One membership per payment
 
+	// Shared with the owner's household when set (see Household)
+	HouseholdID *uint `json:"household_id" gorm:"index"`
+
 	// Multi-Currency Audit
 	BasePriceAUD    float64 `json:"base_price_aud"`
 	ChargedAmount   float64 `json:"charged_amount"`
@@ -39,24 +42,36 @@ type Membership struct {
 
 // --- DTO ---
 type PurchaseMembershipInput struct {
-	PackageType string `json:"package_type" binding:"required"` // drop_in, monthly, quarterly
+	PackageType string `json:"package_type" binding:"required"` // ClassPack code
 	PaymentID   uint   `json:"payment_id" binding:"required"`
+	Shared      bool   `json:"shared"` // Share with the buyer's household
 }
 
 // --- Logic ---
 
-func CalculateMembership(pkgType string) (time.Time, time.Time, int, error) {
+// CalculateMembership resolves a pack code into the pack and its validity dates
+func CalculateMembership(tx *gorm.DB, pkgType string) (ClassPack, time.Time, time.Time, error) {
 	now := time.Now()
-	switch pkgType {
-	case "drop_in":
-		return now, now.AddDate(0, 0, 1), 1, nil // 1 Day val
-	case "monthly":
-		return now, now.AddDate(0, 1, 0), -1, nil // 30 Days val, Unlimited
-	case "quarterly":
-		return now, now.AddDate(0, 3, 0), -1, nil // 90 Days val, Unlimited
-	default:
-		return now, now, 0, errors.New("invalid package type")
+	var pack ClassPack
+	if err := tx.Where("code = ? AND is_active = ?", pkgType, true).First(&pack).Error; err != nil {
+		return pack, now, now, errors.New("invalid package type")
 	}
+	start, end := pack.Validity(now)
+	return pack, start, end, nil
+}
+
+// checkMembershipPayment makes sure a payment was a completed checkout for
+// this very pack, so a cheaper or unpaid order can't buy a dearer pack
+func checkMembershipPayment(payment *Payment, uid uint, pack *ClassPack) error {
+	switch {
+	case payment.UserID != uid:
+		return errors.New("payment belongs to another user")
+	case payment.Status != "success":
+		return errors.New("payment not successful")
+	case payment.ProductType != ProductMembership || payment.ProductID != pack.ID:
+		return errors.New("payment was not for this package")
+	}
+	return nil
 }
 
 // --- Handlers ---
@@ -70,19 +85,7 @@ func PurchaseMembership(c *gin.Context) {
 		return
 	}
 
-	// Transaction variables to hold data for email
-	var paidAmount float64
-	var orderID string
-
 	err := db.Transaction(func(tx *gorm.DB) error {
-		// 1. Verify Payment
-		var payment Payment
-		if err := tx.First(&payment, input.PaymentID).Error; err != nil {
-			return errors.New("payment not found")
-		}
-		paidAmount = payment.Amount
-		orderID = payment.OrderID
-
 		// Safe UID conversion
 		var uid uint
 		if idFloat, ok := userID.(float64); ok {
@@ -93,33 +96,38 @@ func PurchaseMembership(c *gin.Context) {
 			return errors.New("invalid user id")
 		}
 
-		if payment.UserID != uid {
-			return errors.New("payment belongs to another user")
+		// 1. Calculate details
+		pack, start, end, err := CalculateMembership(tx, input.PackageType)
+		if err != nil {
+			return err
+		}
+
+		// 2. Verify Payment and mark it spent
+		payment, err := ConsumePayment(tx, input.PaymentID, "membership", func(p *Payment) error {
+			return checkMembershipPayment(p, uid, &pack)
+		})
+		if err != nil {
+			return err
 		}
-		// In production, uncomment checking for success status
-		// if payment.Status != "success" {
-		// 	return errors.New("payment not successful")
-		// }
 
-		// Check if payment already used for a membership
+		// Payments spent before ConsumedAt was recorded
 		var existingMem Membership
 		if err := tx.Where("payment_id = ?", input.PaymentID).First(&existingMem).Error; err == nil {
 			return errors.New("payment already used for membership")
 		}
 
-		// 2. Calculate details
-		start, end, credits, err := CalculateMembership(input.PackageType)
-		if err != nil {
-			return err
-		}
-
 		// 3. Create Membership with Currency info
+		// Credit packs start at zero and are funded by a ledger grant below
+		startingCredits := 0
+		if pack.Credits == -1 {
+			startingCredits = -1
+		}
 		membership := Membership{
 			UserID:          uid,
 			Type:            input.PackageType,
 			StartDate:       start,
 			EndDate:         end,
-			Credits:         credits,
+			Credits:         startingCredits,
 			Status:          "active",
 			PaymentID:       input.PaymentID,
 			BasePriceAUD:    payment.BaseAmountAUD,
@@ -128,11 +136,39 @@ func PurchaseMembership(c *gin.Context) {
 			ExchangeRate:    payment.ExchangeRate,
 		}
 
+		if input.Shared {
+			var household Household
+			if err := tx.Where("owner_id = ?", uid).First(&household).Error; err != nil {
+				return errors.New("only a household's primary payer can buy a shared membership")
+			}
+			membership.HouseholdID = &household.ID
+		}
+
 		if err := tx.Create(&membership).Error; err != nil {
 			return err
 		}
 
-		return nil
+		if err := RecordCreditEntry(tx, &membership, CreditLedgerEntry{
+			UserID: uid,
+			Type:   CreditGrant,
+			Delta:  pack.Credits,
+			Reason: "purchased " + input.PackageType,
+		}); err != nil {
+			return err
+		}
+
+		// Payment receipt goes out only if the membership is saved
+		var user User
+		tx.First(&user, uid)
+		amount := fmt.Sprintf("%.2f %s", payment.Total(), payment.Currency)
+		return Notify(tx, uid, EventPaymentReceipt, NotificationContent{
+			Title: "Payment received",
+			Body:  fmt.Sprintf("Thank you for your payment of %s (order %s).", amount, payment.OrderID),
+			URL:   frontendURL() + "/profile",
+			Email: func(tx *gorm.DB, to string) error {
+				return SendPaymentReceipt(tx, to, user.Name, amount, payment.OrderID)
+			},
+		})
 	})
 
 	if err != nil {
@@ -140,21 +176,6 @@ func PurchaseMembership(c *gin.Context) {
 		return
 	}
 
-	// Send Payment Receipt Email (Async)
-	go func() {
-		// fetch User to get email
-		var user User
-		var uid uint
-		if idFloat, ok := userID.(float64); ok {
-			uid = uint(idFloat)
-		} else if idUint, ok := userID.(uint); ok {
-			uid = idUint
-		}
-		if db.First(&user, uid).Error == nil {
-			SendPaymentReceipt(user.Email, user.Name, fmt.Sprintf("%.2f", paidAmount), orderID)
-		}
-	}()
-
 	c.JSON(http.StatusCreated, gin.H{"message": "Membership purchased successfully"})
 }
 
@@ -168,16 +189,21 @@ func GetMyMemberships(c *gin.Context) {
 
 // ValidateMembership - Protected - Check if user has active membership/credits
 func ValidateMembership(c *gin.Context) {
-	userID, _ := c.Get("userID")
-
-	var activeMember Membership
-	// Find first active membership that is not expired and has credits (or unlimited)
-	err := db.Where("user_id = ? AND status = ? AND end_date > ?", userID, "active", time.Now()).
-		Where("credits != 0"). // Credits must be > 0 OR -1
-		Order("end_date asc"). // Use the one expiring soonest
-		First(&activeMember).Error
+	uid, _ := currentUserID(c)
+
+	// Find first active membership, own or shared, that is not expired, has
+	// credits (or unlimited) and is within the member's household limits
+	memberships, err := BookableMemberships(db, uid)
+	var activeMember *Membership
+	for i := range memberships {
+		mem := &memberships[i]
+		if mem.EndDate.After(time.Now()) && mem.Credits != 0 && CheckHouseholdLimits(db, mem, uid) == nil {
+			activeMember = mem
+			break
+		}
+	}
 
-	if err != nil {
+	if err != nil || activeMember == nil {
 		c.JSON(http.StatusOK, gin.H{
 			"valid":       false,
 			"can_book":    false,
@@ -195,5 +221,6 @@ func ValidateMembership(c *gin.Context) {
 		"type":        activeMember.Type,
 		"credits":     activeMember.Credits,
 		"expiry_date": activeMember.EndDate,
+		"shared":      activeMember.UserID != uid,
 	})
 }
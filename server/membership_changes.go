@@ -0,0 +1,501 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// History actions
+const (
+	HistoryFreeze       = "freeze"
+	HistoryFreezeCancel = "freeze_cancelled"
+	HistoryExtend       = "extend"
+	HistoryTransfer     = "transfer"
+)
+
+// --- Models ---
+
+// MembershipFreeze pauses a membership. The EndDate is pushed out by Days
+// when the freeze is requested and pulled back if it is cancelled early, in
+// which case Days keeps only the days that were used.
+type MembershipFreeze struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	MembershipID uint      `json:"membership_id" gorm:"index"`
+	UserID       uint      `json:"user_id" gorm:"index"`
+	StartDate    time.Time `json:"start_date"`
+	EndDate      time.Time `json:"end_date"`
+	Days         int       `json:"days"`
+	Reason       string    `json:"reason"`
+	Status       string    `json:"status"` // scheduled, active, completed, cancelled
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// MembershipHistory is an audit trail of freezes, extensions and transfers
+type MembershipHistory struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	MembershipID uint      `json:"membership_id" gorm:"index"`
+	Action       string    `json:"action"` // freeze, freeze_cancelled, extend, transfer
+	FromUserID   uint      `json:"from_user_id"`
+	ToUserID     *uint     `json:"to_user_id"` // Transfers only
+	OldEndDate   time.Time `json:"old_end_date"`
+	NewEndDate   time.Time `json:"new_end_date"`
+	Days         int       `json:"days"`
+	FreezeID     *uint     `json:"freeze_id"`
+	Reason       string    `json:"reason"`
+	CreatedBy    uint      `json:"created_by"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// --- DTOs ---
+type FreezeMembershipInput struct {
+	StartDate string `json:"start_date"` // YYYY-MM-DD, defaults to today
+	Days      int    `json:"days" binding:"required"`
+	Reason    string `json:"reason"`
+}
+
+type ExtendMembershipInput struct {
+	Days   int    `json:"days" binding:"required"`
+	Reason string `json:"reason" binding:"required"`
+}
+
+type TransferMembershipInput struct {
+	ToUserID uint   `json:"to_user_id"`
+	ToEmail  string `json:"to_email"`
+	Reason   string `json:"reason" binding:"required"`
+}
+
+// --- Freeze Policy ---
+
+type freezePolicy struct {
+	MinDays        int
+	MaxDays        int
+	MaxPerTerm     int
+	MaxDaysPerTerm int
+}
+
+func envInt(key string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v >= 0 {
+		return v
+	}
+	return fallback
+}
+
+func loadFreezePolicy() freezePolicy {
+	return freezePolicy{
+		MinDays:        envInt("MEMBERSHIP_FREEZE_MIN_DAYS", 7),
+		MaxDays:        envInt("MEMBERSHIP_FREEZE_MAX_DAYS", 30),
+		MaxPerTerm:     envInt("MEMBERSHIP_FREEZES_PER_TERM", 2),
+		MaxDaysPerTerm: envInt("MEMBERSHIP_FREEZE_DAYS_PER_TERM", 60),
+	}
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+func recordMembershipHistory(tx *gorm.DB, entry MembershipHistory) error {
+	return tx.Create(&entry).Error
+}
+
+// --- Logic ---
+
+// FreezeMembership validates a freeze request against the policy and pushes
+// the membership's EndDate out by the frozen days
+func FreezeMembership(tx *gorm.DB, mem *Membership, uid uint, start time.Time, days int, reason string) (*MembershipFreeze, error) {
+	policy := loadFreezePolicy()
+
+	if mem.Status != "active" && mem.Status != "frozen" {
+		return nil, errors.New("only active memberships can be frozen")
+	}
+	if days < policy.MinDays || days > policy.MaxDays {
+		return nil, fmt.Errorf("freezes must be between %d and %d days", policy.MinDays, policy.MaxDays)
+	}
+	today := startOfDay(time.Now())
+	if start.Before(today) {
+		return nil, errors.New("freezes cannot start in the past")
+	}
+	if !start.Before(mem.EndDate) {
+		return nil, errors.New("freeze must start before the membership ends")
+	}
+
+	// Freezes cancelled after they started still used their days
+	var existing []MembershipFreeze
+	tx.Where("membership_id = ? AND (status != ? OR days > 0)", mem.ID, "cancelled").Find(&existing)
+	if len(existing) >= policy.MaxPerTerm {
+		return nil, fmt.Errorf("this membership has already used its %d freezes", policy.MaxPerTerm)
+	}
+	usedDays := 0
+	end := start.AddDate(0, 0, days)
+	for _, f := range existing {
+		usedDays += f.Days
+		if start.Before(f.EndDate) && f.StartDate.Before(end) {
+			return nil, errors.New("freeze overlaps an existing freeze")
+		}
+	}
+	if usedDays+days > policy.MaxDaysPerTerm {
+		return nil, fmt.Errorf("freezes are limited to %d days per term", policy.MaxDaysPerTerm)
+	}
+
+	freeze := MembershipFreeze{
+		MembershipID: mem.ID,
+		UserID:       uid,
+		StartDate:    start,
+		EndDate:      end,
+		Days:         days,
+		Reason:       reason,
+		Status:       "scheduled",
+	}
+	if !start.After(time.Now()) {
+		freeze.Status = "active"
+	}
+	if err := tx.Create(&freeze).Error; err != nil {
+		return nil, err
+	}
+
+	oldEnd := mem.EndDate
+	mem.EndDate = mem.EndDate.AddDate(0, 0, days)
+	updates := map[string]interface{}{"end_date": mem.EndDate}
+	if freeze.Status == "active" {
+		mem.Status = "frozen"
+		updates["status"] = "frozen"
+	}
+	if err := tx.Model(mem).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+
+	return &freeze, recordMembershipHistory(tx, MembershipHistory{
+		MembershipID: mem.ID,
+		Action:       HistoryFreeze,
+		FromUserID:   mem.UserID,
+		OldEndDate:   oldEnd,
+		NewEndDate:   mem.EndDate,
+		Days:         days,
+		FreezeID:     &freeze.ID,
+		Reason:       reason,
+		CreatedBy:    uid,
+	})
+}
+
+// CancelFreeze ends a freeze early and gives back the days not used
+func CancelFreeze(tx *gorm.DB, mem *Membership, freeze *MembershipFreeze, uid uint) error {
+	if freeze.Status != "scheduled" && freeze.Status != "active" {
+		return errors.New("freeze is no longer active")
+	}
+
+	unused := freeze.Days
+	if freeze.Status == "active" {
+		used := int(time.Since(freeze.StartDate).Hours()/24) + 1
+		unused = freeze.Days - used
+		if unused < 0 {
+			unused = 0
+		}
+		freeze.EndDate = time.Now()
+	}
+	freeze.Days -= unused
+	freeze.Status = "cancelled"
+	if err := tx.Save(freeze).Error; err != nil {
+		return err
+	}
+
+	oldEnd := mem.EndDate
+	mem.EndDate = mem.EndDate.AddDate(0, 0, -unused)
+	updates := map[string]interface{}{"end_date": mem.EndDate}
+	if mem.Status == "frozen" {
+		mem.Status = "active"
+		updates["status"] = "active"
+	}
+	if err := tx.Model(mem).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	return recordMembershipHistory(tx, MembershipHistory{
+		MembershipID: mem.ID,
+		Action:       HistoryFreezeCancel,
+		FromUserID:   mem.UserID,
+		OldEndDate:   oldEnd,
+		NewEndDate:   mem.EndDate,
+		Days:         -unused,
+		FreezeID:     &freeze.ID,
+		CreatedBy:    uid,
+	})
+}
+
+// ProcessMembershipFreezes starts scheduled freezes and thaws finished ones.
+// Called from the hourly background job before expiry runs.
+func ProcessMembershipFreezes() (started int, finished int) {
+	now := time.Now()
+
+	var due []MembershipFreeze
+	db.Where("status = ? AND start_date <= ?", "scheduled", now).Find(&due)
+	for _, f := range due {
+		db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&f).Update("status", "active").Error; err != nil {
+				return err
+			}
+			started++
+			return tx.Model(&Membership{}).Where("id = ? AND status = ?", f.MembershipID, "active").
+				Update("status", "frozen").Error
+		})
+	}
+
+	var ended []MembershipFreeze
+	db.Where("status = ? AND end_date <= ?", "active", now).Find(&ended)
+	for _, f := range ended {
+		db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&f).Update("status", "completed").Error; err != nil {
+				return err
+			}
+			finished++
+			return tx.Model(&Membership{}).Where("id = ? AND status = ?", f.MembershipID, "frozen").
+				Update("status", "active").Error
+		})
+	}
+	return started, finished
+}
+
+// --- Handlers ---
+
+// RequestMembershipFreeze - Protected - Pause one of the caller's memberships
+func RequestMembershipFreeze(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var input FreezeMembershipInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	start := time.Now()
+	if input.StartDate != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", input.StartDate, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid start_date"})
+			return
+		}
+		if parsed.After(start) {
+			start = parsed
+		}
+	}
+
+	var mem Membership
+	var freeze *MembershipFreeze
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Locked so concurrent requests can't both fit under the per-term limits
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", c.Param("id"), uid).First(&mem).Error; err != nil {
+			return errors.New("membership not found")
+		}
+		var err error
+		freeze, err = FreezeMembership(tx, &mem, uid, start, input.Days, input.Reason)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Membership frozen", "freeze": freeze, "membership": mem})
+}
+
+// CancelMembershipFreeze - Protected - End a freeze early
+func CancelMembershipFreeze(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var mem Membership
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", c.Param("id"), uid).First(&mem).Error; err != nil {
+			return errors.New("membership not found")
+		}
+		var freeze MembershipFreeze
+		if err := tx.Where("id = ? AND membership_id = ?", c.Param("freezeId"), mem.ID).First(&freeze).Error; err != nil {
+			return errors.New("freeze not found")
+		}
+		return CancelFreeze(tx, &mem, &freeze, uid)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Freeze cancelled", "membership": mem})
+}
+
+// GetMembershipHistory - Protected - Freezes and changes for one of the caller's memberships
+func GetMembershipHistory(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var mem Membership
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), uid).First(&mem).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Membership not found"})
+		return
+	}
+	respondWithMembershipHistory(c, mem)
+}
+
+// GetAdminMembershipHistory - Admin - Freezes and changes for any membership
+func GetAdminMembershipHistory(c *gin.Context) {
+	var mem Membership
+	if err := db.First(&mem, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Membership not found"})
+		return
+	}
+	respondWithMembershipHistory(c, mem)
+}
+
+func respondWithMembershipHistory(c *gin.Context, mem Membership) {
+	var freezes []MembershipFreeze
+	db.Where("membership_id = ?", mem.ID).Order("start_date desc").Find(&freezes)
+
+	var history []MembershipHistory
+	db.Where("membership_id = ?", mem.ID).Order("created_at desc, id desc").Find(&history)
+
+	policy := loadFreezePolicy()
+	c.JSON(http.StatusOK, gin.H{
+		"membership": mem,
+		"freezes":    freezes,
+		"history":    history,
+		"policy": gin.H{
+			"min_days":          policy.MinDays,
+			"max_days":          policy.MaxDays,
+			"max_per_term":      policy.MaxPerTerm,
+			"max_days_per_term": policy.MaxDaysPerTerm,
+		},
+	})
+}
+
+// ExtendMembership - Admin - Push a membership's end date out with a reason
+func ExtendMembership(c *gin.Context) {
+	adminID, _ := currentUserID(c)
+
+	var input ExtendMembershipInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Days <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be positive"})
+		return
+	}
+
+	var mem Membership
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&mem, c.Param("id")).Error; err != nil {
+			return errors.New("membership not found")
+		}
+
+		oldEnd := mem.EndDate
+		base := mem.EndDate
+		if base.Before(time.Now()) {
+			base = time.Now() // Lapsed memberships are extended from today
+		}
+		mem.EndDate = base.AddDate(0, 0, input.Days)
+		updates := map[string]interface{}{"end_date": mem.EndDate}
+		if mem.Status == "expired" && mem.Credits != 0 {
+			mem.Status = "active"
+			updates["status"] = "active"
+		}
+		if err := tx.Model(&mem).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		return recordMembershipHistory(tx, MembershipHistory{
+			MembershipID: mem.ID,
+			Action:       HistoryExtend,
+			FromUserID:   mem.UserID,
+			OldEndDate:   oldEnd,
+			NewEndDate:   mem.EndDate,
+			Days:         input.Days,
+			Reason:       input.Reason,
+			CreatedBy:    adminID,
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Membership extended", "membership": mem})
+}
+
+// TransferMembership - Admin - Move the remainder of a membership to another user
+func TransferMembership(c *gin.Context) {
+	adminID, _ := currentUserID(c)
+
+	var input TransferMembershipInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var recipient User
+	var lookup error
+	switch {
+	case input.ToUserID > 0:
+		lookup = db.First(&recipient, input.ToUserID).Error
+	case input.ToEmail != "":
+		lookup = db.Where("LOWER(email) = ?", strings.ToLower(strings.TrimSpace(input.ToEmail))).First(&recipient).Error
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to_user_id or to_email is required"})
+		return
+	}
+	if lookup != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recipient not found"})
+		return
+	}
+
+	var mem Membership
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&mem, c.Param("id")).Error; err != nil {
+			return errors.New("membership not found")
+		}
+		if mem.Status == "expired" {
+			return errors.New("expired memberships cannot be transferred")
+		}
+		if mem.UserID == recipient.ID {
+			return errors.New("membership already belongs to this user")
+		}
+
+		fromUserID := mem.UserID
+		mem.UserID = recipient.ID
+		mem.HouseholdID = nil // Sharing belonged to the previous owner's household
+		if err := tx.Model(&mem).Updates(map[string]interface{}{"user_id": recipient.ID, "household_id": nil}).Error; err != nil {
+			return err
+		}
+		// Scheduled freezes follow the membership to its new owner
+		if err := tx.Model(&MembershipFreeze{}).Where("membership_id = ? AND status IN ?", mem.ID, []string{"scheduled", "active"}).
+			Update("user_id", recipient.ID).Error; err != nil {
+			return err
+		}
+
+		return recordMembershipHistory(tx, MembershipHistory{
+			MembershipID: mem.ID,
+			Action:       HistoryTransfer,
+			FromUserID:   fromUserID,
+			ToUserID:     &recipient.ID,
+			OldEndDate:   mem.EndDate,
+			NewEndDate:   mem.EndDate,
+			Reason:       input.Reason,
+			CreatedBy:    adminID,
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Membership transferred", "membership": mem})
+}
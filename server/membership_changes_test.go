@@ -0,0 +1,153 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreezeMembership(t *testing.T) {
+	setupTestDB(t, &Membership{}, &MembershipFreeze{}, &MembershipHistory{})
+	t.Setenv("MEMBERSHIP_FREEZE_MIN_DAYS", "7")
+	t.Setenv("MEMBERSHIP_FREEZE_MAX_DAYS", "30")
+	t.Setenv("MEMBERSHIP_FREEZES_PER_TERM", "3")
+	t.Setenv("MEMBERSHIP_FREEZE_DAYS_PER_TERM", "50")
+
+	today := startOfDay(time.Now())
+	mem := Membership{UserID: 1, Type: "monthly", PaymentID: 1, Status: "active", EndDate: today.AddDate(0, 3, 0)}
+	require.NoError(t, db.Create(&mem).Error)
+	expired := Membership{UserID: 1, Type: "monthly", PaymentID: 2, Status: "expired", EndDate: today.AddDate(0, 0, -1)}
+	require.NoError(t, db.Create(&expired).Error)
+
+	cases := []struct {
+		name   string
+		mem    *Membership
+		start  time.Time
+		days   int
+		status string
+		err    string
+	}{
+		{"expired membership", &expired, today.AddDate(0, 0, 1), 10, "", "only active memberships can be frozen"},
+		{"too short", &mem, today.AddDate(0, 0, 1), 6, "", "freezes must be between 7 and 30 days"},
+		{"too long", &mem, today.AddDate(0, 0, 1), 31, "", "freezes must be between 7 and 30 days"},
+		{"in the past", &mem, today.AddDate(0, 0, -1), 10, "", "freezes cannot start in the past"},
+		{"after the membership ends", &mem, mem.EndDate, 10, "", "freeze must start before the membership ends"},
+		{"scheduled", &mem, today.AddDate(0, 0, 20), 10, "scheduled", ""},
+		{"overlapping", &mem, today.AddDate(0, 0, 25), 10, "", "freeze overlaps an existing freeze"},
+		{"up to the days per term", &mem, today.AddDate(0, 0, 40), 30, "scheduled", ""},
+		{"over the days per term", &mem, today.AddDate(0, 0, 75), 14, "", "freezes are limited to 50 days per term"},
+		{"rest of the days per term", &mem, today.AddDate(0, 0, 75), 7, "scheduled", ""},
+		{"freezes per term", &mem, today.AddDate(0, 0, 85), 7, "", "this membership has already used its 3 freezes"},
+	}
+	endDate := mem.EndDate
+	for _, tc := range cases {
+		freeze, err := FreezeMembership(db, tc.mem, 1, tc.start, tc.days, "travel")
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, tc.status, freeze.Status, tc.name)
+		endDate = endDate.AddDate(0, 0, tc.days)
+		assert.Equal(t, endDate, mem.EndDate, tc.name)
+	}
+
+	var history int64
+	db.Model(&MembershipHistory{}).Where("membership_id = ?", mem.ID).Count(&history)
+	assert.Equal(t, int64(3), history)
+}
+
+func TestFreezeLifecycle(t *testing.T) {
+	setupTestDB(t, &Membership{}, &MembershipFreeze{}, &MembershipHistory{})
+	t.Setenv("MEMBERSHIP_FREEZE_MIN_DAYS", "1")
+	t.Setenv("MEMBERSHIP_FREEZES_PER_TERM", "5")
+
+	today := startOfDay(time.Now())
+	end := today.AddDate(0, 2, 0)
+	mem := Membership{UserID: 1, Type: "monthly", PaymentID: 1, Status: "active", EndDate: end}
+	require.NoError(t, db.Create(&mem).Error)
+
+	// A freeze starting now takes effect straight away
+	active, err := FreezeMembership(db, &mem, 1, time.Now(), 10, "")
+	require.NoError(t, err)
+	assert.Equal(t, "active", active.Status)
+	assert.Equal(t, "frozen", mem.Status)
+
+	// Cancelling on the first day gives back all but that day
+	require.NoError(t, CancelFreeze(db, &mem, active, 1))
+	assert.Equal(t, "active", mem.Status)
+	assert.Equal(t, end.AddDate(0, 0, 1), mem.EndDate)
+	assert.Equal(t, 1, active.Days)
+	assert.EqualError(t, CancelFreeze(db, &mem, active, 1), "freeze is no longer active")
+
+	// A scheduled freeze returns every day when cancelled
+	scheduled, err := FreezeMembership(db, &mem, 1, today.AddDate(0, 0, 5), 10, "")
+	require.NoError(t, err)
+	require.NoError(t, CancelFreeze(db, &mem, scheduled, 1))
+	assert.Equal(t, end.AddDate(0, 0, 1), mem.EndDate)
+
+	// The background job starts due freezes and thaws finished ones
+	due := MembershipFreeze{MembershipID: mem.ID, UserID: 1, StartDate: time.Now().Add(-time.Hour), EndDate: time.Now().AddDate(0, 0, 7), Days: 7, Status: "scheduled"}
+	require.NoError(t, db.Create(&due).Error)
+	started, finished := ProcessMembershipFreezes()
+	assert.Equal(t, []int{1, 0}, []int{started, finished})
+	db.First(&mem, mem.ID)
+	assert.Equal(t, "frozen", mem.Status)
+
+	db.Model(&due).Update("end_date", time.Now().Add(-time.Minute))
+	started, finished = ProcessMembershipFreezes()
+	assert.Equal(t, []int{0, 1}, []int{started, finished})
+	db.First(&mem, mem.ID)
+	assert.Equal(t, "active", mem.Status)
+}
+
+func TestFreezeCancelCountsTowardLimits(t *testing.T) {
+	setupTestDB(t, &Membership{}, &MembershipFreeze{}, &MembershipHistory{})
+	t.Setenv("MEMBERSHIP_FREEZE_MIN_DAYS", "7")
+	t.Setenv("MEMBERSHIP_FREEZE_MAX_DAYS", "30")
+	t.Setenv("MEMBERSHIP_FREEZE_DAYS_PER_TERM", "60")
+
+	today := startOfDay(time.Now())
+	newMembership := func(paymentID uint) *Membership {
+		mem := Membership{UserID: 1, Type: "monthly", PaymentID: paymentID, Status: "active", EndDate: today.AddDate(0, 6, 0)}
+		require.NoError(t, db.Create(&mem).Error)
+		return &mem
+	}
+	// freezeAndCancel freezes from now and cancels once the freeze has run for
+	// usedDays, keeping those days on the membership
+	freezeAndCancel := func(mem *Membership, usedDays int) error {
+		freeze, err := FreezeMembership(db, mem, 1, time.Now(), 30, "")
+		if err != nil {
+			return err
+		}
+		freeze.StartDate = time.Now().AddDate(0, 0, -usedDays+1)
+		return CancelFreeze(db, mem, freeze, 1)
+	}
+
+	// Every started freeze counts, even when cancelled the same day
+	t.Setenv("MEMBERSHIP_FREEZES_PER_TERM", "2")
+	mem := newMembership(1)
+	end := mem.EndDate
+	for i := 0; i < 2; i++ {
+		require.NoError(t, freezeAndCancel(mem, 1), "cycle %d", i+1)
+	}
+	assert.EqualError(t, freezeAndCancel(mem, 1), "this membership has already used its 2 freezes")
+	assert.Equal(t, end.AddDate(0, 0, 2), mem.EndDate, "each cancelled freeze kept the day it used")
+
+	// Days used by cancelled freezes count toward the days per term
+	t.Setenv("MEMBERSHIP_FREEZES_PER_TERM", "10")
+	mem = newMembership(2)
+	require.NoError(t, freezeAndCancel(mem, 25))
+	require.NoError(t, freezeAndCancel(mem, 25))
+	assert.EqualError(t, freezeAndCancel(mem, 1), "freezes are limited to 60 days per term")
+
+	// Cancelling before the start gives every day back and doesn't count
+	t.Setenv("MEMBERSHIP_FREEZES_PER_TERM", "1")
+	mem = newMembership(3)
+	scheduled, err := FreezeMembership(db, mem, 1, today.AddDate(0, 0, 5), 30, "")
+	require.NoError(t, err)
+	require.NoError(t, CancelFreeze(db, mem, scheduled, 1))
+	require.NoError(t, freezeAndCancel(mem, 1))
+}
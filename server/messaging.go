@@ -0,0 +1,709 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+// Conversation kinds
+const (
+	ConversationAppointment = "appointment" // The client has booked with the professional
+	ConversationInquiry     = "inquiry"     // The client got in touch before booking
+)
+
+// Longest message accepted, in bytes
+const maxMessageLength = 4000
+
+// WebSocket timings
+const (
+	wsTicketTTL    = 30 * time.Second // A ticket must be used this soon after it is issued
+	wsPingInterval = 30 * time.Second
+	wsReadTimeout  = 75 * time.Second // Allowed gap between frames, including pongs
+	wsWriteTimeout = 10 * time.Second
+)
+
+// --- Models ---
+
+// Conversation is the message thread between a client and a professional.
+// Messages (see marketplace.go) belong to one.
+type Conversation struct {
+	ID                 uint       `json:"id" gorm:"primaryKey"`
+	ClientID           uint       `json:"client_id" gorm:"uniqueIndex:idx_conversation;not null"`
+	ProfessionalID     uuid.UUID  `json:"professional_id" gorm:"type:uuid;uniqueIndex:idx_conversation;not null"`
+	ProfessionalUserID uint       `json:"professional_user_id" gorm:"index;not null"`
+	Kind               string     `json:"kind"`
+	LastMessageAt      *time.Time `json:"last_message_at" gorm:"index"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+// --- DTOs ---
+
+type StartConversationInput struct {
+	ProfessionalID string `json:"professional_id"` // Clients start conversations with professionals
+	ClientID       uint   `json:"client_id"`       // Professionals can start one with a client they have an appointment with
+	Content        string `json:"content"`         // Optional first message; required for inquiries
+}
+
+type SendMessageInput struct {
+	Content       string `json:"content"`
+	AttachmentURL string `json:"attachment_url" binding:"omitempty,url"`
+}
+
+// MessageResponse is a message without the preloaded users
+type MessageResponse struct {
+	ID             uuid.UUID  `json:"id"`
+	ConversationID uint       `json:"conversation_id"`
+	SenderID       uint       `json:"sender_id"`
+	RecipientID    uint       `json:"recipient_id"`
+	Content        string     `json:"content"`
+	AttachmentURL  string     `json:"attachment_url"`
+	IsRead         bool       `json:"is_read"`
+	ReadAt         *time.Time `json:"read_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// messageEvent is sent to the user's open WebSockets
+type messageEvent struct {
+	Type           string           `json:"type"` // message, read, typing, unread
+	ConversationID uint             `json:"conversation_id,omitempty"`
+	Message        *MessageResponse `json:"message,omitempty"`
+	UserID         uint             `json:"user_id,omitempty"` // Who read or is typing
+	ReadAt         *time.Time       `json:"read_at,omitempty"`
+	Unread         *int64           `json:"unread,omitempty"` // The user's total unread messages
+}
+
+// --- Logic ---
+
+// wsClient is one open WebSocket. Writes come from any goroutine, so they
+// are serialised here; reads only come from the handler.
+type wsClient struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+}
+
+// write sends a text message, closing the socket if it fails so that its
+// reader notices and removes it
+func (cl *wsClient) write(data []byte) error {
+	cl.writeMu.Lock()
+	defer cl.writeMu.Unlock()
+	cl.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	err := cl.conn.WriteMessage(websocket.TextMessage, data)
+	if err != nil {
+		cl.conn.Close()
+	}
+	return err
+}
+
+// messageHub tracks the WebSockets open on this server, by user. With more
+// than one server, users connected elsewhere count as offline and get the
+// email fallback instead.
+var messageHub = struct {
+	sync.Mutex
+	conns map[uint]map[*wsClient]bool
+}{conns: map[uint]map[*wsClient]bool{}}
+
+func hubAdd(userID uint, conn *wsClient) {
+	messageHub.Lock()
+	defer messageHub.Unlock()
+	if messageHub.conns[userID] == nil {
+		messageHub.conns[userID] = map[*wsClient]bool{}
+	}
+	messageHub.conns[userID][conn] = true
+}
+
+func hubRemove(userID uint, conn *wsClient) {
+	messageHub.Lock()
+	defer messageHub.Unlock()
+	delete(messageHub.conns[userID], conn)
+	if len(messageHub.conns[userID]) == 0 {
+		delete(messageHub.conns, userID)
+	}
+}
+
+// userOnline reports whether the user has the app open on this server
+func userOnline(userID uint) bool {
+	messageHub.Lock()
+	defer messageHub.Unlock()
+	return len(messageHub.conns[userID]) > 0
+}
+
+// pushToUser sends an event to each of the user's open WebSockets
+func pushToUser(userID uint, event messageEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	messageHub.Lock()
+	var conns []*wsClient
+	for conn := range messageHub.conns[userID] {
+		conns = append(conns, conn)
+	}
+	messageHub.Unlock()
+	for _, conn := range conns {
+		conn.write(data)
+	}
+}
+
+func unreadMessageCount(userID uint) int64 {
+	var count int64
+	db.Model(&Message{}).Where("recipient_id = ? AND is_read = ?", userID, false).Count(&count)
+	return count
+}
+
+func pushUnreadCount(userID uint) {
+	count := unreadMessageCount(userID)
+	pushToUser(userID, messageEvent{Type: "unread", Unread: &count})
+}
+
+func messageResponse(m Message) MessageResponse {
+	return MessageResponse{
+		ID:             m.ID,
+		ConversationID: m.ConversationID,
+		SenderID:       m.SenderID,
+		RecipientID:    m.RecipientID,
+		Content:        m.Content,
+		AttachmentURL:  m.AttachmentURL,
+		IsRead:         m.IsRead,
+		ReadAt:         m.ReadAt,
+		CreatedAt:      m.CreatedAt,
+	}
+}
+
+// hasAppointmentWith reports whether the client has ever booked the professional
+func hasAppointmentWith(clientID uint, professionalID uuid.UUID) bool {
+	var count int64
+	db.Model(&Appointment{}).Where("client_id = ? AND professional_id = ?", clientID, professionalID).Count(&count)
+	return count > 0
+}
+
+// conversationFor loads a conversation the user takes part in
+func conversationFor(c *gin.Context) (Conversation, uint, bool) {
+	uid, _ := currentUserID(c)
+	var conversation Conversation
+	err := db.Where("id = ? AND (client_id = ? OR professional_user_id = ?)", c.Param("id"), uid, uid).
+		First(&conversation).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return conversation, uid, false
+	}
+	return conversation, uid, true
+}
+
+// otherParty is the user on the other side of the conversation from userID
+func (conv Conversation) otherParty(userID uint) uint {
+	if userID == conv.ClientID {
+		return conv.ProfessionalUserID
+	}
+	return conv.ClientID
+}
+
+// checkCanSend enforces the inquiry limit: until the professional replies, a
+// client who hasn't booked may send only MESSAGE_INQUIRY_LIMIT messages
+func checkCanSend(conv *Conversation, senderID uint) error {
+	if conv.Kind == ConversationInquiry && hasAppointmentWith(conv.ClientID, conv.ProfessionalID) {
+		conv.Kind = ConversationAppointment
+		db.Model(conv).Update("kind", ConversationAppointment)
+	}
+	if conv.Kind != ConversationInquiry || senderID != conv.ClientID {
+		return nil
+	}
+	var replies, sent int64
+	db.Model(&Message{}).Where("conversation_id = ? AND sender_id = ?", conv.ID, conv.ProfessionalUserID).Count(&replies)
+	if replies > 0 {
+		return nil
+	}
+	db.Model(&Message{}).Where("conversation_id = ? AND sender_id = ?", conv.ID, senderID).Count(&sent)
+	if limit := envInt("MESSAGE_INQUIRY_LIMIT", 3); sent >= int64(limit) {
+		return fmt.Errorf("You can send up to %d messages until the professional replies", limit)
+	}
+	return nil
+}
+
+// sendMessage saves a message and delivers it to the recipient's open
+// WebSockets. Offline recipients are told later by NotifyUnreadMessages.
+func sendMessage(conv *Conversation, senderID uint, input SendMessageInput) (Message, error) {
+	now := time.Now()
+	message := Message{
+		ID:             uuid.New(),
+		ConversationID: conv.ID,
+		SenderID:       senderID,
+		RecipientID:    conv.otherParty(senderID),
+		Content:        input.Content,
+		AttachmentURL:  input.AttachmentURL,
+		CreatedAt:      now,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Sender", "Recipient").Create(&message).Error; err != nil {
+			return err
+		}
+		return tx.Model(conv).Update("last_message_at", &now).Error
+	})
+	if err != nil {
+		return message, err
+	}
+
+	response := messageResponse(message)
+	event := messageEvent{Type: "message", ConversationID: conv.ID, Message: &response}
+	pushToUser(message.RecipientID, event)
+	pushToUser(senderID, event) // The sender's other tabs and devices
+	pushUnreadCount(message.RecipientID)
+	return message, nil
+}
+
+// validateMessage trims a message and checks it has something in it
+func validateMessage(input *SendMessageInput) error {
+	input.Content = strings.TrimSpace(input.Content)
+	if input.Content == "" && input.AttachmentURL == "" {
+		return fmt.Errorf("A message needs content or an attachment")
+	}
+	if len(input.Content) > maxMessageLength {
+		return fmt.Errorf("Messages can be at most %d characters", maxMessageLength)
+	}
+	return nil
+}
+
+// markConversationRead marks the user's unread messages in the conversation
+// as read and sends a read receipt to the other party
+func markConversationRead(conv Conversation, userID uint) int64 {
+	now := time.Now()
+	res := db.Model(&Message{}).
+		Where("conversation_id = ? AND recipient_id = ? AND is_read = ?", conv.ID, userID, false).
+		Updates(map[string]interface{}{"is_read": true, "read_at": &now})
+	if res.RowsAffected > 0 {
+		pushToUser(conv.otherParty(userID), messageEvent{Type: "read", ConversationID: conv.ID, UserID: userID, ReadAt: &now})
+		pushUnreadCount(userID)
+	}
+	return res.RowsAffected
+}
+
+// NotifyUnreadMessages emails (or pushes, per the user's preferences) anyone
+// with messages unread for MESSAGE_NOTIFY_DELAY_MINUTES who isn't online, once
+// per conversation until they catch up
+func NotifyUnreadMessages() int {
+	cutoff := time.Now().Add(-time.Duration(envInt("MESSAGE_NOTIFY_DELAY_MINUTES", 5)) * time.Minute)
+	var pending []Message
+	db.Where("is_read = ? AND notified_at IS NULL AND created_at <= ?", false, cutoff).
+		Order("created_at asc").Limit(500).Find(&pending)
+
+	type thread struct{ recipient, conversation uint }
+	grouped := map[thread][]Message{}
+	var order []thread
+	for _, m := range pending {
+		key := thread{m.RecipientID, m.ConversationID}
+		if grouped[key] == nil {
+			order = append(order, key)
+		}
+		grouped[key] = append(grouped[key], m)
+	}
+
+	notified := 0
+	for _, key := range order {
+		if userOnline(key.recipient) {
+			// They saw it arrive; don't email about it later either
+			db.Model(&Message{}).Where("recipient_id = ? AND conversation_id = ? AND notified_at IS NULL", key.recipient, key.conversation).
+				Update("notified_at", time.Now())
+			continue
+		}
+		messages := grouped[key]
+		ids := make([]uuid.UUID, len(messages))
+		for i, m := range messages {
+			ids[i] = m.ID
+		}
+		latest := messages[len(messages)-1]
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			now := time.Now()
+			if err := tx.Model(&Message{}).Where("id IN ?", ids).Update("notified_at", &now).Error; err != nil {
+				return err
+			}
+			var sender, recipient User
+			tx.First(&sender, latest.SenderID)
+			tx.First(&recipient, key.recipient)
+			preview := latest.Content
+			if len([]rune(preview)) > 200 {
+				preview = string([]rune(preview)[:200]) + "…"
+			}
+			if preview == "" {
+				preview = "Sent an attachment"
+			}
+			link := frontendURL() + "/messages/" + strconv.FormatUint(uint64(key.conversation), 10)
+			return Notify(tx, key.recipient, EventMessageReceived, NotificationContent{
+				Title: "New message from " + sender.Name,
+				Body:  preview,
+				URL:   link,
+				Email: func(tx *gorm.DB, to string) error {
+					return SendNewMessage(tx, to, recipient.Name, sender.Name, len(messages), preview, link)
+				},
+			})
+		})
+		if err != nil {
+			fmt.Printf("Messages: could not notify user %d: %v\n", key.recipient, err)
+			continue
+		}
+		notified++
+	}
+	return notified
+}
+
+// wsTickets are single-use tickets for opening the messages WebSocket.
+// Browsers can't set headers on a WebSocket request, and a JWT in the URL
+// would end up in access logs, so the socket takes a short-lived ticket
+// instead. Tickets only work on the server that issued them, like messageHub.
+var wsTickets = struct {
+	sync.Mutex
+	byTicket map[string]wsTicket
+}{byTicket: map[string]wsTicket{}}
+
+type wsTicket struct {
+	userID  uint
+	expires time.Time
+}
+
+// issueWebSocketTicket returns a new ticket for the user
+func issueWebSocketTicket(userID uint) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	ticket := hex.EncodeToString(b)
+
+	now := time.Now()
+	wsTickets.Lock()
+	defer wsTickets.Unlock()
+	for t, issued := range wsTickets.byTicket {
+		if now.After(issued.expires) {
+			delete(wsTickets.byTicket, t)
+		}
+	}
+	wsTickets.byTicket[ticket] = wsTicket{userID: userID, expires: now.Add(wsTicketTTL)}
+	return ticket, nil
+}
+
+// redeemWebSocketTicket uses up a ticket and returns who it was issued to
+func redeemWebSocketTicket(ticket string) (uint, bool) {
+	wsTickets.Lock()
+	defer wsTickets.Unlock()
+	issued, ok := wsTickets.byTicket[ticket]
+	delete(wsTickets.byTicket, ticket)
+	if !ok || time.Now().After(issued.expires) {
+		return 0, false
+	}
+	return issued.userID, true
+}
+
+// websocketOrigins are the pages allowed to open the messages WebSocket:
+// WEBSOCKET_ALLOWED_ORIGINS (comma separated), otherwise the CORS origins
+// and the frontend
+func websocketOrigins() []string {
+	if env := os.Getenv("WEBSOCKET_ALLOWED_ORIGINS"); env != "" {
+		var origins []string
+		for _, origin := range strings.Split(env, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				origins = append(origins, strings.TrimRight(origin, "/"))
+			}
+		}
+		return origins
+	}
+	return append([]string{frontendURL()}, corsOrigins...)
+}
+
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		for _, allowed := range websocketOrigins() {
+			if strings.EqualFold(origin, allowed) {
+				return true
+			}
+		}
+		return false
+	},
+}
+
+// --- Handlers ---
+
+// GetConversations - Protected - The user's conversations, most recent first, with unread counts
+func GetConversations(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset := (page - 1) * limit
+
+	query := db.Model(&Conversation{}).Where("client_id = ? OR professional_user_id = ?", uid, uid)
+	var total int64
+	query.Count(&total)
+	var conversations []Conversation
+	query.Order("last_message_at desc").Limit(limit).Offset(offset).Find(&conversations)
+
+	data := make([]gin.H, 0, len(conversations))
+	for _, conv := range conversations {
+		var other User
+		db.First(&other, conv.otherParty(uid))
+		var unread int64
+		db.Model(&Message{}).Where("conversation_id = ? AND recipient_id = ? AND is_read = ?", conv.ID, uid, false).Count(&unread)
+		var last []Message
+		db.Where("conversation_id = ?", conv.ID).Order("created_at desc").Limit(1).Find(&last)
+
+		item := gin.H{
+			"conversation": conv,
+			"with":         gin.H{"id": other.ID, "name": other.Name, "profile_image_url": other.ProfileImageURL, "online": userOnline(other.ID)},
+			"unread":       unread,
+		}
+		if len(last) > 0 {
+			item["last_message"] = messageResponse(last[0])
+		}
+		data = append(data, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  data,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// StartConversation - Protected - Open (or reopen) a conversation with a professional, or with a client you have an appointment with
+func StartConversation(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	var input StartConversationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conv := Conversation{}
+	switch {
+	case input.ProfessionalID != "":
+		professionalID, err := uuid.Parse(input.ProfessionalID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid professional_id"})
+			return
+		}
+		var professional Professional
+		if err := db.Where("id = ?", professionalID).First(&professional).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Professional not found"})
+			return
+		}
+		if professional.UserID == uid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You can't message yourself"})
+			return
+		}
+		conv = Conversation{ClientID: uid, ProfessionalID: professional.ID, ProfessionalUserID: professional.UserID, Kind: ConversationInquiry}
+		if hasAppointmentWith(uid, professional.ID) {
+			conv.Kind = ConversationAppointment
+		}
+	case input.ClientID != 0:
+		var professional Professional
+		if err := db.Where("user_id = ?", uid).First(&professional).Error; err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only professionals can start a conversation with a client"})
+			return
+		}
+		if !hasAppointmentWith(input.ClientID, professional.ID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only message clients who have booked with you"})
+			return
+		}
+		conv = Conversation{ClientID: input.ClientID, ProfessionalID: professional.ID, ProfessionalUserID: uid, Kind: ConversationAppointment}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "professional_id or client_id is required"})
+		return
+	}
+
+	message := SendMessageInput{Content: input.Content}
+	hasMessage := strings.TrimSpace(message.Content) != ""
+	if hasMessage {
+		if err := validateMessage(&message); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else if conv.Kind == ConversationInquiry {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Include a message saying what you'd like to ask"})
+		return
+	}
+
+	err := db.Where("client_id = ? AND professional_id = ?", conv.ClientID, conv.ProfessionalID).
+		Attrs(conv).FirstOrCreate(&conv).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start conversation"})
+		return
+	}
+
+	response := gin.H{"conversation": conv}
+	if hasMessage {
+		if err := checkCanSend(&conv, uid); err != nil {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		sent, err := sendMessage(&conv, uid, message)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+			return
+		}
+		response["message"] = messageResponse(sent)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetConversationMessages - Protected - Message history, newest first
+func GetConversationMessages(c *gin.Context) {
+	conv, _, ok := conversationFor(c)
+	if !ok {
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset := (page - 1) * limit
+
+	query := db.Model(&Message{}).Where("conversation_id = ?", conv.ID)
+	var total int64
+	query.Count(&total)
+	var messages []Message
+	query.Order("created_at desc").Limit(limit).Offset(offset).Find(&messages)
+
+	data := make([]MessageResponse, len(messages))
+	for i, m := range messages {
+		data[i] = messageResponse(m)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data":  data,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// SendConversationMessage - Protected - Send a message
+func SendConversationMessage(c *gin.Context) {
+	conv, uid, ok := conversationFor(c)
+	if !ok {
+		return
+	}
+	var input SendMessageInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateMessage(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkCanSend(&conv, uid); err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	message, err := sendMessage(&conv, uid, input)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": messageResponse(message)})
+}
+
+// MarkConversationRead - Protected - Mark everything in a conversation as read, sending a read receipt
+func MarkConversationRead(c *gin.Context) {
+	conv, uid, ok := conversationFor(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"marked": markConversationRead(conv, uid)})
+}
+
+// GetUnreadMessageCount - Protected - Total unread messages, for the inbox badge
+func GetUnreadMessageCount(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	c.JSON(http.StatusOK, gin.H{"unread": unreadMessageCount(uid)})
+}
+
+// CreateWebSocketTicket - Protected - A single-use ticket for opening the messages WebSocket
+func CreateWebSocketTicket(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	ticket, err := issueWebSocketTicket(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue ticket"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"ticket": ticket, "expires_in": int(wsTicketTTL.Seconds())})
+}
+
+// MessagesWebSocket - Public - Live delivery of messages, read receipts and typing indicators.
+// Opened with ?ticket= from CreateWebSocketTicket, from an allowed origin.
+// Clients may send {"type":"read"|"typing","conversation_id":N}.
+func MessagesWebSocket(c *gin.Context) {
+	uid, ok := redeemWebSocketTicket(c.Query("ticket"))
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired ticket"})
+		return
+	}
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return // The upgrader has already responded
+	}
+	client := &wsClient{conn: conn}
+	hubAdd(uid, client)
+	defer hubRemove(uid, client)
+	defer conn.Close()
+
+	conn.SetReadLimit(64 << 10)
+	conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	})
+
+	// Keep proxies from dropping the idle connection, and notice dead clients
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)) != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	count := unreadMessageCount(uid)
+	if data, err := json.Marshal(messageEvent{Type: "unread", Unread: &count}); err == nil {
+		client.write(data)
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		var event messageEvent
+		if json.Unmarshal(data, &event) != nil || event.ConversationID == 0 {
+			continue
+		}
+		var conv Conversation
+		if db.Where("id = ? AND (client_id = ? OR professional_user_id = ?)", event.ConversationID, uid, uid).
+			First(&conv).Error != nil {
+			continue
+		}
+		switch event.Type {
+		case "read":
+			markConversationRead(conv, uid)
+		case "typing":
+			pushToUser(conv.otherParty(uid), messageEvent{Type: "typing", ConversationID: conv.ID, UserID: uid})
+		}
+	}
+}
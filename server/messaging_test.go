@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessagesWebSocketTickets(t *testing.T) {
+	setupTestDB(t)
+	t.Setenv("WEBSOCKET_ALLOWED_ORIGINS", "https://app.example.com, https://admin.example.com/")
+
+	r := gin.New()
+	r.GET("/api/messages/ws", MessagesWebSocket)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/messages/ws?ticket="
+
+	newTicket := func() string {
+		w := callHandler(CreateWebSocketTicket, nil, nil)
+		require.Equal(t, http.StatusCreated, w.Code)
+		var body struct{ Ticket string }
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body.Ticket
+	}
+	dial := func(ticket, origin string) (*websocket.Conn, int) {
+		conn, resp, err := websocket.DefaultDialer.Dial(url+ticket, http.Header{"Origin": {origin}})
+		if err != nil {
+			require.NotNil(t, resp, err)
+			return nil, resp.StatusCode
+		}
+		return conn, resp.StatusCode
+	}
+
+	expired := newTicket()
+	wsTickets.Lock()
+	wsTickets.byTicket[expired] = wsTicket{userID: 1, expires: time.Now().Add(-time.Second)}
+	wsTickets.Unlock()
+
+	cases := []struct {
+		name   string
+		ticket string
+		origin string
+		status int
+	}{
+		{"no ticket", "", "https://app.example.com", http.StatusUnauthorized},
+		{"a JWT is not a ticket", "eyJhbGciOiJIUzI1NiJ9.e30.sig", "https://app.example.com", http.StatusUnauthorized},
+		{"expired ticket", expired, "https://app.example.com", http.StatusUnauthorized},
+		{"origin not allowed", newTicket(), "https://evil.example.com", http.StatusForbidden},
+		{"allowed origin", newTicket(), "https://admin.example.com", http.StatusSwitchingProtocols},
+	}
+	for _, tc := range cases {
+		conn, status := dial(tc.ticket, tc.origin)
+		assert.Equal(t, tc.status, status, tc.name)
+		if conn != nil {
+			conn.Close()
+		}
+	}
+
+	// A ticket opens one socket only
+	ticket := newTicket()
+	conn, status := dial(ticket, "https://app.example.com")
+	require.Equal(t, http.StatusSwitchingProtocols, status)
+	defer conn.Close()
+	_, status = dial(ticket, "https://app.example.com")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// The socket opens with the unread count, then gets events pushed to the user
+	var event messageEvent
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, "unread", event.Type)
+
+	assert.Eventually(t, func() bool { return userOnline(1) }, time.Second, 10*time.Millisecond)
+	pushToUser(1, messageEvent{Type: "typing", ConversationID: 5, UserID: 2})
+	var pushed messageEvent
+	require.NoError(t, conn.ReadJSON(&pushed))
+	assert.Equal(t, messageEvent{Type: "typing", ConversationID: 5, UserID: 2}, pushed)
+
+	conn.Close()
+	assert.Eventually(t, func() bool { return !userOnline(1) }, 2*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"kaivaliyayoga/internal/geo"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// --- DTOs ---
+
+// NearbyResult is a professional or in-person class within the search radius
+type NearbyResult struct {
+	Kind         string        `json:"kind"` // professional, class
+	DistanceKm   float64       `json:"distance_km"`
+	Professional *Professional `json:"professional,omitempty"`
+	Class        *Class        `json:"class,omitempty"`
+}
+
+// --- Logic ---
+
+const (
+	defaultNearbyRadiusKm = 25
+	maxNearbyRadiusKm     = 500
+)
+
+// gazetteer is the offline place-name lookup; nil if it failed to load
+func gazetteer() *geo.Gazetteer {
+	g, err := geo.Default()
+	if err != nil {
+		fmt.Println("Gazetteer unavailable:", err)
+		return nil
+	}
+	return g
+}
+
+// geocode finds a city's coordinates in the bundled gazetteer
+func geocode(city, country string) (geo.Point, bool) {
+	g := gazetteer()
+	if g == nil || strings.TrimSpace(city) == "" {
+		return geo.Point{}, false
+	}
+	place, ok := g.Lookup(city, country)
+	return place.Point, ok
+}
+
+// studioPoint is the studio's coordinates (STUDIO_LATITUDE, STUDIO_LONGITUDE)
+func studioPoint() (geo.Point, bool) {
+	lat, err1 := strconv.ParseFloat(os.Getenv("STUDIO_LATITUDE"), 64)
+	lng, err2 := strconv.ParseFloat(os.Getenv("STUDIO_LONGITUDE"), 64)
+	p := geo.Point{Lat: lat, Lng: lng}
+	return p, err1 == nil && err2 == nil && p.Valid()
+}
+
+// locateClass fills in an in-person class's coordinates from its city, or
+// the studio's, when they weren't given
+func locateClass(class *Class) {
+	if class.LocationType == "online" || (geo.Point{Lat: class.Latitude, Lng: class.Longitude}).Valid() {
+		return
+	}
+	p, ok := geocode(class.City, class.Country)
+	if !ok {
+		p, ok = studioPoint()
+	}
+	if ok {
+		class.Latitude, class.Longitude = p.Lat, p.Lng
+	}
+}
+
+// GeocodeProfessionals fills in coordinates for professionals who have a
+// city but no location, so they show up in nearby searches
+func GeocodeProfessionals() int {
+	var professionals []Professional
+	db.Select("id", "city", "country").
+		Where("latitude = 0 AND longitude = 0 AND city <> ''").
+		Find(&professionals)
+	located := 0
+	for _, p := range professionals {
+		point, ok := geocode(p.City, p.Country)
+		if !ok {
+			continue
+		}
+		if db.Model(&Professional{}).Where("id = ?", p.ID).
+			Updates(map[string]interface{}{"latitude": point.Lat, "longitude": point.Lng}).Error == nil {
+			located++
+		}
+	}
+	return located
+}
+
+// withinBox narrows a query to rows whose latitude/longitude columns fall in
+// box, so the database can use its location index before distances are
+// worked out
+func withinBox(query *gorm.DB, box geo.Box) *gorm.DB {
+	query = query.Where("latitude BETWEEN ? AND ?", box.MinLat, box.MaxLat).
+		Where("NOT (latitude = 0 AND longitude = 0)")
+	if box.WrapsAntimeridian() {
+		return query.Where("(longitude >= ? OR longitude <= ?)", box.MinLng, box.MaxLng)
+	}
+	return query.Where("longitude BETWEEN ? AND ?", box.MinLng, box.MaxLng)
+}
+
+func roundKm(km float64) float64 {
+	return float64(int(km*10+0.5)) / 10
+}
+
+// nearby returns professionals and in-person classes within radiusKm of
+// center, nearest first
+func nearby(center geo.Point, radiusKm float64, kind, professionalType string) []NearbyResult {
+	box := geo.BoundingBox(center, radiusKm)
+	results := []NearbyResult{}
+
+	if kind == "" || kind == "professionals" {
+		query := withinBox(db.Model(&Professional{}), box).
+			Where("verification_status = ?", "approved")
+		if professionalType != "" {
+			query = query.Where("professional_type = ?", professionalType)
+		}
+		var professionals []Professional
+		query.Find(&professionals)
+		for i := range professionals {
+			p := &professionals[i]
+			if d := geo.Distance(center, geo.Point{Lat: p.Latitude, Lng: p.Longitude}); d <= radiusKm {
+				results = append(results, NearbyResult{Kind: "professional", DistanceKm: roundKm(d), Professional: p})
+			}
+		}
+	}
+
+	if kind == "" || kind == "classes" {
+		var classes []Class
+		withinBox(db.Model(&Class{}), box).
+			Where("location_type IN ?", []string{"in-person", "hybrid"}).
+			Find(&classes)
+		for i := range classes {
+			cls := &classes[i]
+			if d := geo.Distance(center, geo.Point{Lat: cls.Latitude, Lng: cls.Longitude}); d <= radiusKm {
+				results = append(results, NearbyResult{Kind: "class", DistanceKm: roundKm(d), Class: cls})
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].DistanceKm < results[j].DistanceKm
+	})
+	return results
+}
+
+// --- Handlers ---
+
+// GetNearby - Public - Professionals and in-person classes near lat/lng, or a city from the gazetteer, nearest first
+func GetNearby(c *gin.Context) {
+	var center geo.Point
+	var place *geo.Place
+	if city := c.Query("city"); city != "" {
+		g := gazetteer()
+		if g == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Place search is unavailable"})
+			return
+		}
+		found, ok := g.Lookup(city, c.Query("country"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "We couldn't find that place"})
+			return
+		}
+		center, place = found.Point, &found
+	} else {
+		lat, err1 := strconv.ParseFloat(c.Query("lat"), 64)
+		lng, err2 := strconv.ParseFloat(c.Query("lng"), 64)
+		center = geo.Point{Lat: lat, Lng: lng}
+		if err1 != nil || err2 != nil || !center.Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Give a city, or lat and lng"})
+			return
+		}
+	}
+
+	radius, err := strconv.ParseFloat(c.DefaultQuery("radius_km", strconv.Itoa(defaultNearbyRadiusKm)), 64)
+	if err != nil || radius <= 0 || radius > maxNearbyRadiusKm {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("radius_km must be between 0 and %d", maxNearbyRadiusKm)})
+		return
+	}
+	kind := c.Query("kind")
+	if kind != "" && kind != "professionals" && kind != "classes" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be professionals or classes"})
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	results := nearby(center, radius, kind, c.Query("type"))
+	total := len(results)
+	start := min((page-1)*limit, total)
+	end := min(start+limit, total)
+
+	c.JSON(http.StatusOK, gin.H{
+		"origin":    gin.H{"latitude": center.Lat, "longitude": center.Lng, "place": place},
+		"radius_km": radius,
+		"data":      results[start:end],
+		"total":     total,
+		"page":      page,
+		"limit":     limit,
+	})
+}
+
+// GeocodePlace - Public - Autocomplete place names from the offline gazetteer, optionally within a country
+func GeocodePlace(c *gin.Context) {
+	g := gazetteer()
+	if g == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Place search is unavailable"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if limit < 1 || limit > 50 {
+		limit = 10
+	}
+	places := g.Suggest(c.Query("q"), c.Query("country"), limit)
+	if places == nil {
+		places = []geo.Place{}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": places})
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"kaivaliyayoga/internal/notify"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Notification event types
+const (
+	EventBookingConfirmed       = "booking_confirmed"
+	EventBookingCancelled       = "booking_cancelled"
+	EventClassReminder          = "class_reminder"
+	EventPaymentReceipt         = "payment_receipt"
+	EventInstalmentReminder     = "instalment_reminder"
+	EventAbandonedCheckout      = "abandoned_checkout"
+	EventAppointmentBooked      = "appointment_booked"
+	EventAppointmentRescheduled = "appointment_rescheduled"
+	EventAppointmentCancelled   = "appointment_cancelled"
+	EventAppointmentDeclined    = "appointment_declined"
+	EventAppointmentReminder    = "appointment_reminder"
+	EventMessageReceived        = "message_received"
+)
+
+var notificationChannels = []string{notify.ChannelEmail, notify.ChannelSMS, notify.ChannelWhatsApp, notify.ChannelPush}
+
+// notificationEvent describes an event type and which channels it uses by default
+type notificationEvent struct {
+	Key      string
+	Label    string
+	Defaults []string // Channels on until the user turns them off
+	Required string   // Channel the user cannot turn off, if any
+}
+
+// Listed in the order shown on the preferences page. SMS and WhatsApp cost
+// money per message so are opt-in everywhere.
+var notificationEvents = []notificationEvent{
+	{EventBookingConfirmed, "Class booking confirmed", []string{notify.ChannelEmail}, ""},
+	{EventBookingCancelled, "Class booking cancelled", []string{notify.ChannelEmail}, ""},
+	{EventClassReminder, "Class reminder", []string{notify.ChannelEmail, notify.ChannelPush}, ""},
+	{EventPaymentReceipt, "Payment receipt", []string{notify.ChannelEmail}, notify.ChannelEmail},
+	{EventInstalmentReminder, "Instalment due", []string{notify.ChannelEmail, notify.ChannelPush}, ""},
+	{EventAbandonedCheckout, "Unfinished checkout", []string{notify.ChannelEmail}, ""},
+	{EventAppointmentBooked, "Appointment booked", []string{notify.ChannelEmail, notify.ChannelPush}, ""},
+	{EventAppointmentRescheduled, "Appointment rescheduled", []string{notify.ChannelEmail, notify.ChannelPush}, ""},
+	{EventAppointmentCancelled, "Appointment cancelled", []string{notify.ChannelEmail, notify.ChannelPush}, ""},
+	{EventAppointmentDeclined, "Appointment declined", []string{notify.ChannelEmail, notify.ChannelPush}, ""},
+	{EventAppointmentReminder, "Appointment reminder", []string{notify.ChannelEmail, notify.ChannelPush}, ""},
+	{EventMessageReceived, "New message while you're away", []string{notify.ChannelEmail, notify.ChannelPush}, ""},
+}
+
+// Providers for the non-email channels, set up by initNotificationProviders
+var notificationProviders = map[string]notify.Provider{}
+
+// --- Models ---
+
+// NotificationPreference turns one channel on or off for one event. Events
+// without a row use the event's defaults.
+type NotificationPreference struct {
+	ID      uint   `json:"id" gorm:"primaryKey"`
+	UserID  uint   `json:"user_id" gorm:"uniqueIndex:idx_notification_pref"`
+	Event   string `json:"event" gorm:"uniqueIndex:idx_notification_pref"`
+	Channel string `json:"channel" gorm:"uniqueIndex:idx_notification_pref"`
+	Enabled bool   `json:"enabled"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NotificationSettings holds a user's quiet hours (in User.Timezone) and
+// channel addresses
+type NotificationSettings struct {
+	ID                uint   `json:"id" gorm:"primaryKey"`
+	UserID            uint   `json:"user_id" gorm:"uniqueIndex"`
+	QuietHoursEnabled bool   `json:"quiet_hours_enabled"`
+	QuietHoursStart   string `json:"quiet_hours_start" gorm:"default:'22:00'"` // HH:MM
+	QuietHoursEnd     string `json:"quiet_hours_end" gorm:"default:'07:00'"`
+	WhatsAppNumber    string `json:"whatsapp_number"` // Defaults to User.Phone
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PushSubscription is a browser registered for web push
+type PushSubscription struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	UserID    uint   `json:"user_id" gorm:"index"`
+	Endpoint  string `json:"endpoint" gorm:"uniqueIndex;not null"`
+	P256dh    string `json:"-"`
+	Auth      string `json:"-"`
+	UserAgent string `json:"user_agent"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Notification is one event sent to a user on one channel. Email is handed to
+// the outbox straight away; other channels are delivered by DeliverNotifications.
+type Notification struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	UserID       uint       `json:"user_id" gorm:"index"`
+	Event        string     `json:"event" gorm:"index"`
+	Channel      string     `json:"channel"`
+	Title        string     `json:"title"`
+	Body         string     `json:"body"`
+	URL          string     `json:"url"`
+	Status       string     `json:"status" gorm:"index"`        // queued (email), pending, sending, sent, failed, expired
+	DeliverAfter time.Time  `json:"deliver_after" gorm:"index"` // Held until quiet hours end
+	ExpiresAt    *time.Time `json:"expires_at"`
+	Attempts     int        `json:"attempts"`
+	LastError    string     `json:"last_error"`
+	SentAt       *time.Time `json:"sent_at"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// --- DTOs ---
+
+// NotificationContent is what an event says. The email is rendered by the
+// existing HTML templates; other channels use the plain text.
+type NotificationContent struct {
+	Title     string
+	Body      string
+	URL       string
+	Email     func(tx *gorm.DB, to string) error // Queues the HTML email, nil for no email
+	ExpiresAt *time.Time                         // Not worth delivering after this, e.g. a reminder once class has started
+}
+
+type PreferenceInput struct {
+	Event   string `json:"event" binding:"required"`
+	Channel string `json:"channel" binding:"required"`
+	Enabled bool   `json:"enabled"`
+}
+
+type UpdateNotificationPreferencesInput struct {
+	Preferences       []PreferenceInput `json:"preferences"`
+	QuietHoursEnabled *bool             `json:"quiet_hours_enabled"`
+	QuietHoursStart   *string           `json:"quiet_hours_start"`
+	QuietHoursEnd     *string           `json:"quiet_hours_end"`
+	WhatsAppNumber    *string           `json:"whatsapp_number"`
+	Timezone          *string           `json:"timezone"`
+}
+
+type PushSubscriptionInput struct {
+	Endpoint string `json:"endpoint" binding:"required"`
+	Keys     struct {
+		P256dh string `json:"p256dh" binding:"required"`
+		Auth   string `json:"auth" binding:"required"`
+	} `json:"keys"`
+}
+
+// --- Logic ---
+
+// initNotificationProviders uses Twilio and the push relay when configured,
+// and local fakes otherwise
+func initNotificationProviders() {
+	sid, token := os.Getenv("TWILIO_ACCOUNT_SID"), os.Getenv("TWILIO_AUTH_TOKEN")
+	notificationProviders[notify.ChannelSMS] = notify.NewFake(notify.ChannelSMS)
+	if from := os.Getenv("TWILIO_SMS_FROM"); sid != "" && from != "" {
+		notificationProviders[notify.ChannelSMS] = &notify.Twilio{AccountSID: sid, AuthToken: token, From: from}
+	}
+	notificationProviders[notify.ChannelWhatsApp] = notify.NewFake(notify.ChannelWhatsApp)
+	if from := os.Getenv("TWILIO_WHATSAPP_FROM"); sid != "" && from != "" {
+		notificationProviders[notify.ChannelWhatsApp] = &notify.Twilio{AccountSID: sid, AuthToken: token, From: from, WhatsApp: true}
+	}
+	notificationProviders[notify.ChannelPush] = notify.NewFake(notify.ChannelPush)
+	if url := os.Getenv("PUSH_WEBHOOK_URL"); url != "" {
+		notificationProviders[notify.ChannelPush] = &notify.PushWebhook{URL: url, Secret: os.Getenv("PUSH_WEBHOOK_SECRET")}
+	}
+
+	for _, channel := range []string{notify.ChannelSMS, notify.ChannelWhatsApp, notify.ChannelPush} {
+		fmt.Printf("Notifications: %s via %s\n", channel, notificationProviders[channel].Name())
+	}
+}
+
+func findNotificationEvent(key string) (notificationEvent, bool) {
+	for _, event := range notificationEvents {
+		if event.Key == key {
+			return event, true
+		}
+	}
+	return notificationEvent{}, false
+}
+
+func validNotificationChannel(channel string) bool {
+	for _, c := range notificationChannels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// notificationPreferences returns event -> channel -> enabled, with defaults
+// filled in for anything the user has not set
+func notificationPreferences(tx *gorm.DB, userID uint) map[string]map[string]bool {
+	prefs := map[string]map[string]bool{}
+	for _, event := range notificationEvents {
+		prefs[event.Key] = map[string]bool{}
+		for _, channel := range notificationChannels {
+			prefs[event.Key][channel] = false
+		}
+		for _, channel := range event.Defaults {
+			prefs[event.Key][channel] = true
+		}
+	}
+
+	var saved []NotificationPreference
+	tx.Where("user_id = ?", userID).Find(&saved)
+	for _, pref := range saved {
+		if channels, ok := prefs[pref.Event]; ok {
+			channels[pref.Channel] = pref.Enabled
+		}
+	}
+
+	for _, event := range notificationEvents {
+		if event.Required != "" {
+			prefs[event.Key][event.Required] = true
+		}
+	}
+	return prefs
+}
+
+func notificationSettingsFor(tx *gorm.DB, userID uint) NotificationSettings {
+	settings := NotificationSettings{UserID: userID, QuietHoursStart: "22:00", QuietHoursEnd: "07:00"}
+	tx.Where("user_id = ?", userID).FirstOrInit(&settings)
+	return settings
+}
+
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a HH:MM time", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// quietHoursEnd returns when a notification raised at now may be delivered:
+// now itself, or the end of the user's quiet hours in their timezone. Quiet
+// hours may run past midnight (e.g. 22:00 to 07:00).
+func quietHoursEnd(now time.Time, timezone, start, end string) time.Time {
+	from, err1 := parseClock(start)
+	to, err2 := parseClock(end)
+	if err1 != nil || err2 != nil || from == to {
+		return now
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	inQuiet := (from < to && minute >= from && minute < to) || (from > to && (minute >= from || minute < to))
+	if !inQuiet {
+		return now
+	}
+
+	resume := time.Date(local.Year(), local.Month(), local.Day(), to/60, to%60, 0, 0, loc)
+	if !resume.After(local) {
+		resume = resume.AddDate(0, 0, 1)
+	}
+	return resume.In(now.Location())
+}
+
+// Notify sends an event to a user on every channel they have enabled, within
+// tx so nothing goes out unless the event itself is saved. SMS, WhatsApp and
+// push are held until the user's quiet hours end; email is never held.
+func Notify(tx *gorm.DB, userID uint, event string, content NotificationContent) error {
+	if _, ok := findNotificationEvent(event); !ok {
+		return fmt.Errorf("unknown notification event %q", event)
+	}
+	var user User
+	if err := tx.First(&user, userID).Error; err != nil {
+		log.Printf("WARNING: Not sending %s to missing user %d\n", event, userID)
+		return nil
+	}
+
+	now := time.Now()
+	settings := notificationSettingsFor(tx, userID)
+	deliverAfter := now
+	if settings.QuietHoursEnabled {
+		deliverAfter = quietHoursEnd(now, user.Timezone, settings.QuietHoursStart, settings.QuietHoursEnd)
+	}
+
+	prefs := notificationPreferences(tx, userID)[event]
+	for _, channel := range notificationChannels {
+		if !prefs[channel] {
+			continue
+		}
+		n := Notification{
+			UserID:       userID,
+			Event:        event,
+			Channel:      channel,
+			Title:        content.Title,
+			Body:         content.Body,
+			URL:          content.URL,
+			Status:       "pending",
+			DeliverAfter: deliverAfter,
+			ExpiresAt:    content.ExpiresAt,
+		}
+
+		switch channel {
+		case notify.ChannelEmail:
+			if content.Email == nil || user.Email == "" {
+				continue
+			}
+			if err := content.Email(tx, user.Email); err != nil {
+				return err
+			}
+			n.Status = "queued"
+			n.DeliverAfter = now
+		case notify.ChannelSMS:
+			if user.Phone == "" {
+				continue
+			}
+		case notify.ChannelWhatsApp:
+			if settings.WhatsAppNumber == "" && user.Phone == "" {
+				continue
+			}
+		case notify.ChannelPush:
+			var subscriptions int64
+			tx.Model(&PushSubscription{}).Where("user_id = ?", userID).Count(&subscriptions)
+			if subscriptions == 0 {
+				continue
+			}
+		}
+
+		if content.ExpiresAt != nil && n.DeliverAfter.After(*content.ExpiresAt) {
+			continue // Quiet hours outlast the event
+		}
+		if err := tx.Create(&n).Error; err != nil {
+			return fmt.Errorf("failed to save notification: %w", err)
+		}
+	}
+	return nil
+}
+
+// deliverNotification sends a claimed notification through its channel's provider
+func deliverNotification(n *Notification) error {
+	provider, ok := notificationProviders[n.Channel]
+	if !ok {
+		return fmt.Errorf("no provider for %s", n.Channel)
+	}
+	var user User
+	if err := db.First(&user, n.UserID).Error; err != nil {
+		return errors.New("user not found")
+	}
+	msg := notify.Message{Title: n.Title, Body: n.Body, URL: n.URL}
+
+	switch n.Channel {
+	case notify.ChannelSMS:
+		msg.To = user.Phone
+	case notify.ChannelWhatsApp:
+		msg.To = notificationSettingsFor(db, n.UserID).WhatsAppNumber
+		if msg.To == "" {
+			msg.To = user.Phone
+		}
+	case notify.ChannelPush:
+		var subscriptions []PushSubscription
+		db.Where("user_id = ?", n.UserID).Find(&subscriptions)
+		if len(subscriptions) == 0 {
+			return errors.New("no push subscriptions")
+		}
+		// Delivered if any of the user's browsers accepts it
+		var lastErr error
+		delivered := false
+		for _, sub := range subscriptions {
+			msg.To, msg.P256dh, msg.Auth = sub.Endpoint, sub.P256dh, sub.Auth
+			if err := provider.Send(msg); err != nil {
+				lastErr = err
+				continue
+			}
+			delivered = true
+		}
+		if delivered {
+			return nil
+		}
+		return lastErr
+	}
+	return provider.Send(msg)
+}
+
+// DeliverNotifications sends SMS, WhatsApp and push notifications that are
+// due, retrying failures with backoff up to 5 attempts
+func DeliverNotifications() (sent, failed int) {
+	now := time.Now()
+	var due []Notification
+	db.Where("status = ? AND deliver_after <= ?", "pending", now).Order("deliver_after asc").Limit(200).Find(&due)
+
+	for i := range due {
+		n := &due[i]
+		if n.ExpiresAt != nil && now.After(*n.ExpiresAt) {
+			db.Model(n).Update("status", "expired")
+			continue
+		}
+		claim := db.Model(&Notification{}).Where("id = ? AND status = ?", n.ID, "pending").Update("status", "sending")
+		if claim.Error != nil || claim.RowsAffected == 0 {
+			continue // Another instance took it
+		}
+
+		n.Attempts++
+		err := deliverNotification(n)
+		if err == nil {
+			db.Model(n).Updates(map[string]interface{}{"status": "sent", "attempts": n.Attempts, "sent_at": time.Now(), "last_error": ""})
+			sent++
+			continue
+		}
+
+		updates := map[string]interface{}{"attempts": n.Attempts, "last_error": err.Error(), "status": "pending"}
+		if n.Attempts >= 5 {
+			updates["status"] = "failed"
+			failed++
+		} else {
+			updates["deliver_after"] = time.Now().Add(time.Duration(1<<n.Attempts) * time.Minute)
+		}
+		log.Printf("WARNING: %s notification %d to user %d failed (attempt %d): %v\n", n.Channel, n.ID, n.UserID, n.Attempts, err)
+		db.Model(n).Updates(updates)
+	}
+	return sent, failed
+}
+
+// --- Handlers ---
+
+// GetNotificationPreferences - Protected - Channels per event, quiet hours and timezone
+func GetNotificationPreferences(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	var user User
+	db.First(&user, uid)
+	prefs := notificationPreferences(db, uid)
+
+	events := []gin.H{}
+	for _, event := range notificationEvents {
+		events = append(events, gin.H{
+			"event":    event.Key,
+			"label":    event.Label,
+			"channels": prefs[event.Key],
+			"required": event.Required,
+		})
+	}
+	var subscriptions []PushSubscription
+	db.Where("user_id = ?", uid).Find(&subscriptions)
+
+	c.JSON(http.StatusOK, gin.H{
+		"events":             events,
+		"settings":           notificationSettingsFor(db, uid),
+		"timezone":           user.Timezone,
+		"phone":              user.Phone,
+		"push_subscriptions": subscriptions,
+	})
+}
+
+// UpdateNotificationPreferences - Protected - Change channels per event, quiet hours or timezone
+func UpdateNotificationPreferences(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	var input UpdateNotificationPreferencesInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, pref := range input.Preferences {
+		event, ok := findNotificationEvent(pref.Event)
+		if !ok || !validNotificationChannel(pref.Channel) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown event or channel: %s/%s", pref.Event, pref.Channel)})
+			return
+		}
+		if event.Required == pref.Channel && !pref.Enabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s notifications are always sent by %s", event.Label, pref.Channel)})
+			return
+		}
+	}
+
+	settings := notificationSettingsFor(db, uid)
+	if input.QuietHoursEnabled != nil {
+		settings.QuietHoursEnabled = *input.QuietHoursEnabled
+	}
+	for _, field := range []struct {
+		value  *string
+		target *string
+	}{{input.QuietHoursStart, &settings.QuietHoursStart}, {input.QuietHoursEnd, &settings.QuietHoursEnd}} {
+		if field.value == nil {
+			continue
+		}
+		if _, err := parseClock(*field.value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Quiet hours: " + err.Error()})
+			return
+		}
+		*field.target = *field.value
+	}
+	if input.WhatsAppNumber != nil {
+		settings.WhatsAppNumber = strings.TrimSpace(*input.WhatsAppNumber)
+	}
+	if input.Timezone != nil {
+		if _, err := time.LoadLocation(*input.Timezone); err != nil || *input.Timezone == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone"})
+			return
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, pref := range input.Preferences {
+			row := NotificationPreference{UserID: uid, Event: pref.Event, Channel: pref.Channel}
+			tx.Where(&row).FirstOrInit(&row)
+			row.Enabled = pref.Enabled
+			if err := tx.Save(&row).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Save(&settings).Error; err != nil {
+			return err
+		}
+		if input.Timezone != nil {
+			return tx.Model(&User{}).Where("id = ?", uid).Update("timezone", *input.Timezone).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save preferences"})
+		return
+	}
+	GetNotificationPreferences(c)
+}
+
+// GetMyNotifications - Protected - Recent notifications across all channels
+func GetMyNotifications(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	var notifications []Notification
+	db.Where("user_id = ?", uid).Order("created_at desc").Limit(50).Find(&notifications)
+	c.JSON(http.StatusOK, notifications)
+}
+
+// AddPushSubscription - Protected - Register this browser for web push
+func AddPushSubscription(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	var input PushSubscriptionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A browser has one endpoint; re-registering moves it to the current user
+	sub := PushSubscription{Endpoint: input.Endpoint}
+	db.Where("endpoint = ?", input.Endpoint).FirstOrInit(&sub)
+	sub.UserID = uid
+	sub.P256dh = input.Keys.P256dh
+	sub.Auth = input.Keys.Auth
+	sub.UserAgent = c.Request.UserAgent()
+	if err := db.Save(&sub).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save subscription"})
+		return
+	}
+	c.JSON(http.StatusCreated, sub)
+}
+
+// RemovePushSubscription - Protected - Stop web push to a browser
+func RemovePushSubscription(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	res := db.Where("id = ? AND user_id = ?", c.Param("id"), uid).Delete(&PushSubscription{})
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Push notifications turned off for this browser"})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHoursEnd(t *testing.T) {
+	sydney, _ := time.LoadLocation("Australia/Sydney")
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, sydney)
+	}
+
+	cases := []struct {
+		name       string
+		now        time.Time
+		start, end string
+		want       time.Time
+	}{
+		{"before quiet hours", at(19, 21, 30), "22:00", "07:00", at(19, 21, 30)},
+		{"late evening waits for morning", at(19, 23, 15), "22:00", "07:00", at(20, 7, 0)},
+		{"early morning waits until end", at(20, 5, 0), "22:00", "07:00", at(20, 7, 0)},
+		{"end is not quiet", at(20, 7, 0), "22:00", "07:00", at(20, 7, 0)},
+		{"same-day window", at(19, 13, 30), "13:00", "14:00", at(19, 14, 0)},
+		{"empty window", at(19, 23, 0), "22:00", "22:00", at(19, 23, 0)},
+		{"bad times ignored", at(19, 23, 0), "late", "07:00", at(19, 23, 0)},
+	}
+	for _, tc := range cases {
+		// Raised in UTC; quiet hours are judged in the user's timezone
+		got := quietHoursEnd(tc.now.UTC(), "Australia/Sydney", tc.start, tc.end)
+		if !got.Equal(tc.want) {
+			t.Errorf("%s: quietHoursEnd = %v, want %v", tc.name, got.In(sydney), tc.want)
+		}
+	}
+
+	// Unknown timezones fall back to UTC
+	utcNight := time.Date(2026, 10, 19, 23, 0, 0, 0, time.UTC)
+	if got := quietHoursEnd(utcNight, "Nowhere/Special", "22:00", "07:00"); !got.Equal(time.Date(2026, 10, 20, 7, 0, 0, 0, time.UTC)) {
+		t.Errorf("UTC fallback: got %v", got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -12,6 +13,7 @@ import (
 	"github.com/gin-gonic/gin"
 	razorpay "github.com/razorpay/razorpay-go"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // --- Razorpay Client ---
@@ -33,18 +35,62 @@ type Payment struct {
 	Signature string `json:"-"`
 
 	// Multi-Currency Fields
-	BaseAmountAUD float64 `json:"base_amount_aud"` // The reference price in AUD
-	Amount        float64 `json:"amount"`          // Charged amount in user's currency
-	Currency      string  `json:"currency"`        // User's currency code (e.g. INR)
-	ExchangeRate  float64 `json:"exchange_rate"`   // Rate used at time of transaction
-	CountryCode   string  `json:"country_code"`    // ISO country code (e.g. IN)
+	BaseAmountAUD      float64 `json:"base_amount_aud"`      // The reference price in AUD
+	Amount             float64 `json:"amount"`               // Charged amount in user's currency
+	Currency           string  `json:"currency"`             // User's currency code (e.g. INR)
+	ExchangeRate       float64 `json:"exchange_rate"`        // AUD-based rate used at time of transaction
+	ExchangeRateSource string  `json:"exchange_rate_source"` // Provider the rate came from (see DailyExchangeRate)
+	ExchangeRateDate   string  `json:"exchange_rate_date"`   // Day the rate was published for
+	CountryCode        string  `json:"country_code"`         // ISO country code (e.g. IN), as declared by the customer
+
+	// What was purchased and any discount applied
+	ProductType    string  `json:"product_type"` // membership, program, class, service
+	ProductID      uint    `json:"product_id"`
+	SubtotalAmount float64 `json:"subtotal_amount"` // Amount before discount
+	DiscountAmount float64 `json:"discount_amount"`
+	PromoCode      string  `json:"promo_code"`
+
+	// Tax breakdown (see TaxRule)
+	NetAmount      float64          `json:"net_amount"`
+	TaxAmount      float64          `json:"tax_amount"`
+	TaxInclusive   bool             `json:"tax_inclusive"`
+	ReverseCharge  bool             `json:"reverse_charge"`
+	RegionCode     string           `json:"region_code"`
+	CustomerTaxID  string           `json:"customer_tax_id"`
+	TaxIDCheck     string           `json:"tax_id_check"` // How CustomerTaxID was validated for reverse charge: vies, admin
+	TaxIDCheckedAt *time.Time       `json:"tax_id_checked_at"`
+	TaxLines       []PaymentTaxLine `json:"tax_lines,omitempty" gorm:"foreignKey:PaymentID"`
 
 	Method string `json:"method"` // card, upi, etc.
-	Status string `json:"status"` // created, success, failed
+	Status string `json:"status"` // created, success, failed, expired, refunded, partially_refunded
+
+	// Stale checkout sweeper (see SweepStalePayments)
+	StatusCheckedAt      *time.Time `json:"status_checked_at"`
+	ExpiredAt            *time.Time `json:"expired_at"`
+	AbandonedEmailSentAt *time.Time `json:"abandoned_email_sent_at"`
+
+	// GeoIP country check (see flagCountryMismatch)
+	DetectedCountry   string     `json:"detected_country"`                 // From the client IP
+	FraudReviewStatus string     `json:"fraud_review_status" gorm:"index"` // "", pending, cleared, confirmed
+	FraudReason       string     `json:"fraud_reason"`
+	FraudReviewedBy   *uint      `json:"fraud_reviewed_by"`
+	FraudReviewedAt   *time.Time `json:"fraud_reviewed_at"`
+
+	// Store credit (see Wallet). Amount is only the gateway share.
+	WalletAmount     float64 `json:"wallet_amount"`
+	RefundedAmount   float64 `json:"refunded_amount"`
+	RefundedToWallet float64 `json:"refunded_to_wallet"` // Part of RefundedAmount returned as store credit
 
 	// Razorpay fields
 	RazorpayPaymentID string `json:"razorpay_payment_id"`
 
+	// PayPal fields
+	PayPalCaptureID string `json:"paypal_capture_id"` // Needed for refunds
+
+	// Set once the payment has been spent on its product (see ConsumePayment)
+	ConsumedAt *time.Time `json:"consumed_at"`
+	ConsumedBy string     `json:"consumed_by"` // membership, booking, enrollment, instalment, appointment
+
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
@@ -56,6 +102,12 @@ type CreateOrderInput struct {
 	Currency      string  `json:"currency" binding:"required"`
 	BaseAmountAUD float64 `json:"base_amount_aud"`
 	CountryCode   string  `json:"country_code"`
+	ProductType   string  `json:"product_type"`
+	ProductID     uint    `json:"product_id"`
+	PromoCode     string  `json:"promo_code"`
+	RegionCode    string  `json:"region_code"` // State/region for tax, e.g. KA
+	TaxID         string  `json:"tax_id"`      // Customer GSTIN/ABN/VAT number
+	UseWallet     bool    `json:"use_wallet"`  // Apply wallet balance before charging the gateway
 }
 
 type VerifyPaymentInput struct {
@@ -64,6 +116,45 @@ type VerifyPaymentInput struct {
 	RazorpaySignature string `json:"razorpay_signature" binding:"required"`
 }
 
+// --- Logic ---
+
+// checkProductPayment checks a payment is the caller's, completed, and was
+// taken for this product
+func checkProductPayment(payment *Payment, uid uint, productType string, productID uint) error {
+	switch {
+	case payment.UserID != uid:
+		return errors.New("payment belongs to another user")
+	case payment.Status != "success":
+		return errors.New("payment not completed")
+	case payment.ProductType != productType || payment.ProductID != productID:
+		return errors.New("payment was not for this product")
+	}
+	return nil
+}
+
+// ConsumePayment spends a payment on the purchase it was taken for. The row
+// is locked and marked used, so one payment can only ever fund one purchase.
+func ConsumePayment(tx *gorm.DB, paymentID uint, consumer string, check func(*Payment) error) (*Payment, error) {
+	var payment Payment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, paymentID).Error; err != nil {
+		return nil, errors.New("payment not found")
+	}
+	if err := check(&payment); err != nil {
+		return nil, err
+	}
+	if payment.ConsumedAt != nil {
+		return nil, errors.New("payment already used")
+	}
+
+	now := time.Now()
+	payment.ConsumedAt = &now
+	payment.ConsumedBy = consumer
+	if err := tx.Model(&payment).Updates(map[string]interface{}{"consumed_at": now, "consumed_by": consumer}).Error; err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
 // --- Handlers ---
 
 // CreateRazorpayOrder - Protected - Creates a Razorpay Order
@@ -80,26 +171,42 @@ func CreateRazorpayOrder(c *gin.Context) {
 		return
 	}
 
-	// Safely convert userID
-	var uid uint
-	switch v := userID.(type) {
-	case float64:
-		uid = uint(v)
-	case uint:
-		uid = v
-	case int:
-		uid = uint(v)
-	case string:
-		// Handle string userID (shouldn't happen but just in case)
-		var parsed int
-		fmt.Sscanf(v, "%d", &parsed)
-		uid = uint(parsed)
-	default:
+	uid, ok := currentUserID(c)
+	if !ok {
 		fmt.Printf("Unknown userID type: %T value: %v\n", userID, userID)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID type"})
 		return
 	}
 
+	detectedCountry := resolveCheckoutCountry(c, &input.CountryCode)
+	if err := checkGatewayRouting(detectedCountry, GatewayRazorpay); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "gateway": gatewayForCountry(detectedCountry)})
+		return
+	}
+
+	// Price the order server-side; the client amount is the pre-discount subtotal
+	pricing, err := PriceOrder(db, OrderPricingInput{
+		UserID:      uid,
+		ProductType: input.ProductType,
+		ProductID:   input.ProductID,
+		Subtotal:    input.Amount,
+		Currency:    "INR",
+		PromoCode:   input.PromoCode,
+		CountryCode: input.CountryCode,
+		RegionCode:  input.RegionCode,
+		TaxID:       input.TaxID,
+		UseWallet:   input.UseWallet,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if pricing.GatewayAmount() <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order is covered by wallet balance; use wallet checkout"})
+		return
+	}
+	input.Amount = pricing.GatewayAmount()
+
 	// Create Razorpay order
 	data := map[string]interface{}{
 		"amount":   int(input.Amount * 100), // Amount in paise
@@ -123,14 +230,18 @@ func CreateRazorpayOrder(c *gin.Context) {
 	payment := Payment{
 		UserID:        uid,
 		OrderID:       orderID,
-		Amount:        input.Amount,
 		Currency:      "INR",
 		BaseAmountAUD: input.BaseAmountAUD,
 		CountryCode:   input.CountryCode,
+		RegionCode:    input.RegionCode,
+		CustomerTaxID: input.TaxID,
+		ProductType:   input.ProductType,
+		ProductID:     input.ProductID,
 		Status:        "created",
 	}
+	flagCountryMismatch(&payment, detectedCountry)
 	fmt.Printf("DEBUG: Payment struct - UserID=%d, OrderID=%s, Amount=%f\n", payment.UserID, payment.OrderID, payment.Amount)
-	if err := db.Create(&payment).Error; err != nil {
+	if err := CreateOrderPayment(&payment, pricing); err != nil {
 		fmt.Println("DB ERROR:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save payment record"})
 		return
@@ -171,18 +282,50 @@ func VerifyRazorpayPayment(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
+	verified := gin.H{
+		"message":    "Payment verified successfully",
+		"payment_id": payment.ID,
+		"status":     "success",
+	}
 
+	// Only the first verification of an open order marks it paid and runs the
+	// side effects below; a retry of an already-paid order is a no-op
+	if payment.Status == "success" {
+		c.JSON(http.StatusOK, verified)
+		return
+	}
+	if payment.Status != "created" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment is " + payment.Status})
+		return
+	}
+	res := db.Model(&Payment{}).Where("id = ? AND status = ?", payment.ID, "created").Updates(map[string]interface{}{
+		"status":              "success",
+		"razorpay_payment_id": input.RazorpayPaymentID,
+		"signature":           input.RazorpaySignature,
+		"payment_id":          input.RazorpayPaymentID,
+	})
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusOK, verified) // Another request verified it first
+		return
+	}
 	payment.Status = "success"
 	payment.RazorpayPaymentID = input.RazorpayPaymentID
 	payment.Signature = input.RazorpaySignature
 	payment.PaymentID = input.RazorpayPaymentID
-	db.Save(&payment)
+	ExtendSeatHold(db, payment.ID)
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":    "Payment verified successfully",
-		"payment_id": payment.ID,
-		"status":     "success",
-	})
+	if err := issueInvoiceForPayment(&payment); err != nil {
+		fmt.Printf("WARNING: Failed to issue invoice for payment %d: %v\n", payment.ID, err)
+	}
+	if err := ActivateGiftCard(&payment); err != nil {
+		fmt.Printf("WARNING: Failed to activate gift card for payment %d: %v\n", payment.ID, err)
+	}
+
+	c.JSON(http.StatusOK, verified)
 }
 
 // GetMyPayments - Protected - Get payment history for user
@@ -200,7 +343,7 @@ func GetMyPayments(c *gin.Context) {
 	}
 
 	var payments []Payment
-	db.Where("user_id = ?", uid).Order("created_at desc").Find(&payments)
+	db.Where("user_id = ?", uid).Preload("TaxLines").Order("created_at desc").Find(&payments)
 	c.JSON(http.StatusOK, payments)
 }
 
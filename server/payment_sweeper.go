@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Gateway order states as seen by the sweeper
+const (
+	OrderPaid    = "paid"
+	OrderFailed  = "failed"
+	OrderPending = "pending"
+)
+
+// GatewayOrderStatus is what a gateway reports for one of our orders
+type GatewayOrderStatus struct {
+	Status    string  // paid, failed, pending
+	PaymentID string  // Razorpay payment ID or PayPal capture ID when paid
+	Amount    float64 // Major units
+}
+
+// PaymentStatusProvider looks up an order's status at the gateway
+type PaymentStatusProvider interface {
+	Name() string
+	OrderStatus(payment *Payment) (GatewayOrderStatus, error)
+}
+
+// --- Providers ---
+
+func paymentStatusProviders() map[string]PaymentStatusProvider {
+	providers := map[string]PaymentStatusProvider{}
+	if os.Getenv("RAZORPAY_KEY_ID") != "" {
+		providers[GatewayRazorpay] = razorpayStatusProvider{}
+	}
+	if os.Getenv("PAYPAL_CLIENT_ID") != "" {
+		providers[GatewayPayPal] = paypalStatusProvider{}
+	}
+	return providers
+}
+
+// paymentGateway infers which gateway took a payment. Razorpay order IDs
+// always start with "order_" and wallet checkouts with "wallet_".
+func paymentGateway(p *Payment) string {
+	if strings.HasPrefix(p.OrderID, "wallet_") {
+		return GatewayWallet
+	}
+	if p.RazorpayPaymentID != "" || strings.HasPrefix(p.OrderID, "order_") {
+		return GatewayRazorpay
+	}
+	return GatewayPayPal
+}
+
+type razorpayStatusProvider struct{}
+
+func (razorpayStatusProvider) Name() string { return GatewayRazorpay }
+
+func (razorpayStatusProvider) OrderStatus(payment *Payment) (GatewayOrderStatus, error) {
+	res, err := razorpayClient.Order.Payments(payment.OrderID, nil, nil)
+	if err != nil {
+		return GatewayOrderStatus{}, err
+	}
+
+	status := GatewayOrderStatus{Status: OrderPending}
+	items, _ := res["items"].([]interface{})
+	failed := 0
+	for _, raw := range items {
+		item, _ := raw.(map[string]interface{})
+		switch item["status"] {
+		case "captured", "refunded":
+			id, _ := item["id"].(string)
+			amount, _ := item["amount"].(float64)
+			return GatewayOrderStatus{Status: OrderPaid, PaymentID: id, Amount: amount / 100}, nil
+		case "failed":
+			failed++
+		}
+	}
+	// Every attempt failed; the customer may still retry until the order expires
+	if len(items) > 0 && failed == len(items) {
+		status.Status = OrderFailed
+	}
+	return status, nil
+}
+
+type paypalStatusProvider struct{}
+
+func (paypalStatusProvider) Name() string { return GatewayPayPal }
+
+func (paypalStatusProvider) OrderStatus(payment *Payment) (GatewayOrderStatus, error) {
+	accessToken, err := getPayPalAccessToken()
+	if err != nil {
+		return GatewayOrderStatus{}, err
+	}
+
+	req, err := http.NewRequest("GET", paypalBaseURL+"/v2/checkout/orders/"+payment.OrderID, nil)
+	if err != nil {
+		return GatewayOrderStatus{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return GatewayOrderStatus{}, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return GatewayOrderStatus{Status: OrderFailed}, nil // PayPal drops orders that were never approved
+	}
+	if resp.StatusCode != http.StatusOK {
+		return GatewayOrderStatus{}, fmt.Errorf("PayPal order lookup failed: %s", string(body))
+	}
+
+	var order map[string]interface{}
+	if err := json.Unmarshal(body, &order); err != nil {
+		return GatewayOrderStatus{}, err
+	}
+	switch order["status"] {
+	case "COMPLETED":
+		return GatewayOrderStatus{Status: OrderPaid, PaymentID: paypalCaptureID(order)}, nil
+	case "VOIDED":
+		return GatewayOrderStatus{Status: OrderFailed}, nil
+	}
+	return GatewayOrderStatus{Status: OrderPending}, nil
+}
+
+// --- Sweeper ---
+
+type sweeperConfig struct {
+	CheckAfter     time.Duration // Leave fresh checkouts alone
+	ExpireAfter    time.Duration // Give up on orders still pending after this
+	AbandonedEmail bool
+}
+
+func loadSweeperConfig() sweeperConfig {
+	return sweeperConfig{
+		CheckAfter:     time.Duration(envInt("PAYMENT_SWEEP_AFTER_MINUTES", 30)) * time.Minute,
+		ExpireAfter:    time.Duration(envInt("PAYMENT_EXPIRE_AFTER_HOURS", 24)) * time.Hour,
+		AbandonedEmail: os.Getenv("ABANDONED_CHECKOUT_EMAILS") == "true",
+	}
+}
+
+// SweepResult counts what a sweep did to stale payments
+type SweepResult struct {
+	Checked   int `json:"checked"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Expired   int `json:"expired"`
+	Errors    int `json:"errors"`
+}
+
+// SweepStalePayments resolves payments left in "created" by abandoned or
+// interrupted checkouts
+func SweepStalePayments() SweepResult {
+	cfg := loadSweeperConfig()
+	providers := paymentStatusProviders()
+	var result SweepResult
+
+	var stale []Payment
+	db.Where("status = ? AND created_at < ?", "created", time.Now().Add(-cfg.CheckAfter)).
+		Order("created_at asc").Limit(500).Find(&stale)
+
+	for i := range stale {
+		payment := &stale[i]
+		result.Checked++
+
+		status := GatewayOrderStatus{Status: OrderPending}
+		if provider, ok := providers[paymentGateway(payment)]; ok {
+			var err error
+			status, err = provider.OrderStatus(payment)
+			if err != nil {
+				fmt.Printf("WARNING: Sweeper could not check payment %d: %v\n", payment.ID, err)
+				result.Errors++
+				continue
+			}
+		}
+
+		outcome, err := applyGatewayOrderStatus(payment, status, cfg)
+		if err != nil {
+			fmt.Printf("ERROR: Sweeper could not update payment %d: %v\n", payment.ID, err)
+			result.Errors++
+			continue
+		}
+		switch outcome {
+		case "success":
+			result.Succeeded++
+			if err := issueInvoiceForPayment(payment); err != nil {
+				fmt.Printf("WARNING: Failed to issue invoice for payment %d: %v\n", payment.ID, err)
+			}
+			if err := ActivateGiftCard(payment); err != nil {
+				fmt.Printf("WARNING: Failed to activate gift card for payment %d: %v\n", payment.ID, err)
+			}
+		case "failed":
+			result.Failed++
+		case "expired":
+			result.Expired++
+			if cfg.AbandonedEmail {
+				sendAbandonedCheckoutEmail(payment)
+			}
+		}
+	}
+
+	ReleaseExpiredSeatHolds()
+	return result
+}
+
+// applyGatewayOrderStatus moves a stale payment to its final state. Returns
+// the new status, or "" if it should be checked again later.
+func applyGatewayOrderStatus(payment *Payment, status GatewayOrderStatus, cfg sweeperConfig) (string, error) {
+	now := time.Now()
+	updates := map[string]interface{}{"status_checked_at": now}
+
+	switch {
+	case status.Status == OrderPaid:
+		updates["status"] = "success"
+		if paymentGateway(payment) == GatewayRazorpay {
+			updates["razorpay_payment_id"] = status.PaymentID
+			updates["payment_id"] = status.PaymentID
+		} else {
+			updates["pay_pal_capture_id"] = status.PaymentID
+		}
+	case status.Status == OrderFailed && payment.CreatedAt.Before(now.Add(-cfg.ExpireAfter)):
+		updates["status"] = "failed"
+	case payment.CreatedAt.Before(now.Add(-cfg.ExpireAfter)):
+		updates["status"] = "expired"
+		updates["expired_at"] = now
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Guard against a late verify callback racing the sweeper
+		res := tx.Model(&Payment{}).Where("id = ? AND status = ?", payment.ID, "created").Updates(updates)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errors.New("payment is no longer pending")
+		}
+
+		switch updates["status"] {
+		case "success":
+			return ExtendSeatHold(tx, payment.ID)
+		case "failed", "expired":
+			if err := ReverseWalletDebit(tx, payment); err != nil {
+				return err
+			}
+			return ReleaseSeatHold(tx, payment.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	newStatus, _ := updates["status"].(string)
+	if newStatus != "" {
+		payment.Status = newStatus
+	}
+	return newStatus, nil
+}
+
+// sendAbandonedCheckoutEmail nudges the customer unless they have since paid
+func sendAbandonedCheckoutEmail(payment *Payment) {
+	var later int64
+	db.Model(&Payment{}).Where("user_id = ? AND status = ? AND created_at > ?", payment.UserID, "success", payment.CreatedAt).
+		Count(&later)
+	if later > 0 || payment.AbandonedEmailSentAt != nil {
+		return
+	}
+
+	var user User
+	if db.First(&user, payment.UserID).Error != nil || user.Email == "" {
+		return
+	}
+
+	now := time.Now()
+	db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(payment).Update("abandoned_email_sent_at", now).Error; err != nil {
+			return err
+		}
+		item := describePayment(tx, payment)
+		amount := fmt.Sprintf("%.2f %s", payment.Amount, payment.Currency)
+		return Notify(tx, user.ID, EventAbandonedCheckout, NotificationContent{
+			Title: "Your checkout is waiting",
+			Body:  fmt.Sprintf("Your checkout for %s (%s) wasn't completed.", item, amount),
+			URL:   frontendURL() + "/pricing",
+			Email: func(tx *gorm.DB, to string) error {
+				return SendAbandonedCheckout(tx, to, user.Name, item, amount)
+			},
+		})
+	})
+}
+
+// --- Handlers ---
+
+// RunPaymentSweep - Admin - Run the stale payment sweeper now
+func RunPaymentSweep(c *gin.Context) {
+	c.JSON(http.StatusOK, SweepStalePayments())
+}
+
+// GET /admin/reports/abandonment?from=2024-04-01&to=2024-06-30
+// Checkout outcomes and abandonment rates by gateway and product.
+func GetAbandonmentReport(c *gin.Context) {
+	fromDate, toDate, err := parseReportRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var payments []Payment
+	db.Select("id, user_id, order_id, razorpay_payment_id, amount, currency, status, product_type, abandoned_email_sent_at, created_at").
+		Where("created_at BETWEEN ? AND ?", fromDate, toDate).Find(&payments)
+
+	type bucket struct {
+		Total           int                `json:"total"`
+		Succeeded       int                `json:"succeeded"`
+		Failed          int                `json:"failed"`
+		Expired         int                `json:"expired"`
+		Pending         int                `json:"pending"`
+		AbandonmentRate float64            `json:"abandonment_rate"` // Expired / settled checkouts
+		LostRevenue     map[string]float64 `json:"lost_revenue"`     // By currency
+	}
+	newBucket := func() *bucket { return &bucket{LostRevenue: map[string]float64{}} }
+
+	overall := newBucket()
+	byGateway := map[string]*bucket{}
+	byProduct := map[string]*bucket{}
+	emailed, recovered := 0, 0
+
+	successAfter := map[uint][]time.Time{}
+	for _, p := range payments {
+		if p.Status == "success" || p.Status == "refunded" || p.Status == "partially_refunded" {
+			successAfter[p.UserID] = append(successAfter[p.UserID], p.CreatedAt)
+		}
+	}
+
+	for _, p := range payments {
+		gateway := paymentGateway(&p)
+		product := p.ProductType
+		if product == "" {
+			product = "unknown"
+		}
+		if byGateway[gateway] == nil {
+			byGateway[gateway] = newBucket()
+		}
+		if byProduct[product] == nil {
+			byProduct[product] = newBucket()
+		}
+
+		for _, b := range []*bucket{overall, byGateway[gateway], byProduct[product]} {
+			b.Total++
+			switch p.Status {
+			case "success", "refunded", "partially_refunded":
+				b.Succeeded++
+			case "failed":
+				b.Failed++
+			case "expired":
+				b.Expired++
+				b.LostRevenue[p.Currency] = roundMoney(b.LostRevenue[p.Currency] + p.Amount)
+			default:
+				b.Pending++
+			}
+		}
+
+		if p.AbandonedEmailSentAt != nil {
+			emailed++
+			for _, t := range successAfter[p.UserID] {
+				if t.After(*p.AbandonedEmailSentAt) {
+					recovered++
+					break
+				}
+			}
+		}
+	}
+
+	buckets := []*bucket{overall}
+	for _, b := range byGateway {
+		buckets = append(buckets, b)
+	}
+	for _, b := range byProduct {
+		buckets = append(buckets, b)
+	}
+	for _, b := range buckets {
+		if settled := b.Succeeded + b.Failed + b.Expired; settled > 0 {
+			b.AbandonmentRate = roundMoney(float64(b.Expired) / float64(settled))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":       fromDate,
+		"to":         toDate,
+		"overall":    overall,
+		"by_gateway": byGateway,
+		"by_product": byProduct,
+		"abandoned_emails": gin.H{
+			"sent":      emailed,
+			"recovered": recovered,
+		},
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumePayment(t *testing.T) {
+	setupTestDB(t, &Payment{})
+
+	paid := Payment{UserID: 1, Amount: 100, Currency: "INR", Status: "success", ProductType: ProductClass, ProductID: 7}
+	unpaid := Payment{UserID: 1, Amount: 100, Currency: "INR", Status: "created", ProductType: ProductClass, ProductID: 7}
+	require.NoError(t, db.Create(&paid).Error)
+	require.NoError(t, db.Create(&unpaid).Error)
+
+	forClass := func(uid, classID uint) func(*Payment) error {
+		return func(p *Payment) error { return checkProductPayment(p, uid, ProductClass, classID) }
+	}
+	cases := []struct {
+		name      string
+		paymentID uint
+		check     func(*Payment) error
+		err       string
+	}{
+		{"unknown payment", 404, forClass(1, 7), "payment not found"},
+		{"someone else's payment", paid.ID, forClass(2, 7), "payment belongs to another user"},
+		{"unpaid", unpaid.ID, forClass(1, 7), "payment not completed"},
+		{"a different class", paid.ID, forClass(1, 8), "payment was not for this product"},
+		{"the class it paid for", paid.ID, forClass(1, 7), ""},
+		{"spent twice", paid.ID, forClass(1, 7), "payment already used"},
+	}
+	for _, tc := range cases {
+		payment, err := ConsumePayment(db, tc.paymentID, "booking", tc.check)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		assert.NotNil(t, payment.ConsumedAt, tc.name)
+	}
+
+	db.First(&paid, paid.ID)
+	assert.Equal(t, "booking", paid.ConsumedBy)
+	db.First(&unpaid, unpaid.ID)
+	assert.Nil(t, unpaid.ConsumedAt, "failed checks leave the payment unspent")
+}
@@ -31,6 +31,13 @@ type PayPalOrderInput struct {
 	Amount      float64 `json:"amount" binding:"required"`
 	Currency    string  `json:"currency" binding:"required"` // USD, EUR, GBP, etc.
 	Description string  `json:"description"`
+	ProductType string  `json:"product_type"`
+	ProductID   uint    `json:"product_id"`
+	PromoCode   string  `json:"promo_code"`
+	CountryCode string  `json:"country_code"`
+	RegionCode  string  `json:"region_code"`
+	TaxID       string  `json:"tax_id"`
+	UseWallet   bool    `json:"use_wallet"`
 }
 
 type PayPalCaptureInput struct {
@@ -93,8 +100,7 @@ func getPayPalAccessToken() (string, error) {
 
 // CreatePayPalOrder - Protected - Creates a PayPal Order
 func CreatePayPalOrder(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
+	if _, exists := c.Get("userID"); !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
@@ -116,24 +122,45 @@ func CreatePayPalOrder(c *gin.Context) {
 		return
 	}
 
-	// Get access token
-	accessToken, err := getPayPalAccessToken()
+	uid, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	detectedCountry := resolveCheckoutCountry(c, &input.CountryCode)
+	if err := checkGatewayRouting(detectedCountry, GatewayPayPal); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "gateway": gatewayForCountry(detectedCountry)})
+		return
+	}
+
+	// Price the order server-side; the client amount is the pre-discount subtotal
+	pricing, err := PriceOrder(db, OrderPricingInput{
+		UserID:      uid,
+		ProductType: input.ProductType,
+		ProductID:   input.ProductID,
+		Subtotal:    input.Amount,
+		Currency:    currency,
+		PromoCode:   input.PromoCode,
+		CountryCode: input.CountryCode,
+		RegionCode:  input.RegionCode,
+		TaxID:       input.TaxID,
+		UseWallet:   input.UseWallet,
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate with PayPal", "details": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if pricing.GatewayAmount() <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order is covered by wallet balance; use wallet checkout"})
+		return
+	}
+	input.Amount = pricing.GatewayAmount()
 
-	// Safely convert userID
-	var uid uint
-	switch v := userID.(type) {
-	case float64:
-		uid = uint(v)
-	case uint:
-		uid = v
-	case int:
-		uid = uint(v)
-	default:
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+	// Get access token
+	accessToken, err := getPayPalAccessToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate with PayPal", "details": err.Error()})
 		return
 	}
 
@@ -192,14 +219,22 @@ func CreatePayPalOrder(c *gin.Context) {
 
 	// Save to DB
 	payment := Payment{
-		UserID:   uid,
-		OrderID:  orderID,
-		Amount:   input.Amount,
-		Currency: currency,
-		Method:   "paypal",
-		Status:   "created",
+		UserID:        uid,
+		OrderID:       orderID,
+		Currency:      currency,
+		CountryCode:   input.CountryCode,
+		RegionCode:    input.RegionCode,
+		CustomerTaxID: input.TaxID,
+		ProductType:   input.ProductType,
+		ProductID:     input.ProductID,
+		Method:        "paypal",
+		Status:        "created",
+	}
+	flagCountryMismatch(&payment, detectedCountry)
+	if err := CreateOrderPayment(&payment, pricing); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save payment record"})
+		return
 	}
-	db.Create(&payment)
 
 	c.JSON(http.StatusOK, gin.H{
 		"order_id":   orderID,
@@ -272,7 +307,16 @@ func CapturePayPalOrder(c *gin.Context) {
 		if err := db.Where("order_id = ?", orderID).First(&payment).Error; err == nil {
 			payment.Status = "success"
 			payment.PaymentID = captureRes["id"].(string) // Use capture ID
+			payment.PayPalCaptureID = paypalCaptureID(captureRes)
 			db.Save(&payment)
+			ExtendSeatHold(db, payment.ID)
+
+			if err := issueInvoiceForPayment(&payment); err != nil {
+				fmt.Printf("WARNING: Failed to issue invoice for payment %d: %v\n", payment.ID, err)
+			}
+			if err := ActivateGiftCard(&payment); err != nil {
+				fmt.Printf("WARNING: Failed to activate gift card for payment %d: %v\n", payment.ID, err)
+			}
 		}
 
 		c.JSON(http.StatusOK, gin.H{
@@ -288,6 +332,23 @@ func CapturePayPalOrder(c *gin.Context) {
 	}
 }
 
+// paypalCaptureID digs the capture ID out of an order capture response
+func paypalCaptureID(captureRes map[string]interface{}) string {
+	units, _ := captureRes["purchase_units"].([]interface{})
+	for _, u := range units {
+		unit, _ := u.(map[string]interface{})
+		payments, _ := unit["payments"].(map[string]interface{})
+		captures, _ := payments["captures"].([]interface{})
+		for _, c := range captures {
+			capture, _ := c.(map[string]interface{})
+			if id, ok := capture["id"].(string); ok {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
 // GetPayPalConfig - Public - Returns PayPal client ID for frontend
 func GetPayPalConfig(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Rounding modes for converted prices
+const (
+	RoundNone      = "none"          // Two decimal places
+	RoundWhole     = "whole"         // Whole units, e.g. whole rupees
+	RoundEnding99  = "ending_99"     // 24.99
+	RoundEnding95  = "ending_95"     // 24.95
+	RoundNearest49 = "nearest_49_99" // Whole prices ending in 49 or 99, e.g. 1,649 / 1,699
+)
+
+// --- Models ---
+
+// CurrencyRoundingRule controls how prices converted from AUD are rounded
+type CurrencyRoundingRule struct {
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	Currency string `json:"currency" gorm:"uniqueIndex;not null"`
+	Mode     string `json:"mode" binding:"required"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PriceOverride fixes a product's price in one currency instead of converting it
+type PriceOverride struct {
+	ID          uint    `json:"id" gorm:"primaryKey"`
+	ProductType string  `json:"product_type" gorm:"uniqueIndex:idx_price_override" binding:"required"` // membership (class pack), class, program
+	ProductID   uint    `json:"product_id" gorm:"uniqueIndex:idx_price_override" binding:"required"`
+	Currency    string  `json:"currency" gorm:"uniqueIndex:idx_price_override" binding:"required"`
+	Amount      float64 `json:"amount" binding:"required"`
+	Note        string  `json:"note"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// --- DTOs ---
+
+// PriceListItem is one product's price in a currency
+type PriceListItem struct {
+	ProductType  string  `json:"product_type"`
+	ProductID    uint    `json:"product_id"`
+	Code         string  `json:"code,omitempty"`
+	Name         string  `json:"name"`
+	BaseAmount   float64 `json:"base_amount"`
+	BaseCurrency string  `json:"base_currency"`
+	Amount       float64 `json:"amount"`
+	Currency     string  `json:"currency"`
+	Source       string  `json:"source"` // base, converted, override
+}
+
+type PriceList struct {
+	Currency string          `json:"currency"`
+	Rounding string          `json:"rounding"`
+	RateDate string          `json:"rate_date"`
+	Items    []PriceListItem `json:"items"`
+}
+
+// --- Logic ---
+
+// applyRounding rounds a converted price to the nearest price allowed by mode
+func applyRounding(amount float64, mode string) float64 {
+	if amount <= 0 {
+		return 0
+	}
+	switch mode {
+	case RoundWhole:
+		return math.Max(1, math.Round(amount))
+	case RoundEnding99:
+		return roundMoney(math.Max(1, math.Round(amount+0.01)) - 0.01)
+	case RoundEnding95:
+		return roundMoney(math.Max(1, math.Round(amount+0.05)) - 0.05)
+	case RoundNearest49:
+		return math.Max(1, math.Round((amount+1)/50))*50 - 1
+	}
+	return roundMoney(amount)
+}
+
+func validRoundingMode(mode string) bool {
+	switch mode {
+	case RoundNone, RoundWhole, RoundEnding99, RoundEnding95, RoundNearest49:
+		return true
+	}
+	return false
+}
+
+func roundingModeFor(tx *gorm.DB, currency string) string {
+	var rule CurrencyRoundingRule
+	if tx.Where("currency = ?", currency).First(&rule).Error != nil {
+		return RoundNone
+	}
+	return rule.Mode
+}
+
+// LocalizePrice converts a base price into a currency, unless an admin has
+// fixed the price for that product and currency
+func LocalizePrice(tx *gorm.DB, item PriceListItem, currency string) (PriceListItem, error) {
+	currency = strings.ToUpper(currency)
+	item.Currency = currency
+
+	var override PriceOverride
+	if tx.Where("product_type = ? AND product_id = ? AND currency = ?", item.ProductType, item.ProductID, currency).
+		First(&override).Error == nil {
+		item.Amount = override.Amount
+		item.Source = "override"
+		return item, nil
+	}
+
+	if currency == item.BaseCurrency {
+		item.Amount = roundMoney(item.BaseAmount)
+		item.Source = "base"
+		return item, nil
+	}
+
+	converted, err := ConvertCurrency(item.BaseAmount, item.BaseCurrency, currency)
+	if err != nil {
+		return item, err
+	}
+	item.Amount = applyRounding(converted, roundingModeFor(tx, currency))
+	item.Source = "converted"
+	return item, nil
+}
+
+// catalogItem looks up the base price of a product sold from the price list.
+// ok is false for products priced elsewhere (services, gift cards, instalments);
+// catalogue products without a valid, listed product are an error.
+func catalogItem(tx *gorm.DB, productType string, productID uint) (item PriceListItem, ok bool, err error) {
+	switch productType {
+	case ProductMembership, ProductClass, ProductProgram:
+		if productID == 0 {
+			return item, true, fmt.Errorf("product_id is required for %s", productType)
+		}
+	}
+
+	switch productType {
+	case ProductMembership:
+		var pack ClassPack
+		if err := tx.Where("id = ? AND is_active = ?", productID, true).First(&pack).Error; err != nil {
+			return item, true, errors.New("class pack not found")
+		}
+		return classPackItem(pack), true, nil
+	case ProductClass:
+		var class Class
+		if err := tx.First(&class, productID).Error; err != nil {
+			return item, true, errors.New("class not found")
+		}
+		if class.PriceCents <= 0 {
+			return item, true, errors.New("class is not sold individually")
+		}
+		return classItem(class), true, nil
+	case ProductProgram:
+		var program Program
+		if err := tx.First(&program, productID).Error; err != nil {
+			return item, true, errors.New("program not found")
+		}
+		if program.Price <= 0 {
+			return item, true, errors.New("program is not for sale")
+		}
+		return programItem(program), true, nil
+	}
+	return item, false, nil
+}
+
+func classPackItem(pack ClassPack) PriceListItem {
+	return PriceListItem{
+		ProductType:  ProductMembership,
+		ProductID:    pack.ID,
+		Code:         pack.Code,
+		Name:         pack.Name,
+		BaseAmount:   pack.PriceAUD,
+		BaseCurrency: rateBase,
+	}
+}
+
+func classItem(class Class) PriceListItem {
+	baseCurrency := strings.ToUpper(class.Currency)
+	if baseCurrency == "" {
+		baseCurrency = rateBase
+	}
+	return PriceListItem{
+		ProductType:  ProductClass,
+		ProductID:    class.ID,
+		Name:         class.Name,
+		BaseAmount:   float64(class.PriceCents) / 100,
+		BaseCurrency: baseCurrency,
+	}
+}
+
+func programItem(program Program) PriceListItem {
+	return PriceListItem{
+		ProductType:  ProductProgram,
+		ProductID:    program.ID,
+		Name:         program.Name,
+		BaseAmount:   program.Price,
+		BaseCurrency: programCurrency,
+	}
+}
+
+// BuildPriceList prices every catalogue product in a currency
+func BuildPriceList(tx *gorm.DB, currency string) (PriceList, error) {
+	currency = strings.ToUpper(currency)
+	list := PriceList{Currency: currency, Rounding: roundingModeFor(tx, currency), Items: []PriceListItem{}}
+
+	var items []PriceListItem
+	var packs []ClassPack
+	tx.Where("is_active = ?", true).Order("sort_order asc, id asc").Find(&packs)
+	for _, pack := range packs {
+		items = append(items, classPackItem(pack))
+	}
+	var classes []Class
+	tx.Where("price_cents > 0").Order("id asc").Find(&classes)
+	for _, class := range classes {
+		items = append(items, classItem(class))
+	}
+	var programs []Program
+	tx.Where("price > 0").Order("id asc").Find(&programs)
+	for _, program := range programs {
+		items = append(items, programItem(program))
+	}
+
+	for _, item := range items {
+		priced, err := LocalizePrice(tx, item, currency)
+		if err != nil {
+			return list, err
+		}
+		list.Items = append(list.Items, priced)
+	}
+
+	ratesMutex.RLock()
+	list.RateDate = exchangeRatesDate
+	ratesMutex.RUnlock()
+	return list, nil
+}
+
+// --- Handlers ---
+
+// GetPriceLists - Public - Price lists for every supported currency, keyed by
+// currency. Currencies without a rate are left out.
+func GetPriceLists(c *gin.Context) {
+	lists := map[string]PriceList{}
+	for _, currency := range append([]string{rateBase}, supportedCurrencies...) {
+		list, err := BuildPriceList(db, currency)
+		if err != nil {
+			fmt.Printf("WARNING: No %s price list: %v\n", currency, err)
+			continue
+		}
+		lists[currency] = list
+	}
+	c.JSON(http.StatusOK, lists)
+}
+
+// GetPriceList - Public - Price list in one currency
+func GetPriceList(c *gin.Context) {
+	list, err := BuildPriceList(db, c.Param("currency"))
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Prices are unavailable: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// GetRoundingRules - Admin - Rounding rule per currency
+func GetRoundingRules(c *gin.Context) {
+	var rules []CurrencyRoundingRule
+	db.Order("currency asc").Find(&rules)
+	c.JSON(http.StatusOK, rules)
+}
+
+// SetRoundingRule - Admin - Create or change a currency's rounding rule
+func SetRoundingRule(c *gin.Context) {
+	var input CurrencyRoundingRule
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validRoundingMode(input.Mode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be none, whole, ending_99, ending_95 or nearest_49_99"})
+		return
+	}
+
+	rule := CurrencyRoundingRule{Currency: strings.ToUpper(c.Param("currency"))}
+	db.Where("currency = ?", rule.Currency).FirstOrInit(&rule)
+	rule.Mode = input.Mode
+	if err := db.Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save rounding rule"})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// GetPriceOverrides - Admin - All fixed prices
+func GetPriceOverrides(c *gin.Context) {
+	var overrides []PriceOverride
+	db.Order("product_type asc, product_id asc, currency asc").Find(&overrides)
+	c.JSON(http.StatusOK, overrides)
+}
+
+// SetPriceOverride - Admin - Fix a product's price in a currency
+func SetPriceOverride(c *gin.Context) {
+	var input PriceOverride
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be greater than zero"})
+		return
+	}
+	if _, ok, err := catalogItem(db, input.ProductType, input.ProductID); !ok || err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product is not on the price list"})
+		return
+	}
+
+	override := PriceOverride{ProductType: input.ProductType, ProductID: input.ProductID, Currency: strings.ToUpper(input.Currency)}
+	db.Where(&override).FirstOrInit(&override)
+	override.Amount = roundMoney(input.Amount)
+	override.Note = input.Note
+	if err := db.Save(&override).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save price override"})
+		return
+	}
+	c.JSON(http.StatusOK, override)
+}
+
+// DeletePriceOverride - Admin - Go back to the converted price
+func DeletePriceOverride(c *gin.Context) {
+	if err := db.Delete(&PriceOverride{}, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete price override"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Price override removed"})
+}
+
+// --- Seeder ---
+
+func SeedRoundingRules() {
+	var count int64
+	db.Model(&CurrencyRoundingRule{}).Count(&count)
+	if count > 0 {
+		return
+	}
+
+	rules := []CurrencyRoundingRule{
+		{Currency: "AUD", Mode: RoundNone},
+		{Currency: "INR", Mode: RoundNearest49},
+		{Currency: "USD", Mode: RoundEnding99},
+		{Currency: "GBP", Mode: RoundEnding99},
+		{Currency: "CAD", Mode: RoundEnding99},
+		{Currency: "NZD", Mode: RoundEnding99},
+		{Currency: "SGD", Mode: RoundEnding99},
+		{Currency: "EUR", Mode: RoundEnding95},
+		{Currency: "AED", Mode: RoundWhole},
+	}
+	for _, rule := range rules {
+		db.Create(&rule)
+	}
+}
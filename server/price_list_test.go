@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestApplyRounding(t *testing.T) {
+	cases := []struct {
+		amount float64
+		mode   string
+		want   float64
+	}{
+		{4412.37, RoundNone, 4412.37},
+		{4412.37, RoundWhole, 4412},
+		{4412.37, RoundNearest49, 4399},
+		{4430, RoundNearest49, 4449},
+		{12.5, RoundNearest49, 49},
+		{19.42, RoundEnding99, 18.99},
+		{19.62, RoundEnding99, 19.99},
+		{0.3, RoundEnding99, 0.99},
+		{17.9, RoundEnding95, 17.95},
+		{17.4, RoundEnding95, 16.95},
+		{0.4, RoundWhole, 1},
+	}
+	for _, tc := range cases {
+		if got := applyRounding(tc.amount, tc.mode); got != tc.want {
+			t.Errorf("applyRounding(%v, %q) = %v, want %v", tc.amount, tc.mode, got, tc.want)
+		}
+	}
+}
@@ -22,14 +22,15 @@ type Professional struct {
 	// Location & Delivery
 	Country   string         `json:"country"`
 	City      string         `json:"city"`
-	Latitude  float64        `json:"latitude"`
-	Longitude float64        `json:"longitude"`
+	Latitude  float64        `json:"latitude" gorm:"index:idx_professionals_location"`
+	Longitude float64        `json:"longitude" gorm:"index:idx_professionals_location"`
 	Languages datatypes.JSON `json:"languages"` // Array of strings e.g. ["English", "Spanish"]
 
 	// Verification & Stats
 	YearsExperience    int     `json:"years_experience"`
 	Rating             float64 `json:"rating"`
 	ReviewCount        int     `json:"review_count"`
+	WeightedRating     float64 `json:"weighted_rating"` // Bayesian average used for ranking; equals Rating when disabled
 	IsFeatured         bool    `json:"is_featured"`
 	VerificationStatus string  `json:"verification_status" gorm:"default:'pending'"` // pending, approved, rejected, needs_info
 
@@ -82,6 +83,20 @@ type ProfessionalAvailability struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// ProfessionalTimeOff blocks out leave and holidays on top of the weekly
+// schedule; nothing can be booked inside it
+type ProfessionalTimeOff struct {
+	ID             uuid.UUID    `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	ProfessionalID uuid.UUID    `json:"professional_id" gorm:"type:uuid;not null;index"`
+	Professional   Professional `json:"-" gorm:"foreignKey:ProfessionalID"`
+
+	StartTime time.Time `json:"start_time" binding:"required"`
+	EndTime   time.Time `json:"end_time" binding:"required"`
+	Reason    string    `json:"reason"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
 // ProfessionalApplication stores data for unverified applicants
 type ProfessionalApplication struct {
 	ID uuid.UUID `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
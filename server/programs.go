@@ -1,13 +1,23 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var (
+	errProgramFull     = errors.New("Program is full")
+	errAlreadyEnrolled = errors.New("Already enrolled in this program")
+)
+
+// programCurrency is the currency program prices are set in
+const programCurrency = "INR"
+
 // --- Models ---
 
 type Program struct {
@@ -15,8 +25,8 @@ type Program struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	Duration    string    `json:"duration"` // e.g. "8 weeks"
-	Price       float64   `json:"price"`
-	Level       string    `json:"level"` // Beginner, Intermediate, Advanced
+	Price       float64   `json:"price"`    // In programCurrency
+	Level       string    `json:"level"`    // Beginner, Intermediate, Advanced
 	StartDate   time.Time `json:"start_date"`
 	MaxStudents int       `json:"max_students"`
 }
@@ -26,10 +36,21 @@ type ProgramEnrollment struct {
 	UserID         uint      `json:"user_id"`
 	ProgramID      uint      `json:"program_id"`
 	Program        Program   `json:"program" gorm:"foreignKey:ProgramID"`
-	PaymentStatus  string    `json:"payment_status"` // "pending", "paid"
+	PaymentStatus  string    `json:"payment_status"` // "pending", "partially_paid", "paid"
 	PaymentID      uint      `json:"payment_id"`     // Link to internal Payment ID
 	AmountPaid     float64   `json:"amount_paid"`
 	EnrollmentDate time.Time `json:"enrollment_date"`
+
+	// Instalments
+	InstalmentPlanID *uint   `json:"instalment_plan_id"`
+	TotalAmount      float64 `json:"total_amount"`
+	AccessStatus     string  `json:"access_status" gorm:"default:'active'"` // active, suspended (overdue instalment)
+}
+
+// HasAccess reports whether the student may use the program. Access is
+// suspended while an instalment is overdue past the plan's grace period.
+func (e ProgramEnrollment) HasAccess() bool {
+	return e.AccessStatus == "active"
 }
 
 // --- Handlers ---
@@ -91,13 +112,15 @@ func CreateProgram(c *gin.Context) {
 }
 
 // POST /api/programs/enroll
-// Body: { "program_id": 123, "payment_id": 45 }
+// Body: { "program_id": 123, "payment_id": 45, "instalment_plan_id": 2 }
+// With an instalment plan the payment is the deposit.
 func EnrollProgram(c *gin.Context) {
-	userID, _ := c.Get("userID")
+	userID, _ := currentUserID(c)
 
 	var input struct {
-		ProgramID uint        `json:"program_id" binding:"required"`
-		PaymentID interface{} `json:"payment_id" binding:"required"`
+		ProgramID        uint        `json:"program_id" binding:"required"`
+		PaymentID        interface{} `json:"payment_id" binding:"required"`
+		InstalmentPlanID uint        `json:"instalment_plan_id"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -112,24 +135,6 @@ func EnrollProgram(c *gin.Context) {
 		return
 	}
 
-	// 2. Check Capacity
-	var currentEnrollments int64
-	db.Model(&ProgramEnrollment{}).Where("program_id = ?", program.ID).Count(&currentEnrollments)
-
-	if int(currentEnrollments) >= program.MaxStudents {
-		c.JSON(http.StatusConflict, gin.H{"error": "Program is full"})
-		return
-	}
-
-	// 3. Check if already enrolled
-	var existing ProgramEnrollment
-	if err := db.Where("user_id = ? AND program_id = ?", userID, program.ID).First(&existing).Error; err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "Already enrolled in this program"})
-		return
-	}
-
-	// 4. Verify Payment logic...
-
 	// Handle PaymentID type (frontend might send string or number)
 	var finalPaymentID uint
 	switch v := input.PaymentID.(type) {
@@ -144,24 +149,89 @@ func EnrollProgram(c *gin.Context) {
 		return
 	}
 
-	// 5. Create Enrollment
-	enrollment := ProgramEnrollment{
-		UserID:         userID.(uint),
-		ProgramID:      program.ID,
-		PaymentStatus:  "paid",
-		PaymentID:      finalPaymentID,
-		AmountPaid:     program.Price,
-		EnrollmentDate: time.Now(),
-	}
-
-	if err := db.Create(&enrollment).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enroll"})
+	var enrollment *ProgramEnrollment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 2. Check Capacity, locked against concurrent enrollments
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&program, program.ID).Error; err != nil {
+			return err
+		}
+		var currentEnrollments int64
+		tx.Model(&ProgramEnrollment{}).Where("program_id = ?", program.ID).Count(&currentEnrollments)
+		if int(currentEnrollments) >= program.MaxStudents {
+			return errProgramFull
+		}
+
+		// 3. Check if already enrolled
+		var existing ProgramEnrollment
+		if err := tx.Where("user_id = ? AND program_id = ?", userID, program.ID).First(&existing).Error; err == nil {
+			return errAlreadyEnrolled
+		}
+
+		// 4. Verify Payment and create Enrollment. With a plan the payment is the deposit.
+		if input.InstalmentPlanID != 0 {
+			var err error
+			enrollment, err = EnrollWithInstalments(tx, userID, &program, input.InstalmentPlanID, finalPaymentID)
+			return err
+		}
+
+		if _, err := ConsumePayment(tx, finalPaymentID, "enrollment", func(p *Payment) error {
+			return checkProductPayment(p, userID, ProductProgram, program.ID)
+		}); err != nil {
+			return err
+		}
+		// Payments spent before ConsumedAt was recorded
+		var used int64
+		tx.Model(&ProgramEnrollment{}).Where("payment_id = ?", finalPaymentID).Count(&used)
+		if used > 0 {
+			return errors.New("payment already used")
+		}
+
+		enrollment = &ProgramEnrollment{
+			UserID:         userID,
+			ProgramID:      program.ID,
+			PaymentStatus:  "paid",
+			PaymentID:      finalPaymentID,
+			AmountPaid:     program.Price,
+			TotalAmount:    program.Price,
+			AccessStatus:   "active",
+			EnrollmentDate: time.Now(),
+		}
+		return tx.Create(enrollment).Error
+	})
+	if err != nil {
+		switch err {
+		case errProgramFull, errAlreadyEnrolled:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{"message": "Enrollment successful", "enrollment": enrollment})
 }
 
+// GET /api/programs/:id/access
+// Whether the caller may use the program's content; 403 while suspended.
+func GetProgramAccess(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var enrollment ProgramEnrollment
+	if err := db.Where("user_id = ? AND program_id = ?", uid, c.Param("id")).First(&enrollment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not enrolled in this program"})
+		return
+	}
+	if !enrollment.HasAccess() {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":         "Access is suspended until the overdue instalment is paid",
+			"access_status": enrollment.AccessStatus,
+			"enrollment_id": enrollment.ID,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"access_status": enrollment.AccessStatus, "enrollment_id": enrollment.ID})
+}
+
 // GET /api/programs/my
 func GetMyEnrollments(c *gin.Context) {
 	userID, _ := c.Get("userID")
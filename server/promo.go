@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Product types a promo code can be restricted to
+const (
+	ProductMembership = "membership"
+	ProductProgram    = "program"
+	ProductClass      = "class"
+	ProductService    = "service"
+)
+
+// --- Models ---
+
+type PromoCode struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Code        string `json:"code" gorm:"uniqueIndex;not null"` // Stored upper-case
+	Description string `json:"description"`
+
+	DiscountType  string  `json:"discount_type"`  // "percent", "fixed"
+	DiscountValue float64 `json:"discount_value"` // 0-100 for percent, amount in Currency for fixed
+	Currency      string  `json:"currency" gorm:"default:'AUD'"`
+
+	// Restrictions
+	ProductTypes      string         `json:"product_types"`     // Comma separated, empty = all products
+	MaxUses           int            `json:"max_uses"`          // 0 = unlimited
+	MaxUsesPerUser    int            `json:"max_uses_per_user"` // 0 = unlimited
+	FirstPurchaseOnly bool           `json:"first_purchase_only"`
+	StartsAt          *time.Time     `json:"starts_at"`
+	EndsAt            *time.Time     `json:"ends_at"`
+	MinOrderAmounts   datatypes.JSON `json:"min_order_amounts"` // e.g. {"AUD": 20, "INR": 1000}
+
+	IsActive bool `json:"is_active"` // No column default, so an inactive code stays inactive on create
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// PromoRedemption records a code applied to an order
+type PromoRedemption struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	PromoCodeID    uint      `json:"promo_code_id" gorm:"index"`
+	UserID         uint      `json:"user_id" gorm:"index"`
+	PaymentID      uint      `json:"payment_id" gorm:"uniqueIndex"`
+	ProductType    string    `json:"product_type"`
+	DiscountAmount float64   `json:"discount_amount"`
+	Currency       string    `json:"currency"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// --- DTO ---
+type ValidatePromoInput struct {
+	Code        string  `json:"code" binding:"required"`
+	ProductType string  `json:"product_type" binding:"required"`
+	Amount      float64 `json:"amount" binding:"required"`
+	Currency    string  `json:"currency" binding:"required"`
+}
+
+// --- Logic ---
+
+// ApplyPromoCode validates a code for this user/order and returns the discount
+// in the order currency. It does not record a redemption.
+func ApplyPromoCode(tx *gorm.DB, code string, uid uint, productType string, amount float64, currency string) (*PromoCode, float64, error) {
+	currency = strings.ToUpper(currency)
+
+	var promo PromoCode
+	if err := tx.Where("code = ? AND is_active = ?", strings.ToUpper(strings.TrimSpace(code)), true).First(&promo).Error; err != nil {
+		return nil, 0, errors.New("invalid promo code")
+	}
+
+	now := time.Now()
+	if promo.StartsAt != nil && now.Before(*promo.StartsAt) {
+		return nil, 0, errors.New("promo code is not active yet")
+	}
+	if promo.EndsAt != nil && now.After(*promo.EndsAt) {
+		return nil, 0, errors.New("promo code has expired")
+	}
+
+	if !promo.AppliesTo(productType) {
+		return nil, 0, errors.New("promo code does not apply to this product")
+	}
+
+	minAmount, err := promo.minOrderAmount(currency)
+	if err != nil {
+		return nil, 0, err
+	}
+	if amount < minAmount {
+		return nil, 0, errors.New("order amount is below the minimum for this promo code")
+	}
+
+	if err := checkPromoCaps(tx, &promo, uid); err != nil {
+		return nil, 0, err
+	}
+
+	if promo.FirstPurchaseOnly {
+		var purchases int64
+		tx.Model(&Payment{}).Where("user_id = ? AND status = ?", uid, "success").Count(&purchases)
+		if purchases > 0 {
+			return nil, 0, errors.New("promo code is only valid on your first purchase")
+		}
+	}
+
+	var discount float64
+	switch promo.DiscountType {
+	case "percent":
+		discount = amount * promo.DiscountValue / 100
+	case "fixed":
+		discount, err = ConvertCurrency(promo.DiscountValue, promo.Currency, currency)
+		if err != nil {
+			return nil, 0, err
+		}
+	default:
+		return nil, 0, errors.New("promo code is misconfigured")
+	}
+
+	discount = math.Round(discount*100) / 100
+	if discount > amount {
+		discount = amount
+	}
+	return &promo, discount, nil
+}
+
+// AppliesTo reports whether the code may be used on the given product type
+func (p *PromoCode) AppliesTo(productType string) bool {
+	if strings.TrimSpace(p.ProductTypes) == "" {
+		return true
+	}
+	for _, t := range strings.Split(p.ProductTypes, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), productType) {
+			return true
+		}
+	}
+	return false
+}
+
+// minOrderAmount returns the minimum for the currency, converting the AUD
+// minimum when no currency-specific value is configured.
+func (p *PromoCode) minOrderAmount(currency string) (float64, error) {
+	if len(p.MinOrderAmounts) == 0 {
+		return 0, nil
+	}
+	var mins map[string]float64
+	if err := json.Unmarshal(p.MinOrderAmounts, &mins); err != nil {
+		return 0, errors.New("promo code is misconfigured")
+	}
+	if min, ok := mins[currency]; ok {
+		return min, nil
+	}
+	if min, ok := mins["AUD"]; ok {
+		return ConvertCurrency(min, "AUD", currency)
+	}
+	return 0, nil
+}
+
+// checkPromoCaps enforces MaxUses and MaxUsesPerUser. Caps only count
+// orders that are pending or paid.
+func checkPromoCaps(tx *gorm.DB, promo *PromoCode, uid uint) error {
+	if promo.MaxUses > 0 {
+		var used int64
+		promoRedemptionsInUse(tx).Where("promo_redemptions.promo_code_id = ?", promo.ID).Count(&used)
+		if used >= int64(promo.MaxUses) {
+			return errors.New("promo code usage limit reached")
+		}
+	}
+	if promo.MaxUsesPerUser > 0 {
+		var used int64
+		promoRedemptionsInUse(tx).Where("promo_redemptions.promo_code_id = ? AND promo_redemptions.user_id = ?", promo.ID, uid).Count(&used)
+		if used >= int64(promo.MaxUsesPerUser) {
+			return errors.New("you have already used this promo code")
+		}
+	}
+	return nil
+}
+
+// redeemPromoCode records a code against a new order. The code row is locked
+// and the caps counted again, so concurrent checkouts that all passed
+// ApplyPromoCode can't go over them.
+func redeemPromoCode(tx *gorm.DB, promoID uint, payment *Payment) error {
+	var promo PromoCode
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND is_active = ?", promoID, true).First(&promo).Error; err != nil {
+		return errors.New("invalid promo code")
+	}
+	if err := checkPromoCaps(tx, &promo, payment.UserID); err != nil {
+		return err
+	}
+	return tx.Create(&PromoRedemption{
+		PromoCodeID:    promo.ID,
+		UserID:         payment.UserID,
+		PaymentID:      payment.ID,
+		ProductType:    payment.ProductType,
+		DiscountAmount: payment.DiscountAmount,
+		Currency:       payment.Currency,
+	}).Error
+}
+
+func promoRedemptionsInUse(tx *gorm.DB) *gorm.DB {
+	return tx.Model(&PromoRedemption{}).
+		Joins("JOIN payments ON payments.id = promo_redemptions.payment_id").
+		Where("payments.status IN ?", []string{"created", "success"})
+}
+
+// --- Handlers ---
+
+// ValidatePromo - Protected - Preview the discount for a code before checkout
+func ValidatePromo(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var input ValidatePromoInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	promo, discount, err := ApplyPromoCode(db, input.Code, uid, input.ProductType, input.Amount, input.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"valid": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"valid":           true,
+		"code":            promo.Code,
+		"discount_amount": discount,
+		"final_amount":    input.Amount - discount,
+		"currency":        strings.ToUpper(input.Currency),
+	})
+}
+
+// GetAdminPromoCodes - Admin - List promo codes with usage counts
+func GetAdminPromoCodes(c *gin.Context) {
+	var codes []PromoCode
+	db.Order("created_at desc").Find(&codes)
+
+	type promoWithUsage struct {
+		PromoCode
+		TimesUsed int64 `json:"times_used"`
+	}
+	response := []promoWithUsage{}
+	for _, p := range codes {
+		var used int64
+		promoRedemptionsInUse(db).Where("promo_redemptions.promo_code_id = ?", p.ID).Count(&used)
+		response = append(response, promoWithUsage{PromoCode: p, TimesUsed: used})
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// CreatePromoCode - Admin - Create a new promo code
+func CreatePromoCode(c *gin.Context) {
+	input := PromoCode{IsActive: true}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizePromoCode(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.Create(&input).Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Promo code already exists"})
+		return
+	}
+	c.JSON(http.StatusCreated, input)
+}
+
+// UpdatePromoCode - Admin - Update an existing promo code
+func UpdatePromoCode(c *gin.Context) {
+	var promo PromoCode
+	if err := db.First(&promo, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promo code not found"})
+		return
+	}
+
+	var input PromoCode
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizePromoCode(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	input.ID = promo.ID
+	input.CreatedAt = promo.CreatedAt
+	if err := db.Save(&input).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update promo code"})
+		return
+	}
+	c.JSON(http.StatusOK, input)
+}
+
+// DeletePromoCode - Admin - Deactivate and remove a promo code
+func DeletePromoCode(c *gin.Context) {
+	var promo PromoCode
+	if err := db.First(&promo, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promo code not found"})
+		return
+	}
+	db.Delete(&promo)
+	c.JSON(http.StatusOK, gin.H{"message": "Promo code deleted"})
+}
+
+// GetPromoRedemptions - Admin - List redemptions for a code
+func GetPromoRedemptions(c *gin.Context) {
+	var redemptions []PromoRedemption
+	db.Where("promo_code_id = ?", c.Param("id")).Order("created_at desc").Find(&redemptions)
+	c.JSON(http.StatusOK, redemptions)
+}
+
+func normalizePromoCode(p *PromoCode) error {
+	p.Code = strings.ToUpper(strings.TrimSpace(p.Code))
+	p.Currency = strings.ToUpper(p.Currency)
+	if p.Currency == "" {
+		p.Currency = "AUD"
+	}
+	if p.Code == "" {
+		return errors.New("code is required")
+	}
+	switch p.DiscountType {
+	case "percent":
+		if p.DiscountValue <= 0 || p.DiscountValue > 100 {
+			return errors.New("percent discount must be between 0 and 100")
+		}
+	case "fixed":
+		if p.DiscountValue <= 0 {
+			return errors.New("fixed discount must be greater than zero")
+		}
+	default:
+		return errors.New("discount_type must be percent or fixed")
+	}
+	for _, t := range strings.Split(p.ProductTypes, ",") {
+		switch strings.TrimSpace(t) {
+		case "", ProductMembership, ProductProgram, ProductClass, ProductService:
+		default:
+			return errors.New("unknown product type: " + t)
+		}
+	}
+	if p.StartsAt != nil && p.EndsAt != nil && p.EndsAt.Before(*p.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	return nil
+}
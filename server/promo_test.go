@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+)
+
+func TestApplyPromoCode(t *testing.T) {
+	setupTestDB(t, &User{}, &Payment{}, &PromoCode{}, &PromoRedemption{})
+	useTestRates(t, map[string]float64{"AUD": 1, "INR": 55})
+
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	codes := []PromoCode{
+		{Code: "TEN", DiscountType: "percent", DiscountValue: 10, Currency: "AUD", IsActive: true},
+		{Code: "FIVEOFF", DiscountType: "fixed", DiscountValue: 5, Currency: "AUD", IsActive: true},
+		{Code: "OFF", DiscountType: "percent", DiscountValue: 10, Currency: "AUD"},
+		{Code: "SOON", DiscountType: "percent", DiscountValue: 10, Currency: "AUD", IsActive: true, StartsAt: &future},
+		{Code: "GONE", DiscountType: "percent", DiscountValue: 10, Currency: "AUD", IsActive: true, EndsAt: &past},
+		{Code: "PACKS", DiscountType: "percent", DiscountValue: 10, Currency: "AUD", IsActive: true, ProductTypes: "membership"},
+		{Code: "MIN20", DiscountType: "fixed", DiscountValue: 5, Currency: "AUD", IsActive: true, MinOrderAmounts: datatypes.JSON(`{"AUD": 20}`)},
+		{Code: "NEWBIE", DiscountType: "percent", DiscountValue: 50, Currency: "AUD", IsActive: true, FirstPurchaseOnly: true},
+		{Code: "HUGE", DiscountType: "fixed", DiscountValue: 500, Currency: "AUD", IsActive: true},
+	}
+	require.NoError(t, db.Create(&codes).Error)
+	var off PromoCode
+	db.Where("code = ?", "OFF").First(&off)
+	require.False(t, off.IsActive, "inactive codes are stored as inactive")
+
+	require.NoError(t, db.Create(&Payment{UserID: 2, Status: "success"}).Error)
+
+	cases := []struct {
+		code        string
+		uid         uint
+		productType string
+		amount      float64
+		currency    string
+		discount    float64
+		err         string
+	}{
+		{"ten", 1, ProductClass, 30, "AUD", 3, ""},
+		{"FIVEOFF", 1, ProductClass, 1000, "inr", 275, ""},
+		{"OFF", 1, ProductClass, 30, "AUD", 0, "invalid promo code"},
+		{"SOON", 1, ProductClass, 30, "AUD", 0, "promo code is not active yet"},
+		{"GONE", 1, ProductClass, 30, "AUD", 0, "promo code has expired"},
+		{"PACKS", 1, ProductClass, 30, "AUD", 0, "promo code does not apply to this product"},
+		{"PACKS", 1, ProductMembership, 30, "AUD", 3, ""},
+		{"MIN20", 1, ProductClass, 19.99, "AUD", 0, "order amount is below the minimum for this promo code"},
+		{"MIN20", 1, ProductClass, 1100, "INR", 275, ""},
+		{"NEWBIE", 1, ProductClass, 30, "AUD", 15, ""},
+		{"NEWBIE", 2, ProductClass, 30, "AUD", 0, "promo code is only valid on your first purchase"},
+		{"HUGE", 1, ProductClass, 30, "AUD", 30, ""},
+	}
+	for _, tc := range cases {
+		_, discount, err := ApplyPromoCode(db, tc.code, tc.uid, tc.productType, tc.amount, tc.currency)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, tc.code)
+			continue
+		}
+		require.NoError(t, err, tc.code)
+		assert.Equal(t, tc.discount, discount, tc.code)
+	}
+}
+
+func TestPromoCapsRecheckedOnRedemption(t *testing.T) {
+	setupTestDB(t, &User{}, &Payment{}, &PromoCode{}, &PromoRedemption{}, &PaymentTaxLine{})
+	useTestRates(t, map[string]float64{"AUD": 1})
+
+	cases := []struct {
+		name  string
+		promo PromoCode
+		users []uint
+		err   string
+	}{
+		{"total cap", PromoCode{Code: "ONCE", MaxUses: 1}, []uint{1, 2}, "promo code usage limit reached"},
+		{"per-user cap", PromoCode{Code: "EACH", MaxUsesPerUser: 1}, []uint{1, 1}, "you have already used this promo code"},
+		{"other users unaffected", PromoCode{Code: "EACH2", MaxUsesPerUser: 1}, []uint{1, 2}, ""},
+	}
+	for _, tc := range cases {
+		promo := tc.promo
+		promo.DiscountType, promo.DiscountValue, promo.Currency, promo.IsActive = "percent", 10, "AUD", true
+		require.NoError(t, db.Create(&promo).Error)
+
+		// Both checkouts pass validation before either saves its order,
+		// as they would when submitted at the same time
+		var pricings []OrderPricing
+		for _, uid := range tc.users {
+			applied, discount, err := ApplyPromoCode(db, promo.Code, uid, ProductClass, 100, "AUD")
+			require.NoError(t, err, tc.name)
+			pricings = append(pricings, OrderPricing{Subtotal: 100, Promo: applied, Discount: discount,
+				Tax: TaxResult{Net: 100 - discount, Gross: 100 - discount}, Total: 100 - discount})
+		}
+
+		require.NoError(t, CreateOrderPayment(&Payment{UserID: tc.users[0], Currency: "AUD", Status: "created"}, pricings[0]), tc.name)
+		second := Payment{UserID: tc.users[1], Currency: "AUD", Status: "created"}
+		err := CreateOrderPayment(&second, pricings[1])
+		if tc.err == "" {
+			assert.NoError(t, err, tc.name)
+			continue
+		}
+		assert.EqualError(t, err, tc.err, tc.name)
+		assert.Error(t, db.First(&Payment{}, second.ID).Error, "%s: the losing order is rolled back", tc.name)
+	}
+}
@@ -0,0 +1,816 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Gateways
+const (
+	GatewayRazorpay = "razorpay"
+	GatewayPayPal   = "paypal"
+	GatewayStub     = "stub"
+)
+
+// Normalised gateway transaction statuses
+const (
+	SettlementCaptured          = "captured"
+	SettlementRefunded          = "refunded"
+	SettlementPartiallyRefunded = "partially_refunded"
+	SettlementFailed            = "failed"
+	SettlementPending           = "pending"
+)
+
+// Discrepancy kinds
+const (
+	DiscrepancyAmount             = "amount_mismatch"
+	DiscrepancyStatus             = "status_mismatch"
+	DiscrepancyCurrency           = "currency_mismatch"
+	DiscrepancyMissingPayment     = "missing_payment"     // Captured at the gateway, no Payment row
+	DiscrepancyMissingTransaction = "missing_transaction" // Payment marked paid, gateway has nothing
+)
+
+// GatewayTransaction is a gateway-side payment normalised across providers.
+// Amounts are in major currency units.
+type GatewayTransaction struct {
+	Gateway        string    `json:"gateway"`
+	OrderID        string    `json:"order_id"`
+	PaymentID      string    `json:"payment_id"` // Razorpay payment ID or PayPal capture ID
+	Amount         float64   `json:"amount"`
+	RefundedAmount float64   `json:"refunded_amount"`
+	Currency       string    `json:"currency"`
+	Status         string    `json:"status"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// SettlementProvider lists a gateway's transactions for a date range
+type SettlementProvider interface {
+	Name() string
+	FetchTransactions(from, to time.Time) ([]GatewayTransaction, error)
+}
+
+// --- Models ---
+
+type ReconciliationRun struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	Gateway       string     `json:"gateway" gorm:"index"`
+	FromDate      time.Time  `json:"from_date"`
+	ToDate        time.Time  `json:"to_date"`
+	Status        string     `json:"status"` // running, completed, failed
+	Error         string     `json:"error"`
+	Transactions  int        `json:"transactions"`
+	Matched       int        `json:"matched"`
+	Discrepancies int        `json:"discrepancies"`
+	StartedAt     time.Time  `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at"`
+}
+
+type ReconciliationDiscrepancy struct {
+	ID               uint    `json:"id" gorm:"primaryKey"`
+	RunID            uint    `json:"run_id" gorm:"index"`
+	Gateway          string  `json:"gateway"`
+	Kind             string  `json:"kind"`
+	PaymentID        *uint   `json:"payment_id" gorm:"index"` // Our Payment row, if any
+	GatewayOrderID   string  `json:"gateway_order_id"`
+	GatewayPaymentID string  `json:"gateway_payment_id"`
+	ExpectedAmount   float64 `json:"expected_amount"`
+	ActualAmount     float64 `json:"actual_amount"`
+	ExpectedCurrency string  `json:"expected_currency"`
+	ActualCurrency   string  `json:"actual_currency"`
+	ExpectedStatus   string  `json:"expected_status"`
+	ActualStatus     string  `json:"actual_status"`
+	Details          string  `json:"details"`
+
+	Status     string     `json:"status" gorm:"default:'open';index"` // open, resolved, ignored
+	Resolution string     `json:"resolution"`
+	Notes      string     `json:"notes"`
+	ResolvedBy *uint      `json:"resolved_by"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// --- DTOs ---
+type ReconciliationRunInput struct {
+	Gateway string `json:"gateway" binding:"required"`
+	From    string `json:"from" binding:"required"` // YYYY-MM-DD
+	To      string `json:"to" binding:"required"`   // YYYY-MM-DD, inclusive
+}
+
+type ResolveDiscrepancyInput struct {
+	Action string `json:"action" binding:"required"` // sync_from_gateway, resolve, ignore
+	Notes  string `json:"notes"`
+}
+
+// --- Providers ---
+
+// settlementProviders returns the providers that are configured in this environment
+func settlementProviders() map[string]SettlementProvider {
+	providers := map[string]SettlementProvider{}
+	if os.Getenv("RAZORPAY_KEY_ID") != "" {
+		providers[GatewayRazorpay] = razorpaySettlementProvider{}
+	}
+	if os.Getenv("PAYPAL_CLIENT_ID") != "" {
+		providers[GatewayPayPal] = paypalSettlementProvider{}
+	}
+	if path := os.Getenv("RECONCILIATION_STUB_FILE"); path != "" {
+		providers[GatewayStub] = fileSettlementProvider{Path: path}
+	}
+	return providers
+}
+
+type razorpaySettlementProvider struct{}
+
+func (razorpaySettlementProvider) Name() string { return GatewayRazorpay }
+
+func (razorpaySettlementProvider) FetchTransactions(from, to time.Time) ([]GatewayTransaction, error) {
+	const pageSize = 100
+	var txns []GatewayTransaction
+	for skip := 0; ; skip += pageSize {
+		res, err := razorpayClient.Payment.All(map[string]interface{}{
+			"from":  from.Unix(),
+			"to":    to.Unix(),
+			"count": pageSize,
+			"skip":  skip,
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		items, _ := res["items"].([]interface{})
+		for _, raw := range items {
+			item, _ := raw.(map[string]interface{})
+			txns = append(txns, razorpayTransaction(item))
+		}
+		if len(items) < pageSize {
+			return txns, nil
+		}
+	}
+}
+
+func razorpayTransaction(item map[string]interface{}) GatewayTransaction {
+	str := func(key string) string { s, _ := item[key].(string); return s }
+	num := func(key string) float64 { f, _ := item[key].(float64); return f }
+
+	txn := GatewayTransaction{
+		Gateway:        GatewayRazorpay,
+		OrderID:        str("order_id"),
+		PaymentID:      str("id"),
+		Amount:         num("amount") / 100,
+		RefundedAmount: num("amount_refunded") / 100,
+		Currency:       strings.ToUpper(str("currency")),
+		CreatedAt:      time.Unix(int64(num("created_at")), 0),
+	}
+	switch str("status") {
+	case "captured":
+		txn.Status = SettlementCaptured
+		if txn.RefundedAmount > 0 {
+			txn.Status = SettlementPartiallyRefunded
+		}
+	case "refunded":
+		txn.Status = SettlementRefunded
+		if txn.RefundedAmount > 0 && txn.RefundedAmount < txn.Amount {
+			txn.Status = SettlementPartiallyRefunded
+		}
+	case "failed":
+		txn.Status = SettlementFailed
+	default: // created, authorized
+		txn.Status = SettlementPending
+	}
+	return txn
+}
+
+type paypalSettlementProvider struct{}
+
+func (paypalSettlementProvider) Name() string { return GatewayPayPal }
+
+// FetchTransactions uses the PayPal Transaction Search API. Refunds are
+// separate transactions that reference the original capture.
+func (paypalSettlementProvider) FetchTransactions(from, to time.Time) ([]GatewayTransaction, error) {
+	accessToken, err := getPayPalAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	captures := map[string]*GatewayTransaction{}
+	var order []string
+	refunds := map[string]float64{}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("start_date", from.UTC().Format(time.RFC3339))
+		query.Set("end_date", to.UTC().Format(time.RFC3339))
+		query.Set("fields", "transaction_info")
+		query.Set("page_size", "500")
+		query.Set("page", strconv.Itoa(page))
+
+		req, err := http.NewRequest("GET", paypalBaseURL+"/v1/reporting/transactions?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("PayPal transaction search failed: %s", string(body))
+		}
+
+		var res struct {
+			TransactionDetails []struct {
+				TransactionInfo struct {
+					TransactionID     string `json:"transaction_id"`
+					PayPalReferenceID string `json:"paypal_reference_id"`
+					EventCode         string `json:"transaction_event_code"`
+					Status            string `json:"transaction_status"`
+					InitiationDate    string `json:"transaction_initiation_date"`
+					InvoiceID         string `json:"invoice_id"`
+					TransactionAmount struct {
+						Currency string `json:"currency_code"`
+						Value    string `json:"value"`
+					} `json:"transaction_amount"`
+				} `json:"transaction_info"`
+			} `json:"transaction_details"`
+			TotalPages int `json:"total_pages"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			return nil, err
+		}
+
+		for _, detail := range res.TransactionDetails {
+			info := detail.TransactionInfo
+			amount, _ := strconv.ParseFloat(info.TransactionAmount.Value, 64)
+			if strings.HasPrefix(info.EventCode, "T11") { // Reversals and refunds
+				refunds[info.PayPalReferenceID] += math.Abs(amount)
+				continue
+			}
+			if amount <= 0 {
+				continue
+			}
+
+			createdAt, _ := time.Parse("2006-01-02T15:04:05-0700", info.InitiationDate)
+			txn := &GatewayTransaction{
+				Gateway:   GatewayPayPal,
+				PaymentID: info.TransactionID,
+				OrderID:   info.InvoiceID,
+				Amount:    amount,
+				Currency:  strings.ToUpper(info.TransactionAmount.Currency),
+				CreatedAt: createdAt,
+			}
+			switch info.Status {
+			case "S":
+				txn.Status = SettlementCaptured
+			case "D", "V":
+				txn.Status = SettlementFailed
+			default:
+				txn.Status = SettlementPending
+			}
+			captures[info.TransactionID] = txn
+			order = append(order, info.TransactionID)
+		}
+
+		if page >= res.TotalPages {
+			break
+		}
+	}
+
+	txns := make([]GatewayTransaction, 0, len(order))
+	for _, id := range order {
+		txn := captures[id]
+		if refunded := roundMoney(refunds[id]); refunded > 0 {
+			txn.RefundedAmount = refunded
+			txn.Status = SettlementPartiallyRefunded
+			if refunded >= txn.Amount {
+				txn.Status = SettlementRefunded
+			}
+		}
+		txns = append(txns, *txn)
+	}
+	return txns, nil
+}
+
+// fileSettlementProvider reads transactions from a JSON file. Useful for
+// local testing and for importing settlement exports by hand.
+type fileSettlementProvider struct {
+	Path string
+}
+
+func (fileSettlementProvider) Name() string { return GatewayStub }
+
+func (p fileSettlementProvider) FetchTransactions(from, to time.Time) ([]GatewayTransaction, error) {
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	var all []GatewayTransaction
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	var txns []GatewayTransaction
+	for _, txn := range all {
+		if !txn.CreatedAt.Before(from) && txn.CreatedAt.Before(to) {
+			txns = append(txns, txn)
+		}
+	}
+	return txns, nil
+}
+
+// --- Matching ---
+
+// expectedSettlementStatus maps our payment status to the gateway status it
+// should have. Payments still "created" have no expectation.
+func expectedSettlementStatus(status string) string {
+	switch status {
+	case "success":
+		return SettlementCaptured
+	case "refunded":
+		return SettlementRefunded
+	case "partially_refunded":
+		return SettlementPartiallyRefunded
+	case "failed":
+		return SettlementFailed
+	}
+	return ""
+}
+
+// expectedPaymentSettlement is the gateway status a payment should have.
+// Refunds to the wallet never reach the gateway, so only the card share of
+// a refund is expected there.
+func expectedPaymentSettlement(p *Payment) string {
+	if p.Status != "refunded" && p.Status != "partially_refunded" {
+		return expectedSettlementStatus(p.Status)
+	}
+	switch refunded := p.GatewayRefunded(); {
+	case refunded < 0.01:
+		return SettlementCaptured
+	case amountsDiffer(refunded, p.Amount):
+		return SettlementPartiallyRefunded
+	}
+	return SettlementRefunded
+}
+
+// settlementRank orders attempts on the same order by how far they got
+func settlementRank(status string) int {
+	switch status {
+	case SettlementRefunded, SettlementPartiallyRefunded:
+		return 3
+	case SettlementCaptured:
+		return 2
+	case SettlementPending:
+		return 1
+	}
+	return 0
+}
+
+func amountsDiffer(a, b float64) bool {
+	return math.Abs(a-b) >= 0.01
+}
+
+// matchSettlements compares gateway transactions with our payments and
+// returns the number of clean matches and any discrepancies found.
+// Transactions are matched on gateway payment ID first, then order ID.
+func matchSettlements(payments []Payment, txns []GatewayTransaction) (int, []ReconciliationDiscrepancy) {
+	byRef := map[string]int{}
+	for i, p := range payments {
+		for _, ref := range []string{p.OrderID, p.PaymentID, p.RazorpayPaymentID, p.PayPalCaptureID} {
+			if ref != "" {
+				byRef[ref] = i
+			}
+		}
+	}
+
+	// Orders can have several attempts; keep the one that actually settled
+	best := map[int]GatewayTransaction{}
+	var found []ReconciliationDiscrepancy
+	for _, txn := range txns {
+		idx, ok := byRef[txn.PaymentID]
+		if !ok && txn.OrderID != "" {
+			idx, ok = byRef[txn.OrderID]
+		}
+
+		if !ok {
+			if txn.Status == SettlementCaptured || txn.Status == SettlementPartiallyRefunded {
+				found = append(found, ReconciliationDiscrepancy{
+					Gateway:          txn.Gateway,
+					Kind:             DiscrepancyMissingPayment,
+					GatewayOrderID:   txn.OrderID,
+					GatewayPaymentID: txn.PaymentID,
+					ActualAmount:     txn.Amount,
+					ActualCurrency:   txn.Currency,
+					ActualStatus:     txn.Status,
+					Details:          "Captured at the gateway with no matching payment",
+				})
+			}
+			continue
+		}
+
+		if prev, ok := best[idx]; !ok || settlementRank(txn.Status) > settlementRank(prev.Status) {
+			best[idx] = txn
+		}
+	}
+
+	matched := 0
+	for idx := range payments {
+		txn, ok := best[idx]
+		if !ok {
+			continue
+		}
+		p := payments[idx]
+		paymentID := p.ID
+		base := ReconciliationDiscrepancy{
+			Gateway:          txn.Gateway,
+			PaymentID:        &paymentID,
+			GatewayOrderID:   txn.OrderID,
+			GatewayPaymentID: txn.PaymentID,
+			ExpectedAmount:   p.Amount,
+			ActualAmount:     txn.Amount,
+			ExpectedCurrency: p.Currency,
+			ActualCurrency:   txn.Currency,
+			ExpectedStatus:   p.Status,
+			ActualStatus:     txn.Status,
+		}
+
+		clean := true
+		if expected := expectedPaymentSettlement(&p); expected != txn.Status {
+			stuck := p.Status == "created" && txn.Status == SettlementCaptured
+			if expected != "" || stuck {
+				d := base
+				d.Kind = DiscrepancyStatus
+				d.Details = fmt.Sprintf("Payment is %q but gateway reports %q", p.Status, txn.Status)
+				found = append(found, d)
+				clean = false
+			}
+		}
+		if !strings.EqualFold(p.Currency, txn.Currency) {
+			d := base
+			d.Kind = DiscrepancyCurrency
+			d.Details = fmt.Sprintf("Payment currency %s, gateway settled %s", p.Currency, txn.Currency)
+			found = append(found, d)
+			clean = false
+		} else if txn.Status != SettlementFailed && txn.Status != SettlementPending &&
+			(amountsDiffer(p.Amount, txn.Amount) || amountsDiffer(p.GatewayRefunded(), txn.RefundedAmount)) {
+			d := base
+			d.Kind = DiscrepancyAmount
+			d.Details = fmt.Sprintf("Amount %.2f (refunded %.2f) vs gateway %.2f (refunded %.2f)",
+				p.Amount, p.GatewayRefunded(), txn.Amount, txn.RefundedAmount)
+			found = append(found, d)
+			clean = false
+		}
+		if clean {
+			matched++
+		}
+	}
+
+	// Payments we consider paid that the gateway has no record of
+	for i, p := range payments {
+		if _, ok := best[i]; ok || expectedSettlementStatus(p.Status) == "" || p.Status == "failed" {
+			continue
+		}
+		paymentID := p.ID
+		found = append(found, ReconciliationDiscrepancy{
+			Kind:             DiscrepancyMissingTransaction,
+			PaymentID:        &paymentID,
+			GatewayOrderID:   p.OrderID,
+			GatewayPaymentID: p.PaymentID,
+			ExpectedAmount:   p.Amount,
+			ExpectedCurrency: p.Currency,
+			ExpectedStatus:   p.Status,
+			Details:          "Payment is marked paid but the gateway has no transaction",
+		})
+	}
+
+	return matched, found
+}
+
+// --- Job ---
+
+// RunReconciliation pulls a gateway's transactions for [from, to) and records
+// any discrepancies against our payments
+func RunReconciliation(provider SettlementProvider, from, to time.Time) (*ReconciliationRun, error) {
+	run := ReconciliationRun{
+		Gateway:   provider.Name(),
+		FromDate:  from,
+		ToDate:    to,
+		Status:    "running",
+		StartedAt: time.Now(),
+	}
+	if err := db.Create(&run).Error; err != nil {
+		return nil, err
+	}
+
+	finish := func(err error) (*ReconciliationRun, error) {
+		now := time.Now()
+		run.FinishedAt = &now
+		run.Status = "completed"
+		if err != nil {
+			run.Status = "failed"
+			run.Error = err.Error()
+		}
+		db.Save(&run)
+		return &run, err
+	}
+
+	// Pad the gateway window so payments created near the boundary still match
+	txns, err := provider.FetchTransactions(from.Add(-24*time.Hour), to.Add(24*time.Hour))
+	if err != nil {
+		return finish(err)
+	}
+
+	var payments []Payment
+	query := db.Where("created_at >= ? AND created_at < ?", from, to)
+	if provider.Name() == GatewayRazorpay {
+		query = query.Where("order_id LIKE ?", "order_%")
+	} else if provider.Name() == GatewayPayPal {
+		query = query.Where("order_id NOT LIKE ? AND order_id NOT LIKE ?", "order_%", "wallet_%")
+	}
+	query.Find(&payments)
+
+	// Only report orphaned captures that fall inside the requested range
+	var inRange []GatewayTransaction
+	refs := map[string]bool{}
+	for _, p := range payments {
+		refs[p.OrderID], refs[p.PaymentID], refs[p.RazorpayPaymentID], refs[p.PayPalCaptureID] = true, true, true, true
+	}
+	for _, txn := range txns {
+		if refs[txn.PaymentID] || refs[txn.OrderID] || (!txn.CreatedAt.Before(from) && txn.CreatedAt.Before(to)) {
+			inRange = append(inRange, txn)
+		}
+	}
+
+	matched, found := matchSettlements(payments, inRange)
+	run.Transactions = len(inRange)
+	run.Matched = matched
+	run.Discrepancies = len(found)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for i := range found {
+			found[i].RunID = run.ID
+			found[i].Gateway = provider.Name()
+			found[i].Status = "open"
+			if err := tx.Create(&found[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return finish(err)
+}
+
+// ReconcileYesterday runs reconciliation for every configured gateway over
+// the previous day. Called from the daily background job.
+func ReconcileYesterday() {
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	from := to.AddDate(0, 0, -1)
+	for name, provider := range settlementProviders() {
+		run, err := RunReconciliation(provider, from, to)
+		if err != nil {
+			fmt.Printf("ERROR: %s reconciliation failed: %v\n", name, err)
+			continue
+		}
+		if run.Discrepancies > 0 {
+			fmt.Printf("WARNING: %s reconciliation found %d discrepancies (run %d)\n", name, run.Discrepancies, run.ID)
+		}
+	}
+}
+
+// --- Handlers ---
+
+// StartReconciliation - Admin - Reconcile a gateway over a date range
+func StartReconciliation(c *gin.Context) {
+	var input ReconciliationRunInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	provider, ok := settlementProviders()[input.Gateway]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Gateway is not configured for reconciliation"})
+		return
+	}
+
+	from, err := time.Parse("2006-01-02", input.From)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from date"})
+		return
+	}
+	to, err := time.Parse("2006-01-02", input.To)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to date"})
+		return
+	}
+	to = to.Add(24 * time.Hour) // Include end date
+	if !to.After(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+		return
+	}
+
+	run, err := RunReconciliation(provider, from, to)
+	if err != nil {
+		status := http.StatusBadGateway
+		if run == nil {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": "Reconciliation failed", "details": err.Error(), "run": run})
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+// GetReconciliationRuns - Admin - Recent reconciliation runs
+func GetReconciliationRuns(c *gin.Context) {
+	var runs []ReconciliationRun
+	query := db.Order("started_at desc").Limit(50)
+	if gateway := c.Query("gateway"); gateway != "" {
+		query = query.Where("gateway = ?", gateway)
+	}
+	query.Find(&runs)
+	c.JSON(http.StatusOK, runs)
+}
+
+// GetReconciliationReport - Admin - Discrepancy report, ?run_id=&status=open&export=true
+func GetReconciliationReport(c *gin.Context) {
+	query := db.Model(&ReconciliationDiscrepancy{})
+	if runID := c.Query("run_id"); runID != "" {
+		query = query.Where("run_id = ?", runID)
+	}
+	if status := c.DefaultQuery("status", "open"); status != "all" {
+		query = query.Where("status = ?", status)
+	}
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+
+	var discrepancies []ReconciliationDiscrepancy
+	query.Order("created_at desc").Find(&discrepancies)
+
+	if c.Query("export") == "true" {
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", "attachment;filename=reconciliation.csv")
+		writer := csv.NewWriter(c.Writer)
+
+		writer.Write([]string{"ID", "Run", "Gateway", "Kind", "Payment", "Gateway Order", "Gateway Payment",
+			"Expected Amount", "Actual Amount", "Expected Currency", "Actual Currency",
+			"Expected Status", "Actual Status", "Details", "Status"})
+		for _, d := range discrepancies {
+			payment := ""
+			if d.PaymentID != nil {
+				payment = strconv.FormatUint(uint64(*d.PaymentID), 10)
+			}
+			writer.Write([]string{
+				strconv.FormatUint(uint64(d.ID), 10),
+				strconv.FormatUint(uint64(d.RunID), 10),
+				d.Gateway,
+				d.Kind,
+				payment,
+				d.GatewayOrderID,
+				d.GatewayPaymentID,
+				fmt.Sprintf("%.2f", d.ExpectedAmount),
+				fmt.Sprintf("%.2f", d.ActualAmount),
+				d.ExpectedCurrency,
+				d.ActualCurrency,
+				d.ExpectedStatus,
+				d.ActualStatus,
+				d.Details,
+				d.Status,
+			})
+		}
+		writer.Flush()
+		return
+	}
+
+	summary := map[string]int{}
+	for _, d := range discrepancies {
+		summary[d.Kind]++
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"discrepancies": discrepancies,
+		"summary":       summary,
+		"total":         len(discrepancies),
+	})
+}
+
+// ResolveDiscrepancy - Admin - Close a discrepancy, optionally syncing the payment from the gateway
+func ResolveDiscrepancy(c *gin.Context) {
+	adminID, _ := currentUserID(c)
+
+	var input ResolveDiscrepancyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var d ReconciliationDiscrepancy
+	if err := db.First(&d, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Discrepancy not found"})
+		return
+	}
+	if d.Status != "open" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Discrepancy is already closed"})
+		return
+	}
+
+	var issued *Payment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		switch input.Action {
+		case "sync_from_gateway":
+			payment, err := syncPaymentFromGateway(tx, &d)
+			if err != nil {
+				return err
+			}
+			if payment.Status == "success" {
+				issued = payment
+			}
+			d.Status = "resolved"
+		case "resolve":
+			d.Status = "resolved"
+		case "ignore":
+			d.Status = "ignored"
+		default:
+			return errors.New("action must be sync_from_gateway, resolve or ignore")
+		}
+
+		now := time.Now()
+		d.Resolution = input.Action
+		d.Notes = input.Notes
+		d.ResolvedBy = &adminID
+		d.ResolvedAt = &now
+		return tx.Save(&d).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if issued != nil {
+		if err := issueInvoiceForPayment(issued); err != nil {
+			fmt.Printf("WARNING: Failed to issue invoice for payment %d: %v\n", issued.ID, err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Discrepancy closed", "discrepancy": d})
+}
+
+// syncPaymentFromGateway makes the payment match what the gateway reported
+func syncPaymentFromGateway(tx *gorm.DB, d *ReconciliationDiscrepancy) (*Payment, error) {
+	if d.PaymentID == nil {
+		return nil, errors.New("no payment to sync; record the capture manually and resolve")
+	}
+	if d.Kind == DiscrepancyMissingTransaction || d.Kind == DiscrepancyCurrency {
+		return nil, errors.New("this discrepancy needs manual investigation")
+	}
+
+	var payment Payment
+	if err := tx.First(&payment, *d.PaymentID).Error; err != nil {
+		return nil, errors.New("payment not found")
+	}
+
+	updates := map[string]interface{}{}
+	switch d.ActualStatus {
+	case SettlementCaptured:
+		updates["status"] = "success"
+	case SettlementRefunded:
+		updates["status"] = "refunded"
+	case SettlementPartiallyRefunded:
+		updates["status"] = "partially_refunded"
+	case SettlementFailed:
+		updates["status"] = "failed"
+		if err := ReverseWalletDebit(tx, &payment); err != nil {
+			return nil, err
+		}
+	}
+	if d.Kind == DiscrepancyAmount {
+		updates["amount"] = d.ActualAmount
+	}
+	if d.Gateway == GatewayRazorpay && payment.RazorpayPaymentID == "" {
+		updates["razorpay_payment_id"] = d.GatewayPaymentID
+		updates["payment_id"] = d.GatewayPaymentID
+	}
+	if d.Gateway == GatewayPayPal && payment.PayPalCaptureID == "" {
+		updates["pay_pal_capture_id"] = d.GatewayPaymentID
+	}
+
+	if err := tx.Model(&payment).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	tx.First(&payment, payment.ID)
+	return &payment, nil
+}
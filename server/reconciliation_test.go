@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchSettlements(t *testing.T) {
+	payments := []Payment{
+		{ID: 1, OrderID: "order_clean", Amount: 100, Currency: "INR", Status: "success"},
+		{ID: 2, OrderID: "order_stuck", Amount: 50, Currency: "INR", Status: "created"},
+		{ID: 3, OrderID: "order_short", Amount: 100, Currency: "INR", Status: "success"},
+		{ID: 4, OrderID: "order_gone", Amount: 20, Currency: "INR", Status: "success"},
+		{ID: 5, OrderID: "PAYPAL1", PayPalCaptureID: "CAP1", Amount: 30, Currency: "USD", Status: "success"},
+		{ID: 6, OrderID: "order_abandoned", Amount: 10, Currency: "INR", Status: "created"},
+	}
+	txns := []GatewayTransaction{
+		{OrderID: "order_clean", PaymentID: "pay_1", Amount: 100, Currency: "INR", Status: SettlementFailed},
+		{OrderID: "order_clean", PaymentID: "pay_2", Amount: 100, Currency: "INR", Status: SettlementCaptured},
+		{OrderID: "order_stuck", PaymentID: "pay_3", Amount: 50, Currency: "INR", Status: SettlementCaptured},
+		{OrderID: "order_short", PaymentID: "pay_4", Amount: 90, Currency: "INR", Status: SettlementCaptured},
+		{PaymentID: "CAP1", Amount: 30, Currency: "EUR", Status: SettlementCaptured},
+		{OrderID: "order_orphan", PaymentID: "pay_5", Amount: 10, Currency: "INR", Status: SettlementCaptured},
+		{OrderID: "order_abandoned", PaymentID: "pay_6", Amount: 10, Currency: "INR", Status: SettlementFailed},
+	}
+
+	matched, found := matchSettlements(payments, txns)
+	assert.Equal(t, 2, matched) // order_clean and order_abandoned
+
+	kinds := map[string]uint{}
+	for _, d := range found {
+		var paymentID uint
+		if d.PaymentID != nil {
+			paymentID = *d.PaymentID
+		}
+		kinds[d.Kind] = paymentID
+	}
+	assert.Len(t, found, 5)
+	assert.Equal(t, uint(2), kinds[DiscrepancyStatus])
+	assert.Equal(t, uint(3), kinds[DiscrepancyAmount])
+	assert.Equal(t, uint(5), kinds[DiscrepancyCurrency])
+	assert.Equal(t, uint(4), kinds[DiscrepancyMissingTransaction])
+	assert.Equal(t, uint(0), kinds[DiscrepancyMissingPayment])
+}
+
+func TestMatchSettlementsRefunds(t *testing.T) {
+	payments := []Payment{
+		{ID: 1, OrderID: "order_1", Amount: 100, RefundedAmount: 40, Currency: "INR", Status: "partially_refunded"},
+		{ID: 2, OrderID: "order_2", Amount: 100, Currency: "INR", Status: "success"},
+		// Refunds to the wallet never reach the gateway
+		{ID: 3, OrderID: "order_3", Amount: 100, RefundedAmount: 30, RefundedToWallet: 30, Currency: "INR", Status: "partially_refunded"},
+		{ID: 4, OrderID: "order_4", Amount: 60, WalletAmount: 40, RefundedAmount: 100, RefundedToWallet: 40, Currency: "INR", Status: "refunded"},
+		{ID: 5, OrderID: "order_5", Amount: 100, RefundedAmount: 50, RefundedToWallet: 20, Currency: "INR", Status: "partially_refunded"},
+	}
+	txns := []GatewayTransaction{
+		{OrderID: "order_1", Amount: 100, RefundedAmount: 40, Currency: "INR", Status: SettlementPartiallyRefunded},
+		{OrderID: "order_2", Amount: 100, RefundedAmount: 100, Currency: "INR", Status: SettlementRefunded},
+		{OrderID: "order_3", Amount: 100, Currency: "INR", Status: SettlementCaptured},
+		{OrderID: "order_4", Amount: 60, RefundedAmount: 60, Currency: "INR", Status: SettlementRefunded},
+		{OrderID: "order_5", Amount: 100, RefundedAmount: 50, Currency: "INR", Status: SettlementPartiallyRefunded},
+	}
+
+	matched, found := matchSettlements(payments, txns)
+	assert.Equal(t, 3, matched)
+	require.Len(t, found, 3)
+	// Status and refunded amount both differ on order_2
+	assert.Equal(t, uint(2), *found[0].PaymentID)
+	assert.Equal(t, uint(2), *found[1].PaymentID)
+	// Only 30 of order_5's refund went back to the card
+	assert.Equal(t, uint(5), *found[2].PaymentID)
+	assert.Equal(t, DiscrepancyAmount, found[2].Kind)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// --- DTO ---
+type RefundInput struct {
+	Amount   float64 `json:"amount"` // Omit for a full refund of the remaining amount
+	Reason   string  `json:"reason" binding:"required"`
+	ToWallet bool    `json:"to_wallet"` // Refund as store credit instead of to the card
+}
+
+// --- Gateway Refunds ---
+
+// refundRazorpay refunds part of a Razorpay payment. Amounts are in paise.
+func refundRazorpay(payment *Payment, amount float64, reason string) (string, error) {
+	res, err := razorpayClient.Payment.Refund(payment.RazorpayPaymentID, int(math.Round(amount*100)), map[string]interface{}{
+		"notes": map[string]interface{}{"reason": reason},
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	id, _ := res["id"].(string)
+	return id, nil
+}
+
+// refundPayPal refunds part of a PayPal capture
+func refundPayPal(payment *Payment, amount float64, reason string) (string, error) {
+	accessToken, err := getPayPalAccessToken()
+	if err != nil {
+		return "", err
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"amount": map[string]interface{}{
+			"value":         fmt.Sprintf("%.2f", amount),
+			"currency_code": payment.Currency,
+		},
+		"note_to_payer": reason,
+	})
+	refundURL := fmt.Sprintf("%s/v2/payments/captures/%s/refund", paypalBaseURL, payment.PayPalCaptureID)
+	req, err := http.NewRequest("POST", refundURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		fmt.Println("PAYPAL REFUND ERROR:", string(respBody))
+		return "", errors.New("PayPal refund failed")
+	}
+
+	var refundRes map[string]interface{}
+	json.Unmarshal(respBody, &refundRes)
+	id, _ := refundRes["id"].(string)
+	return id, nil
+}
+
+// refundAtGateway routes the refund to the gateway that took the payment
+func refundAtGateway(payment *Payment, amount float64, reason string) (string, error) {
+	switch {
+	case payment.RazorpayPaymentID != "":
+		return refundRazorpay(payment, amount, reason)
+	case payment.PayPalCaptureID != "":
+		return refundPayPal(payment, amount, reason)
+	}
+	return "", errors.New("payment has no gateway reference to refund against")
+}
+
+// refundSplit checks a refund against what is left on the payment and splits
+// it between the card and the wallet. Amount 0 refunds everything left.
+func refundSplit(payment *Payment, amount float64, toWallet bool) (total, gateway, wallet float64, err error) {
+	if payment.Status != "success" && payment.Status != "partially_refunded" {
+		return 0, 0, 0, errors.New("Only completed payments can be refunded")
+	}
+
+	remaining := roundMoney(payment.Total() - payment.RefundedAmount)
+	total = roundMoney(amount)
+	if total == 0 {
+		total = remaining
+	}
+	if total <= 0 || total > remaining {
+		return 0, 0, 0, fmt.Errorf("Refund amount must be between 0 and %.2f", remaining)
+	}
+
+	if !toWallet {
+		gatewayRemaining := roundMoney(payment.Amount - payment.GatewayRefunded())
+		gateway = math.Max(0, math.Min(total, gatewayRemaining))
+	}
+	return total, gateway, roundMoney(total - gateway), nil
+}
+
+// gatewayRefundError is a refund the gateway turned down
+type gatewayRefundError struct{ err error }
+
+func (e gatewayRefundError) Error() string { return e.err.Error() }
+
+// refundRecordError is a gateway refund that went through but could not be recorded
+type refundRecordError struct {
+	refundID string
+	err      error
+}
+
+func (e refundRecordError) Error() string { return e.err.Error() }
+
+// refundResult is what a refund moved and where
+type refundResult struct {
+	Amount     float64
+	Gateway    float64
+	Wallet     float64
+	RefundID   string
+	CreditNote *Invoice
+}
+
+// refundPayment refunds a payment at the gateway or to the wallet and issues a
+// credit note. Whatever was paid from the wallet always goes back to it.
+// Amount 0 refunds everything left.
+func refundPayment(payment *Payment, amount float64, toWallet bool, reason string) (refundResult, error) {
+	// The payment stays locked from the balance check until the refund is
+	// recorded, so a double submit waits and then sees less left to refund
+	var res refundResult
+	var recordErr error
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(payment, payment.ID).Error; err != nil {
+			return err
+		}
+		var err error
+		res.Amount, res.Gateway, res.Wallet, err = refundSplit(payment, amount, toWallet)
+		if err != nil {
+			return err
+		}
+		if err := voidRefundedGiftCard(tx, payment, res.Amount); err != nil {
+			return err
+		}
+
+		// Make sure there is an invoice to credit before money moves
+		invoice, err := IssuePaymentInvoice(tx, payment)
+		if err != nil {
+			return err
+		}
+
+		if res.Gateway > 0 {
+			res.RefundID, err = refundAtGateway(payment, res.Gateway, reason)
+			if err != nil {
+				return gatewayRefundError{err}
+			}
+		}
+
+		recordErr = func() error {
+			payment.RefundedAmount = roundMoney(payment.RefundedAmount + res.Amount)
+			payment.RefundedToWallet = roundMoney(payment.RefundedToWallet + res.Wallet)
+			payment.Status = "partially_refunded"
+			if payment.RefundedAmount >= payment.Total() {
+				payment.Status = "refunded"
+			}
+			if err := tx.Model(payment).Updates(map[string]interface{}{
+				"refunded_amount":    payment.RefundedAmount,
+				"refunded_to_wallet": payment.RefundedToWallet,
+				"status":             payment.Status,
+			}).Error; err != nil {
+				return err
+			}
+
+			if res.Wallet > 0 {
+				paymentID := payment.ID
+				if _, err := PostWalletTransaction(tx, WalletTransaction{
+					UserID:      payment.UserID,
+					Kind:        WalletRefund,
+					Amount:      res.Wallet,
+					Currency:    payment.Currency,
+					Description: "Refund: " + reason,
+					PaymentID:   &paymentID,
+				}); err != nil {
+					return err
+				}
+			}
+
+			var err error
+			res.CreditNote, err = IssueCreditNote(tx, invoice, res.Amount, res.Wallet, reason)
+			return err
+		}()
+		return recordErr
+	})
+	if err != nil && recordErr != nil {
+		return res, refundRecordError{res.RefundID, err}
+	}
+	return res, err
+}
+
+// --- Handlers ---
+
+// RefundPayment - Admin - Refund a payment at the gateway or to the wallet and
+// issue a credit note. Whatever was paid from the wallet always goes back to it.
+func RefundPayment(c *gin.Context) {
+	var input RefundInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var payment Payment
+	if err := db.First(&payment, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	res, err := refundPayment(&payment, input.Amount, input.ToWallet, input.Reason)
+
+	var gatewayErr gatewayRefundError
+	var recordErr refundRecordError
+	switch {
+	case err == nil:
+	case errors.As(err, &recordErr):
+		// The gateway has already refunded; surface the reference so it can be reconciled
+		fmt.Printf("ERROR: Refund %s succeeded but was not recorded for payment %d: %v\n", recordErr.refundID, payment.ID, recordErr.err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Refund issued but failed to record credit note", "refund_id": recordErr.refundID})
+		return
+	case errors.As(err, &gatewayErr):
+		fmt.Println("REFUND ERROR:", gatewayErr.err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Gateway refund failed", "details": gatewayErr.Error()})
+		return
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Payment refunded",
+		"refund_id":   res.RefundID,
+		"to_card":     res.Gateway,
+		"to_wallet":   res.Wallet,
+		"payment":     payment,
+		"credit_note": res.CreditNote,
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefundSplit(t *testing.T) {
+	cases := []struct {
+		name                   string
+		payment                Payment
+		amount                 float64
+		toWallet               bool
+		total, gateway, wallet float64
+		err                    string
+	}{
+		{"full refund to card", Payment{Amount: 100, Status: "success"}, 0, false, 100, 100, 0, ""},
+		{"partial to wallet", Payment{Amount: 100, Status: "success"}, 30, true, 30, 0, 30, ""},
+		{"wallet share goes back to wallet", Payment{Amount: 60, WalletAmount: 40, Status: "success"}, 0, false, 100, 60, 40, ""},
+		{"what is left after a card refund", Payment{Amount: 100, RefundedAmount: 70, Status: "partially_refunded"}, 0, false, 30, 30, 0, ""},
+		{"wallet refunds leave the card untouched", Payment{Amount: 100, RefundedAmount: 50, RefundedToWallet: 50, Status: "partially_refunded"}, 0, false, 50, 50, 0, ""},
+		{"more than is left", Payment{Amount: 100, RefundedAmount: 70, Status: "partially_refunded"}, 40, false, 0, 0, 0, "Refund amount must be between 0 and 30.00"},
+		{"fully refunded", Payment{Amount: 100, RefundedAmount: 100, Status: "refunded"}, 0, false, 0, 0, 0, "Only completed payments can be refunded"},
+		{"unpaid", Payment{Amount: 100, Status: "created"}, 0, false, 0, 0, 0, "Only completed payments can be refunded"},
+	}
+	for _, tc := range cases {
+		total, gateway, wallet, err := refundSplit(&tc.payment, tc.amount, tc.toWallet)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, []float64{tc.total, tc.gateway, tc.wallet}, []float64{total, gateway, wallet}, tc.name)
+	}
+}
+
+func TestRefundPaymentDoubleSubmit(t *testing.T) {
+	setupInvoiceDB(t)
+	gin.SetMode(gin.TestMode)
+
+	payment := Payment{UserID: 1, OrderID: "order_1", Amount: 100, Currency: "INR", Status: "success"}
+	require.NoError(t, db.Create(&payment).Error)
+
+	refund := func() int {
+		body, _ := json.Marshal(RefundInput{Reason: "Class cancelled", ToWallet: true})
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(int(payment.ID))}}
+		c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		RefundPayment(c)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, refund())
+	assert.Equal(t, http.StatusBadRequest, refund(), "nothing is left to refund")
+
+	db.First(&payment, payment.ID)
+	assert.Equal(t, "refunded", payment.Status)
+	assert.Equal(t, 100.0, payment.RefundedAmount)
+	assert.Equal(t, 100.0, WalletBalance(db, 1, "INR"))
+	var notes int64
+	db.Model(&Invoice{}).Where("type = ?", InvoiceTypeCreditNote).Count(&notes)
+	assert.Equal(t, int64(1), notes)
+}
+
+func TestRefundGiftCardPayment(t *testing.T) {
+	setupInvoiceDB(t)
+	require.NoError(t, db.AutoMigrate(&GiftCard{}))
+
+	buy := func(code, status string) (GiftCard, Payment) {
+		card := GiftCard{Code: code, Amount: 2500, Currency: "INR", PurchaserID: 1, Status: status}
+		require.NoError(t, db.Create(&card).Error)
+		payment := Payment{UserID: 1, OrderID: "order_" + code, Amount: 2500, Currency: "INR", Status: "success",
+			ProductType: ProductGiftCard, ProductID: card.ID}
+		require.NoError(t, db.Create(&payment).Error)
+		return card, payment
+	}
+
+	// An unredeemed card is voided by a full refund and can't be redeemed after
+	card, payment := buy("KY-UNUSED", "active")
+	_, err := refundPayment(&payment, 1000, true, "changed mind")
+	assert.EqualError(t, err, "gift cards can only be refunded in full")
+	_, err = refundPayment(&payment, 0, true, "changed mind")
+	require.NoError(t, err)
+	db.First(&card, card.ID)
+	assert.Equal(t, "void", card.Status)
+	assert.Equal(t, 2500.0, WalletBalance(db, 1, "INR"))
+
+	w := callHandler(RedeemGiftCard, nil, gin.H{"code": "KY-UNUSED"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "gift card has been refunded")
+	assert.Equal(t, 2500.0, WalletBalance(db, 1, "INR"), "the refund is all the buyer gets")
+
+	// A redeemed card has already been spent into a wallet
+	card, payment = buy("KY-SPENT", "redeemed")
+	_, err = refundPayment(&payment, 0, true, "changed mind")
+	assert.EqualError(t, err, "gift card has already been redeemed and can't be refunded")
+	db.First(&payment, payment.ID)
+	assert.Equal(t, "success", payment.Status)
+	assert.Zero(t, payment.RefundedAmount)
+	assert.Equal(t, 2500.0, WalletBalance(db, 1, "INR"))
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Reminder target kinds
+const (
+	ReminderClass       = "class"
+	ReminderAppointment = "appointment"
+)
+
+// --- Models ---
+
+// SentReminder records a reminder so it goes out once per occurrence and
+// offset, even across restarts or with several servers running
+type SentReminder struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	Kind          string    `json:"kind" gorm:"uniqueIndex:idx_sent_reminder"`       // class, appointment
+	TargetRef     string    `json:"target_ref" gorm:"uniqueIndex:idx_sent_reminder"` // Booking ID or appointment ID
+	UserID        uint      `json:"user_id" gorm:"uniqueIndex:idx_sent_reminder"`
+	OccurrenceAt  time.Time `json:"occurrence_at" gorm:"uniqueIndex:idx_sent_reminder"` // Rescheduling gets fresh reminders
+	OffsetMinutes int       `json:"offset_minutes" gorm:"uniqueIndex:idx_sent_reminder"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// --- Logic ---
+
+// studioLocation is the timezone class times are written in (STUDIO_TIMEZONE)
+func studioLocation() *time.Location {
+	name := os.Getenv("STUDIO_TIMEZONE")
+	if name == "" {
+		name = "Australia/Sydney"
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// reminderOffsets reads REMINDER_OFFSETS, e.g. "24h,1h", longest first
+func reminderOffsets() []time.Duration {
+	raw := os.Getenv("REMINDER_OFFSETS")
+	if raw == "" {
+		raw = "24h,1h"
+	}
+	var offsets []time.Duration
+	for _, part := range strings.Split(raw, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || d <= 0 {
+			log.Printf("WARNING: Ignoring reminder offset %q\n", part)
+			continue
+		}
+		offsets = append(offsets, d)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] > offsets[j] })
+	return offsets
+}
+
+// parseClassTime reads a class's start time such as "07:00" or "8:00 AM"
+func parseClassTime(value string) (hour, minute int, err error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	for _, layout := range []string{"15:04", "3:04 PM", "3:04PM", "3 PM", "3PM"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Hour(), t.Minute(), nil
+		}
+	}
+	return 0, 0, fmt.Errorf("unrecognised class time %q", value)
+}
+
+// classOccurrenceAfter is the first session of a weekly class starting after t
+func classOccurrenceAfter(class Class, t time.Time, loc *time.Location) (time.Time, error) {
+	hour, minute, err := parseClassTime(class.Time)
+	if err != nil {
+		return time.Time{}, err
+	}
+	weekday := -1
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(strings.TrimSpace(class.Day), d.String()) {
+			weekday = int(d)
+		}
+	}
+	if weekday == -1 {
+		return time.Time{}, fmt.Errorf("unrecognised class day %q", class.Day)
+	}
+
+	local := t.In(loc)
+	start := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, loc)
+	start = start.AddDate(0, 0, (weekday-int(local.Weekday())+7)%7)
+	if !start.After(t) {
+		start = start.AddDate(0, 0, 7)
+	}
+	return start, nil
+}
+
+// dueReminderOffsets returns the offsets whose reminder time has passed for a
+// session that has not started yet
+func dueReminderOffsets(offsets []time.Duration, start, now time.Time) []time.Duration {
+	var due []time.Duration
+	if !now.Before(start) {
+		return due
+	}
+	for _, offset := range offsets {
+		if !now.Before(start.Add(-offset)) {
+			due = append(due, offset)
+		}
+	}
+	return due
+}
+
+// humanizeUntil describes the time left before a session, e.g. "in 1 hour"
+func humanizeUntil(d time.Duration) string {
+	switch {
+	case d >= 36*time.Hour:
+		return fmt.Sprintf("in %d days", int((d+12*time.Hour)/(24*time.Hour)))
+	case d >= 90*time.Minute:
+		return fmt.Sprintf("in %d hours", int((d+30*time.Minute)/time.Hour))
+	case d >= 50*time.Minute:
+		return "in 1 hour"
+	case d >= 2*time.Minute:
+		return fmt.Sprintf("in %d minutes", int(d/time.Minute))
+	}
+	return "now"
+}
+
+// reminderTarget is one upcoming session for one person
+type reminderTarget struct {
+	kind       string
+	ref        string
+	userID     uint
+	event      string
+	title      string // Class or service name
+	start      time.Time
+	meetingURL string
+}
+
+// sendReminder records every due offset not yet sent for a target, then sends
+// a single reminder for them. Returns false if nothing was due or another
+// server got there first.
+func sendReminder(target reminderTarget, offsets []time.Duration, now time.Time) bool {
+	due := dueReminderOffsets(offsets, target.start, now)
+	if len(due) == 0 {
+		return false
+	}
+	occurrence := target.start.UTC().Truncate(time.Minute)
+
+	var sent []SentReminder
+	db.Where("kind = ? AND target_ref = ? AND user_id = ? AND occurrence_at = ?", target.kind, target.ref, target.userID, occurrence).
+		Find(&sent)
+	done := map[int]bool{}
+	for _, s := range sent {
+		done[s.OffsetMinutes] = true
+	}
+	var pending []int
+	for _, offset := range due {
+		if minutes := int(offset / time.Minute); !done[minutes] {
+			pending = append(pending, minutes)
+		}
+	}
+	if len(pending) == 0 {
+		return false
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, minutes := range pending {
+			if err := tx.Create(&SentReminder{
+				Kind:          target.kind,
+				TargetRef:     target.ref,
+				UserID:        target.userID,
+				OccurrenceAt:  occurrence,
+				OffsetMinutes: minutes,
+			}).Error; err != nil {
+				return err // Unique index: already sent
+			}
+		}
+
+		var user User
+		if err := tx.First(&user, target.userID).Error; err != nil {
+			return nil
+		}
+		when := formatInTimezone(target.start, user.Timezone)
+		body := fmt.Sprintf("%s starts %s, %s.", target.title, humanizeUntil(target.start.Sub(now)), when)
+		url := frontendURL() + "/bookings"
+		if target.kind == ReminderAppointment {
+			url = frontendURL() + "/appointments"
+		}
+		if target.meetingURL != "" {
+			body += " Join online: " + target.meetingURL
+			url = target.meetingURL
+		}
+		expires := target.start.UTC()
+		return Notify(tx, user.ID, target.event, NotificationContent{
+			Title: "Reminder: " + target.title,
+			Body:  body,
+			URL:   url,
+			Email: func(tx *gorm.DB, to string) error {
+				return SendClassReminder(tx, to, user.Name, target.title, when, target.meetingURL)
+			},
+			ExpiresAt: &expires,
+		})
+	})
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// upcomingClassReminders lists confirmed bookings with the session each is for
+func upcomingClassReminders(now time.Time, horizon time.Duration) []reminderTarget {
+	loc := studioLocation()
+	var bookings []Booking
+	// A booking is for the first session after it was made, so at most a week old
+	db.Preload("Class").Where("status = ? AND created_at > ?", "confirmed", now.AddDate(0, 0, -8)).Find(&bookings)
+
+	var targets []reminderTarget
+	for _, booking := range bookings {
+		start, err := classOccurrenceAfter(booking.Class, booking.CreatedAt, loc)
+		if err != nil || !start.After(now) || start.Sub(now) > horizon {
+			continue
+		}
+		meetingURL := ""
+		if booking.Class.LocationType != "in-person" {
+			meetingURL = booking.Class.MeetingURL
+		}
+		targets = append(targets, reminderTarget{
+			kind:       ReminderClass,
+			ref:        fmt.Sprint(booking.ID),
+			userID:     booking.UserID,
+			event:      EventClassReminder,
+			title:      booking.Class.Name,
+			start:      start,
+			meetingURL: meetingURL,
+		})
+	}
+	return targets
+}
+
+// upcomingAppointmentReminders lists live appointments for both the client and the professional
+func upcomingAppointmentReminders(now time.Time, horizon time.Duration) []reminderTarget {
+	var appointments []Appointment
+	db.Preload("Service").Preload("Professional").
+		Where("status NOT IN ? AND start_time > ? AND start_time <= ?",
+			[]string{"cancelled", "completed", "no_show"}, now, now.Add(horizon)).
+		Find(&appointments)
+
+	var targets []reminderTarget
+	for _, appointment := range appointments {
+		title := appointment.Service.Name
+		if title == "" {
+			title = "Your appointment"
+		}
+		base := reminderTarget{
+			kind:       ReminderAppointment,
+			ref:        appointment.ID.String(),
+			event:      EventAppointmentReminder,
+			title:      title,
+			start:      appointment.StartTime,
+			meetingURL: appointment.MeetingLink,
+		}
+		client := base
+		client.userID = appointment.ClientID
+		targets = append(targets, client)
+		if appointment.Professional.UserID != 0 {
+			professional := base
+			professional.userID = appointment.Professional.UserID
+			targets = append(targets, professional)
+		}
+	}
+	return targets
+}
+
+// SendDueReminders sends class and appointment reminders at each REMINDER_OFFSETS offset
+func SendDueReminders() int {
+	offsets := reminderOffsets()
+	if len(offsets) == 0 {
+		return 0
+	}
+	now := time.Now()
+	horizon := offsets[0]
+
+	targets := append(upcomingClassReminders(now, horizon), upcomingAppointmentReminders(now, horizon)...)
+	sent := 0
+	for _, target := range targets {
+		if sendReminder(target, offsets, now) {
+			sent++
+		}
+	}
+	return sent
+}
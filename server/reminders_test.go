@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClassOccurrenceAfter(t *testing.T) {
+	sydney, _ := time.LoadLocation("Australia/Sydney")
+	monday7am := Class{Day: "Monday", Time: "07:00"}
+	// Sunday 18 Oct 2026, 10:00 in Sydney
+	sunday := time.Date(2026, 10, 18, 10, 0, 0, 0, sydney)
+
+	got, err := classOccurrenceAfter(monday7am, sunday, sydney)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 10, 19, 7, 0, 0, 0, sydney); !got.Equal(want) {
+		t.Errorf("next Monday class = %v, want %v", got, want)
+	}
+
+	// Booked during the session itself: the next one is a week later
+	during := time.Date(2026, 10, 19, 7, 30, 0, 0, sydney)
+	if got, _ := classOccurrenceAfter(monday7am, during, sydney); !got.Equal(time.Date(2026, 10, 26, 7, 0, 0, 0, sydney)) {
+		t.Errorf("after start = %v", got)
+	}
+
+	evening := Class{Day: "sunday", Time: "6:30 PM"}
+	if got, _ := classOccurrenceAfter(evening, sunday, sydney); !got.Equal(time.Date(2026, 10, 18, 18, 30, 0, 0, sydney)) {
+		t.Errorf("same-day evening class = %v", got)
+	}
+
+	if _, err := classOccurrenceAfter(Class{Day: "Someday", Time: "07:00"}, sunday, sydney); err == nil {
+		t.Error("expected an error for an unknown day")
+	}
+	if _, err := classOccurrenceAfter(Class{Day: "Monday", Time: "early"}, sunday, sydney); err == nil {
+		t.Error("expected an error for an unknown time")
+	}
+}
+
+func TestDueReminderOffsets(t *testing.T) {
+	offsets := []time.Duration{24 * time.Hour, time.Hour}
+	start := time.Date(2026, 10, 20, 7, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		now  time.Time
+		want int
+	}{
+		{start.Add(-30 * time.Hour), 0},
+		{start.Add(-23 * time.Hour), 1},
+		{start.Add(-45 * time.Minute), 2},
+		{start, 0}, // Already started
+	}
+	for _, tc := range cases {
+		if got := dueReminderOffsets(offsets, start, tc.now); len(got) != tc.want {
+			t.Errorf("%v before start: %d offsets due, want %d", start.Sub(tc.now), len(got), tc.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestBayesianRating(t *testing.T) {
+	cases := []struct {
+		sum    float64
+		n      int
+		prior  float64
+		weight float64
+		want   float64
+	}{
+		{sum: 0, n: 0, prior: 0, weight: 0, want: 0},
+		{sum: 14, n: 3, prior: 4, weight: 0, want: 14.0 / 3},       // Plain mean
+		{sum: 5, n: 1, prior: 4, weight: 9, want: 4.1},             // One 5-star review barely moves a new professional
+		{sum: 450, n: 100, prior: 4, weight: 9, want: 486.0 / 109}, // An established professional keeps close to their own mean
+		{sum: 0, n: 0, prior: 4, weight: 9, want: 4},               // No reviews yet: the prior
+	}
+	for _, c := range cases {
+		if got := bayesianRating(c.sum, c.n, c.prior, c.weight); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("bayesianRating(%v, %d, %v, %v) = %v, want %v", c.sum, c.n, c.prior, c.weight, got, c.want)
+		}
+	}
+}
+
+func TestAppointmentReviewable(t *testing.T) {
+	now := time.Date(2026, 10, 20, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	cases := []struct {
+		status string
+		end    time.Time
+		want   bool
+	}{
+		{"completed", future, true},
+		{"confirmed", past, true},
+		{"pending", past, false},
+		{"rescheduled", past, false},
+		{"confirmed", future, false},
+		{"cancelled", past, false},
+		{"no_show", past, false},
+	}
+	for _, c := range cases {
+		if got := appointmentReviewable(Appointment{Status: c.status, EndTime: c.end}, now); got != c.want {
+			t.Errorf("%s ending %v: reviewable = %v, want %v", c.status, c.end, got, c.want)
+		}
+	}
+}
+
+func TestReviewSaveError(t *testing.T) {
+	setupTestDB(t, &User{})
+	// SQLite has no gen_random_uuid(), so create the UUID-keyed tables first
+	// and let AutoMigrate add the remaining columns. SQLite can't add a unique
+	// column to a table, so the appointment column is created up front.
+	for _, ddl := range []string{
+		"CREATE TABLE professionals (id TEXT PRIMARY KEY)",
+		"CREATE TABLE reviews (id TEXT PRIMARY KEY, appointment_id TEXT NOT NULL UNIQUE)",
+	} {
+		if err := db.Exec(ddl).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.AutoMigrate(&Professional{}, &Review{}); err != nil {
+		t.Fatal(err)
+	}
+
+	reviewed, other := uuid.New(), uuid.New()
+	first := Review{ID: uuid.New(), AppointmentID: reviewed, ClientID: 1, ProfessionalID: uuid.New(), Rating: 5}
+	if err := db.Omit("Client", "Professional").Create(&first).Error; err != nil {
+		t.Fatal(err)
+	}
+	// The insert a concurrent duplicate makes once it is past the count
+	dupe := Review{ID: uuid.New(), AppointmentID: reviewed, ClientID: 1, ProfessionalID: first.ProfessionalID, Rating: 4}
+	dupeErr := db.Omit("Client", "Professional").Create(&dupe).Error
+	if dupeErr == nil {
+		t.Fatal("the unique index allowed a second review")
+	}
+
+	failed := errors.New("connection lost")
+	cases := []struct {
+		name        string
+		err         error
+		appointment uuid.UUID
+		want        error
+	}{
+		{"saved", nil, other, nil},
+		{"counted as reviewed", errAlreadyReviewed, reviewed, errAlreadyReviewed},
+		{"lost the race to the unique index", dupeErr, reviewed, errAlreadyReviewed},
+		{"other failure", failed, other, failed},
+	}
+	for _, c := range cases {
+		if got := reviewSaveError(c.err, c.appointment); got != c.want {
+			t.Errorf("%s: reviewSaveError = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
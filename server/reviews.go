@@ -0,0 +1,451 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// --- Models ---
+
+// ReviewReport is one user flagging a review for moderators. A review
+// enters the moderation queue when it has any open reports.
+type ReviewReport struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	ReviewID  uuid.UUID `json:"review_id" gorm:"type:uuid;uniqueIndex:idx_review_report;not null"`
+	UserID    uint      `json:"user_id" gorm:"uniqueIndex:idx_review_report;not null"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// --- DTOs ---
+
+type CreateReviewInput struct {
+	Rating  float64 `json:"rating" binding:"required,min=1,max=5"`
+	Comment string  `json:"comment" binding:"max=2000"`
+}
+
+type ReviewReplyInput struct {
+	Reply string `json:"reply" binding:"required,max=2000"`
+}
+
+type ReviewReportInput struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+type HideReviewInput struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// PublicReview is a review as shown on a professional's profile
+type PublicReview struct {
+	ID         uuid.UUID  `json:"id"`
+	Rating     float64    `json:"rating"`
+	Comment    string     `json:"comment"`
+	ClientName string     `json:"client_name"` // First name only
+	Reply      string     `json:"reply,omitempty"`
+	RepliedAt  *time.Time `json:"replied_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// --- Logic ---
+
+var errAlreadyReviewed = errors.New("appointment already reviewed")
+
+// appointmentReviewable reports whether an appointment has happened: marked
+// completed, or confirmed by the professional and over. Bookings that were
+// never confirmed can't be reviewed.
+func appointmentReviewable(a Appointment, now time.Time) bool {
+	switch a.Status {
+	case "completed":
+		return true
+	case "confirmed":
+		return !a.EndTime.IsZero() && a.EndTime.Before(now)
+	}
+	return false
+}
+
+// reviewSaveError reports a failed review save as errAlreadyReviewed when the
+// appointment has a review after all: a concurrent review got past the count
+// and the insert tripped the unique index
+func reviewSaveError(err error, appointmentID uuid.UUID) error {
+	if err == nil || err == errAlreadyReviewed {
+		return err
+	}
+	var existing int64
+	db.Model(&Review{}).Where("appointment_id = ?", appointmentID).Count(&existing)
+	if existing > 0 {
+		return errAlreadyReviewed
+	}
+	return err
+}
+
+// bayesianRating pulls an average of n ratings towards prior, as if the
+// professional also had weight ratings of prior. Weight 0 is the plain mean.
+func bayesianRating(sum float64, n int, prior, weight float64) float64 {
+	if float64(n)+weight == 0 {
+		return 0
+	}
+	return (sum + prior*weight) / (float64(n) + weight)
+}
+
+// reviewPrior is the mean the Bayesian average pulls towards:
+// REVIEW_PRIOR_MEAN, or the mean of every visible review on the platform
+func reviewPrior(tx *gorm.DB) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("REVIEW_PRIOR_MEAN"), 64); err == nil {
+		return v
+	}
+	var mean struct{ Avg float64 }
+	tx.Model(&Review{}).Select("COALESCE(AVG(rating), 0) AS avg").Where("is_public = ?", true).Scan(&mean)
+	return mean.Avg
+}
+
+// reviewWeight is REVIEW_BAYESIAN_WEIGHT, the number of prior ratings each
+// professional starts with; 0 turns the Bayesian average off
+func reviewWeight() float64 {
+	weight, err := strconv.ParseFloat(os.Getenv("REVIEW_BAYESIAN_WEIGHT"), 64)
+	if err != nil || weight < 0 {
+		return 0
+	}
+	return weight
+}
+
+// recomputeProfessionalRating updates Rating, ReviewCount and WeightedRating
+// from the professional's visible reviews. The professional row is locked so
+// concurrent reviews can't overwrite each other's totals.
+func recomputeProfessionalRating(tx *gorm.DB, professionalID uuid.UUID) error {
+	var professional Professional
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", professionalID).First(&professional).Error; err != nil {
+		return err
+	}
+
+	var totals struct {
+		Count int
+		Sum   float64
+	}
+	if err := tx.Model(&Review{}).Select("COUNT(*) AS count, COALESCE(SUM(rating), 0) AS sum").
+		Where("professional_id = ? AND is_public = ?", professionalID, true).Scan(&totals).Error; err != nil {
+		return err
+	}
+
+	rating := bayesianRating(totals.Sum, totals.Count, 0, 0)
+	weighted := rating
+	if weight := reviewWeight(); weight > 0 {
+		weighted = bayesianRating(totals.Sum, totals.Count, reviewPrior(tx), weight)
+	}
+	return tx.Model(&professional).Updates(map[string]interface{}{
+		"rating":          roundRating(rating),
+		"review_count":    totals.Count,
+		"weighted_rating": roundRating(weighted),
+	}).Error
+}
+
+func roundRating(r float64) float64 {
+	return float64(int(r*100+0.5)) / 100
+}
+
+// RecomputeWeightedRatings refreshes every professional's Bayesian average,
+// since the platform-wide prior moves as reviews come in
+func RecomputeWeightedRatings() int {
+	if reviewWeight() == 0 {
+		return 0
+	}
+	var ids []uuid.UUID
+	db.Model(&Professional{}).Where("review_count > 0 OR weighted_rating > 0").Pluck("id", &ids)
+	updated := 0
+	for _, id := range ids {
+		if db.Transaction(func(tx *gorm.DB) error { return recomputeProfessionalRating(tx, id) }) == nil {
+			updated++
+		}
+	}
+	return updated
+}
+
+func publicReview(r Review) PublicReview {
+	name := strings.TrimSpace(r.Client.Name)
+	if i := strings.IndexByte(name, ' '); i > 0 {
+		name = name[:i]
+	}
+	review := PublicReview{
+		ID:         r.ID,
+		Rating:     r.Rating,
+		Comment:    r.Comment,
+		ClientName: name,
+		CreatedAt:  r.CreatedAt,
+	}
+	if r.RepliedAt != nil {
+		review.Reply = r.Reply
+		review.RepliedAt = r.RepliedAt
+	}
+	return review
+}
+
+// --- Handlers ---
+
+// CreateReview - Protected - Review an appointment that has taken place, once
+func CreateReview(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	var input CreateReviewInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var appointment Appointment
+	if err := db.Where("id = ? AND client_id = ?", c.Param("id"), uid).First(&appointment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found"})
+		return
+	}
+	if !appointmentReviewable(appointment, time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can review an appointment once it has taken place"})
+		return
+	}
+
+	review := Review{
+		ID:             uuid.New(),
+		AppointmentID:  appointment.ID,
+		ClientID:       uid,
+		ProfessionalID: appointment.ProfessionalID,
+		Rating:         float64(int(input.Rating*2+0.5)) / 2, // Half stars
+		Comment:        strings.TrimSpace(input.Comment),
+		IsPublic:       true,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var existing int64
+		tx.Model(&Review{}).Where("appointment_id = ?", appointment.ID).Count(&existing)
+		if existing > 0 {
+			return errAlreadyReviewed
+		}
+		if err := tx.Omit("Client", "Professional").Create(&review).Error; err != nil {
+			return err
+		}
+		return recomputeProfessionalRating(tx, appointment.ProfessionalID)
+	})
+	err = reviewSaveError(err, appointment.ID)
+	switch {
+	case err == errAlreadyReviewed:
+		c.JSON(http.StatusConflict, gin.H{"error": "You have already reviewed this appointment"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save review"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Thanks for your review", "data": review})
+}
+
+// GetProfessionalReviews - Public - Visible reviews for a professional, newest first, with their rating summary
+func GetProfessionalReviews(c *gin.Context) {
+	professionalID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid professional ID"})
+		return
+	}
+	var professional Professional
+	if err := db.Where("id = ?", professionalID).First(&professional).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Professional not found"})
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset := (page - 1) * limit
+
+	query := db.Model(&Review{}).Where("professional_id = ? AND is_public = ?", professionalID, true)
+	var total int64
+	query.Count(&total)
+	var reviews []Review
+	query.Preload("Client").Order("created_at desc").Limit(limit).Offset(offset).Find(&reviews)
+
+	var distribution []struct {
+		Stars int `json:"stars"`
+		Count int `json:"count"`
+	}
+	db.Model(&Review{}).Select("CAST(rating AS INTEGER) AS stars, COUNT(*) AS count").
+		Where("professional_id = ? AND is_public = ?", professionalID, true).
+		Group("CAST(rating AS INTEGER)").Order("stars desc").Scan(&distribution)
+
+	data := make([]PublicReview, len(reviews))
+	for i, r := range reviews {
+		data[i] = publicReview(r)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"summary": gin.H{
+			"rating":          professional.Rating,
+			"review_count":    professional.ReviewCount,
+			"weighted_rating": professional.WeightedRating,
+			"distribution":    distribution,
+		},
+		"data":  data,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// ReplyToReview - Protected - The reviewed professional's one public reply
+func ReplyToReview(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	var input ReviewReplyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var professional Professional
+	if err := db.Where("user_id = ?", uid).First(&professional).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the reviewed professional can reply"})
+		return
+	}
+
+	now := time.Now()
+	res := db.Model(&Review{}).
+		Where("id = ? AND professional_id = ? AND replied_at IS NULL", c.Param("id"), professional.ID).
+		Updates(map[string]interface{}{"reply": strings.TrimSpace(input.Reply), "replied_at": &now})
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save reply"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		var review Review
+		if err := db.Where("id = ? AND professional_id = ?", c.Param("id"), professional.ID).First(&review).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "You have already replied to this review"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Reply posted"})
+}
+
+// ReportReview - Protected - Flag a review for the moderators
+func ReportReview(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	var input ReviewReportInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var review Review
+	if err := db.Where("id = ? AND is_public = ?", c.Param("id"), true).First(&review).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&ReviewReport{ReviewID: review.ID, UserID: uid, Reason: strings.TrimSpace(input.Reason)})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error // Reported before; nothing to add
+		}
+		return tx.Model(&review).Update("report_count", gorm.Expr("report_count + 1")).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to report review"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Thanks, a moderator will take a look"})
+}
+
+// GetReviewModerationQueue - Admin - Reviews by status: reported (default), hidden, visible or all
+func GetReviewModerationQueue(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset := (page - 1) * limit
+
+	query := db.Model(&Review{})
+	order := "created_at desc"
+	switch c.DefaultQuery("status", "reported") {
+	case "reported":
+		query = query.Where("report_count > 0 AND is_public = ?", true)
+		order = "report_count desc, created_at asc"
+	case "hidden":
+		query = query.Where("is_public = ?", false)
+		order = "hidden_at desc"
+	case "visible":
+		query = query.Where("is_public = ?", true)
+	case "all":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be reported, hidden, visible or all"})
+		return
+	}
+	if professionalID := c.Query("professional_id"); professionalID != "" {
+		query = query.Where("professional_id = ?", professionalID)
+	}
+
+	var total int64
+	query.Count(&total)
+	var reviews []Review
+	query.Preload("Client").Order(order).Limit(limit).Offset(offset).Find(&reviews)
+
+	data := make([]gin.H, len(reviews))
+	for i, r := range reviews {
+		var reports []ReviewReport
+		db.Where("review_id = ?", r.ID).Order("created_at asc").Find(&reports)
+		data[i] = gin.H{"review": r, "reports": reports}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data":  data,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// HideReview - Admin - Take a review off the professional's profile and out of their rating
+func HideReview(c *gin.Context) {
+	adminID, _ := currentUserID(c)
+	var input HideReviewInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	moderateReview(c, map[string]interface{}{
+		"is_public":     false,
+		"hidden_reason": input.Reason,
+		"hidden_at":     &now,
+		"hidden_by":     &adminID,
+	}, "Review hidden")
+}
+
+// RestoreReview - Admin - Put a hidden review back, or dismiss the reports on a visible one
+func RestoreReview(c *gin.Context) {
+	moderateReview(c, map[string]interface{}{
+		"is_public":     true,
+		"hidden_reason": "",
+		"hidden_at":     nil,
+		"hidden_by":     nil,
+	}, "Review restored")
+}
+
+// moderateReview applies a moderation decision, which also settles any
+// reports, and recomputes the professional's rating
+func moderateReview(c *gin.Context, updates map[string]interface{}, message string) {
+	var review Review
+	if err := db.Where("id = ?", c.Param("id")).First(&review).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+		return
+	}
+	updates["report_count"] = 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&review).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("review_id = ?", review.ID).Delete(&ReviewReport{}).Error; err != nil {
+			return err
+		}
+		return recomputeProfessionalRating(tx, review.ProfessionalID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update review"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// --- Model ---
+
+// SeatHold reserves a class spot while the customer is paying for it, so
+// the class can't fill up between checkout and booking
+type SeatHold struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	ClassID   uint      `json:"class_id" gorm:"index"`
+	UserID    uint      `json:"user_id" gorm:"index"`
+	PaymentID uint      `json:"payment_id" gorm:"uniqueIndex"`
+	Status    string    `json:"status" gorm:"index"` // held, converted, released
+	ExpiresAt time.Time `json:"expires_at"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func seatHoldDuration() time.Duration {
+	return time.Duration(envInt("SEAT_HOLD_MINUTES", 30)) * time.Minute
+}
+
+// heldSeats counts seats held for a class by anyone other than excludeUserID
+func heldSeats(tx *gorm.DB, classID, excludeUserID uint) int64 {
+	var count int64
+	tx.Model(&SeatHold{}).
+		Where("class_id = ? AND status = ? AND expires_at > ? AND user_id != ?", classID, "held", time.Now(), excludeUserID).
+		Count(&count)
+	return count
+}
+
+// HoldSeat reserves a spot in a class for a pending payment
+func HoldSeat(tx *gorm.DB, classID, userID, paymentID uint) error {
+	// Locked so two checkouts can't both take the last seat
+	var class Class
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&class, classID).Error; err != nil {
+		return errors.New("class not found")
+	}
+
+	var booked int64
+	tx.Model(&Booking{}).Where("class_id = ? AND status = ?", classID, "confirmed").Count(&booked)
+	if booked+heldSeats(tx, classID, userID) >= int64(class.Capacity) {
+		return errors.New("class is full")
+	}
+
+	return tx.Create(&SeatHold{
+		ClassID:   classID,
+		UserID:    userID,
+		PaymentID: paymentID,
+		Status:    "held",
+		ExpiresAt: time.Now().Add(seatHoldDuration()),
+	}).Error
+}
+
+// ReleaseSeatHold frees the seat held for a payment that will not complete
+func ReleaseSeatHold(tx *gorm.DB, paymentID uint) error {
+	return tx.Model(&SeatHold{}).Where("payment_id = ? AND status = ?", paymentID, "held").
+		Update("status", "released").Error
+}
+
+// ExtendSeatHold keeps a paid-for seat held until the booking is made
+func ExtendSeatHold(tx *gorm.DB, paymentID uint) error {
+	return tx.Model(&SeatHold{}).Where("payment_id = ? AND status = ?", paymentID, "held").
+		Update("expires_at", time.Now().Add(24*time.Hour)).Error
+}
+
+// ConvertSeatHold marks a hold as used by a confirmed booking
+func ConvertSeatHold(tx *gorm.DB, paymentID uint) error {
+	return tx.Model(&SeatHold{}).Where("payment_id = ? AND status = ?", paymentID, "held").
+		Update("status", "converted").Error
+}
+
+// ReleaseExpiredSeatHolds frees holds whose time ran out
+func ReleaseExpiredSeatHolds() int64 {
+	res := db.Model(&SeatHold{}).Where("status = ? AND expires_at <= ?", "held", time.Now()).
+		Update("status", "released")
+	return res.RowsAffected
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHoldSeat(t *testing.T) {
+	setupTestDB(t, &User{}, &Class{}, &Booking{}, &SeatHold{})
+
+	class := Class{Name: "Hatha", Capacity: 3}
+	require.NoError(t, db.Create(&class).Error)
+	require.NoError(t, db.Create(&Booking{UserID: 9, ClassID: class.ID, Status: "confirmed"}).Error)
+	require.NoError(t, db.Create(&Booking{UserID: 8, ClassID: class.ID, Status: "cancelled"}).Error)
+	require.NoError(t, db.Create(&SeatHold{ClassID: class.ID, UserID: 7, PaymentID: 99, Status: "held", ExpiresAt: time.Now().Add(-time.Minute)}).Error)
+
+	cases := []struct {
+		name      string
+		classID   uint
+		userID    uint
+		paymentID uint
+		err       string
+	}{
+		{"free seat", class.ID, 1, 1, ""},
+		{"last seat", class.ID, 2, 2, ""},
+		{"full with a booking and two holds", class.ID, 3, 3, "class is full"},
+		{"a customer's own hold doesn't block them", class.ID, 1, 4, ""},
+		{"unknown class", 404, 1, 5, "class not found"},
+	}
+	for _, tc := range cases {
+		err := HoldSeat(db, tc.classID, tc.userID, tc.paymentID)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.name)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.name)
+		}
+	}
+	assert.Equal(t, int64(3), heldSeats(db, class.ID, 0), "expired holds don't count")
+	assert.Equal(t, int64(1), heldSeats(db, class.ID, 1))
+}
+
+func TestSeatHoldLifecycle(t *testing.T) {
+	setupTestDB(t, &User{}, &Class{}, &Booking{}, &SeatHold{})
+
+	class := Class{Name: "Yin", Capacity: 1}
+	require.NoError(t, db.Create(&class).Error)
+
+	require.NoError(t, HoldSeat(db, class.ID, 1, 1))
+	assert.EqualError(t, HoldSeat(db, class.ID, 2, 2), "class is full")
+
+	// A failed payment frees the seat for the next customer
+	require.NoError(t, ReleaseSeatHold(db, 1))
+	require.NoError(t, HoldSeat(db, class.ID, 2, 2))
+
+	// A paid hold outlives its checkout window until it is converted
+	require.NoError(t, ExtendSeatHold(db, 2))
+	var hold SeatHold
+	db.Where("payment_id = ?", 2).First(&hold)
+	assert.True(t, hold.ExpiresAt.After(time.Now().Add(23*time.Hour)))
+	require.NoError(t, ConvertSeatHold(db, 2))
+	assert.Equal(t, int64(0), heldSeats(db, class.ID, 0))
+
+	// Converted and released holds are left alone by the sweep
+	db.Model(&SeatHold{}).Where("payment_id = ?", 2).Update("expires_at", time.Now().Add(-time.Minute))
+	require.NoError(t, HoldSeat(db, class.ID, 3, 3))
+	db.Model(&SeatHold{}).Where("payment_id = ?", 3).Update("expires_at", time.Now().Add(-time.Minute))
+	assert.Equal(t, int64(1), ReleaseExpiredSeatHolds())
+	db.Where("payment_id = ?", 2).First(&hold)
+	assert.Equal(t, "converted", hold.Status)
+}
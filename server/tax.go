@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// --- Models ---
+
+// TaxRule configures the tax charged to customers in a country or region
+type TaxRule struct {
+	ID          uint    `json:"id" gorm:"primaryKey"`
+	CountryCode string  `json:"country_code" gorm:"index"` // ISO country code (e.g. IN)
+	RegionCode  string  `json:"region_code"`               // State/region code, empty = whole country
+	Name        string  `json:"name"`                      // GST, VAT
+	Rate        float64 `json:"rate"`                      // Percent, e.g. 18
+
+	PriceIncludesTax bool `json:"price_includes_tax"` // Prices shown to customers already include tax
+	ReverseCharge    bool `json:"reverse_charge"`     // B2B customers with a tax ID self-account for tax
+	IsActive         bool `json:"is_active"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// PaymentTaxLine stores each tax component charged on a payment (e.g. CGST + SGST)
+type PaymentTaxLine struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	PaymentID     uint      `json:"payment_id" gorm:"index"`
+	Name          string    `json:"name"` // CGST, SGST, IGST, GST, VAT
+	Rate          float64   `json:"rate"`
+	TaxableAmount float64   `json:"taxable_amount"`
+	Amount        float64   `json:"amount"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// TaxResult is the breakdown of an order amount into net and tax
+type TaxResult struct {
+	Net           float64          `json:"net"`
+	Tax           float64          `json:"tax"`
+	Gross         float64          `json:"gross"`
+	Inclusive     bool             `json:"inclusive"`
+	ReverseCharge bool             `json:"reverse_charge"`
+	Lines         []PaymentTaxLine `json:"lines"`
+
+	// How the customer's tax ID was validated for reverse charge
+	TaxIDCheck     string     `json:"tax_id_check,omitempty"` // vies, admin
+	TaxIDCheckedAt *time.Time `json:"tax_id_checked_at,omitempty"`
+}
+
+// --- Logic ---
+
+func sellerTaxCountry() string {
+	if country := os.Getenv("TAX_SELLER_COUNTRY"); country != "" {
+		return strings.ToUpper(country)
+	}
+	return "IN"
+}
+
+// CalculateTax applies the matching rule to an amount. For tax-inclusive
+// rules the amount is treated as gross, otherwise tax is added on top.
+// Reverse charge needs a tax ID that passes verifyTaxID for user uid.
+func CalculateTax(tx *gorm.DB, country, region string, amount float64, taxID string, uid uint) TaxResult {
+	country = strings.ToUpper(country)
+	region = strings.ToUpper(region)
+	result := TaxResult{Net: amount, Gross: amount}
+
+	var rule TaxRule
+	if err := tx.Where("country_code = ? AND region_code = ? AND is_active = ?", country, region, true).First(&rule).Error; err != nil {
+		if err := tx.Where("country_code = ? AND region_code = ? AND is_active = ?", country, "", true).First(&rule).Error; err != nil {
+			return result // No tax configured for this country
+		}
+	}
+
+	result.Inclusive = rule.PriceIncludesTax
+	net := amount
+	if rule.PriceIncludesTax {
+		net = roundMoney(amount / (1 + rule.Rate/100))
+	}
+
+	// Cross-border B2B supplies: the customer accounts for the tax
+	if rule.ReverseCharge && taxID != "" && country != sellerTaxCountry() {
+		if check, ok := verifyTaxID(tx, country, taxID, uid); ok {
+			result.ReverseCharge = true
+			result.TaxIDCheck = check.Via
+			result.TaxIDCheckedAt = &check.CheckedAt
+			result.Net = net
+			result.Gross = net
+			return result
+		}
+	}
+
+	for _, component := range taxComponents(rule, region) {
+		line := PaymentTaxLine{
+			Name:          component.name,
+			Rate:          component.rate,
+			TaxableAmount: net,
+			Amount:        roundMoney(net * component.rate / 100),
+		}
+		result.Lines = append(result.Lines, line)
+		result.Tax += line.Amount
+	}
+	result.Tax = roundMoney(result.Tax)
+	result.Net = net
+	if rule.PriceIncludesTax {
+		// Absorb rounding in the net so the customer pays the listed price
+		result.Net = roundMoney(amount - result.Tax)
+		result.Gross = amount
+	} else {
+		result.Gross = roundMoney(net + result.Tax)
+	}
+	return result
+}
+
+// TaxIDCheck records how a tax ID was found to be valid
+type TaxIDCheck struct {
+	Via       string // vies, admin
+	CheckedAt time.Time
+}
+
+// taxIDFormats are the national parts of EU VAT numbers, after the country prefix
+var taxIDFormats = map[string]*regexp.Regexp{
+	"AT": regexp.MustCompile(`^U\d{8}$`),
+	"BE": regexp.MustCompile(`^[01]\d{9}$`),
+	"DE": regexp.MustCompile(`^\d{9}$`),
+	"DK": regexp.MustCompile(`^\d{8}$`),
+	"ES": regexp.MustCompile(`^[0-9A-Z]\d{7}[0-9A-Z]$`),
+	"FI": regexp.MustCompile(`^\d{8}$`),
+	"FR": regexp.MustCompile(`^[0-9A-HJ-NP-Z]{2}\d{9}$`),
+	"IE": regexp.MustCompile(`^(\d{7}[A-W][A-I]?|\d[A-Z+*]\d{5}[A-W])$`),
+	"IT": regexp.MustCompile(`^\d{11}$`),
+	"LU": regexp.MustCompile(`^\d{8}$`),
+	"NL": regexp.MustCompile(`^\d{9}B\d{2}$`),
+	"PL": regexp.MustCompile(`^\d{10}$`),
+	"PT": regexp.MustCompile(`^\d{9}$`),
+	"SE": regexp.MustCompile(`^\d{12}$`),
+}
+
+// normalizeTaxID upper-cases a tax ID and strips separators and the
+// country prefix, e.g. "de 123.456.789" -> "123456789"
+func normalizeTaxID(country, taxID string) string {
+	id := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '.' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(taxID)))
+	return strings.TrimPrefix(id, strings.ToUpper(country))
+}
+
+var viesHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// viesLookup asks the EU VIES service whether a VAT number is registered.
+// Replaced in tests.
+var viesLookup = func(country, number string) (bool, error) {
+	url := os.Getenv("VIES_URL")
+	if url == "" {
+		url = "https://ec.europa.eu/taxation_customs/vies/rest-api/check-vat-number"
+	}
+	body, _ := json.Marshal(map[string]string{"countryCode": country, "vatNumber": number})
+	resp, err := viesHTTPClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("VIES returned %s", resp.Status)
+	}
+	var result struct {
+		Valid bool `json:"valid"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Valid, nil
+}
+
+// verifyTaxID decides whether a customer's tax ID is good enough to zero-rate
+// their order. It must match the country's format and either have been
+// verified by an admin on the user's profile or be confirmed by VIES.
+func verifyTaxID(tx *gorm.DB, country, taxID string, uid uint) (TaxIDCheck, bool) {
+	format, known := taxIDFormats[country]
+	number := normalizeTaxID(country, taxID)
+	if !known || !format.MatchString(number) {
+		return TaxIDCheck{}, false
+	}
+
+	var user User
+	if tx.First(&user, uid).Error == nil && user.TaxIDVerifiedAt != nil &&
+		normalizeTaxID(country, user.TaxID) == number {
+		return TaxIDCheck{Via: "admin", CheckedAt: *user.TaxIDVerifiedAt}, true
+	}
+
+	valid, err := viesLookup(country, number)
+	if err != nil {
+		fmt.Printf("WARNING: VIES check failed for %s%s: %v\n", country, number, err)
+		return TaxIDCheck{}, false
+	}
+	if !valid {
+		return TaxIDCheck{}, false
+	}
+	return TaxIDCheck{Via: "vies", CheckedAt: time.Now()}, true
+}
+
+type taxComponent struct {
+	name string
+	rate float64
+}
+
+// taxComponents splits Indian GST into CGST/SGST for intra-state supplies and
+// IGST for inter-state supplies. Other rules are charged as a single line.
+func taxComponents(rule TaxRule, region string) []taxComponent {
+	if rule.CountryCode != "IN" || rule.Name != "GST" || sellerTaxCountry() != "IN" {
+		return []taxComponent{{name: rule.Name, rate: rule.Rate}}
+	}
+	sellerRegion := strings.ToUpper(os.Getenv("TAX_SELLER_REGION"))
+	if sellerRegion != "" && region == sellerRegion {
+		return []taxComponent{
+			{name: "CGST", rate: rule.Rate / 2},
+			{name: "SGST", rate: rule.Rate / 2},
+		}
+	}
+	return []taxComponent{{name: "IGST", rate: rule.Rate}}
+}
+
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// --- Handlers ---
+
+// GetAdminTaxRules - Admin - List tax rules
+func GetAdminTaxRules(c *gin.Context) {
+	var rules []TaxRule
+	db.Order("country_code asc, region_code asc").Find(&rules)
+	c.JSON(http.StatusOK, rules)
+}
+
+// CreateTaxRule - Admin - Add a tax rule for a country/region
+func CreateTaxRule(c *gin.Context) {
+	input := TaxRule{IsActive: true}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input.CountryCode = strings.ToUpper(input.CountryCode)
+	input.RegionCode = strings.ToUpper(input.RegionCode)
+	if input.CountryCode == "" || input.Name == "" || input.Rate < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "country_code, name and a non-negative rate are required"})
+		return
+	}
+
+	if err := db.Create(&input).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tax rule"})
+		return
+	}
+	c.JSON(http.StatusCreated, input)
+}
+
+// UpdateTaxRule - Admin - Update a tax rule. Past payments keep their stored tax lines.
+func UpdateTaxRule(c *gin.Context) {
+	var rule TaxRule
+	if err := db.First(&rule, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tax rule not found"})
+		return
+	}
+
+	var input TaxRule
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule.CountryCode = strings.ToUpper(input.CountryCode)
+	rule.RegionCode = strings.ToUpper(input.RegionCode)
+	rule.Name = input.Name
+	rule.Rate = input.Rate
+	rule.PriceIncludesTax = input.PriceIncludesTax
+	rule.ReverseCharge = input.ReverseCharge
+	rule.IsActive = input.IsActive
+
+	if err := db.Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tax rule"})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// VerifyUserTaxID - Admin - Mark a customer's tax ID as checked, so their orders can be zero-rated without VIES
+func VerifyUserTaxID(c *gin.Context) {
+	var user User
+	if err := db.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.TaxID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User has no tax ID"})
+		return
+	}
+	now := time.Now()
+	db.Model(&user).Update("tax_id_verified_at", now)
+	c.JSON(http.StatusOK, gin.H{"tax_id": user.TaxID, "tax_id_verified_at": now})
+}
+
+// UnverifyUserTaxID - Admin - Withdraw a tax ID verification
+func UnverifyUserTaxID(c *gin.Context) {
+	if err := db.Model(&User{}).Where("id = ?", c.Param("id")).Update("tax_id_verified_at", nil).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Tax ID verification removed"})
+}
+
+// DeleteTaxRule - Admin - Remove a tax rule
+func DeleteTaxRule(c *gin.Context) {
+	var rule TaxRule
+	if err := db.First(&rule, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tax rule not found"})
+		return
+	}
+	db.Delete(&rule)
+	c.JSON(http.StatusOK, gin.H{"message": "Tax rule deleted"})
+}
+
+// taxSummaryRow is the tax on sales for one tax in one country and currency,
+// net of the credit notes issued against them
+type taxSummaryRow struct {
+	CountryCode           string  `json:"country_code"`
+	Currency              string  `json:"currency"`
+	Name                  string  `json:"name"`
+	Rate                  float64 `json:"rate"`
+	Payments              int64   `json:"payments"`
+	CreditNotes           int64   `json:"credit_notes"`
+	CreditedTaxableAmount float64 `json:"credited_taxable_amount"`
+	CreditedTaxAmount     float64 `json:"credited_tax_amount"`
+	TaxableAmount         float64 `json:"taxable_amount"` // Net of credit notes
+	TaxAmount             float64 `json:"tax_amount"`     // Net of credit notes
+}
+
+// reverseChargeCredit is a credit note against a reverse-charged sale
+type reverseChargeCredit struct {
+	Number      string    `json:"number"`
+	CountryCode string    `json:"country_code"`
+	Currency    string    `json:"currency"`
+	BuyerTaxID  string    `json:"buyer_tax_id"`
+	Subtotal    float64   `json:"subtotal"`
+	IssuedAt    time.Time `json:"issued_at"`
+}
+
+// invoicedPaymentStatuses are payments that were taken and invoiced, whatever
+// has been refunded since; refunds are netted off through their credit notes
+var invoicedPaymentStatuses = []string{"success", "partially_refunded", "refunded"}
+
+// buildTaxSummary totals the tax on payments taken between from and to, less
+// the credit notes issued in the same period. Credit notes credit each of the
+// payment's tax lines in proportion, as IssueCreditNote does.
+func buildTaxSummary(from, to time.Time) ([]taxSummaryRow, []Payment, []reverseChargeCredit) {
+	var sales []taxSummaryRow
+	db.Table("payment_tax_lines").
+		Select("payments.country_code, payments.currency, payment_tax_lines.name, payment_tax_lines.rate, "+
+			"COUNT(DISTINCT payments.id) as payments, COALESCE(SUM(payment_tax_lines.taxable_amount), 0) as taxable_amount, "+
+			"COALESCE(SUM(payment_tax_lines.amount), 0) as tax_amount").
+		Joins("JOIN payments ON payments.id = payment_tax_lines.payment_id").
+		Where("payments.status IN ? AND payments.created_at BETWEEN ? AND ?", invoicedPaymentStatuses, from, to).
+		Group("payments.country_code, payments.currency, payment_tax_lines.name, payment_tax_lines.rate").
+		Scan(&sales)
+
+	var credits []taxSummaryRow
+	db.Table("invoices").
+		Select("payments.country_code, payments.currency, payment_tax_lines.name, payment_tax_lines.rate, "+
+			"COUNT(DISTINCT invoices.id) as credit_notes, "+
+			"COALESCE(SUM(payment_tax_lines.taxable_amount * invoices.total / originals.total), 0) as credited_taxable_amount, "+
+			"COALESCE(SUM(payment_tax_lines.amount * invoices.total / originals.total), 0) as credited_tax_amount").
+		Joins("JOIN invoices originals ON originals.id = invoices.original_invoice_id").
+		Joins("JOIN payments ON payments.id = invoices.payment_id").
+		Joins("JOIN payment_tax_lines ON payment_tax_lines.payment_id = payments.id").
+		Where("invoices.type = ? AND originals.total > 0 AND invoices.issued_at BETWEEN ? AND ?", InvoiceTypeCreditNote, from, to).
+		Group("payments.country_code, payments.currency, payment_tax_lines.name, payment_tax_lines.rate").
+		Scan(&credits)
+
+	// Refunds of earlier sales have rows of their own
+	byKey := map[string]int{}
+	var rows []taxSummaryRow
+	for _, r := range append(sales, credits...) {
+		key := fmt.Sprintf("%s|%s|%s|%g", r.CountryCode, r.Currency, r.Name, r.Rate)
+		if i, ok := byKey[key]; ok {
+			rows[i].CreditNotes += r.CreditNotes
+			rows[i].CreditedTaxableAmount += r.CreditedTaxableAmount
+			rows[i].CreditedTaxAmount += r.CreditedTaxAmount
+			continue
+		}
+		byKey[key] = len(rows)
+		rows = append(rows, r)
+	}
+	for i := range rows {
+		r := &rows[i]
+		r.CreditedTaxableAmount = roundMoney(r.CreditedTaxableAmount)
+		r.CreditedTaxAmount = roundMoney(r.CreditedTaxAmount)
+		r.TaxableAmount = roundMoney(r.TaxableAmount - r.CreditedTaxableAmount)
+		r.TaxAmount = roundMoney(r.TaxAmount - r.CreditedTaxAmount)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].CountryCode != rows[j].CountryCode {
+			return rows[i].CountryCode < rows[j].CountryCode
+		}
+		return rows[i].Name < rows[j].Name
+	})
+
+	var reverseCharged []Payment
+	db.Where("status IN ? AND reverse_charge = ? AND created_at BETWEEN ? AND ?", invoicedPaymentStatuses, true, from, to).
+		Find(&reverseCharged)
+
+	var reverseChargeCredits []reverseChargeCredit
+	db.Table("invoices").
+		Select("invoices.number, payments.country_code, invoices.currency, invoices.buyer_tax_id, invoices.subtotal, invoices.issued_at").
+		Joins("JOIN payments ON payments.id = invoices.payment_id").
+		Where("invoices.type = ? AND invoices.reverse_charge = ? AND invoices.issued_at BETWEEN ? AND ?", InvoiceTypeCreditNote, true, from, to).
+		Order("invoices.issued_at").
+		Scan(&reverseChargeCredits)
+
+	return rows, reverseCharged, reverseChargeCredits
+}
+
+// GET /admin/reports/tax?from=2024-04-01&to=2024-06-30&export=true
+// Summarises tax on invoiced payments, less credit notes, for filing.
+func GetTaxSummaryReport(c *gin.Context) {
+	fromDate, toDate, err := parseReportRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, reverseCharged, reverseChargeCredits := buildTaxSummary(fromDate, toDate)
+
+	if c.Query("export") == "true" {
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", "attachment;filename=tax_summary.csv")
+		writer := csv.NewWriter(c.Writer)
+
+		writer.Write([]string{"Country", "Currency", "Tax", "Rate", "Payments", "Credit Notes",
+			"Credited Taxable Amount", "Credited Tax Amount", "Taxable Amount", "Tax Amount"})
+		for _, r := range rows {
+			writer.Write([]string{
+				r.CountryCode,
+				r.Currency,
+				r.Name,
+				fmt.Sprintf("%.2f", r.Rate),
+				fmt.Sprintf("%d", r.Payments),
+				fmt.Sprintf("%d", r.CreditNotes),
+				fmt.Sprintf("%.2f", r.CreditedTaxableAmount),
+				fmt.Sprintf("%.2f", r.CreditedTaxAmount),
+				fmt.Sprintf("%.2f", r.TaxableAmount),
+				fmt.Sprintf("%.2f", r.TaxAmount),
+			})
+		}
+		for _, p := range reverseCharged {
+			writer.Write([]string{
+				p.CountryCode,
+				p.Currency,
+				"Reverse charge (" + p.CustomerTaxID + ")",
+				"0.00",
+				"1",
+				"0",
+				"0.00",
+				"0.00",
+				fmt.Sprintf("%.2f", p.NetAmount),
+				"0.00",
+			})
+		}
+		for _, cn := range reverseChargeCredits {
+			writer.Write([]string{
+				cn.CountryCode,
+				cn.Currency,
+				"Reverse charge credit " + cn.Number + " (" + cn.BuyerTaxID + ")",
+				"0.00",
+				"0",
+				"1",
+				fmt.Sprintf("%.2f", cn.Subtotal),
+				"0.00",
+				fmt.Sprintf("%.2f", -cn.Subtotal),
+				"0.00",
+			})
+		}
+		writer.Flush()
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":                   fromDate,
+		"to":                     toDate,
+		"summary":                rows,
+		"reverse_charged":        reverseCharged,
+		"reverse_charge_credits": reverseChargeCredits,
+	})
+}
+
+// parseReportRange reads ?from=&to= (YYYY-MM-DD), defaulting to the last month
+func parseReportRange(c *gin.Context) (time.Time, time.Time, error) {
+	fromDate := time.Now().AddDate(0, -1, 0)
+	toDate := time.Now()
+
+	if fromStr := c.Query("from"); fromStr != "" {
+		parsed, err := time.Parse("2006-01-02", fromStr)
+		if err != nil {
+			return fromDate, toDate, fmt.Errorf("invalid from date")
+		}
+		fromDate = parsed
+	}
+	if toStr := c.Query("to"); toStr != "" {
+		parsed, err := time.Parse("2006-01-02", toStr)
+		if err != nil {
+			return fromDate, toDate, fmt.Errorf("invalid to date")
+		}
+		toDate = parsed.Add(24 * time.Hour) // Include end date
+	}
+	return fromDate, toDate, nil
+}
+
+// --- Seeder ---
+
+// SeedTaxRules creates default GST/VAT rules. Rates should be reviewed by the
+// studio's accountant before going live.
+func SeedTaxRules() {
+	var count int64
+	db.Model(&TaxRule{}).Count(&count)
+	if count > 0 {
+		return // Already seeded
+	}
+
+	rules := []TaxRule{
+		{CountryCode: "IN", Name: "GST", Rate: 18, PriceIncludesTax: true, IsActive: true},
+		{CountryCode: "AU", Name: "GST", Rate: 10, PriceIncludesTax: true, IsActive: true},
+		{CountryCode: "DE", Name: "VAT", Rate: 19, PriceIncludesTax: true, ReverseCharge: true, IsActive: true},
+		{CountryCode: "FR", Name: "VAT", Rate: 20, PriceIncludesTax: true, ReverseCharge: true, IsActive: true},
+		{CountryCode: "IE", Name: "VAT", Rate: 23, PriceIncludesTax: true, ReverseCharge: true, IsActive: true},
+		{CountryCode: "NL", Name: "VAT", Rate: 21, PriceIncludesTax: true, ReverseCharge: true, IsActive: true},
+		{CountryCode: "ES", Name: "VAT", Rate: 21, PriceIncludesTax: true, ReverseCharge: true, IsActive: true},
+		{CountryCode: "IT", Name: "VAT", Rate: 22, PriceIncludesTax: true, ReverseCharge: true, IsActive: true},
+	}
+
+	for _, r := range rules {
+		db.Create(&r)
+	}
+	println("Tax rules seeded successfully.")
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalculateTax(t *testing.T) {
+	setupTestDB(t, &User{}, &TaxRule{})
+	t.Setenv("TAX_SELLER_COUNTRY", "IN")
+	t.Setenv("TAX_SELLER_REGION", "KA")
+
+	require.NoError(t, db.Create(&[]TaxRule{
+		{CountryCode: "IN", Name: "GST", Rate: 18, PriceIncludesTax: true, IsActive: true},
+		{CountryCode: "AU", Name: "GST", Rate: 10, IsActive: true},
+		{CountryCode: "DE", Name: "VAT", Rate: 19, PriceIncludesTax: true, ReverseCharge: true, IsActive: true},
+		{CountryCode: "FR", Name: "VAT", Rate: 20, IsActive: false},
+	}).Error)
+	verifiedAt := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, db.Create(&[]User{
+		{Name: "Buyer", Email: "buyer@example.com"},
+		{Name: "Verified", Email: "verified@example.com", TaxID: "DE 987 654 321", TaxIDVerifiedAt: &verifiedAt},
+	}).Error)
+
+	registered := map[string]bool{"123456789": true}
+	viesDown := false
+	saved := viesLookup
+	viesLookup = func(country, number string) (bool, error) {
+		if viesDown {
+			return false, errors.New("timeout")
+		}
+		return country == "DE" && registered[number], nil
+	}
+	t.Cleanup(func() { viesLookup = saved })
+
+	cases := []struct {
+		name          string
+		country       string
+		region        string
+		amount        float64
+		taxID         string
+		uid           uint
+		viesDown      bool
+		net, tax, tot float64
+		lines         []string
+		check         string
+	}{
+		{"intra-state GST splits", "IN", "KA", 118, "", 1, false, 100, 18, 118, []string{"CGST", "SGST"}, ""},
+		{"inter-state GST", "IN", "MH", 118, "", 1, false, 100, 18, 118, []string{"IGST"}, ""},
+		{"domestic tax ID is still taxed", "IN", "MH", 118, "29ABCDE1234F1Z5", 1, false, 100, 18, 118, []string{"IGST"}, ""},
+		{"exclusive tax added on top", "AU", "", 100, "", 1, false, 100, 10, 110, []string{"GST"}, ""},
+		{"no rule", "US", "", 50, "", 1, false, 50, 0, 50, nil, ""},
+		{"inactive rule", "FR", "", 50, "", 1, false, 50, 0, 50, nil, ""},
+		{"VIES confirmed", "DE", "", 119, "DE123456789", 1, false, 100, 0, 100, nil, "vies"},
+		{"made-up tax ID", "DE", "", 119, "anything", 1, false, 100, 19, 119, []string{"VAT"}, ""},
+		{"well-formed but unregistered", "DE", "", 119, "DE111111111", 1, false, 100, 19, 119, []string{"VAT"}, ""},
+		{"VIES unavailable", "DE", "", 119, "DE123456789", 1, true, 100, 19, 119, []string{"VAT"}, ""},
+		{"admin verified without VIES", "DE", "", 119, "DE987654321", 2, true, 100, 0, 100, nil, "admin"},
+		{"admin verified a different ID", "DE", "", 119, "DE987654321", 1, true, 100, 19, 119, []string{"VAT"}, ""},
+	}
+	for _, tc := range cases {
+		viesDown = tc.viesDown
+		result := CalculateTax(db, tc.country, tc.region, tc.amount, tc.taxID, tc.uid)
+		assert.Equal(t, tc.net, result.Net, tc.name)
+		assert.Equal(t, tc.tax, result.Tax, tc.name)
+		assert.Equal(t, tc.tot, result.Gross, tc.name)
+		assert.Equal(t, tc.check != "", result.ReverseCharge, tc.name)
+		assert.Equal(t, tc.check, result.TaxIDCheck, tc.name)
+		var lines []string
+		for _, l := range result.Lines {
+			lines = append(lines, l.Name)
+		}
+		assert.Equal(t, tc.lines, lines, tc.name)
+	}
+}
+
+func TestNormalizeTaxID(t *testing.T) {
+	assert.Equal(t, "123456789", normalizeTaxID("DE", " de 123.456-789 "))
+	assert.Equal(t, "123456789B01", normalizeTaxID("nl", "NL123456789B01"))
+	assert.True(t, taxIDFormats["NL"].MatchString("123456789B01"))
+	assert.False(t, taxIDFormats["IT"].MatchString("1234"))
+}
+
+func TestBuildTaxSummary(t *testing.T) {
+	setupInvoiceDB(t)
+	now := time.Now()
+	from, to := now.Add(-24*time.Hour), now.Add(24*time.Hour)
+
+	pay := func(order, country string, net float64, gst bool, created time.Time) Payment {
+		p := Payment{UserID: 1, OrderID: order, Currency: "AUD", CountryCode: country, Status: "success",
+			Amount: net, SubtotalAmount: net, NetAmount: net, CreatedAt: created}
+		if gst {
+			p.Amount, p.TaxAmount = net*1.1, net*0.1
+		} else {
+			p.ReverseCharge, p.CustomerTaxID = true, "DE123456789"
+		}
+		require.NoError(t, db.Create(&p).Error)
+		if gst {
+			require.NoError(t, db.Create(&PaymentTaxLine{PaymentID: p.ID, Name: "GST", Rate: 10, TaxableAmount: net, Amount: net * 0.1}).Error)
+		}
+		return p
+	}
+	refund := func(p Payment, amount float64, status string) {
+		inv, err := IssuePaymentInvoice(db, &p)
+		require.NoError(t, err)
+		_, err = IssueCreditNote(db, inv, amount, 0, "refund")
+		require.NoError(t, err)
+		require.NoError(t, db.Model(&p).Update("status", status).Error)
+	}
+
+	pay("kept", "AU", 100, true, now)
+	refund(pay("refunded", "AU", 200, true, now), 220, "refunded")
+	refund(pay("last quarter", "AU", 100, true, now.AddDate(0, -3, 0)), 55, "partially_refunded")
+	failed := pay("failed", "AU", 500, true, now)
+	require.NoError(t, db.Model(&failed).Update("status", "failed").Error)
+	refund(pay("b2b", "DE", 300, false, now), 100, "partially_refunded")
+
+	rows, reverseCharged, credits := buildTaxSummary(from, to)
+
+	// Refunded sales stay in, and every credit note issued in the period comes off
+	require.Len(t, rows, 1)
+	assert.Equal(t, taxSummaryRow{
+		CountryCode: "AU", Currency: "AUD", Name: "GST", Rate: 10,
+		Payments: 2, CreditNotes: 2,
+		CreditedTaxableAmount: 250, CreditedTaxAmount: 25,
+		TaxableAmount: 50, TaxAmount: 5,
+	}, rows[0])
+
+	require.Len(t, reverseCharged, 1)
+	assert.Equal(t, "b2b", reverseCharged[0].OrderID)
+	require.Len(t, credits, 1)
+	assert.Equal(t, "DE", credits[0].CountryCode)
+	assert.Equal(t, 100.0, credits[0].Subtotal)
+
+	// The quarter before only has the sale that was refunded later
+	rows, _, _ = buildTaxSummary(now.AddDate(0, -3, -1), now.AddDate(0, -3, 1))
+	require.Len(t, rows, 1)
+	assert.Equal(t, int64(1), rows[0].Payments)
+	assert.Zero(t, rows[0].CreditNotes)
+	assert.Equal(t, 100.0, rows[0].TaxableAmount)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// setupTestDB points the global db at a fresh in-memory SQLite database with
+// the given models migrated, and restores it when the test ends
+func setupTestDB(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+	conn, err := gorm.Open(sqlite.Open("file:test_"+uuid.NewString()+"?mode=memory"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	previous := db
+	db = conn
+	t.Cleanup(func() { db = previous })
+	return conn
+}
+
+// useTestRates serves fixed AUD-based exchange rates instead of calling a provider
+func useTestRates(t *testing.T, rates map[string]float64) {
+	t.Helper()
+	ratesMutex.Lock()
+	saved, savedTime := exchangeRatesCache, exchangeRatesCacheTime
+	exchangeRatesCache, exchangeRatesCacheTime = rates, time.Now()
+	ratesMutex.Unlock()
+	t.Cleanup(func() {
+		ratesMutex.Lock()
+		exchangeRatesCache, exchangeRatesCacheTime = saved, savedTime
+		ratesMutex.Unlock()
+	})
+}
+
+// callHandler runs a handler as user 1 with a JSON body
+func callHandler(handler gin.HandlerFunc, params gin.Params, body interface{}) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	raw, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set("userID", uint(1))
+	c.Params = params
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(raw))
+	handler(c)
+	return w
+}
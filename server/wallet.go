@@ -0,0 +1,561 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ProductGiftCard is the checkout product type for buying a gift card
+const ProductGiftCard = "gift_card"
+
+// GatewayWallet marks payments settled entirely from wallet balance
+const GatewayWallet = "wallet"
+
+// Wallet transaction kinds
+const (
+	WalletRefund           = "refund"            // Refund to store credit
+	WalletGrant            = "admin_grant"       // Goodwill credit from an admin
+	WalletAdminDebit       = "admin_debit"       // Correction by an admin
+	WalletGiftCard         = "gift_card"         // Gift card redeemed into the wallet
+	WalletCheckout         = "checkout"          // Spent at checkout
+	WalletCheckoutReversal = "checkout_reversal" // Checkout failed or expired, credit returned
+)
+
+var ErrInsufficientWalletBalance = errors.New("insufficient wallet balance")
+
+// --- Models ---
+
+// Wallet holds a user's store credit in one currency
+type Wallet struct {
+	ID       uint    `json:"id" gorm:"primaryKey"`
+	UserID   uint    `json:"user_id" gorm:"uniqueIndex:idx_wallet_user_currency"`
+	Currency string  `json:"currency" gorm:"uniqueIndex:idx_wallet_user_currency"`
+	Balance  float64 `json:"balance"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WalletTransaction is an append-only record of every balance change
+type WalletTransaction struct {
+	ID           uint    `json:"id" gorm:"primaryKey"`
+	WalletID     uint    `json:"wallet_id" gorm:"index"`
+	UserID       uint    `json:"user_id" gorm:"index"`
+	Kind         string  `json:"kind"`
+	Amount       float64 `json:"amount"` // Positive credits, negative debits
+	Currency     string  `json:"currency"`
+	BalanceAfter float64 `json:"balance_after"`
+	Description  string  `json:"description"`
+	PaymentID    *uint   `json:"payment_id" gorm:"index"`
+	GiftCardID   *uint   `json:"gift_card_id"`
+	CreatedBy    *uint   `json:"created_by"` // Admin for grants and corrections
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GiftCard is bought through checkout and redeemed into the recipient's wallet
+type GiftCard struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	Code           string     `json:"code,omitempty" gorm:"uniqueIndex;not null"`
+	Amount         float64    `json:"amount"`
+	Currency       string     `json:"currency"`
+	PurchaserID    uint       `json:"purchaser_id" gorm:"index"`
+	PaymentID      *uint      `json:"payment_id"`
+	RecipientName  string     `json:"recipient_name"`
+	RecipientEmail string     `json:"recipient_email"`
+	Message        string     `json:"message"`
+	Status         string     `json:"status" gorm:"index"` // pending, active, redeemed, void
+	RedeemedBy     *uint      `json:"redeemed_by"`
+	RedeemedAt     *time.Time `json:"redeemed_at"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// --- DTOs ---
+type WalletAdjustmentInput struct {
+	Currency string  `json:"currency" binding:"required"`
+	Amount   float64 `json:"amount" binding:"required"` // Negative to debit
+	Reason   string  `json:"reason" binding:"required"`
+}
+
+type CreateGiftCardInput struct {
+	Amount         float64 `json:"amount" binding:"required"`
+	Currency       string  `json:"currency" binding:"required"`
+	RecipientName  string  `json:"recipient_name"`
+	RecipientEmail string  `json:"recipient_email" binding:"required,email"`
+	Message        string  `json:"message"`
+}
+
+type RedeemGiftCardInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// --- Logic ---
+
+// Total is the full order amount, whether paid at the gateway or from the wallet
+func (p *Payment) Total() float64 {
+	return roundMoney(p.Amount + p.WalletAmount)
+}
+
+// GatewayRefunded is the part of RefundedAmount paid back through the gateway
+func (p *Payment) GatewayRefunded() float64 {
+	return roundMoney(p.RefundedAmount - p.RefundedToWallet)
+}
+
+// WalletBalance returns a user's balance in a currency
+func WalletBalance(tx *gorm.DB, userID uint, currency string) float64 {
+	var wallet Wallet
+	if tx.Where("user_id = ? AND currency = ?", userID, strings.ToUpper(currency)).First(&wallet).Error != nil {
+		return 0
+	}
+	return wallet.Balance
+}
+
+// PostWalletTransaction changes a wallet balance and records why. Debits
+// fail with ErrInsufficientWalletBalance rather than going negative.
+func PostWalletTransaction(tx *gorm.DB, entry WalletTransaction) (*WalletTransaction, error) {
+	entry.Amount = roundMoney(entry.Amount)
+	entry.Currency = strings.ToUpper(entry.Currency)
+	if entry.Amount == 0 {
+		return nil, errors.New("amount must not be zero")
+	}
+
+	wallet := Wallet{UserID: entry.UserID, Currency: entry.Currency}
+	if err := tx.Where("user_id = ? AND currency = ?", entry.UserID, entry.Currency).FirstOrCreate(&wallet).Error; err != nil {
+		return nil, err
+	}
+
+	update := tx.Model(&Wallet{}).Where("id = ?", wallet.ID)
+	if entry.Amount < 0 {
+		update = update.Where("balance + 0.005 >= ?", -entry.Amount)
+	}
+	res := update.Update("balance", gorm.Expr("balance + ?", entry.Amount))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrInsufficientWalletBalance
+	}
+	tx.First(&wallet, wallet.ID)
+
+	entry.ID = 0
+	entry.WalletID = wallet.ID
+	entry.BalanceAfter = wallet.Balance
+	if err := tx.Create(&entry).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// debitWalletForPayment takes the wallet share of a checkout
+func debitWalletForPayment(tx *gorm.DB, payment *Payment) error {
+	paymentID := payment.ID
+	_, err := PostWalletTransaction(tx, WalletTransaction{
+		UserID:      payment.UserID,
+		Kind:        WalletCheckout,
+		Amount:      -payment.WalletAmount,
+		Currency:    payment.Currency,
+		Description: describePayment(tx, payment),
+		PaymentID:   &paymentID,
+	})
+	return err
+}
+
+// ReverseWalletDebit returns the wallet share of a checkout that failed or
+// expired. It is safe to call more than once.
+func ReverseWalletDebit(tx *gorm.DB, payment *Payment) error {
+	if payment.WalletAmount <= 0 {
+		return nil
+	}
+	var reversed int64
+	tx.Model(&WalletTransaction{}).Where("payment_id = ? AND kind = ?", payment.ID, WalletCheckoutReversal).Count(&reversed)
+	if reversed > 0 {
+		return nil
+	}
+
+	paymentID := payment.ID
+	_, err := PostWalletTransaction(tx, WalletTransaction{
+		UserID:      payment.UserID,
+		Kind:        WalletCheckoutReversal,
+		Amount:      payment.WalletAmount,
+		Currency:    payment.Currency,
+		Description: "Checkout not completed",
+		PaymentID:   &paymentID,
+	})
+	return err
+}
+
+// priceGiftCard checks a gift card checkout is for the buyer's own pending card
+// and returns its face value
+func priceGiftCard(tx *gorm.DB, input OrderPricingInput) (float64, error) {
+	var card GiftCard
+	if err := tx.First(&card, input.ProductID).Error; err != nil {
+		return 0, errors.New("gift card not found")
+	}
+	if card.PurchaserID != input.UserID || card.Status != "pending" {
+		return 0, errors.New("gift card is not awaiting payment")
+	}
+	if card.Currency != strings.ToUpper(input.Currency) {
+		return 0, fmt.Errorf("gift card must be paid in %s", card.Currency)
+	}
+	if input.PromoCode != "" || input.UseWallet {
+		return 0, errors.New("gift cards can't be bought with promo codes or wallet credit")
+	}
+	return card.Amount, nil
+}
+
+func giftCardValidity() time.Duration {
+	return time.Duration(envInt("GIFT_CARD_VALID_DAYS", 365)) * 24 * time.Hour
+}
+
+// generateGiftCardCode returns a code like KY-7F3K-Q9MW-2HXD
+func generateGiftCardCode() (string, error) {
+	const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	var b strings.Builder
+	b.WriteString("KY")
+	for i := 0; i < 12; i++ {
+		if i%4 == 0 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(alphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// ActivateGiftCard issues the card once its checkout is paid and sends it to the recipient
+func ActivateGiftCard(payment *Payment) error {
+	if payment.ProductType != ProductGiftCard || payment.Status != "success" {
+		return nil
+	}
+
+	var card GiftCard
+	if err := db.First(&card, payment.ProductID).Error; err != nil {
+		return err
+	}
+	expires := time.Now().Add(giftCardValidity())
+	paymentID := payment.ID
+	return db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&card).Where("status = ?", "pending").Updates(map[string]interface{}{
+			"status":     "active",
+			"payment_id": &paymentID,
+			"expires_at": &expires,
+		})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+
+		var purchaser User
+		tx.First(&purchaser, card.PurchaserID)
+		return SendGiftCard(tx, card.RecipientEmail, card.RecipientName, purchaser.Name, card.Code,
+			fmt.Sprintf("%.2f %s", card.Amount, card.Currency), card.Message, expires.Format("02 Jan 2006"))
+	})
+}
+
+// voidRefundedGiftCard voids the gift card a refunded payment bought, so the
+// buyer can't have both the refund and the card. A card that has already been
+// redeemed into a wallet can't be taken back, so it isn't refundable, and
+// neither is part of a card.
+func voidRefundedGiftCard(tx *gorm.DB, payment *Payment, amount float64) error {
+	if payment.ProductType != ProductGiftCard {
+		return nil
+	}
+	if amount < roundMoney(payment.Total()-payment.RefundedAmount) {
+		return errors.New("gift cards can only be refunded in full")
+	}
+
+	var card GiftCard
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&card, payment.ProductID).Error; err != nil {
+		return nil // No card was issued, nothing to void
+	}
+	if card.Status == "redeemed" {
+		return errors.New("gift card has already been redeemed and can't be refunded")
+	}
+	return tx.Model(&card).Update("status", "void").Error
+}
+
+// --- Handlers ---
+
+// GetMyWallet - Protected - Balances in every currency the user holds credit in
+func GetMyWallet(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	var wallets []Wallet
+	db.Where("user_id = ?", uid).Order("currency asc").Find(&wallets)
+	c.JSON(http.StatusOK, wallets)
+}
+
+// GetMyWalletTransactions - Protected - Wallet history, optionally for one currency
+func GetMyWalletTransactions(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	query := db.Where("user_id = ?", uid)
+	if currency := c.Query("currency"); currency != "" {
+		query = query.Where("currency = ?", strings.ToUpper(currency))
+	}
+	var txns []WalletTransaction
+	query.Order("id desc").Find(&txns)
+	c.JSON(http.StatusOK, txns)
+}
+
+// PayWithWallet - Protected - Pay for an order entirely from wallet balance
+func PayWithWallet(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var input CreateOrderInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	currency := strings.ToUpper(input.Currency)
+	detectedCountry := resolveCheckoutCountry(c, &input.CountryCode)
+
+	pricing, err := PriceOrder(db, OrderPricingInput{
+		UserID:      uid,
+		ProductType: input.ProductType,
+		ProductID:   input.ProductID,
+		Subtotal:    input.Amount,
+		Currency:    currency,
+		PromoCode:   input.PromoCode,
+		CountryCode: input.CountryCode,
+		RegionCode:  input.RegionCode,
+		TaxID:       input.TaxID,
+		UseWallet:   true,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if pricing.GatewayAmount() > 0 {
+		c.JSON(http.StatusPaymentRequired, gin.H{
+			"error":          ErrInsufficientWalletBalance.Error(),
+			"total":          pricing.Total,
+			"wallet_balance": pricing.WalletAmount,
+		})
+		return
+	}
+
+	payment := Payment{
+		UserID:        uid,
+		OrderID:       "wallet_" + uuid.New().String(),
+		Currency:      currency,
+		BaseAmountAUD: input.BaseAmountAUD,
+		CountryCode:   input.CountryCode,
+		RegionCode:    input.RegionCode,
+		CustomerTaxID: input.TaxID,
+		ProductType:   input.ProductType,
+		ProductID:     input.ProductID,
+		Method:        GatewayWallet,
+		Status:        "success",
+	}
+	flagCountryMismatch(&payment, detectedCountry)
+	if err := CreateOrderPayment(&payment, pricing); err != nil {
+		if errors.Is(err, ErrInsufficientWalletBalance) {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ExtendSeatHold(db, payment.ID)
+
+	if err := issueInvoiceForPayment(&payment); err != nil {
+		fmt.Printf("WARNING: Failed to issue invoice for payment %d: %v\n", payment.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Paid from wallet",
+		"status":     "success",
+		"payment_id": payment.ID,
+	})
+}
+
+// CreateGiftCard - Protected - Start a gift card purchase. Pay for it through
+// checkout with product_type "gift_card" and the returned id.
+func CreateGiftCard(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var input CreateGiftCardInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be greater than zero"})
+		return
+	}
+
+	code, err := generateGiftCardCode()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create gift card"})
+		return
+	}
+	card := GiftCard{
+		Code:           code,
+		Amount:         roundMoney(input.Amount),
+		Currency:       strings.ToUpper(input.Currency),
+		PurchaserID:    uid,
+		RecipientName:  input.RecipientName,
+		RecipientEmail: strings.ToLower(strings.TrimSpace(input.RecipientEmail)),
+		Message:        input.Message,
+		Status:         "pending",
+	}
+	if err := db.Create(&card).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create gift card"})
+		return
+	}
+
+	card.Code = ""
+	c.JSON(http.StatusCreated, gin.H{
+		"gift_card":    card,
+		"product_type": ProductGiftCard,
+		"product_id":   card.ID,
+	})
+}
+
+// GetMyGiftCards - Protected - Gift cards the user has bought
+func GetMyGiftCards(c *gin.Context) {
+	uid, _ := currentUserID(c)
+	var cards []GiftCard
+	db.Where("purchaser_id = ?", uid).Order("id desc").Find(&cards)
+	for i := range cards {
+		if cards[i].Status == "pending" {
+			cards[i].Code = ""
+		}
+	}
+	c.JSON(http.StatusOK, cards)
+}
+
+// RedeemGiftCard - Protected - Add a gift card's value to the caller's wallet
+func RedeemGiftCard(c *gin.Context) {
+	uid, _ := currentUserID(c)
+
+	var input RedeemGiftCardInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	code := strings.ToUpper(strings.TrimSpace(input.Code))
+
+	var entry *WalletTransaction
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var card GiftCard
+		if err := tx.Where("code = ?", code).First(&card).Error; err != nil || card.Status == "pending" {
+			return errors.New("gift card not found")
+		}
+		if card.Status == "redeemed" {
+			return errors.New("gift card has already been redeemed")
+		}
+		if card.Status == "void" {
+			return errors.New("gift card has been refunded")
+		}
+		if card.ExpiresAt != nil && card.ExpiresAt.Before(time.Now()) {
+			return errors.New("gift card has expired")
+		}
+
+		now := time.Now()
+		res := tx.Model(&card).Where("status = ?", "active").Updates(map[string]interface{}{
+			"status":      "redeemed",
+			"redeemed_by": uid,
+			"redeemed_at": now,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errors.New("gift card has already been redeemed")
+		}
+
+		var err error
+		entry, err = PostWalletTransaction(tx, WalletTransaction{
+			UserID:      uid,
+			Kind:        WalletGiftCard,
+			Amount:      card.Amount,
+			Currency:    card.Currency,
+			Description: "Gift card " + card.Code,
+			GiftCardID:  &card.ID,
+		})
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Gift card redeemed", "transaction": entry})
+}
+
+// GetAdminUserWallet - Admin - A user's balances and history
+func GetAdminUserWallet(c *gin.Context) {
+	var wallets []Wallet
+	db.Where("user_id = ?", c.Param("id")).Order("currency asc").Find(&wallets)
+	var txns []WalletTransaction
+	db.Where("user_id = ?", c.Param("id")).Order("id desc").Find(&txns)
+	c.JSON(http.StatusOK, gin.H{"wallets": wallets, "transactions": txns})
+}
+
+// AdjustWallet - Admin - Grant goodwill credit or correct a balance
+func AdjustWallet(c *gin.Context) {
+	adminID, _ := currentUserID(c)
+
+	var input WalletAdjustmentInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	if err := db.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	kind := WalletGrant
+	if input.Amount < 0 {
+		kind = WalletAdminDebit
+	}
+
+	var entry *WalletTransaction
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		entry, err = PostWalletTransaction(tx, WalletTransaction{
+			UserID:      user.ID,
+			Kind:        kind,
+			Amount:      input.Amount,
+			Currency:    input.Currency,
+			Description: input.Reason,
+			CreatedBy:   &adminID,
+		})
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Wallet updated", "transaction": entry})
+}
+
+// GetAdminGiftCards - Admin - All gift cards, optionally filtered by status
+func GetAdminGiftCards(c *gin.Context) {
+	query := db.Order("id desc")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var cards []GiftCard
+	query.Find(&cards)
+	c.JSON(http.StatusOK, cards)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostWalletTransaction(t *testing.T) {
+	setupTestDB(t, &Wallet{}, &WalletTransaction{})
+
+	cases := []struct {
+		name    string
+		amount  float64
+		balance float64
+		err     error
+	}{
+		{"credit opens the wallet", 50, 50, nil},
+		{"debit", -20.004, 30, nil},
+		{"exact balance", -30, 0, nil},
+		{"overdraw", -0.01, 0, ErrInsufficientWalletBalance},
+		{"credit again", 10.555, 10.56, nil},
+	}
+	for _, tc := range cases {
+		entry, err := PostWalletTransaction(db, WalletTransaction{UserID: 1, Kind: WalletGrant, Amount: tc.amount, Currency: "inr"})
+		if tc.err != nil {
+			assert.ErrorIs(t, err, tc.err, tc.name)
+		} else {
+			require.NoError(t, err, tc.name)
+			assert.Equal(t, tc.balance, entry.BalanceAfter, tc.name)
+		}
+		assert.InDelta(t, tc.balance, WalletBalance(db, 1, "INR"), 0.001, tc.name)
+	}
+
+	_, err := PostWalletTransaction(db, WalletTransaction{UserID: 1, Kind: WalletGrant, Amount: 0.001, Currency: "INR"})
+	assert.EqualError(t, err, "amount must not be zero")
+	assert.Equal(t, 0.0, WalletBalance(db, 1, "AUD"), "balances are per currency")
+}
+
+func TestWalletCheckoutReversal(t *testing.T) {
+	setupTestDB(t, &Wallet{}, &WalletTransaction{}, &Payment{})
+
+	_, err := PostWalletTransaction(db, WalletTransaction{UserID: 1, Kind: WalletGrant, Amount: 100, Currency: "INR"})
+	require.NoError(t, err)
+
+	payment := Payment{UserID: 1, Amount: 20, WalletAmount: 80, Currency: "INR", Status: "created"}
+	require.NoError(t, db.Create(&payment).Error)
+	require.NoError(t, debitWalletForPayment(db, &payment))
+	assert.Equal(t, 20.0, WalletBalance(db, 1, "INR"))
+
+	second := Payment{UserID: 1, Amount: 50, WalletAmount: 50, Currency: "INR", Status: "created"}
+	require.NoError(t, db.Create(&second).Error)
+	assert.ErrorIs(t, debitWalletForPayment(db, &second), ErrInsufficientWalletBalance)
+
+	// Expiry and failure handlers may both reverse the same checkout
+	require.NoError(t, ReverseWalletDebit(db, &payment))
+	require.NoError(t, ReverseWalletDebit(db, &payment))
+	assert.Equal(t, 100.0, WalletBalance(db, 1, "INR"))
+}
+
+func TestPriceGiftCard(t *testing.T) {
+	setupTestDB(t, &GiftCard{})
+
+	pending := GiftCard{Code: "KY-PEND", Amount: 2500, Currency: "INR", PurchaserID: 1, Status: "pending"}
+	active := GiftCard{Code: "KY-ACTV", Amount: 2500, Currency: "INR", PurchaserID: 1, Status: "active"}
+	require.NoError(t, db.Create(&pending).Error)
+	require.NoError(t, db.Create(&active).Error)
+
+	base := OrderPricingInput{UserID: 1, ProductType: ProductGiftCard, ProductID: pending.ID, Subtotal: 1, Currency: "inr"}
+	cases := []struct {
+		name   string
+		change func(in *OrderPricingInput)
+		err    string
+	}{
+		{"face value, whatever the client sent", func(in *OrderPricingInput) {}, ""},
+		{"unknown card", func(in *OrderPricingInput) { in.ProductID = 404 }, "gift card not found"},
+		{"someone else's card", func(in *OrderPricingInput) { in.UserID = 2 }, "gift card is not awaiting payment"},
+		{"already paid", func(in *OrderPricingInput) { in.ProductID = active.ID }, "gift card is not awaiting payment"},
+		{"wrong currency", func(in *OrderPricingInput) { in.Currency = "AUD" }, "gift card must be paid in INR"},
+		{"promo code", func(in *OrderPricingInput) { in.PromoCode = "WELCOME" }, "gift cards can't be bought with promo codes or wallet credit"},
+		{"wallet credit", func(in *OrderPricingInput) { in.UseWallet = true }, "gift cards can't be bought with promo codes or wallet credit"},
+	}
+	for _, tc := range cases {
+		input := base
+		tc.change(&input)
+		amount, err := priceGiftCard(db, input)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, 2500.0, amount, tc.name)
+	}
+}
+
+func TestRedeemGiftCard(t *testing.T) {
+	setupTestDB(t, &Wallet{}, &WalletTransaction{}, &GiftCard{})
+
+	expired := time.Now().Add(-time.Hour)
+	cards := []GiftCard{
+		{Code: "KY-GOOD", Amount: 2500, Currency: "INR", Status: "active"},
+		{Code: "KY-PEND", Amount: 2500, Currency: "INR", Status: "pending"},
+		{Code: "KY-OLD1", Amount: 2500, Currency: "INR", Status: "active", ExpiresAt: &expired},
+	}
+	require.NoError(t, db.Create(&cards).Error)
+
+	cases := []struct {
+		code   string
+		status int
+		body   string
+	}{
+		{" ky-good ", http.StatusOK, "Gift card redeemed"},
+		{"KY-GOOD", http.StatusBadRequest, "gift card has already been redeemed"},
+		{"KY-PEND", http.StatusBadRequest, "gift card not found"},
+		{"KY-OLD1", http.StatusBadRequest, "gift card has expired"},
+		{"KY-NONE", http.StatusBadRequest, "gift card not found"},
+	}
+	for _, tc := range cases {
+		w := callHandler(RedeemGiftCard, nil, gin.H{"code": tc.code})
+		assert.Equal(t, tc.status, w.Code, tc.code)
+		assert.Contains(t, w.Body.String(), tc.body, tc.code)
+	}
+	assert.Equal(t, 2500.0, WalletBalance(db, 1, "INR"), "the card is credited once")
+}
@@ -57,7 +57,7 @@ const Services = () => {
                     'Content-Type': 'application/json',
                     'Authorization': `Bearer ${token}`
                 },
-                body: JSON.stringify({ amount: amount, currency: "INR" })
+                body: JSON.stringify({ amount: amount, currency: "INR", product_type: "program", product_id: backendProg.ID })
             });
 
             if (!orderResponse.ok) throw new Error('Failed to create order');
@@ -224,6 +224,12 @@ const Services = () => {
         const amount = parseInt(priceStr.replace(/[^\d]/g, ''), 10);
 
         try {
+            // The order must name the pack it pays for
+            const packsResponse = await fetch('http://localhost:8080/api/class-packs');
+            const packs = packsResponse.ok ? await packsResponse.json() : [];
+            const pack = packs.find(p => p.code === type);
+            if (!pack) throw new Error(`Package "${type}" is not on sale`);
+
             // 1. Create Order
             const orderResponse = await fetch('http://localhost:8080/api/payments/create-order', {
                 method: 'POST',
@@ -231,7 +237,7 @@ const Services = () => {
                     'Content-Type': 'application/json',
                     'Authorization': `Bearer ${token}`
                 },
-                body: JSON.stringify({ amount: amount, currency: "INR" })
+                body: JSON.stringify({ amount: amount, currency: "INR", product_type: "membership", product_id: pack.id })
             });
 
             if (!orderResponse.ok) throw new Error('Failed to create order');